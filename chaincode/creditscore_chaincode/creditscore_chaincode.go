@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
 	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -11,6 +13,33 @@ import (
 
 //HomelendChaincode xx
 type HomelendChaincode struct {
+	// identityProvider resolves the caller, cid is used when it is nil
+	identityProvider IdentityProvider
+}
+
+//IdentityProvider - resolves the identity and MSP of the transaction creator
+type IdentityProvider interface {
+	GetID(stub shim.ChaincodeStubInterface) (string, error)
+	GetMSPID(stub shim.ChaincodeStubInterface) (string, error)
+}
+
+//cidIdentityProvider - IdentityProvider backed by the client identity library
+type cidIdentityProvider struct {
+}
+
+func (p cidIdentityProvider) GetID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetID(stub)
+}
+
+func (p cidIdentityProvider) GetMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetMSPID(stub)
+}
+
+func (t *HomelendChaincode) identities() IdentityProvider {
+	if t.identityProvider == nil {
+		return cidIdentityProvider{}
+	}
+	return t.identityProvider
 }
 
 // Init initializes chaincode
@@ -24,7 +53,7 @@ func (t *HomelendChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
-	identity, err := cid.GetID(stub)
+	identity, err := t.identities().GetID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("Identity error %+v", args)
@@ -32,7 +61,7 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return shim.Error(str)
 	}
 
-	mspid, err := cid.GetMSPID(stub)
+	mspid, err := t.identities().GetMSPID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", args)
@@ -42,36 +71,101 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 
 	fmt.Println(fmt.Printf("Access log %s %s", identity, mspid))
 
-	// if function == "query" {
-	// }
-
-	return t.query(stub, args[0], args[1])
+	if function == "score" {
+		return t.score(stub, args, false)
+	} else if function == "explain" {
+		return t.score(stub, args, true)
+	} else if function == "query" {
+		return t.query(stub, args)
+	}
 
 	fmt.Println("invoke did not find func: " + function) //error
 	return shim.Error("Received unknown function invocation")
 }
 
-// todo: implement credit score system
-func (t *HomelendChaincode) query(stub shim.ChaincodeStubInterface, salary string, loanAmount string) pb.Response {
-	fmt.Println(fmt.Sprintf("creditscore started %s", salary, loanAmount))
+//score - scores the lib.CreditScoreRequest JSON in args[0] and returns the lib.CreditScoreResponse as JSON,
+//explain adds the description of every reason code. This is the contract lending_chaincode invokes.
+func (t *HomelendChaincode) score(stub shim.ChaincodeStubInterface, args []string, explain bool) pb.Response {
+	fmt.Println(fmt.Sprintf("score executed with args: %+v", args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 
-	salaryInt, err := strconv.Atoi(salary)
+	request := &lib.CreditScoreRequest{}
+	err := json.Unmarshal([]byte(args[0]), request)
 	if err != nil {
-		str := fmt.Sprintf("Salary is invalid %+v it must be an integer", err.Error())
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	switch {
-	case salaryInt < 10000:
-		return shim.Success([]byte("C"))
-	case salaryInt > 10000 && salaryInt < 20000:
-		return shim.Success([]byte("B"))
-	case salaryInt > 20000:
-		return shim.Success([]byte("A"))
-	default:
-		return shim.Error("Error while calculating credit score")
+	score, err := lib.ScoreCredit(request.Input)
+	if err != nil {
+		str := fmt.Sprintf("Error while calculating credit score %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	response := &lib.CreditScoreResponse{RequestHash: request.RequestHash, Model: lib.CreditModel, CreditScore: score}
+	if explain {
+		response.Explanations = lib.ExplainCredit(score)
+	}
+
+	responseAsBytes, err := json.Marshal(response)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return shim.Success(responseAsBytes)
+}
+
+//query - scores a borrower with the model lending_chaincode uses, see lib.ScoreCredit.
+//args: monthly salary, loan amount, optional monthly obligations, installments paid on time, paid late and defaults.
+//Amounts are decimals of the lib.DefaultCurrency, the result is the lib.CreditScore as JSON
+func (t *HomelendChaincode) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("creditscore started %+v", args))
+
+	if len(args) < 2 || len(args) > 6 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	input := lib.CreditInput{}
+	amounts := []*lib.Money{&input.Salary, &input.LoanAmount, &input.MonthlyObligations}
+	counts := []*int{&input.PaymentsOnTime, &input.PaymentsLate, &input.Defaults}
+	for i, arg := range args {
+		var err error
+		if i < len(amounts) {
+			*amounts[i], err = lib.ParseMoney(arg, lib.DefaultCurrency)
+		} else {
+			*counts[i-len(amounts)], err = strconv.Atoi(arg)
+		}
+		if err != nil {
+			str := fmt.Sprintf("args[%d] is invalid %+v", i, err.Error())
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	score, err := lib.ScoreCredit(input)
+	if err != nil {
+		str := fmt.Sprintf("Error while calculating credit score %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	scoreAsBytes, err := json.Marshal(score)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
 	}
+	return shim.Success(scoreAsBytes)
 }
 
 // ===================================================================================
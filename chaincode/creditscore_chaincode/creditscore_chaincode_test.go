@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//fakeIdentityProvider - lets a test impersonate any MSP
+type fakeIdentityProvider struct {
+	id    string
+	mspid string
+}
+
+func (p *fakeIdentityProvider) GetID(stub shim.ChaincodeStubInterface) (string, error) {
+	return p.id, nil
+}
+
+func (p *fakeIdentityProvider) GetMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return p.mspid, nil
+}
+
+//testNetwork - a MockStub running creditscore_chaincode, invoked by a credit rating agency
+type testNetwork struct {
+	t       *testing.T
+	stub    *shim.MockStub
+	txCount int
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	cc := &HomelendChaincode{identityProvider: &fakeIdentityProvider{id: "credit-agency-1", mspid: "POCCreditRatingAgencyMSP"}}
+	n := &testNetwork{t: t, stub: shim.NewMockStub("creditscore_chaincode", cc)}
+
+	res := n.stub.MockInit(n.nextTxID(), [][]byte{[]byte("init")})
+	if res.Status != shim.OK {
+		t.Fatalf("init failed: %s", res.Message)
+	}
+	return n
+}
+
+func (n *testNetwork) nextTxID() string {
+	n.txCount++
+	return fmt.Sprintf("tx%d", n.txCount)
+}
+
+func (n *testNetwork) invoke(function string, args ...string) pb.Response {
+	input := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+	return n.stub.MockInvoke(n.nextTxID(), input)
+}
+
+func (n *testNetwork) mustInvoke(function string, args ...string) []byte {
+	n.t.Helper()
+	res := n.invoke(function, args...)
+	if res.Status != shim.OK {
+		n.t.Fatalf("%s failed: %s", function, res.Message)
+	}
+	return res.Payload
+}
+
+func (n *testNetwork) mustFail(function string, args ...string) string {
+	n.t.Helper()
+	res := n.invoke(function, args...)
+	if res.Status == shim.OK {
+		n.t.Fatalf("%s was expected to fail", function)
+	}
+	return res.Message
+}
+
+//scoreRequest - the request lending_chaincode sends for the input
+func (n *testNetwork) scoreRequest(input lib.CreditInput) string {
+	n.t.Helper()
+	dataAsBytes, err := json.Marshal(lib.CreditScoreRequest{RequestHash: "request-1", Input: input})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return string(dataAsBytes)
+}
+
+func (n *testNetwork) decodeResponse(payload []byte) *lib.CreditScoreResponse {
+	n.t.Helper()
+	response := &lib.CreditScoreResponse{}
+	err := json.Unmarshal(payload, response)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return response
+}
+
+func TestScore(t *testing.T) {
+	n := newTestNetwork(t)
+	input := lib.CreditInput{Salary: lib.MajorUnits(5000, "USD"), LoanAmount: lib.MajorUnits(400000, "USD"), MonthlyObligations: lib.MajorUnits(2500, "USD")}
+	expected, err := lib.ScoreCredit(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := n.decodeResponse(n.mustInvoke("score", n.scoreRequest(input)))
+	if err := response.Validate("request-1"); err != nil {
+		t.Fatalf("invalid response %s", err)
+	}
+	if response.Model != lib.CreditModel || !reflect.DeepEqual(response.CreditScore, expected) {
+		t.Fatalf("expected %+v of %s, got %+v", expected, lib.CreditModel, response)
+	}
+	if len(response.Explanations) != 0 {
+		t.Fatalf("score explained the reasons %v", response.Explanations)
+	}
+
+	// explain describes every reason code of the same score
+	response = n.decodeResponse(n.mustInvoke("explain", n.scoreRequest(input)))
+	if response.Score != expected.Score || len(response.Explanations) != len(expected.Reasons) {
+		t.Fatalf("expected %d explained by %d reasons, got %+v", expected.Score, len(expected.Reasons), response)
+	}
+	for _, reason := range expected.Reasons {
+		if response.Explanations[reason] == "" {
+			t.Fatalf("reason %s is not explained", reason)
+		}
+	}
+
+	// query scores the same input from positional arguments
+	score := lib.CreditScore{}
+	err = json.Unmarshal(n.mustInvoke("query", "5000", "400000", "2500"), &score)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(score, expected) {
+		t.Fatalf("query scored %+v instead of %+v", score, expected)
+	}
+}
+
+func TestScoreRejectsInvalidRequests(t *testing.T) {
+	n := newTestNetwork(t)
+	valid := n.scoreRequest(lib.CreditInput{Salary: lib.MajorUnits(15000, "USD"), LoanAmount: lib.MajorUnits(200000, "USD")})
+
+	n.mustFail("score")
+	n.mustFail("score", valid, valid)
+	n.mustFail("score", `{"RequestHash":`)
+	n.mustFail("score", n.scoreRequest(lib.CreditInput{Salary: lib.MajorUnits(15000, "EUR"), LoanAmount: lib.MajorUnits(200000, "USD")}))
+	n.mustFail("explain", n.scoreRequest(lib.CreditInput{Salary: lib.MajorUnits(15000, "USD")}))
+	n.mustFail("query", "15000")
+	n.mustFail("query", "15000", "200000", "0", "-")
+	n.mustFail("rate", valid)
+}
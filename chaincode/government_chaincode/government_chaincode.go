@@ -1,32 +1,43 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"math/rand"
 
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
 	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
-type Request struct {
-	Hash         string          `json:"Hash"`
-	PropertyHash string          `json:"Name"`
-	Buyer        string          `json:"Buyer"`
-	Salary       int             `json:"TotalSupply"`
-	LoanAmount   int             `json:"LoanAmount"`
-	Status       int             `json:"Status"`
-	Statuses     []RequestStatus `json:"RequestStatuses"`
-	Timestamp    int             `json:"Timestamp"`
+//HomelendChaincode - the land registry: title records of properties, their liens and warnings, kept by the government
+type HomelendChaincode struct {
+	// identityProvider resolves the caller, cid is used when it is nil
+	identityProvider IdentityProvider
 }
 
-type RequestStatus struct {
-	Status    int `json:"Status"`
-	Timestamp int `json:"Timestamp"`
+//IdentityProvider - resolves the identity and MSP of the transaction creator
+type IdentityProvider interface {
+	GetID(stub shim.ChaincodeStubInterface) (string, error)
+	GetMSPID(stub shim.ChaincodeStubInterface) (string, error)
 }
 
-type HomelendChaincode struct {
+//cidIdentityProvider - IdentityProvider backed by the client identity library
+type cidIdentityProvider struct {
+}
+
+func (p cidIdentityProvider) GetID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetID(stub)
+}
+
+func (p cidIdentityProvider) GetMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetMSPID(stub)
+}
+
+func (t *HomelendChaincode) identities() IdentityProvider {
+	if t.identityProvider == nil {
+		return cidIdentityProvider{}
+	}
+	return t.identityProvider
 }
 
 // Init initializes chaincode
@@ -40,7 +51,7 @@ func (t *HomelendChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
-	identity, err := cid.GetID(stub)
+	identity, err := t.identities().GetID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("Identity error %+v", args)
@@ -48,7 +59,7 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return shim.Error(str)
 	}
 
-	mspid, err := cid.GetMSPID(stub)
+	mspid, err := t.identities().GetMSPID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", args)
@@ -58,43 +69,62 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 
 	fmt.Println(fmt.Printf("Access log %s %s", identity, mspid))
 
-	if function == "query" {
-		numOfArgsResult := t.validateNumOfArgs(stub, args, 1)
-		if len(numOfArgsResult) > 0 {
-			return shim.Error(numOfArgsResult)
-		}
-
-		return t.query(stub, args[0], args[1])
-	} else if function == "checkHouseOwner" {
-		numOfArgsResult := t.validateNumOfArgs(stub, args, 1)
-		if len(numOfArgsResult) > 0 {
-			return shim.Error(numOfArgsResult)
-		}
-
-		request := getRequest(args[0])
-		return t.checkHouseOwner(stub, request)
-	} else if function == "checkLien" {
-		numOfArgsResult := t.validateNumOfArgs(stub, args, 1)
-		if len(numOfArgsResult) > 0 {
-			return shim.Error(numOfArgsResult)
-		}
-
-		request := getRequest(args[0])
-		return t.checkLien(stub, request)
-	} else if function == "checkWarningShot" {
-		numOfArgsResult := t.validateNumOfArgs(stub, args, 1)
+	if numOfArgs, ok := functionArgs[function]; ok {
+		numOfArgsResult := t.validateNumOfArgs(stub, args, numOfArgs)
 		if len(numOfArgsResult) > 0 {
 			return shim.Error(numOfArgsResult)
 		}
+	}
 
-		request := getRequest(args[0])
-		return t.checkWarningShot(stub, request)
+	if function == "query" || function == "getTitle" {
+		return t.getTitleInfo(stub, args)
+	} else if function == "checkHouseOwner" || function == "checkLien" || function == "checkWarningShot" {
+		return t.check(stub, args, function)
+	} else if function == "checkTitle" {
+		return t.checkAll(stub, args)
+	} else if function == "registerTitle" {
+		return t.registerTitle(stub, args)
+	} else if function == "transferTitle" {
+		return t.transferTitle(stub, args)
+	} else if function == "addLien" {
+		return t.addLien(stub, args)
+	} else if function == "releaseLien" {
+		return t.releaseLien(stub, args)
+	} else if function == "addWarning" {
+		return t.addWarning(stub, args)
+	} else if function == "releaseWarning" {
+		return t.releaseWarning(stub, args)
+	} else if function == "settle" {
+		return t.settle(stub, args)
+	} else if function == "setSettlementChaincodes" {
+		return t.setSettlementChaincodes(stub, args)
+	} else if function == "getSettlementChaincodes" {
+		return t.getSettlementChaincodesInfo(stub, args)
 	}
 
 	fmt.Println("invoke did not find func: " + function) //error
 	return shim.Error("Received unknown function invocation")
 }
 
+//functionArgs - the number of arguments of every function
+var functionArgs = map[string]int{
+	"query":                   1,
+	"getTitle":                1,
+	"checkHouseOwner":         1,
+	"checkLien":               1,
+	"checkWarningShot":        1,
+	"checkTitle":              1,
+	"registerTitle":           1,
+	"transferTitle":           2,
+	"addLien":                 2,
+	"releaseLien":             2,
+	"addWarning":              2,
+	"releaseWarning":          2,
+	"settle":                  1,
+	"setSettlementChaincodes": 1,
+	"getSettlementChaincodes": 0,
+}
+
 func (t *HomelendChaincode) validateNumOfArgs(stub shim.ChaincodeStubInterface, args []string, count int) string {
 	if len(args) != count {
 		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
@@ -104,69 +134,30 @@ func (t *HomelendChaincode) validateNumOfArgs(stub shim.ChaincodeStubInterface,
 	return ""
 }
 
-func (t *HomelendChaincode) query(stub shim.ChaincodeStubInterface, arg1 string, arg2 string) pb.Response {
-	fmt.Println(fmt.Sprintf("government started"))
-
-	return shim.Success([]byte("OK"))
-}
+//check - args: lib.TitleCheckRequest JSON. Returns the lib.TitleCheckResult of the check answered from the registry
+func (t *HomelendChaincode) check(stub shim.ChaincodeStubInterface, args []string, check string) pb.Response {
+	fmt.Println(fmt.Sprintf("government %s", check))
 
-func getRequest(requestStr string) *Request {
-	data := &Request{}
-	err := json.Unmarshal([]byte(requestStr), data)
+	results, err := t.checkTitle(stub, args, []string{check})
 	if err != nil {
-		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		str := fmt.Sprintf("%s error %+v", check, err)
 		fmt.Println(str)
-		return nil
-	}
-	return data
-}
-
-func (t *HomelendChaincode) checkLien(stub shim.ChaincodeStubInterface, request *Request) pb.Response {
-	fmt.Println(fmt.Sprintf("government checkLien"))
-
-	if request != nil {
-		val := rand.Intn(100)
-		result := val > 95
-		if result {
-			s := make([]byte, 1)
-			return shim.Success(s)
-		}
-		s := make([]byte, 0)
-		return shim.Success(s)
-	}
-	return shim.Error("Request is null")
-}
-
-func (t *HomelendChaincode) checkHouseOwner(stub shim.ChaincodeStubInterface, request *Request) pb.Response {
-	fmt.Println(fmt.Sprintf("government checkHouseOwner"))
-
-	if request != nil {
-		val := rand.Intn(100)
-		result := val > 95
-		if result {
-			s := make([]byte, 1)
-			return shim.Success(s)
-		}
-		s := make([]byte, 0)
-		return shim.Success(s)
+		return shim.Error(str)
 	}
-	return shim.Error("Request is null")
+	return t.marshalResponse(results[0])
 }
 
-func (t *HomelendChaincode) checkWarningShot(stub shim.ChaincodeStubInterface, request *Request) pb.Response {
-	fmt.Println(fmt.Sprintf("government checkWarningShot"))
+//checkAll - args: lib.TitleCheckRequest JSON. Returns the results of all lib.TitleChecks against the same version of the record
+func (t *HomelendChaincode) checkAll(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("government checkTitle"))
 
-	if request != nil {
-		val := rand.Intn(100)
-		result := val > 95
-		if result {
-			s := make([]byte, 1)
-			return shim.Success(s)
-		}
-		s := make([]byte, 0)
-		return shim.Success(s)
+	results, err := t.checkTitle(stub, args, lib.TitleChecks)
+	if err != nil {
+		str := fmt.Sprintf("checkTitle error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
 	}
-	return shim.Error("Request is null")
+	return t.marshalResponse(results)
 }
 
 // ===================================================================================
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// MSP IDs of the organizations taking part in the sales
+const (
+	bankMSP   = "POCBankMSP"
+	buyerMSP  = "POCBuyerMSP"
+	sellerMSP = "POCSellerMSP"
+)
+
+// identities used by the tests, one per role
+const (
+	governmentID = "government-1"
+	sellerID     = "seller-1"
+	buyerID      = "buyer-1"
+	bankID       = "bank-1"
+	newBankID    = "bank-2"
+
+	propertyHash = "property-1"
+	parcelID     = "6106-52"
+)
+
+//fakeIdentityProvider - lets a test impersonate any MSP
+type fakeIdentityProvider struct {
+	id    string
+	mspid string
+}
+
+func (p *fakeIdentityProvider) GetID(stub shim.ChaincodeStubInterface) (string, error) {
+	return p.id, nil
+}
+
+func (p *fakeIdentityProvider) GetMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return p.mspid, nil
+}
+
+//testNetwork - a MockStub running government_chaincode plus the identity it is invoked with
+type testNetwork struct {
+	t        *testing.T
+	stub     *shim.MockStub
+	identity *fakeIdentityProvider
+	// the chaincode the transaction proposal was sent to, empty when the client invoked government_chaincode itself
+	caller  string
+	txCount int
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	identity := &fakeIdentityProvider{}
+	cc := &HomelendChaincode{identityProvider: identity}
+	n := &testNetwork{t: t, stub: shim.NewMockStub("government_chaincode", cc), identity: identity}
+
+	res := n.stub.MockInit(n.nextTxID(), [][]byte{[]byte("init")})
+	if res.Status != shim.OK {
+		t.Fatalf("init failed: %s", res.Message)
+	}
+	return n
+}
+
+func (n *testNetwork) nextTxID() string {
+	n.txCount++
+	return fmt.Sprintf("tx%d", n.txCount)
+}
+
+//as - switches the identity used by the following invocations, sent by the client itself
+func (n *testNetwork) as(mspid string, id string) *testNetwork {
+	n.identity.mspid = mspid
+	n.identity.id = id
+	n.caller = ""
+	return n
+}
+
+//through - the following invocations come from the client of chaincode, which invokes government_chaincode
+func (n *testNetwork) through(chaincode string) *testNetwork {
+	n.caller = chaincode
+	return n
+}
+
+//signedProposal - a proposal sent to chaincode, as GetCallingChaincode reads it
+func (n *testNetwork) signedProposal(chaincode string) *pb.SignedProposal {
+	n.t.Helper()
+	extension, err := proto.Marshal(&pb.ChaincodeHeaderExtension{ChaincodeId: &pb.ChaincodeID{Name: chaincode}})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{Extension: extension})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	header, err := proto.Marshal(&common.Header{ChannelHeader: channelHeader})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	proposal, err := proto.Marshal(&pb.Proposal{Header: header})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return &pb.SignedProposal{ProposalBytes: proposal}
+}
+
+func (n *testNetwork) invoke(function string, args ...string) pb.Response {
+	input := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+	if n.caller == "" {
+		return n.stub.MockInvoke(n.nextTxID(), input)
+	}
+	return n.stub.MockInvokeWithSignedProposal(n.nextTxID(), input, n.signedProposal(n.caller))
+}
+
+func (n *testNetwork) mustInvoke(function string, args ...string) []byte {
+	n.t.Helper()
+	res := n.invoke(function, args...)
+	if res.Status != shim.OK {
+		n.t.Fatalf("%s as %s failed: %s", function, n.identity.mspid, res.Message)
+	}
+	return res.Payload
+}
+
+func (n *testNetwork) mustFail(function string, args ...string) string {
+	n.t.Helper()
+	res := n.invoke(function, args...)
+	if res.Status == shim.OK {
+		n.t.Fatalf("%s as %s was expected to fail", function, n.identity.mspid)
+	}
+	return res.Message
+}
+
+//marshal - value as the JSON argument of a function
+func (n *testNetwork) marshal(value interface{}) string {
+	n.t.Helper()
+	dataAsBytes, err := json.Marshal(value)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return string(dataAsBytes)
+}
+
+func (n *testNetwork) decodeTitle(payload []byte) *lib.TitleRecord {
+	n.t.Helper()
+	record := &lib.TitleRecord{}
+	err := json.Unmarshal(payload, record)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return record
+}
+
+//register - the government registers the property to the seller
+func (n *testNetwork) register() *lib.TitleRecord {
+	n.t.Helper()
+	record := lib.TitleRecord{PropertyHash: propertyHash, ParcelID: parcelID, OwnerHash: sellerID}
+	return n.decodeTitle(n.as(governmentMSP, governmentID).mustInvoke("registerTitle", n.marshal(record)))
+}
+
+//title - the title record as anyone reads it
+func (n *testNetwork) title() *lib.TitleRecord {
+	n.t.Helper()
+	return n.decodeTitle(n.as(buyerMSP, buyerID).mustInvoke("getTitle", propertyHash))
+}
+
+//mortgage - the lien of holder on the property
+func mortgage(id string, holder string) lib.Lien {
+	return lib.Lien{ID: id, HolderHash: holder, Amount: lib.NewMoney(20000000, lib.DefaultCurrency)}
+}
+
+func TestCheckTitle(t *testing.T) {
+	n := newTestNetwork(t)
+	sale := n.marshal(lib.TitleCheckRequest{PropertyHash: propertyHash, SellerHash: sellerID, BuyerHash: buyerID})
+
+	// an unregistered property fails every check
+	results := make([]lib.TitleCheckResult, 0)
+	err := json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("checkTitle", sale), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(lib.TitleChecks) {
+		t.Fatalf("expected %d results, got %d", len(lib.TitleChecks), len(results))
+	}
+	for _, result := range results {
+		if result.Passed || result.Version != 0 {
+			t.Fatalf("%s of an unregistered property passed", result.Check)
+		}
+	}
+
+	n.register()
+	n.as(governmentMSP, governmentID).mustInvoke("addLien", propertyHash, n.marshal(mortgage("lien-1", bankID)))
+
+	checks := map[string]bool{lib.CheckHouseOwner: true, lib.CheckLien: false, lib.CheckWarningShot: true}
+	for check, passed := range checks {
+		result := lib.TitleCheckResult{}
+		err := json.Unmarshal(n.as(bankMSP, bankID).mustInvoke(check, sale), &result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Check != check || result.Passed != passed || result.Version != 2 || result.ParcelID != parcelID {
+			t.Fatalf("unexpected result of %s %+v", check, result)
+		}
+	}
+	n.mustFail("checkTitle", `{"SellerHash":"seller-1"}`)
+}
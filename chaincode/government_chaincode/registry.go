@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//every property has one title record under its own key
+const titleIndex = "title~property"
+
+//the MSP maintaining the land registry
+const governmentMSP = "POCGovernmentMSP"
+
+//requireGovernment - only members of the government MSP change the registry
+func (t *HomelendChaincode) requireGovernment(stub shim.ChaincodeStubInterface) error {
+	mspid, err := t.identities().GetMSPID(stub)
+	if err != nil {
+		str := fmt.Sprintf("MSPID error %+v", err)
+		return errors.New(str)
+	}
+	if mspid != governmentMSP {
+		str := fmt.Sprintf("%s may not change the land registry", mspid)
+		return errors.New(str)
+	}
+	return nil
+}
+
+//getTitle - returns nil, nil when the property is not registered
+func (t *HomelendChaincode) getTitle(stub shim.ChaincodeStubInterface, propertyHash string) (*lib.TitleRecord, error) {
+	key, err := stub.CreateCompositeKey(titleIndex, []string{propertyHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		return nil, nil
+	}
+
+	record := &lib.TitleRecord{}
+	err = json.Unmarshal(dataAsBytes, record)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal title record %s: %s", propertyHash, err)
+		return nil, errors.New(str)
+	}
+	return record, nil
+}
+
+//putTitle - stores the record as its next version
+func (t *HomelendChaincode) putTitle(stub shim.ChaincodeStubInterface, record *lib.TitleRecord, timestamp time.Time) error {
+	record.Version++
+	record.Timestamp = timestamp
+
+	key, err := stub.CreateCompositeKey(titleIndex, []string{record.PropertyHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return errors.New(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(record)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+//changeTitle - lets change modify the registered title record of propertyHash and stores it as the next version
+func (t *HomelendChaincode) changeTitle(stub shim.ChaincodeStubInterface, propertyHash string, change func(record *lib.TitleRecord, timestamp time.Time) error) pb.Response {
+	err := t.requireGovernment(stub)
+	if err != nil {
+		str := fmt.Sprintf("requireGovernment error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.updateTitle(stub, propertyHash, change)
+}
+
+//updateTitle - changeTitle for a caller that is already authorized
+func (t *HomelendChaincode) updateTitle(stub shim.ChaincodeStubInterface, propertyHash string, change func(record *lib.TitleRecord, timestamp time.Time) error) pb.Response {
+	record, err := t.getTitle(stub, propertyHash)
+	if err != nil {
+		str := fmt.Sprintf("getTitle error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if record == nil {
+		str := fmt.Sprintf("property %s has no title record", propertyHash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = change(record, timestamp)
+	if err != nil {
+		str := fmt.Sprintf("Could not change the title of %s %+v", propertyHash, err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putTitle(stub, record, timestamp)
+	if err != nil {
+		str := fmt.Sprintf("putTitle error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(record)
+}
+
+func (t *HomelendChaincode) marshalResponse(value interface{}) pb.Response {
+	dataAsBytes, err := json.Marshal(value)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return shim.Success(dataAsBytes)
+}
+
+//registerTitle - args: TitleRecord JSON with PropertyHash, ParcelID and OwnerHash. Liens and warnings are added one by one
+func (t *HomelendChaincode) registerTitle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("registerTitle executed with args: %+v", args))
+
+	err := t.requireGovernment(stub)
+	if err != nil {
+		str := fmt.Sprintf("requireGovernment error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	data := &lib.TitleRecord{}
+	err = json.Unmarshal([]byte(args[0]), data)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if data.PropertyHash == "" || data.ParcelID == "" || data.OwnerHash == "" {
+		str := "PropertyHash, ParcelID and OwnerHash are required"
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	existing, err := t.getTitle(stub, data.PropertyHash)
+	if err != nil {
+		str := fmt.Sprintf("getTitle error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if existing != nil {
+		str := fmt.Sprintf("property %s is already registered as parcel %s", data.PropertyHash, existing.ParcelID)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	record := &lib.TitleRecord{PropertyHash: data.PropertyHash, ParcelID: data.ParcelID, OwnerHash: data.OwnerHash, Liens: []lib.Lien{}, Warnings: []lib.Warning{}}
+	err = t.putTitle(stub, record, timestamp)
+	if err != nil {
+		str := fmt.Sprintf("putTitle error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(record)
+}
+
+//transferTitle - args: propertyHash, new owner hash
+func (t *HomelendChaincode) transferTitle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("transferTitle executed with args: %+v", args))
+
+	ownerHash := args[1]
+	if ownerHash == "" {
+		str := "the new owner can not be empty"
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.changeTitle(stub, args[0], func(record *lib.TitleRecord, timestamp time.Time) error {
+		record.OwnerHash = ownerHash
+		return nil
+	})
+}
+
+//addLien - args: propertyHash, Lien JSON with a unique ID, the HolderHash and the Amount
+func (t *HomelendChaincode) addLien(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("addLien executed with args: %+v", args))
+
+	lien := lib.Lien{}
+	err := json.Unmarshal([]byte(args[1]), &lien)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.changeTitle(stub, args[0], func(record *lib.TitleRecord, timestamp time.Time) error {
+		return lib.ApplyTitleChange(record, lib.TitleChange{Lien: &lien}, timestamp)
+	})
+}
+
+//releaseLien - args: propertyHash, lien ID
+func (t *HomelendChaincode) releaseLien(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("releaseLien executed with args: %+v", args))
+
+	lienID := args[1]
+	return t.changeTitle(stub, args[0], func(record *lib.TitleRecord, timestamp time.Time) error {
+		return lib.ApplyTitleChange(record, lib.TitleChange{ReleaseLienID: lienID}, timestamp)
+	})
+}
+
+//addWarning - args: propertyHash, Warning JSON with a unique ID, the BeneficiaryHash and a Description
+func (t *HomelendChaincode) addWarning(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("addWarning executed with args: %+v", args))
+
+	warning := lib.Warning{}
+	err := json.Unmarshal([]byte(args[1]), &warning)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if warning.ID == "" || warning.BeneficiaryHash == "" {
+		str := "a warning needs an ID and a BeneficiaryHash"
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.changeTitle(stub, args[0], func(record *lib.TitleRecord, timestamp time.Time) error {
+		for _, existing := range record.Warnings {
+			if existing.ID == warning.ID {
+				return fmt.Errorf("warning %s already exists", warning.ID)
+			}
+		}
+		warning.Released = false
+		warning.Timestamp = timestamp
+		record.Warnings = append(record.Warnings, warning)
+		return nil
+	})
+}
+
+//releaseWarning - args: propertyHash, warning ID
+func (t *HomelendChaincode) releaseWarning(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("releaseWarning executed with args: %+v", args))
+
+	warningID := args[1]
+	return t.changeTitle(stub, args[0], func(record *lib.TitleRecord, timestamp time.Time) error {
+		for i := range record.Warnings {
+			if record.Warnings[i].ID == warningID && !record.Warnings[i].Released {
+				record.Warnings[i].Released = true
+				record.Warnings[i].Timestamp = timestamp
+				return nil
+			}
+		}
+		return fmt.Errorf("no active warning %s", warningID)
+	})
+}
+
+//getTitleInfo - args: propertyHash. Returns the title record with every lien and warning, released ones included
+func (t *HomelendChaincode) getTitleInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	record, err := t.getTitle(stub, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getTitle error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if record == nil {
+		str := fmt.Sprintf("property %s has no title record", args[0])
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(record)
+}
+
+//checkTitle - args: lib.TitleCheckRequest JSON. Returns the lib.TitleCheckResult of each of checks
+func (t *HomelendChaincode) checkTitle(stub shim.ChaincodeStubInterface, args []string, checks []string) ([]lib.TitleCheckResult, error) {
+	request := lib.TitleCheckRequest{}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		return nil, errors.New(str)
+	}
+	if request.PropertyHash == "" {
+		return nil, errors.New("PropertyHash is required")
+	}
+
+	record, err := t.getTitle(stub, request.PropertyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]lib.TitleCheckResult, 0)
+	for _, check := range checks {
+		result, err := lib.CheckTitle(record, check, request)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisterTitle(t *testing.T) {
+	n := newTestNetwork(t)
+
+	// only the government changes the registry
+	n.as(bankMSP, bankID).mustFail("registerTitle", n.marshal(map[string]string{"PropertyHash": propertyHash, "ParcelID": parcelID, "OwnerHash": sellerID}))
+	n.as(governmentMSP, governmentID).mustFail("registerTitle", `{"PropertyHash":"property-1","ParcelID":"6106-52"}`)
+	n.mustFail("getTitle", propertyHash)
+
+	record := n.register()
+	if record.OwnerHash != sellerID || record.Version != 1 || record.Timestamp.IsZero() {
+		t.Fatalf("unexpected title record %+v", record)
+	}
+	if stored := n.title(); stored.ParcelID != parcelID || stored.OwnerHash != sellerID || stored.Version != 1 {
+		t.Fatalf("expected the title record to be stored, got %+v", stored)
+	}
+
+	// a property is registered once
+	if msg := n.as(governmentMSP, governmentID).mustFail("registerTitle", n.marshal(record)); !strings.Contains(msg, "already registered") {
+		t.Fatalf("unexpected error %s", msg)
+	}
+}
+
+func TestTransferTitle(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(governmentMSP, governmentID).mustFail("transferTitle", propertyHash, buyerID)
+	n.register()
+
+	n.as(sellerMSP, sellerID).mustFail("transferTitle", propertyHash, buyerID)
+	n.as(governmentMSP, governmentID).mustFail("transferTitle", propertyHash, "")
+
+	record := n.decodeTitle(n.mustInvoke("transferTitle", propertyHash, buyerID))
+	if record.OwnerHash != buyerID || record.Version != 2 {
+		t.Fatalf("expected version 2 owned by %s, got %+v", buyerID, record)
+	}
+	if stored := n.title(); stored.OwnerHash != buyerID {
+		t.Fatalf("expected the transfer to be stored, got %+v", stored)
+	}
+}
+
+func TestLiens(t *testing.T) {
+	n := newTestNetwork(t)
+	n.register()
+
+	n.as(bankMSP, bankID).mustFail("addLien", propertyHash, n.marshal(mortgage("lien-1", bankID)))
+	n.as(governmentMSP, governmentID).mustFail("addLien", propertyHash, `{"ID":"lien-1","HolderHash":"bank-1"}`)
+
+	record := n.decodeTitle(n.mustInvoke("addLien", propertyHash, n.marshal(mortgage("lien-1", bankID))))
+	if len(record.ActiveLiens()) != 1 || record.Version != 2 {
+		t.Fatalf("expected one active lien in version 2, got %+v", record)
+	}
+	if msg := n.mustFail("addLien", propertyHash, n.marshal(mortgage("lien-1", newBankID))); !strings.Contains(msg, "already exists") {
+		t.Fatalf("unexpected error %s", msg)
+	}
+
+	n.as(bankMSP, bankID).mustFail("releaseLien", propertyHash, "lien-1")
+	record = n.decodeTitle(n.as(governmentMSP, governmentID).mustInvoke("releaseLien", propertyHash, "lien-1"))
+	if len(record.ActiveLiens()) != 0 || len(record.Liens) != 1 || record.Version != 3 {
+		t.Fatalf("expected the released lien to stay on version 3, got %+v", record)
+	}
+	n.mustFail("releaseLien", propertyHash, "lien-1")
+}
+
+func TestWarnings(t *testing.T) {
+	n := newTestNetwork(t)
+	n.register()
+	warning := `{"ID":"warning-1","BeneficiaryHash":"buyer-1","Description":"sale agreement"}`
+
+	n.as(buyerMSP, buyerID).mustFail("addWarning", propertyHash, warning)
+	n.as(governmentMSP, governmentID).mustFail("addWarning", propertyHash, `{"ID":"warning-1"}`)
+
+	record := n.decodeTitle(n.mustInvoke("addWarning", propertyHash, warning))
+	if len(record.Warnings) != 1 || record.Warnings[0].Released || record.Version != 2 {
+		t.Fatalf("expected an active warning in version 2, got %+v", record)
+	}
+	n.mustFail("addWarning", propertyHash, warning)
+
+	record = n.decodeTitle(n.mustInvoke("releaseWarning", propertyHash, "warning-1"))
+	if !record.Warnings[0].Released || record.Version != 3 {
+		t.Fatalf("expected the warning to be released in version 3, got %+v", record)
+	}
+	n.mustFail("releaseWarning", propertyHash, "warning-1")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//the chaincodes that record the settlements of their sales and mortgages in the registry
+const settlementChaincodesKey = "settlementChaincodes"
+
+var defaultSettlementChaincodes = []string{"lending_chaincode"}
+
+//getSettlementChaincodes - returns defaultSettlementChaincodes until the government sets its own
+func (t *HomelendChaincode) getSettlementChaincodes(stub shim.ChaincodeStubInterface) ([]string, error) {
+	dataAsBytes, err := stub.GetState(settlementChaincodesKey)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		return defaultSettlementChaincodes, nil
+	}
+
+	chaincodes := make([]string, 0)
+	err = json.Unmarshal(dataAsBytes, &chaincodes)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal settlement chaincodes %+v", err)
+		return nil, errors.New(str)
+	}
+	return chaincodes, nil
+}
+
+//requireSettlement - settlements are recorded by the government or by one of the settlement chaincodes
+func (t *HomelendChaincode) requireSettlement(stub shim.ChaincodeStubInterface) error {
+	if t.requireGovernment(stub) == nil {
+		return nil
+	}
+
+	helpers := lib.Helpers{}
+	caller, err := helpers.GetCallingChaincode(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetCallingChaincode error %+v", err)
+		return errors.New(str)
+	}
+	chaincodes, err := t.getSettlementChaincodes(stub)
+	if err != nil {
+		return err
+	}
+	for _, chaincode := range chaincodes {
+		if chaincode == caller {
+			return nil
+		}
+	}
+	str := fmt.Sprintf("%s may not record settlements in the land registry", caller)
+	return errors.New(str)
+}
+
+//settle - args: lib.TitleChange JSON. Records the closing or the payoff of a mortgage: releases a lien, transfers the title
+//and registers a new lien as one new version of the title record, see lib.ApplyTitleChange
+func (t *HomelendChaincode) settle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("settle executed with args: %+v", args))
+
+	err := t.requireSettlement(stub)
+	if err != nil {
+		str := fmt.Sprintf("requireSettlement error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	change := lib.TitleChange{}
+	err = json.Unmarshal([]byte(args[0]), &change)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if change.PropertyHash == "" {
+		str := "PropertyHash is required"
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.updateTitle(stub, change.PropertyHash, func(record *lib.TitleRecord, timestamp time.Time) error {
+		return lib.ApplyTitleChange(record, change, timestamp)
+	})
+}
+
+//setSettlementChaincodes - args: JSON array of the chaincode names allowed to call settle
+func (t *HomelendChaincode) setSettlementChaincodes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setSettlementChaincodes executed with args: %+v", args))
+
+	err := t.requireGovernment(stub)
+	if err != nil {
+		str := fmt.Sprintf("requireGovernment error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	chaincodes := make([]string, 0)
+	err = json.Unmarshal([]byte(args[0]), &chaincodes)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(settlementChaincodesKey, []byte(args[0]))
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(chaincodes)
+}
+
+//getSettlementChaincodesInfo - returns the chaincode names allowed to call settle
+func (t *HomelendChaincode) getSettlementChaincodesInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	chaincodes, err := t.getSettlementChaincodes(stub)
+	if err != nil {
+		str := fmt.Sprintf("getSettlementChaincodes error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(chaincodes)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+//closing - the sale of the property from the seller to the buyer, mortgaged to bankID
+func closing() lib.TitleChange {
+	lien := mortgage("lien-1", bankID)
+	return lib.TitleChange{RequestHash: "request-1", PropertyHash: propertyHash, SellerHash: sellerID, BuyerHash: buyerID, Lien: &lien}
+}
+
+func TestSettleClosing(t *testing.T) {
+	n := newTestNetwork(t)
+	n.register()
+	n.as(governmentMSP, governmentID).mustInvoke("addWarning", propertyHash, `{"ID":"warning-1","BeneficiaryHash":"buyer-1","Description":"sale agreement"}`)
+
+	// the transfer, the lien and the release of the warning of the buyer are one new version
+	record := n.decodeTitle(n.as(bankMSP, bankID).through("lending_chaincode").mustInvoke("settle", n.marshal(closing())))
+	if record.OwnerHash != buyerID || record.Version != 3 {
+		t.Fatalf("expected version 3 owned by %s, got %+v", buyerID, record)
+	}
+	if liens := record.ActiveLiens(); len(liens) != 1 || liens[0].HolderHash != bankID {
+		t.Fatalf("expected the lien of %s, got %+v", bankID, record.Liens)
+	}
+	if !record.Warnings[0].Released {
+		t.Fatalf("expected the warning of the buyer to be released")
+	}
+	if stored := n.title(); stored.Version != 3 || stored.OwnerHash != buyerID {
+		t.Fatalf("expected the settlement to be stored, got %+v", stored)
+	}
+
+	// the sale is settled once, the seller no longer owns the property
+	if msg := n.as(bankMSP, bankID).through("lending_chaincode").mustFail("settle", n.marshal(closing())); !strings.Contains(msg, "not by the seller") {
+		t.Fatalf("unexpected error %s", msg)
+	}
+	if stored := n.title(); stored.Version != 3 {
+		t.Fatalf("a failed settlement changed the title record to version %d", stored.Version)
+	}
+}
+
+func TestSettleRefinance(t *testing.T) {
+	n := newTestNetwork(t)
+	n.register()
+	n.as(bankMSP, bankID).through("lending_chaincode").mustInvoke("settle", n.marshal(closing()))
+
+	// the lien of the refinanced loan is released for the lien of the new lender
+	lien := mortgage("lien-2", newBankID)
+	refinance := lib.TitleChange{RequestHash: "request-1", PropertyHash: propertyHash, ReleaseLienID: "lien-1", Lien: &lien}
+	record := n.decodeTitle(n.as(bankMSP, newBankID).through("lending_chaincode").mustInvoke("settle", n.marshal(refinance)))
+	if liens := record.ActiveLiens(); len(liens) != 1 || liens[0].ID != "lien-2" || record.OwnerHash != buyerID {
+		t.Fatalf("expected the lien of %s only, got %+v", newBankID, record)
+	}
+
+	// the payoff releases the last lien
+	payoff := lib.TitleChange{RequestHash: "request-1", PropertyHash: propertyHash, ReleaseLienID: "lien-2"}
+	record = n.decodeTitle(n.through("lending_chaincode").mustInvoke("settle", n.marshal(payoff)))
+	if len(record.ActiveLiens()) != 0 || len(record.Liens) != 2 || record.Version != 4 {
+		t.Fatalf("expected both liens released in version 4, got %+v", record)
+	}
+	n.through("lending_chaincode").mustFail("settle", n.marshal(payoff))
+}
+
+func TestSettleOnlyBySettlementChaincode(t *testing.T) {
+	n := newTestNetwork(t)
+	n.register()
+
+	// a client does not settle by itself, nor through a chaincode that does not record settlements
+	n.as(bankMSP, bankID).mustFail("settle", n.marshal(closing()))
+	if msg := n.through("mortgage_chaincode").mustFail("settle", n.marshal(closing())); !strings.Contains(msg, "may not record settlements") {
+		t.Fatalf("unexpected error %s", msg)
+	}
+	n.through("lending_chaincode").mustFail("settle", `{"SellerHash":"seller-1"}`)
+
+	// the government decides which chaincodes record settlements
+	n.as(bankMSP, bankID).mustFail("setSettlementChaincodes", `["mortgage_chaincode"]`)
+	n.as(governmentMSP, governmentID).mustInvoke("setSettlementChaincodes", `["mortgage_chaincode"]`)
+	chaincodes := make([]string, 0)
+	err := json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("getSettlementChaincodes"), &chaincodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chaincodes) != 1 || chaincodes[0] != "mortgage_chaincode" {
+		t.Fatalf("unexpected settlement chaincodes %v", chaincodes)
+	}
+	n.through("lending_chaincode").mustFail("settle", n.marshal(closing()))
+	n.through("mortgage_chaincode").mustInvoke("settle", n.marshal(closing()))
+
+	// the government records a settlement itself
+	payoff := lib.TitleChange{PropertyHash: propertyHash, ReleaseLienID: "lien-1"}
+	record := n.decodeTitle(n.as(governmentMSP, governmentID).mustInvoke("settle", n.marshal(payoff)))
+	if len(record.ActiveLiens()) != 0 {
+		t.Fatalf("expected the lien to be released, got %+v", record.Liens)
+	}
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reason codes explaining what lowered or limited a credit score
+const (
+	ReasonLowIncome          = "LOW_INCOME"
+	ReasonMissingIncome      = "MISSING_INCOME"
+	ReasonHighLoanToIncome   = "HIGH_LOAN_TO_INCOME"
+	ReasonHighDebtToIncome   = "HIGH_DEBT_TO_INCOME"
+	ReasonLatePayments       = "LATE_PAYMENTS"
+	ReasonPriorDefault       = "PRIOR_DEFAULT"
+	ReasonNoRepaymentHistory = "NO_REPAYMENT_HISTORY"
+)
+
+// ReasonDescriptions - what each reason code means, returned by the "explain" function of the creditscore chaincode
+var ReasonDescriptions = map[string]string{
+	ReasonLowIncome:          "the monthly salary is below 10000",
+	ReasonMissingIncome:      "no salary was given",
+	ReasonHighLoanToIncome:   "the loan is more than five years of salary",
+	ReasonHighDebtToIncome:   "payments of existing loans are more than 40% of the salary",
+	ReasonLatePayments:       "installments of existing loans were paid late",
+	ReasonPriorDefault:       "an earlier loan defaulted or was foreclosed",
+	ReasonNoRepaymentHistory: "no installments of earlier loans are on the ledger",
+}
+
+// CreditModel - the name and version of the model ScoreCredit implements
+const CreditModel = "homelend-credit-1"
+
+// Bounds of the score and the lowest score of each grade, A is the best grade
+const (
+	MinCreditScore = 300
+	MaxCreditScore = 850
+	gradeAScore    = 720
+	gradeBScore    = 640
+)
+
+// CreditInput - what a credit score is computed from. Salary and MonthlyObligations, the payments of
+// the loans the borrower already has, are monthly amounts in the currency of the LoanAmount.
+// The payment counts are the installments of those loans paid on time and late, Defaults the loans that defaulted.
+type CreditInput struct {
+	Salary             Money `json:"Salary"`
+	LoanAmount         Money `json:"LoanAmount"`
+	MonthlyObligations Money `json:"MonthlyObligations"`
+	PaymentsOnTime     int   `json:"PaymentsOnTime"`
+	PaymentsLate       int   `json:"PaymentsLate"`
+	Defaults           int   `json:"Defaults"`
+}
+
+// CreditScore - the score between MinCreditScore and MaxCreditScore, its grade and the reason codes behind it
+type CreditScore struct {
+	Score   int      `json:"Score"`
+	Grade   string   `json:"Grade"`
+	Reasons []string `json:"Reasons"`
+}
+
+// CreditScoreRequest - the argument of the "score" and "explain" functions of the creditscore chaincode, as JSON
+type CreditScoreRequest struct {
+	RequestHash string      `json:"RequestHash"`
+	Input       CreditInput `json:"Input"`
+}
+
+// CreditScoreResponse - the payload of "score" and "explain", the score of the request by Model.
+// Explanations describe every reason code and are only filled by "explain".
+type CreditScoreResponse struct {
+	RequestHash string `json:"RequestHash"`
+	Model       string `json:"Model"`
+	CreditScore
+	Explanations map[string]string `json:"Explanations,omitempty"`
+}
+
+// Validate - checks a response received from another chaincode belongs to requestHash and holds a usable score
+func (r *CreditScoreResponse) Validate(requestHash string) error {
+	if r.RequestHash != requestHash {
+		return fmt.Errorf("the response is for request %q instead of %q", r.RequestHash, requestHash)
+	}
+	if r.Score < MinCreditScore || r.Score > MaxCreditScore {
+		return fmt.Errorf("score %d is outside %d..%d", r.Score, MinCreditScore, MaxCreditScore)
+	}
+	if len(r.Grade) != 1 || r.Grade[0] < 'A' || r.Grade[0] > 'Z' {
+		return fmt.Errorf("invalid credit grade %q", r.Grade)
+	}
+	return nil
+}
+
+// ExplainCredit - the description of every reason code of score
+func ExplainCredit(score CreditScore) map[string]string {
+	explanations := make(map[string]string)
+	for _, reason := range score.Reasons {
+		explanations[reason] = ReasonDescriptions[reason]
+	}
+	return explanations
+}
+
+// CreditGrade - A from 720, B from 640, C below
+func CreditGrade(score int) string {
+	switch {
+	case score >= gradeAScore:
+		return "A"
+	case score >= gradeBScore:
+		return "B"
+	default:
+		return "C"
+	}
+}
+
+// ScoreCredit - scores the borrower on income, the loan to yearly income, the debt to income of existing
+// loans and the repayment history. Every step compares exact amounts, so all peers and chaincodes agree.
+func ScoreCredit(input CreditInput) (CreditScore, error) {
+	if !input.Salary.SameCurrency(input.LoanAmount) || !input.MonthlyObligations.SameCurrency(input.LoanAmount) {
+		return CreditScore{}, errors.New("salary, loan and obligations must be in the same currency")
+	}
+	if input.Salary.IsNegative() || !input.LoanAmount.IsPositive() || input.MonthlyObligations.IsNegative() {
+		return CreditScore{}, errors.New("the loan must be positive, salary and obligations can not be negative")
+	}
+	if input.PaymentsOnTime < 0 || input.PaymentsLate < 0 || input.Defaults < 0 {
+		return CreditScore{}, errors.New("payment counts can not be negative")
+	}
+
+	score := 550
+	reasons := make([]string, 0)
+	currency := input.LoanAmount.Currency
+
+	// income bands are in major units of the currency of the loan, both bounds are inclusive
+	switch {
+	case !input.Salary.IsPositive():
+		reasons = append(reasons, ReasonMissingIncome)
+	case input.Salary.compare(MajorUnits(20000, currency)) >= 0:
+		score += 100
+	case input.Salary.compare(MajorUnits(10000, currency)) >= 0:
+		score += 50
+	default:
+		reasons = append(reasons, ReasonLowIncome)
+	}
+
+	// the loan against three and five years of salary
+	threeYears := NewMoney(input.Salary.Amount*36, currency)
+	fiveYears := NewMoney(input.Salary.Amount*60, currency)
+	switch {
+	case input.Salary.IsPositive() && input.LoanAmount.compare(threeYears) <= 0:
+		score += 50
+	case input.Salary.IsPositive() && input.LoanAmount.compare(fiveYears) <= 0:
+	default:
+		score -= 75
+		reasons = append(reasons, ReasonHighLoanToIncome)
+	}
+
+	switch {
+	case input.MonthlyObligations.compare(input.Salary.Percent(20)) <= 0:
+		score += 50
+	case input.MonthlyObligations.compare(input.Salary.Percent(40)) <= 0:
+	default:
+		score -= 100
+		reasons = append(reasons, ReasonHighDebtToIncome)
+	}
+
+	if input.PaymentsOnTime+input.PaymentsLate+input.Defaults == 0 {
+		reasons = append(reasons, ReasonNoRepaymentHistory)
+	}
+	score += minInt(input.PaymentsOnTime, 50)
+	if input.PaymentsLate > 0 {
+		score -= minInt(15*input.PaymentsLate, 150)
+		reasons = append(reasons, ReasonLatePayments)
+	}
+	if input.Defaults > 0 {
+		score -= 200 * input.Defaults
+		reasons = append(reasons, ReasonPriorDefault)
+	}
+
+	if score < MinCreditScore {
+		score = MinCreditScore
+	}
+	if score > MaxCreditScore {
+		score = MaxCreditScore
+	}
+	return CreditScore{Score: score, Grade: CreditGrade(score), Reasons: reasons}, nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScoreCredit(t *testing.T) {
+	cases := []struct {
+		input   CreditInput
+		score   int
+		grade   string
+		reasons []string
+	}{
+		{CreditInput{Salary: MajorUnits(15000, "USD"), LoanAmount: MajorUnits(200000, "USD")}, 700, "B", []string{ReasonNoRepaymentHistory}},
+		// the bounds of the income bands are no longer an error
+		{CreditInput{Salary: MajorUnits(10000, "USD"), LoanAmount: MajorUnits(200000, "USD")}, 700, "B", []string{ReasonNoRepaymentHistory}},
+		{CreditInput{Salary: MajorUnits(20000, "USD"), LoanAmount: MajorUnits(200000, "USD"), PaymentsOnTime: 24}, 774, "A", []string{}},
+		{CreditInput{Salary: MajorUnits(5000, "USD"), LoanAmount: MajorUnits(400000, "USD"), MonthlyObligations: MajorUnits(2500, "USD")}, 375, "C", []string{ReasonLowIncome, ReasonHighLoanToIncome, ReasonHighDebtToIncome, ReasonNoRepaymentHistory}},
+		{CreditInput{Salary: MajorUnits(25000, "USD"), LoanAmount: MajorUnits(200000, "USD"), PaymentsOnTime: 100, PaymentsLate: 3, Defaults: 1}, 555, "C", []string{ReasonLatePayments, ReasonPriorDefault}},
+		{CreditInput{LoanAmount: MajorUnits(200000, "USD"), Defaults: 2}, 300, "C", []string{ReasonMissingIncome, ReasonHighLoanToIncome, ReasonPriorDefault}},
+	}
+	for i, c := range cases {
+		score, err := ScoreCredit(c.input)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if score.Score != c.score || score.Grade != c.grade || !reflect.DeepEqual(score.Reasons, c.reasons) {
+			t.Errorf("case %d: expected %d %s %v got %+v", i, c.score, c.grade, c.reasons, score)
+		}
+	}
+
+	if _, err := ScoreCredit(CreditInput{Salary: MajorUnits(15000, "EUR"), LoanAmount: MajorUnits(200000, "USD")}); err == nil {
+		t.Errorf("a salary in another currency was scored")
+	}
+	if _, err := ScoreCredit(CreditInput{Salary: MajorUnits(15000, "USD")}); err == nil {
+		t.Errorf("a request without a loan was scored")
+	}
+}
+
+func TestCreditScoreResponse(t *testing.T) {
+	score, err := ScoreCredit(CreditInput{Salary: MajorUnits(5000, "USD"), LoanAmount: MajorUnits(200000, "USD")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := CreditScoreResponse{RequestHash: "request-1", Model: CreditModel, CreditScore: score, Explanations: ExplainCredit(score)}
+
+	dataAsBytes, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := CreditScoreResponse{}
+	err = json.Unmarshal(dataAsBytes, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, response) || !strings.Contains(string(dataAsBytes), `"Score":`) {
+		t.Errorf("expected %+v got %s", response, dataAsBytes)
+	}
+	if decoded.Explanations[ReasonLowIncome] != ReasonDescriptions[ReasonLowIncome] {
+		t.Errorf("missing explanation of %s: %+v", ReasonLowIncome, decoded.Explanations)
+	}
+
+	if err := decoded.Validate("request-1"); err != nil {
+		t.Errorf("valid response rejected: %s", err)
+	}
+	if err := decoded.Validate("request-2"); err == nil {
+		t.Errorf("a response for another request was accepted")
+	}
+	decoded.Score = 900
+	if err := decoded.Validate("request-1"); err == nil {
+		t.Errorf("a score above %d was accepted", MaxCreditScore)
+	}
+}
@@ -0,0 +1,357 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// RatingBand - the yearly premium in percent of the loan for a loan to value up to MaxLTV percent of the property
+type RatingBand struct {
+	MaxLTV   float32 `json:"MaxLTV"`
+	LoanRate float32 `json:"LoanRate"`
+}
+
+// RatingTable - how an insurer prices mortgage insurance. The yearly premium is PropertyRate percent of the property value
+// plus the LoanRate of the band of the loan to value, at least MinPremium. Policies run TermMonths, quotes stay valid ValidDays.
+type RatingTable struct {
+	InsurerHash  string       `json:"InsurerHash"`
+	Currency     string       `json:"Currency"`
+	PropertyRate float32      `json:"PropertyRate"`
+	Bands        []RatingBand `json:"Bands"`
+	MinPremium   Money        `json:"MinPremium"`
+	TermMonths   int          `json:"TermMonths"`
+	ValidDays    int          `json:"ValidDays"`
+	Version      int          `json:"Version"`
+	Timestamp    time.Time    `json:"Timestamp"`
+}
+
+// QuoteRequest - what a quote is computed from, the property value and the loan in the currency of the rating table.
+// The bank of the loan is the beneficiary of the policy.
+type QuoteRequest struct {
+	RequestHash   string `json:"RequestHash"`
+	BuyerHash     string `json:"BuyerHash"`
+	BankHash      string `json:"BankHash"`
+	PropertyHash  string `json:"PropertyHash"`
+	PropertyValue Money  `json:"PropertyValue"`
+	LoanAmount    Money  `json:"LoanAmount"`
+}
+
+// InsuranceQuote - the premium an insurer asks to cover the loan, computed from TableVersion of its rating table.
+// PolicyHash is set once the quote was accepted.
+type InsuranceQuote struct {
+	Hash        string `json:"Hash"`
+	InsurerHash string `json:"InsurerHash"`
+	QuoteRequest
+	LTV            float32   `json:"LTV"`
+	Coverage       Money     `json:"Coverage"`
+	YearlyPremium  Money     `json:"YearlyPremium"`
+	MonthlyPremium Money     `json:"MonthlyPremium"`
+	TermMonths     int       `json:"TermMonths"`
+	TableVersion   int       `json:"TableVersion"`
+	PolicyHash     string    `json:"PolicyHash"`
+	ExpiresAt      time.Time `json:"ExpiresAt"`
+	Timestamp      time.Time `json:"Timestamp"`
+}
+
+// PolicyStatus - where a policy is in its lifecycle
+type PolicyStatus string
+
+// States of a policy. A policy lapses while a premium is unpaid PremiumGraceDays after its due date and is active again once
+// the overdue premiums are paid, cancelled and claimed policies stay so.
+const (
+	PolicyActive    PolicyStatus = "active"
+	PolicyLapsed    PolicyStatus = "lapsed"
+	PolicyCancelled PolicyStatus = "cancelled"
+	PolicyClaimed   PolicyStatus = "claimed"
+)
+
+// PremiumGraceDays - how long a premium may stay unpaid after its due date before the policy lapses
+const PremiumGraceDays = 30
+
+// PolicyRequest - a policy issued without a quote, e.g. by lending_chaincode when it closes a loan with the selected
+// insurance offer. A Premium is due on each of DueDates.
+type PolicyRequest struct {
+	Hash            string      `json:"Hash"`
+	QuoteHash       string      `json:"QuoteHash"`
+	InsurerHash     string      `json:"InsurerHash"`
+	HolderHash      string      `json:"HolderHash"`
+	BeneficiaryHash string      `json:"BeneficiaryHash"`
+	RequestHash     string      `json:"RequestHash"`
+	PropertyHash    string      `json:"PropertyHash"`
+	Coverage        Money       `json:"Coverage"`
+	Premium         Money       `json:"Premium"`
+	DueDates        []time.Time `json:"DueDates"`
+}
+
+// PremiumInstallment - one monthly premium of the schedule, PaidAt is set once Paid reaches Amount
+type PremiumInstallment struct {
+	Number  int       `json:"Number"`
+	DueDate time.Time `json:"DueDate"`
+	Amount  Money     `json:"Amount"`
+	Paid    Money     `json:"Paid"`
+	PaidAt  time.Time `json:"PaidAt"`
+}
+
+// PremiumPayment - a payment applied to the oldest premiums that were not paid in full
+type PremiumPayment struct {
+	TxID      string    `json:"TxID"`
+	Amount    Money     `json:"Amount"`
+	Timestamp time.Time `json:"Timestamp"`
+}
+
+// Policy - the insurance of a loan, issued from an accepted quote or at the closing of the loan. Coverage is paid to
+// BeneficiaryHash, the bank of the loan, HolderHash pays the monthly Premiums.
+type Policy struct {
+	Hash            string               `json:"Hash"`
+	QuoteHash       string               `json:"QuoteHash"`
+	InsurerHash     string               `json:"InsurerHash"`
+	HolderHash      string               `json:"HolderHash"`
+	BeneficiaryHash string               `json:"BeneficiaryHash"`
+	RequestHash     string               `json:"RequestHash"`
+	PropertyHash    string               `json:"PropertyHash"`
+	Coverage        Money                `json:"Coverage"`
+	MonthlyPremium  Money                `json:"MonthlyPremium"`
+	StartDate       time.Time            `json:"StartDate"`
+	EndDate         time.Time            `json:"EndDate"`
+	Premiums        []PremiumInstallment `json:"Premiums"`
+	Payments        []PremiumPayment     `json:"Payments"`
+	Status          PolicyStatus         `json:"Status"`
+	Timestamp       time.Time            `json:"Timestamp"`
+}
+
+// Validate - the table needs a known currency, bands of growing MaxLTV and a term
+func (table *RatingTable) Validate() error {
+	if !ValidCurrency(table.Currency) {
+		return fmt.Errorf("invalid currency %q", table.Currency)
+	}
+	if table.PropertyRate < 0 {
+		return errors.New("PropertyRate can not be negative")
+	}
+	if len(table.Bands) == 0 {
+		return errors.New("at least one band is required")
+	}
+	for i, band := range table.Bands {
+		if band.MaxLTV <= 0 || band.LoanRate < 0 {
+			return fmt.Errorf("band %d needs a positive MaxLTV and a LoanRate that is not negative", i+1)
+		}
+		if i > 0 && band.MaxLTV <= table.Bands[i-1].MaxLTV {
+			return fmt.Errorf("band %d does not raise MaxLTV above %.2f", i+1, table.Bands[i-1].MaxLTV)
+		}
+	}
+	if table.MinPremium.Currency != table.Currency || table.MinPremium.IsNegative() {
+		return fmt.Errorf("MinPremium must be in %s and can not be negative", table.Currency)
+	}
+	if table.TermMonths < 1 || table.ValidDays < 1 {
+		return errors.New("TermMonths and ValidDays must be positive")
+	}
+	return nil
+}
+
+// Quote - prices the request with the table, the coverage is the whole loan. The band is chosen on the exact
+// loan to value and the premiums are rounded half up to a minor unit, so every peer computes the same quote.
+func (table *RatingTable) Quote(hash string, request QuoteRequest, timestamp time.Time) (InsuranceQuote, error) {
+	if request.PropertyValue.Currency != table.Currency || request.LoanAmount.Currency != table.Currency {
+		return InsuranceQuote{}, fmt.Errorf("the property value and the loan must be in %s", table.Currency)
+	}
+	if !request.PropertyValue.IsPositive() || !request.LoanAmount.IsPositive() {
+		return InsuranceQuote{}, errors.New("the property value and the loan must be positive")
+	}
+
+	ltv := new(big.Rat).SetFrac64(request.LoanAmount.Amount*100, request.PropertyValue.Amount)
+	var band *RatingBand
+	for i := range table.Bands {
+		if ltv.Cmp(RatFromFloat32(table.Bands[i].MaxLTV)) <= 0 {
+			band = &table.Bands[i]
+			break
+		}
+	}
+	ltvPercent, _ := ltv.Float64()
+	if band == nil {
+		return InsuranceQuote{}, fmt.Errorf("no band covers a loan to value of %.2f%%", ltvPercent)
+	}
+
+	yearly := request.PropertyValue.Percent(table.PropertyRate).plus(request.LoanAmount.Percent(band.LoanRate))
+	if yearly.compare(table.MinPremium) < 0 {
+		yearly = table.MinPremium
+	}
+
+	return InsuranceQuote{
+		Hash:           hash,
+		InsurerHash:    table.InsurerHash,
+		QuoteRequest:   request,
+		LTV:            float32(ltvPercent),
+		Coverage:       request.LoanAmount,
+		YearlyPremium:  yearly,
+		MonthlyPremium: yearly.MulRat(big.NewRat(1, 12)),
+		TermMonths:     table.TermMonths,
+		TableVersion:   table.Version,
+		ExpiresAt:      timestamp.AddDate(0, 0, table.ValidDays),
+		Timestamp:      timestamp,
+	}, nil
+}
+
+// NewPolicy - the policy of an accepted quote starting at start, with a premium due every month of the term
+func NewPolicy(quote *InsuranceQuote, start time.Time) (*Policy, error) {
+	if quote.PolicyHash != "" {
+		return nil, fmt.Errorf("quote %s was already accepted as policy %s", quote.Hash, quote.PolicyHash)
+	}
+	if start.After(quote.ExpiresAt) {
+		return nil, fmt.Errorf("quote %s expired at %s", quote.Hash, quote.ExpiresAt)
+	}
+
+	request := PolicyRequest{
+		Hash:            quote.Hash,
+		QuoteHash:       quote.Hash,
+		InsurerHash:     quote.InsurerHash,
+		HolderHash:      quote.BuyerHash,
+		BeneficiaryHash: quote.BankHash,
+		RequestHash:     quote.RequestHash,
+		PropertyHash:    quote.PropertyHash,
+		Coverage:        quote.Coverage,
+		Premium:         quote.MonthlyPremium,
+	}
+	for month := 0; month < quote.TermMonths; month++ {
+		request.DueDates = append(request.DueDates, start.AddDate(0, month, 0))
+	}
+	return IssuePolicy(request, start)
+}
+
+// IssuePolicy - the active policy of request from timestamp, it ends a month after the last premium is due
+func IssuePolicy(request PolicyRequest, timestamp time.Time) (*Policy, error) {
+	if request.Hash == "" || request.InsurerHash == "" || request.HolderHash == "" || request.BeneficiaryHash == "" {
+		return nil, errors.New("a policy needs a Hash, an InsurerHash, a HolderHash and a BeneficiaryHash")
+	}
+	if request.Coverage.IsNegative() || request.Premium.IsNegative() || len(request.DueDates) == 0 {
+		return nil, errors.New("a policy needs premiums that are not negative and at least one due date")
+	}
+
+	premiums := make([]PremiumInstallment, 0, len(request.DueDates))
+	for i, dueDate := range request.DueDates {
+		if i > 0 && dueDate.Before(request.DueDates[i-1]) {
+			return nil, fmt.Errorf("premium %d is due before premium %d", i+1, i)
+		}
+		premiums = append(premiums, PremiumInstallment{
+			Number:  i + 1,
+			DueDate: dueDate,
+			Amount:  request.Premium,
+			Paid:    NewMoney(0, request.Premium.Currency),
+		})
+	}
+
+	return &Policy{
+		Hash:            request.Hash,
+		QuoteHash:       request.QuoteHash,
+		InsurerHash:     request.InsurerHash,
+		HolderHash:      request.HolderHash,
+		BeneficiaryHash: request.BeneficiaryHash,
+		RequestHash:     request.RequestHash,
+		PropertyHash:    request.PropertyHash,
+		Coverage:        request.Coverage,
+		MonthlyPremium:  request.Premium,
+		StartDate:       timestamp,
+		EndDate:         request.DueDates[len(request.DueDates)-1].AddDate(0, 1, 0),
+		Premiums:        premiums,
+		Payments:        make([]PremiumPayment, 0),
+		Status:          PolicyActive,
+		Timestamp:       timestamp,
+	}, nil
+}
+
+// Assess - the status of an active or lapsed policy at now, lapsed while a premium is unpaid PremiumGraceDays after its due date
+func (p *Policy) Assess(now time.Time) {
+	if p.Status != PolicyActive && p.Status != PolicyLapsed {
+		return
+	}
+
+	status := PolicyActive
+	if premium := p.NextPremium(); premium != nil && now.After(premium.DueDate.AddDate(0, 0, PremiumGraceDays)) {
+		status = PolicyLapsed
+	}
+	if status != p.Status {
+		p.Status = status
+		p.Timestamp = now
+	}
+}
+
+// Cancel - ends the cover of an active or lapsed policy, no further premiums are due
+func (p *Policy) Cancel(timestamp time.Time) error {
+	if p.Status != PolicyActive && p.Status != PolicyLapsed {
+		return fmt.Errorf("policy %s is %s", p.Hash, p.Status)
+	}
+	p.Status = PolicyCancelled
+	p.Timestamp = timestamp
+	return nil
+}
+
+// Claim - the beneficiary claims the coverage, only an active policy pays out
+func (p *Policy) Claim(timestamp time.Time) error {
+	p.Assess(timestamp)
+	if p.Status != PolicyActive {
+		return fmt.Errorf("policy %s is %s", p.Hash, p.Status)
+	}
+	p.Status = PolicyClaimed
+	p.Timestamp = timestamp
+	return nil
+}
+
+// Outstanding - the premiums of the whole term that were not paid yet
+func (p *Policy) Outstanding() Money {
+	outstanding := NewMoney(0, p.MonthlyPremium.Currency)
+	for _, premium := range p.Premiums {
+		outstanding = outstanding.plus(premium.Amount.minus(premium.Paid))
+	}
+	return outstanding
+}
+
+// PremiumsDue - the premiums due by now that were not paid yet
+func (p *Policy) PremiumsDue(now time.Time) Money {
+	due := NewMoney(0, p.MonthlyPremium.Currency)
+	for _, premium := range p.Premiums {
+		if !premium.DueDate.After(now) {
+			due = due.plus(premium.Amount.minus(premium.Paid))
+		}
+	}
+	return due
+}
+
+// NextPremium - the oldest premium that was not paid in full, nil once the term is paid
+func (p *Policy) NextPremium() *PremiumInstallment {
+	for i := range p.Premiums {
+		if p.Premiums[i].Paid.compare(p.Premiums[i].Amount) < 0 {
+			return &p.Premiums[i]
+		}
+	}
+	return nil
+}
+
+// PayPremium - applies amount to the oldest premiums first, a payment can not exceed what is outstanding.
+// Paying the overdue premiums of a lapsed policy makes it active again.
+func (p *Policy) PayPremium(amount Money, txID string, timestamp time.Time) error {
+	if p.Status != PolicyActive && p.Status != PolicyLapsed {
+		return fmt.Errorf("policy %s is %s", p.Hash, p.Status)
+	}
+	if !amount.SameCurrency(p.MonthlyPremium) || !amount.IsPositive() {
+		return fmt.Errorf("the payment must be a positive amount in %s", p.MonthlyPremium.Currency)
+	}
+	if outstanding := p.Outstanding(); amount.compare(outstanding) > 0 {
+		return fmt.Errorf("the payment of %s exceeds the outstanding premiums of %s", amount, outstanding)
+	}
+
+	left := amount
+	for premium := p.NextPremium(); premium != nil && left.IsPositive(); premium = p.NextPremium() {
+		part := premium.Amount.minus(premium.Paid)
+		if left.compare(part) < 0 {
+			part = left
+		}
+		premium.Paid = premium.Paid.plus(part)
+		if premium.Paid.compare(premium.Amount) == 0 {
+			premium.PaidAt = timestamp
+		}
+		left = left.minus(part)
+	}
+	p.Payments = append(p.Payments, PremiumPayment{TxID: txID, Amount: amount, Timestamp: timestamp})
+	p.Assess(timestamp)
+	return nil
+}
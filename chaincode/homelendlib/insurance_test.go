@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func testRatingTable() *RatingTable {
+	return &RatingTable{
+		InsurerHash:  "insurer-1",
+		Currency:     "USD",
+		PropertyRate: 0.1,
+		Bands:        []RatingBand{{MaxLTV: 60, LoanRate: 0.2}, {MaxLTV: 80, LoanRate: 0.35}, {MaxLTV: 95, LoanRate: 0.6}},
+		MinPremium:   MajorUnits(300, "USD"),
+		TermMonths:   12,
+		ValidDays:    30,
+		Version:      2,
+	}
+}
+
+func TestRatingTableValidate(t *testing.T) {
+	if err := testRatingTable().Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []func(table *RatingTable){
+		func(table *RatingTable) { table.Currency = "XYZ" },
+		func(table *RatingTable) { table.Bands = nil },
+		func(table *RatingTable) { table.Bands[2].MaxLTV = 80 },
+		func(table *RatingTable) { table.Bands[0].LoanRate = -1 },
+		func(table *RatingTable) { table.MinPremium = MajorUnits(300, "EUR") },
+		func(table *RatingTable) { table.TermMonths = 0 },
+	}
+	for i, change := range invalid {
+		table := testRatingTable()
+		change(table)
+		if err := table.Validate(); err == nil {
+			t.Errorf("case %d: an invalid table was accepted", i)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	table := testRatingTable()
+	now := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	request := QuoteRequest{RequestHash: "request-1", BuyerHash: "buyer-1", BankHash: "bank-1", PropertyHash: "property-1",
+		PropertyValue: MajorUnits(250000, "USD"), LoanAmount: MajorUnits(200000, "USD")}
+
+	// an LTV of exactly 80% is priced in the 80% band: 250 on the property and 700 on the loan
+	quote, err := table.Quote("quote-1", request, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.LTV != 80 || quote.YearlyPremium != MajorUnits(950, "USD") || quote.MonthlyPremium != NewMoney(7917, "USD") {
+		t.Errorf("unexpected premiums %+v", quote)
+	}
+	if quote.Coverage != request.LoanAmount || quote.TableVersion != 2 || quote.TermMonths != 12 || !quote.ExpiresAt.Equal(now.AddDate(0, 0, 30)) {
+		t.Errorf("unexpected quote %+v", quote)
+	}
+
+	// a small loan pays the minimum premium
+	request.PropertyValue, request.LoanAmount = MajorUnits(100000, "USD"), MajorUnits(10000, "USD")
+	if quote, _ := table.Quote("quote-2", request, now); quote.YearlyPremium != table.MinPremium {
+		t.Errorf("expected the minimum premium got %s", quote.YearlyPremium)
+	}
+
+	request.LoanAmount = MajorUnits(96000, "USD")
+	if _, err := table.Quote("quote-3", request, now); err == nil {
+		t.Errorf("a loan above every band was quoted")
+	}
+	request.LoanAmount = MajorUnits(50000, "EUR")
+	if _, err := table.Quote("quote-4", request, now); err == nil {
+		t.Errorf("a loan in another currency was quoted")
+	}
+}
+
+func TestPolicyPremiums(t *testing.T) {
+	now := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	request := QuoteRequest{RequestHash: "request-1", BuyerHash: "buyer-1", BankHash: "bank-1", PropertyHash: "property-1",
+		PropertyValue: MajorUnits(250000, "USD"), LoanAmount: MajorUnits(200000, "USD")}
+	quote, _ := testRatingTable().Quote("quote-1", request, now)
+
+	if _, err := NewPolicy(&quote, now.AddDate(0, 0, 31)); err == nil {
+		t.Errorf("an expired quote was accepted")
+	}
+	policy, err := NewPolicy(&quote, now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.HolderHash != "buyer-1" || policy.BeneficiaryHash != "bank-1" || policy.Status != PolicyActive || len(policy.Premiums) != 12 {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+	if policy.Outstanding() != NewMoney(7917*12, "USD") || policy.PremiumsDue(now.AddDate(0, 1, 1)) != NewMoney(7917*2, "USD") {
+		t.Errorf("unexpected premiums outstanding %s", policy.Outstanding())
+	}
+
+	// one and a half premiums, the second one stays open
+	err = policy.PayPremium(NewMoney(7917+5000, "USD"), "tx-1", now.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Premiums[0].PaidAt.IsZero() || !policy.Premiums[1].PaidAt.IsZero() || policy.NextPremium().Number != 2 || policy.NextPremium().Paid != NewMoney(5000, "USD") {
+		t.Errorf("unexpected premiums after the payment %+v", policy.Premiums[:2])
+	}
+
+	if err := policy.PayPremium(MajorUnits(2000, "USD"), "tx-2", now); err == nil {
+		t.Errorf("a payment above the outstanding premiums was accepted")
+	}
+	if err := policy.PayPremium(MajorUnits(10, "EUR"), "tx-3", now); err == nil {
+		t.Errorf("a payment in another currency was accepted")
+	}
+	if err := policy.PayPremium(policy.Outstanding(), "tx-4", now); err != nil || policy.NextPremium() != nil || len(policy.Payments) != 2 {
+		t.Errorf("the rest of the term was not paid %+v %v", policy.Payments, err)
+	}
+}
+
+func TestPolicyLifecycle(t *testing.T) {
+	start := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	request := PolicyRequest{Hash: "request-1", InsurerHash: "insurer-1", HolderHash: "buyer-1", BeneficiaryHash: "bank-1",
+		Coverage: MajorUnits(200000, "USD"), Premium: MajorUnits(100, "USD"),
+		DueDates: []time.Time{start.AddDate(0, 1, 0), start.AddDate(0, 2, 0), start.AddDate(0, 3, 0)}}
+
+	if _, err := IssuePolicy(PolicyRequest{Hash: "request-1", InsurerHash: "insurer-1", HolderHash: "buyer-1", BeneficiaryHash: "bank-1"}, start); err == nil {
+		t.Errorf("a policy without due dates was issued")
+	}
+	policy, err := IssuePolicy(request, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.EndDate.Equal(start.AddDate(0, 4, 0)) || policy.Premiums[2].Number != 3 {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+
+	// the first premium is unpaid for more than the grace period
+	policy.Assess(start.AddDate(0, 1, PremiumGraceDays))
+	if policy.Status != PolicyActive {
+		t.Errorf("the policy lapsed within the grace period")
+	}
+	lapsed := start.AddDate(0, 1, PremiumGraceDays+1)
+	policy.Assess(lapsed)
+	if policy.Status != PolicyLapsed {
+		t.Fatalf("expected a lapsed policy got %s", policy.Status)
+	}
+	if err := policy.Claim(lapsed); err == nil {
+		t.Errorf("a lapsed policy was claimed")
+	}
+
+	// paying the overdue premium reinstates the cover
+	if err := policy.PayPremium(MajorUnits(100, "USD"), "tx-1", lapsed); err != nil || policy.Status != PolicyActive {
+		t.Fatalf("the policy was not reinstated: %s %v", policy.Status, err)
+	}
+	if err := policy.Claim(lapsed); err != nil || policy.Status != PolicyClaimed {
+		t.Errorf("the claim was not accepted: %s %v", policy.Status, err)
+	}
+	policy.Assess(start.AddDate(1, 0, 0))
+	if err := policy.Cancel(lapsed); err == nil || policy.Status != PolicyClaimed {
+		t.Errorf("a claimed policy changed to %s", policy.Status)
+	}
+	if err := policy.PayPremium(MajorUnits(100, "USD"), "tx-2", lapsed); err == nil {
+		t.Errorf("a premium of a claimed policy was paid")
+	}
+
+	cancelled, _ := IssuePolicy(request, start)
+	if err := cancelled.Cancel(start); err != nil || cancelled.Status != PolicyCancelled {
+		t.Errorf("the policy was not cancelled: %s %v", cancelled.Status, err)
+	}
+}
@@ -4,7 +4,7 @@ package lib
 type Bank struct {
 	Hash        string `json:"Hash"`
 	Name        string `json:"Name"`
-	TotalSupply int    `json:"TotalSupply"`
+	TotalSupply Money  `json:"TotalSupply"`
 	Timestamp   int    `json:"Timestamp"`
 }
 
@@ -28,7 +28,7 @@ type Appraiser struct {
 type Property struct {
 	Hash         string `json:"Hash"`
 	Address      string `json:"Address"`
-	SellingPrice int    `json:"SellingPrice"`
+	SellingPrice Money  `json:"SellingPrice"`
 	Timestamp    int    `json:"Timestamp"`
 }
 
@@ -36,7 +36,7 @@ type Property struct {
 type InsuranceOffer struct {
 	Hash          string `json:"Hash"`
 	InsuranceHash string `json:"InsuranceHash"`
-	Amount        int    `json:"Amount"`
+	Amount        Money  `json:"Amount"`
 	Timestamp     int    `json:"Timestamp"`
 }
 
@@ -49,15 +49,15 @@ type Request struct {
 	AppraiserHash     string           `json:"AppraiserHash"`
 	CreditScore       string           `json:"CreditScore"`
 	AppraiserPrice    string           `json:"AppraiserPrice"`
-	AppraiserAmount   int              `json:"AppraiserAmount"`
+	AppraiserAmount   Money            `json:"AppraiserAmount"`
 	InsuranceHash     string           `json:"InsuranceHash"`
 	InsuranceAmount   string           `json:"InsuranceAmount"`
 	GovernmentResult1 string           `json:"GovernmentResult1"`
 	GovernmentResult2 string           `json:"GovernmentResult2"`
 	GovernmentResult3 string           `json:"GovernmentResult3"`
 	InsuranceOffers   []InsuranceOffer `json:"InsuranceOffers"`
-	Salary            int              `json:"Salary"`
-	LoanAmount        int              `json:"LoanAmount"`
+	Salary            Money            `json:"Salary"`
+	LoanAmount        Money            `json:"LoanAmount"`
 	Status            string           `json:"Status"`
 	Timestamp         int              `json:"Timestamp"`
 }
@@ -2,10 +2,13 @@ package lib
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
 )
 
 //Helpers - Helps us Getting values from database
@@ -23,7 +26,7 @@ func (t *Helpers) GetLastRequest(stub shim.ChaincodeStubInterface, userID string
 	} else if valAsBytes == nil {
 		str := fmt.Sprintf("Request record does not exist %s", userID)
 		fmt.Println(str)
-		return nil, nil, err
+		return nil, nil, errors.New(str)
 	}
 
 	var arrayOfData []*Request
@@ -33,6 +36,10 @@ func (t *Helpers) GetLastRequest(stub shim.ChaincodeStubInterface, userID string
 		str := fmt.Sprintf("Failed to unmarshal: %s", err)
 		fmt.Println(str)
 		return nil, nil, err
+	} else if len(arrayOfData) == 0 {
+		str := fmt.Sprintf("Request record does not exist %s", userID)
+		fmt.Println(str)
+		return nil, nil, errors.New(str)
 	}
 
 	latestRequest := arrayOfData[len(arrayOfData)-1]
@@ -55,7 +62,44 @@ func (t *Helpers) UpdateLastRequest(stub shim.ChaincodeStubInterface, userID str
 
 //PrintAndReturnError - prints and return error
 func (t *Helpers) PrintAndReturnError(stub shim.ChaincodeStubInterface, errorStr string) pb.Response {
-	str := fmt.Sprintf(errorStr)
+	str := fmt.Sprint(errorStr)
 	fmt.Println(str)
 	return shim.Error(str)
 }
+
+//GetTxTime - Get the timestamp of the transaction proposal, it is the same on every endorsing peer
+func (t *Helpers) GetTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+
+	if err != nil {
+		str := fmt.Sprintf("Failed to get tx timestamp %+v", err.Error())
+		fmt.Println(str)
+		return time.Time{}, err
+	}
+
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
+
+//GetCallingChaincode - the chaincode the transaction proposal was sent to, the caller when a chaincode is invoked by another one
+func (t *Helpers) GetCallingChaincode(stub shim.ChaincodeStubInterface) (string, error) {
+	signedProposal, err := stub.GetSignedProposal()
+	if err != nil || signedProposal == nil {
+		return "", fmt.Errorf("no signed proposal %+v", err)
+	}
+	proposal, err := utils.GetProposal(signedProposal.ProposalBytes)
+	if err != nil {
+		return "", err
+	}
+	header, err := utils.GetHeader(proposal.Header)
+	if err != nil {
+		return "", err
+	}
+	extension, err := utils.GetChaincodeHeaderExtension(header)
+	if err != nil {
+		return "", err
+	}
+	if extension.ChaincodeId == nil {
+		return "", errors.New("the proposal names no chaincode")
+	}
+	return extension.ChaincodeId.Name, nil
+}
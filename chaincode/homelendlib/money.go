@@ -0,0 +1,387 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency - the currency of amounts given without one
+const DefaultCurrency = "USD"
+
+// currencyExponents - currencies whose minor unit is not a hundredth
+var currencyExponents = map[string]int{"JPY": 0, "KRW": 0, "BHD": 3, "KWD": 3}
+
+// Money - an exact amount in the minor units (cents) of a currency.
+// Amounts of one currency are added and compared as integers, so every peer computes the same result.
+// Add, Sub and Cmp fail on amounts in two different currencies, a zero Money without a currency
+// takes the currency of the other amount. Amounts of different currencies only meet through Convert.
+//
+// JSON objects {"Amount":123456,"Currency":"USD"} hold minor units, JSON numbers and strings
+// such as 1234.56 or "1234.56" are major units of the DefaultCurrency, as stored before Money existed.
+type Money struct {
+	Amount   int64  `json:"Amount"`
+	Currency string `json:"Currency"`
+}
+
+// CurrencyExponent - digits after the decimal point of the currency
+func CurrencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[currency]; ok {
+		return exponent
+	}
+	return 2
+}
+
+func minorPerMajor(currency string) int64 {
+	factor := int64(1)
+	for i := 0; i < CurrencyExponent(currency); i++ {
+		factor *= 10
+	}
+	return factor
+}
+
+// ValidCurrency - whether code looks like an ISO 4217 code, three upper case letters
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewMoney - minor units of the currency, the DefaultCurrency when empty
+func NewMoney(minor int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{Amount: minor, Currency: currency}
+}
+
+// MajorUnits - a whole amount of the currency, e.g. MajorUnits(100, "USD") is 100.00 USD
+func MajorUnits(major int64, currency string) Money {
+	money := NewMoney(0, currency)
+	money.Amount = major * minorPerMajor(money.Currency)
+	return money
+}
+
+// ParseMoney - reads a decimal amount in major units exactly, "1234.5" is 1234.50.
+// More decimals than the currency has is an error, amounts are never rounded on input.
+func ParseMoney(value string, currency string) (Money, error) {
+	money := NewMoney(0, currency)
+	exponent := CurrencyExponent(money.Currency)
+
+	text := strings.TrimSpace(value)
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "-"), "+")
+
+	whole, fraction := text, ""
+	if dot := strings.Index(text, "."); dot >= 0 {
+		whole, fraction = text[:dot], text[dot+1:]
+	}
+	if whole == "" && fraction == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	if len(fraction) > exponent {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimals for %s", value, exponent, money.Currency)
+	}
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return Money{}, fmt.Errorf("invalid amount %q", value)
+		}
+	}
+
+	// the major and minor parts are read apart, so the amount is out of range only when it does not fit in minor units
+	major, minor := int64(0), int64(0)
+	var err error
+	if whole != "" {
+		major, err = strconv.ParseInt(whole, 10, 64)
+		if err != nil {
+			return Money{}, fmt.Errorf("amount %q is out of range", value)
+		}
+	}
+	if exponent > 0 {
+		minor, err = strconv.ParseInt(fraction+strings.Repeat("0", exponent-len(fraction)), 10, 64)
+		if err != nil {
+			return Money{}, fmt.Errorf("invalid amount %q", value)
+		}
+	}
+	factor := minorPerMajor(money.Currency)
+	if major > (math.MaxInt64-minor)/factor {
+		return Money{}, fmt.Errorf("amount %q is out of range", value)
+	}
+	minor += major * factor
+	if negative {
+		minor = -minor
+	}
+	money.Amount = minor
+	return money, nil
+}
+
+// ParseRate - reads a positive decimal exchange rate exactly, e.g. "1.0825"
+func ParseRate(value string) (*big.Rat, error) {
+	text := strings.TrimSpace(value)
+	if text == "" || strings.Count(text, ".") > 1 || strings.Trim(text, "0123456789.") != "" {
+		return nil, fmt.Errorf("invalid rate %q", value)
+	}
+
+	rate, ok := new(big.Rat).SetString(text)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate %q", value)
+	}
+	return rate, nil
+}
+
+// DenominateJSON - rewrites the listed top level fields of a JSON object given as plain numbers or strings
+// into Money objects of currency, so documents may write amounts in major units of their own currency.
+// Fields already holding a Money object are left as they are.
+func DenominateJSON(document []byte, currency string, fields ...string) ([]byte, error) {
+	if currency == "" {
+		return document, nil
+	}
+
+	values := make(map[string]json.RawMessage)
+	err := json.Unmarshal(document, &values)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields {
+		value, ok := values[field]
+		text := strings.TrimSpace(string(value))
+		if !ok || text == "null" || strings.HasPrefix(text, "{") {
+			continue
+		}
+
+		money, err := ParseMoney(strings.Trim(text, `"`), currency)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", field, err)
+		}
+		values[field], err = json.Marshal(money)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON - accepts the Money object, or a number or string of major units of the DefaultCurrency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(data))
+	if text == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(text, "{") {
+		type plain Money
+		value := plain{}
+		err := json.Unmarshal(data, &value)
+		if err != nil {
+			return err
+		}
+		*m = NewMoney(value.Amount, value.Currency)
+		return nil
+	}
+
+	text = strings.Trim(text, `"`)
+	if text == "" {
+		*m = NewMoney(0, "")
+		return nil
+	}
+	money, err := ParseMoney(text, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	*m = money
+	return nil
+}
+
+// Decimal - the amount in major units as ParseMoney reads it, e.g. "1234.56"
+func (m Money) Decimal() string {
+	exponent := CurrencyExponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	factor := minorPerMajor(m.Currency)
+	if exponent == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/factor, exponent, amount%factor)
+}
+
+// String - the amount with its currency, e.g. "1234.56 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+func (m Money) currencyWith(o Money) string {
+	if m.Currency == "" {
+		return o.Currency
+	}
+	return m.Currency
+}
+
+// SameCurrency - whether the amounts can be added and compared
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == "" || o.Currency == "" || m.Currency == o.Currency
+}
+
+// Add - m + o, an error when the amounts are in different currencies
+func (m Money) Add(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return Money{}, fmt.Errorf("can not add %s to %s", o, m)
+	}
+	return m.plus(o), nil
+}
+
+// Sub - m - o, an error when the amounts are in different currencies
+func (m Money) Sub(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return Money{}, fmt.Errorf("can not subtract %s from %s", o, m)
+	}
+	return m.minus(o), nil
+}
+
+// Sum - the total of amounts, an error when they are not all in one currency
+func Sum(amounts ...Money) (Money, error) {
+	total := Money{}
+	for _, amount := range amounts {
+		if !total.SameCurrency(amount) {
+			return Money{}, fmt.Errorf("can not add %s to %s", amount, total)
+		}
+		total = total.plus(amount)
+	}
+	return total, nil
+}
+
+// plus, minus and compare leave the currency to the caller, the functions of the package using them
+// checked that the amounts are in one currency or built them all in the currency of one document
+func (m Money) plus(o Money) Money {
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currencyWith(o)}
+}
+
+func (m Money) minus(o Money) Money {
+	return Money{Amount: m.Amount - o.Amount, Currency: m.currencyWith(o)}
+}
+
+func (m Money) compare(o Money) int {
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	}
+	return 0
+}
+
+// Neg - -m
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Cmp - -1, 0 or +1 as m is less than, equal to or greater than o, an error when the amounts are in different currencies
+func (m Money) Cmp(o Money) (int, error) {
+	if !m.SameCurrency(o) {
+		return 0, fmt.Errorf("can not compare %s with %s", m, o)
+	}
+	return m.compare(o), nil
+}
+
+// IsZero - m == 0
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsPositive - m > 0
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// IsNegative - m < 0
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// RoundHalfUp - the nearest whole number, halves are rounded away from zero
+func RoundHalfUp(r *big.Rat) int64 {
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+	negative := num.Sign() < 0
+	num.Abs(num)
+
+	// floor((2 * num + den) / (2 * den))
+	num.Mul(num, big.NewInt(2))
+	num.Add(num, den)
+	num.Quo(num, new(big.Int).Mul(den, big.NewInt(2)))
+	if negative {
+		num.Neg(num)
+	}
+	return num.Int64()
+}
+
+// MulRat - m * r rounded half up to a minor unit
+func (m Money) MulRat(r *big.Rat) Money {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), r)
+	return Money{Amount: RoundHalfUp(product), Currency: m.Currency}
+}
+
+// Convert - m in currency at rate units of currency per unit of m, rounded half up to a minor unit of currency
+func (m Money) Convert(rate *big.Rat, currency string) Money {
+	converted := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate)
+	converted.Mul(converted, new(big.Rat).SetFrac64(minorPerMajor(currency), minorPerMajor(m.Currency)))
+	return NewMoney(RoundHalfUp(converted), currency)
+}
+
+// Percent - percent of m, rounded half up to a minor unit
+func (m Money) Percent(percent float32) Money {
+	return m.MulRat(new(big.Rat).Quo(RatFromFloat32(percent), big.NewRat(100, 1)))
+}
+
+// RatFromFloat32 - the decimal a float32 rate is written as, 3.1 is exactly 31/10 and not the nearest binary fraction
+func RatFromFloat32(f float32) *big.Rat {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(float64(f), 'f', -1, 32))
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+// MonthlyRate - the monthly rate of a yearly rate in percent
+func MonthlyRate(yearlyPercent float32) *big.Rat {
+	return new(big.Rat).Quo(RatFromFloat32(yearlyPercent), big.NewRat(1200, 1))
+}
+
+// MonthlyPayment - the fixed payment repaying principal with monthly interest over months,
+// computed on exact fractions and rounded half up, so it is identical on every peer
+func MonthlyPayment(principal Money, yearlyPercent float32, months int) (Money, error) {
+	if months < 1 {
+		return Money{}, errors.New("invalid: duration")
+	}
+
+	rate := MonthlyRate(yearlyPercent)
+	if rate.Sign() == 0 {
+		return principal.MulRat(big.NewRat(1, int64(months))), nil
+	}
+
+	// principal * rate * (1 + rate)^months / ((1 + rate)^months - 1)
+	growth := new(big.Rat).Add(big.NewRat(1, 1), rate)
+	power := big.NewRat(1, 1)
+	for i := 0; i < months; i++ {
+		power.Mul(power, growth)
+	}
+
+	payment := new(big.Rat).Mul(rate, power)
+	payment.Quo(payment, new(big.Rat).Sub(power, big.NewRat(1, 1)))
+	return principal.MulRat(payment), nil
+}
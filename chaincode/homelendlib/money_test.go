@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		value    string
+		currency string
+		minor    int64
+	}{
+		{"1234.56", "USD", 123456},
+		{"1234.5", "USD", 123450},
+		{"-0.05", "USD", -5},
+		{"0", "", 0},
+		{"1500", "JPY", 1500},
+		{"1.234", "BHD", 1234},
+	}
+	for _, c := range cases {
+		money, err := ParseMoney(c.value, c.currency)
+		if err != nil || money.Amount != c.minor {
+			t.Errorf("ParseMoney(%q, %q) = %+v, %v expected %d", c.value, c.currency, money, err, c.minor)
+		}
+	}
+
+	for _, value := range []string{"", ".", "1.234", "1e3", "12a", "1.2.3", "99999999999999999999", "92233720368547758.08"} {
+		if _, err := ParseMoney(value, "USD"); err == nil {
+			t.Errorf("ParseMoney(%q) did not fail", value)
+		}
+	}
+
+	// the largest amount in minor units, beyond what fits once a digit is appended to it
+	if money, err := ParseMoney("92233720368547758.07", "USD"); err != nil || money.Amount != math.MaxInt64 {
+		t.Errorf("expected the largest amount got %+v %v", money, err)
+	}
+	if money, err := ParseMoney("9223372036854775807", "JPY"); err != nil || money.Amount != math.MaxInt64 {
+		t.Errorf("expected the largest yen amount got %+v %v", money, err)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var amounts []Money
+	err := json.Unmarshal([]byte(`[300000, "12.5", {"Amount":150,"Currency":"EUR"}]`), &amounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Money{MajorUnits(300000, "USD"), NewMoney(1250, "USD"), NewMoney(150, "EUR")}
+	for i := range expected {
+		if amounts[i] != expected[i] {
+			t.Errorf("expected %s got %s", expected[i], amounts[i])
+		}
+	}
+
+	bytes, _ := json.Marshal(NewMoney(1250, "USD"))
+	if string(bytes) != `{"Amount":1250,"Currency":"USD"}` {
+		t.Errorf("unexpected JSON %s", bytes)
+	}
+	if s := NewMoney(-1205, "USD").String(); s != "-12.05 USD" {
+		t.Errorf("unexpected String %s", s)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	cases := []struct {
+		rat     *big.Rat
+		rounded int64
+	}{
+		{big.NewRat(5, 2), 3},
+		{big.NewRat(-5, 2), -3},
+		{big.NewRat(249, 100), 2},
+		{big.NewRat(-251, 100), -3},
+		{big.NewRat(7, 1), 7},
+	}
+	for _, c := range cases {
+		if got := RoundHalfUp(c.rat); got != c.rounded {
+			t.Errorf("RoundHalfUp(%s) = %d expected %d", c.rat, got, c.rounded)
+		}
+	}
+
+	// 3.1 is the decimal 3.1 and not the float32 nearest to it
+	if got := NewMoney(1000, "USD").Percent(3.1); got.Amount != 31 {
+		t.Errorf("expected 3.1%% of 10.00 to be 0.31 got %s", got)
+	}
+}
+
+func TestMonthlyPayment(t *testing.T) {
+	cases := []struct {
+		principal Money
+		rate      float32
+		months    int
+		payment   int64
+	}{
+		{MajorUnits(200000, "USD"), 3.5, 240, 115992},
+		{MajorUnits(200000, "USD"), 3.5, 120, 197772},
+		{MajorUnits(1000, "USD"), 0, 3, 33333},
+	}
+	for _, c := range cases {
+		payment, err := MonthlyPayment(c.principal, c.rate, c.months)
+		if err != nil || payment.Amount != c.payment || payment.Currency != c.principal.Currency {
+			t.Errorf("MonthlyPayment(%s, %g, %d) = %s, %v expected %d", c.principal, c.rate, c.months, payment, err, c.payment)
+		}
+	}
+
+	if _, err := MonthlyPayment(MajorUnits(1000, "USD"), 3, 0); err == nil {
+		t.Errorf("a loan without a duration has a payment")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rate, err := ParseRate("1.0825")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := MajorUnits(1000, "EUR").Convert(rate, "USD"); got != NewMoney(108250, "USD") {
+		t.Errorf("expected 1082.50 USD got %s", got)
+	}
+	// 1 USD = 151.235 JPY rounds to the yen
+	rate, _ = ParseRate("151.235")
+	if got := NewMoney(1050, "USD").Convert(rate, "JPY"); got != NewMoney(1588, "JPY") {
+		t.Errorf("expected 1588 JPY got %s", got)
+	}
+
+	for _, value := range []string{"", "0", "-1.2", "1/3", "1e3", "1.2.3"} {
+		if _, err := ParseRate(value); err == nil {
+			t.Errorf("ParseRate(%q) did not fail", value)
+		}
+	}
+}
+
+func TestCurrencyArithmetic(t *testing.T) {
+	usd, jpy := MajorUnits(100, "USD"), NewMoney(10000, "JPY")
+	if sum, err := usd.Add(NewMoney(50, "USD")); err != nil || sum != NewMoney(10050, "USD") {
+		t.Errorf("expected 100.50 USD got %s %v", sum, err)
+	}
+	// a zero amount without a currency takes the currency of the other one
+	if difference, err := (Money{}).Sub(usd); err != nil || difference != MajorUnits(-100, "USD") {
+		t.Errorf("expected -100.00 USD got %s %v", difference, err)
+	}
+	if _, err := usd.Add(jpy); err == nil {
+		t.Errorf("yen were added to dollars")
+	}
+	if _, err := usd.Sub(jpy); err == nil {
+		t.Errorf("yen were subtracted from dollars")
+	}
+	if _, err := usd.Cmp(jpy); err == nil {
+		t.Errorf("yen were compared with dollars")
+	}
+	if cmp, err := usd.Cmp(MajorUnits(99, "USD")); err != nil || cmp != 1 {
+		t.Errorf("expected 100.00 USD above 99.00 USD got %d %v", cmp, err)
+	}
+	if total, err := Sum(usd, NewMoney(1, "USD"), Money{}); err != nil || total != NewMoney(10001, "USD") {
+		t.Errorf("expected 100.01 USD got %s %v", total, err)
+	}
+	if _, err := Sum(usd, jpy); err == nil {
+		t.Errorf("yen were summed with dollars")
+	}
+}
+
+func TestDenominateJSON(t *testing.T) {
+	document, err := DenominateJSON([]byte(`{"Hash":"h","SellingPrice":"1500","Fees":{"Amount":5,"Currency":"EUR"}}`), "JPY", "SellingPrice", "Fees", "Missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values struct {
+		Hash         string
+		SellingPrice Money
+		Fees         Money
+	}
+	err = json.Unmarshal(document, &values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.Hash != "h" || values.SellingPrice != NewMoney(1500, "JPY") || values.Fees != NewMoney(5, "EUR") {
+		t.Errorf("unexpected document %s", document)
+	}
+
+	if _, err := DenominateJSON([]byte(`{"SellingPrice":"12.5"}`), "JPY", "SellingPrice"); err == nil {
+		t.Errorf("a yen amount with decimals was accepted")
+	}
+}
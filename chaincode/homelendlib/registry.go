@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Checks of the land registry, the functions government_chaincode answers them with
+const (
+	CheckHouseOwner  = "checkHouseOwner"
+	CheckLien        = "checkLien"
+	CheckWarningShot = "checkWarningShot"
+)
+
+// TitleChecks - every check a sale has to pass, in the order they are reported
+var TitleChecks = []string{CheckHouseOwner, CheckLien, CheckWarningShot}
+
+// Lien - a charge registered on a property, e.g. the mortgage of a bank. Released liens stay on the record.
+type Lien struct {
+	ID         string    `json:"ID"`
+	HolderHash string    `json:"HolderHash"`
+	Amount     Money     `json:"Amount"`
+	Released   bool      `json:"Released"`
+	Timestamp  time.Time `json:"Timestamp"`
+}
+
+// Warning - a warning note registered on a property in favour of BeneficiaryHash,
+// while it is not released the property can only be sold to the beneficiary
+type Warning struct {
+	ID              string    `json:"ID"`
+	BeneficiaryHash string    `json:"BeneficiaryHash"`
+	Description     string    `json:"Description"`
+	Released        bool      `json:"Released"`
+	Timestamp       time.Time `json:"Timestamp"`
+}
+
+// TitleRecord - the land registry entry of a property, Version grows by one with every change
+type TitleRecord struct {
+	PropertyHash string    `json:"PropertyHash"`
+	ParcelID     string    `json:"ParcelID"`
+	OwnerHash    string    `json:"OwnerHash"`
+	Liens        []Lien    `json:"Liens"`
+	Warnings     []Warning `json:"Warnings"`
+	Version      int       `json:"Version"`
+	Timestamp    time.Time `json:"Timestamp"`
+}
+
+// TitleCheckRequest - the argument of the checks, the sale of PropertyHash by SellerHash to BuyerHash
+type TitleCheckRequest struct {
+	RequestHash  string `json:"RequestHash"`
+	PropertyHash string `json:"PropertyHash"`
+	SellerHash   string `json:"SellerHash"`
+	BuyerHash    string `json:"BuyerHash"`
+}
+
+// TitleCheckResult - whether the sale passed Check against Version of the title record, 0 when the property has none.
+// Failures explain every reason it did not pass, Liens and Warnings are the entries that failed it.
+type TitleCheckResult struct {
+	Check        string    `json:"Check"`
+	PropertyHash string    `json:"PropertyHash"`
+	ParcelID     string    `json:"ParcelID"`
+	Version      int       `json:"Version"`
+	Passed       bool      `json:"Passed"`
+	Failures     []string  `json:"Failures"`
+	Liens        []Lien    `json:"Liens,omitempty"`
+	Warnings     []Warning `json:"Warnings,omitempty"`
+}
+
+// ActiveLiens - the liens that were not released
+func (r *TitleRecord) ActiveLiens() []Lien {
+	liens := make([]Lien, 0)
+	for _, lien := range r.Liens {
+		if !lien.Released {
+			liens = append(liens, lien)
+		}
+	}
+	return liens
+}
+
+// CheckTitle - answers check for the sale from the title record, record is nil when the property is not registered
+func CheckTitle(record *TitleRecord, check string, request TitleCheckRequest) (TitleCheckResult, error) {
+	result := TitleCheckResult{Check: check, PropertyHash: request.PropertyHash, Failures: make([]string, 0)}
+	switch check {
+	case CheckHouseOwner, CheckLien, CheckWarningShot:
+	default:
+		return result, fmt.Errorf("unknown check %q", check)
+	}
+
+	if record == nil {
+		result.Failures = append(result.Failures, fmt.Sprintf("property %s has no title record", request.PropertyHash))
+		return result, nil
+	}
+	result.ParcelID = record.ParcelID
+	result.Version = record.Version
+
+	switch check {
+	case CheckHouseOwner:
+		if record.OwnerHash != request.SellerHash {
+			result.Failures = append(result.Failures, fmt.Sprintf("parcel %s is owned by %s, not by the seller %s", record.ParcelID, record.OwnerHash, request.SellerHash))
+		}
+	case CheckLien:
+		for _, lien := range record.ActiveLiens() {
+			result.Liens = append(result.Liens, lien)
+			result.Failures = append(result.Failures, fmt.Sprintf("lien %s of %s for %s is not released", lien.ID, lien.HolderHash, lien.Amount))
+		}
+	case CheckWarningShot:
+		for _, warning := range record.Warnings {
+			if warning.Released || warning.BeneficiaryHash == request.BuyerHash {
+				continue
+			}
+			result.Warnings = append(result.Warnings, warning)
+			result.Failures = append(result.Failures, fmt.Sprintf("warning %s in favour of %s: %s", warning.ID, warning.BeneficiaryHash, warning.Description))
+		}
+	}
+
+	result.Passed = len(result.Failures) == 0
+	return result, nil
+}
+
+// TitleChange - a settlement lending_chaincode records in the land registry with the "settle" function of government_chaincode.
+// The steps apply in order as one new version of the record: the lien ReleaseLienID is released, the title passes
+// from SellerHash to BuyerHash when BuyerHash is set, and Lien is registered when it is set.
+type TitleChange struct {
+	RequestHash   string `json:"RequestHash"`
+	PropertyHash  string `json:"PropertyHash"`
+	ReleaseLienID string `json:"ReleaseLienID"`
+	SellerHash    string `json:"SellerHash"`
+	BuyerHash     string `json:"BuyerHash"`
+	Lien          *Lien  `json:"Lien"`
+}
+
+// ApplyTitleChange - applies change to the title record. The title only passes from its owner and free of active liens,
+// the warnings in favour of the buyer are released with it as the sale they protected is completed.
+func ApplyTitleChange(record *TitleRecord, change TitleChange, timestamp time.Time) error {
+	if change.ReleaseLienID != "" {
+		released := false
+		for i := range record.Liens {
+			if record.Liens[i].ID == change.ReleaseLienID && !record.Liens[i].Released {
+				record.Liens[i].Released = true
+				record.Liens[i].Timestamp = timestamp
+				released = true
+			}
+		}
+		if !released {
+			return fmt.Errorf("no active lien %s on parcel %s", change.ReleaseLienID, record.ParcelID)
+		}
+	}
+
+	if change.BuyerHash != "" {
+		if record.OwnerHash != change.SellerHash {
+			return fmt.Errorf("parcel %s is owned by %s, not by the seller %s", record.ParcelID, record.OwnerHash, change.SellerHash)
+		}
+		if liens := record.ActiveLiens(); len(liens) > 0 {
+			return fmt.Errorf("parcel %s can not be transferred with the active lien %s of %s", record.ParcelID, liens[0].ID, liens[0].HolderHash)
+		}
+		record.OwnerHash = change.BuyerHash
+		for i := range record.Warnings {
+			if record.Warnings[i].BeneficiaryHash == change.BuyerHash && !record.Warnings[i].Released {
+				record.Warnings[i].Released = true
+				record.Warnings[i].Timestamp = timestamp
+			}
+		}
+	}
+
+	if change.Lien != nil {
+		lien := *change.Lien
+		if lien.ID == "" || lien.HolderHash == "" || !lien.Amount.IsPositive() {
+			return errors.New("a lien needs an ID, a HolderHash and a positive Amount")
+		}
+		for _, existing := range record.Liens {
+			if existing.ID == lien.ID {
+				return fmt.Errorf("lien %s already exists", lien.ID)
+			}
+		}
+		lien.Released = false
+		lien.Timestamp = timestamp
+		record.Liens = append(record.Liens, lien)
+	}
+	return nil
+}
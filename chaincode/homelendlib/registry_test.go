@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckTitle(t *testing.T) {
+	record := &TitleRecord{
+		PropertyHash: "property-1",
+		ParcelID:     "6106-52",
+		OwnerHash:    "seller-1",
+		Liens: []Lien{
+			{ID: "lien-1", HolderHash: "bank-0", Amount: MajorUnits(100000, "USD"), Released: true},
+			{ID: "lien-2", HolderHash: "bank-1", Amount: MajorUnits(50000, "USD")},
+		},
+		Warnings: []Warning{
+			{ID: "warning-1", BeneficiaryHash: "buyer-1", Description: "sale agreement"},
+			{ID: "warning-2", BeneficiaryHash: "buyer-2", Description: "sale agreement", Released: true},
+		},
+		Version: 4,
+	}
+	sale := TitleCheckRequest{RequestHash: "request-1", PropertyHash: "property-1", SellerHash: "seller-1", BuyerHash: "buyer-1"}
+
+	cases := []struct {
+		check    string
+		request  TitleCheckRequest
+		passed   bool
+		failures int
+	}{
+		{CheckHouseOwner, sale, true, 0},
+		{CheckHouseOwner, TitleCheckRequest{PropertyHash: "property-1", SellerHash: "seller-2", BuyerHash: "buyer-1"}, false, 1},
+		// the released lien of bank-0 is ignored
+		{CheckLien, sale, false, 1},
+		// a warning in favour of the buyer protects the sale, one in favour of someone else blocks it
+		{CheckWarningShot, sale, true, 0},
+		{CheckWarningShot, TitleCheckRequest{PropertyHash: "property-1", SellerHash: "seller-1", BuyerHash: "buyer-3"}, false, 1},
+	}
+	for i, c := range cases {
+		result, err := CheckTitle(record, c.check, c.request)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if result.Passed != c.passed || len(result.Failures) != c.failures || result.Version != 4 || result.ParcelID != "6106-52" || result.Check != c.check {
+			t.Errorf("case %d: unexpected result %+v", i, result)
+		}
+	}
+
+	result, _ := CheckTitle(record, CheckLien, sale)
+	if !reflect.DeepEqual(result.Liens, record.Liens[1:]) {
+		t.Errorf("expected the failing lien lien-2 got %+v", result.Liens)
+	}
+
+	for _, check := range TitleChecks {
+		result, err := CheckTitle(nil, check, sale)
+		if err != nil || result.Passed || result.Version != 0 || len(result.Failures) != 1 {
+			t.Errorf("%s of an unregistered property: %+v %v", check, result, err)
+		}
+	}
+
+	if _, err := CheckTitle(record, "checkZoning", sale); err == nil {
+		t.Errorf("an unknown check was answered")
+	}
+}
+
+func TestApplyTitleChange(t *testing.T) {
+	record := &TitleRecord{
+		PropertyHash: "property-1",
+		ParcelID:     "6106-52",
+		OwnerHash:    "seller-1",
+		Liens:        []Lien{{ID: "lien-0", HolderHash: "bank-0", Amount: MajorUnits(50000, "USD")}},
+		Warnings:     []Warning{{ID: "warning-1", BeneficiaryHash: "buyer-1", Description: "sale agreement"}},
+	}
+	sale := TitleChange{RequestHash: "request-1", PropertyHash: "property-1", SellerHash: "seller-1", BuyerHash: "buyer-1",
+		Lien: &Lien{ID: "request-1", HolderHash: "bank-1", Amount: MajorUnits(200000, "USD")}}
+
+	// the lien of the seller has to be released first
+	if err := ApplyTitleChange(record, sale, time.Time{}); err == nil {
+		t.Fatalf("a title with an active lien was transferred")
+	}
+	if err := ApplyTitleChange(record, TitleChange{ReleaseLienID: "lien-0"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyTitleChange(record, TitleChange{ReleaseLienID: "lien-0"}, time.Time{}); err == nil {
+		t.Errorf("a released lien was released again")
+	}
+
+	if err := ApplyTitleChange(record, TitleChange{SellerHash: "seller-2", BuyerHash: "buyer-1"}, time.Time{}); err == nil {
+		t.Errorf("the title was transferred by someone who does not own it")
+	}
+	if err := ApplyTitleChange(record, sale, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if record.OwnerHash != "buyer-1" || !record.Warnings[0].Released || len(record.ActiveLiens()) != 1 || record.ActiveLiens()[0].HolderHash != "bank-1" {
+		t.Errorf("unexpected record after the sale %+v", record)
+	}
+	if err := ApplyTitleChange(record, TitleChange{Lien: sale.Lien}, time.Time{}); err == nil {
+		t.Errorf("a lien was registered twice")
+	}
+}
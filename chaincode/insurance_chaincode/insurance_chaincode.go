@@ -2,17 +2,41 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
-	"time"
 
-	"github.com/homelend-blockchain/chaincode/homelendlib"
 	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
-//HomelendChaincode is...
+//HomelendChaincode - mortgage insurance: rating tables of insurers, the quotes priced with them, policies and their premiums
 type HomelendChaincode struct {
+	// identityProvider resolves the caller, cid is used when it is nil
+	identityProvider IdentityProvider
+}
+
+//IdentityProvider - resolves the identity and MSP of the transaction creator
+type IdentityProvider interface {
+	GetID(stub shim.ChaincodeStubInterface) (string, error)
+	GetMSPID(stub shim.ChaincodeStubInterface) (string, error)
+}
+
+//cidIdentityProvider - IdentityProvider backed by the client identity library
+type cidIdentityProvider struct {
+}
+
+func (p cidIdentityProvider) GetID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetID(stub)
+}
+
+func (p cidIdentityProvider) GetMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetMSPID(stub)
+}
+
+func (t *HomelendChaincode) identities() IdentityProvider {
+	if t.identityProvider == nil {
+		return cidIdentityProvider{}
+	}
+	return t.identityProvider
 }
 
 // Init initializes chaincode
@@ -26,7 +50,7 @@ func (t *HomelendChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
-	identity, err := cid.GetID(stub)
+	identity, err := t.identities().GetID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("Identity error %+v", args)
@@ -34,7 +58,7 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return shim.Error(str)
 	}
 
-	mspid, err := cid.GetMSPID(stub)
+	mspid, err := t.identities().GetMSPID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", args)
@@ -44,71 +68,69 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 
 	fmt.Println(fmt.Printf("Access log %s %s", identity, mspid))
 
-	if function == "putOffers" {
-		return t.putOffer(stub, args)
+	if numOfArgs, ok := functionArgs[function]; ok {
+		numOfArgsResult := t.validateNumOfArgs(stub, args, numOfArgs)
+		if len(numOfArgsResult) > 0 {
+			return shim.Error(numOfArgsResult)
+		}
+	}
+
+	if function == "putRatingTable" {
+		return t.putRatingTable(stub, args)
+	} else if function == "getRatingTable" {
+		return t.getRatingTableInfo(stub, args)
+	} else if function == "quote" {
+		return t.quote(stub, args)
+	} else if function == "getQuote" {
+		return t.getQuoteInfo(stub, args)
+	} else if function == "acceptQuote" {
+		return t.acceptQuote(stub, args)
+	} else if function == "getPolicy" {
+		return t.getPolicyInfo(stub, args)
+	} else if function == "payPremium" {
+		return t.payPremium(stub, args)
+	} else if function == "issuePolicy" {
+		return t.issuePolicy(stub, args)
+	} else if function == "cancelPolicy" {
+		return t.cancelPolicy(stub, args)
+	} else if function == "claimPolicy" {
+		return t.claimPolicy(stub, args)
+	} else if function == "assignPolicy" {
+		return t.assignPolicy(stub, args)
+	} else if function == "setPolicyChaincodes" {
+		return t.setPolicyChaincodes(stub, args)
+	} else if function == "getPolicyChaincodes" {
+		return t.getPolicyChaincodesInfo(stub, args)
 	}
 
 	fmt.Println("invoke did not find func: " + function) //error
 	return shim.Error("Received unknown function invocation")
 }
 
-func (t *HomelendChaincode) putOffer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println(fmt.Sprintf("buy executed with args: %+v", args))
+//functionArgs - the number of arguments of every function
+var functionArgs = map[string]int{
+	"putRatingTable":      1,
+	"getRatingTable":      1,
+	"quote":               2,
+	"getQuote":            1,
+	"acceptQuote":         1,
+	"getPolicy":           1,
+	"payPremium":          2,
+	"issuePolicy":         1,
+	"cancelPolicy":        1,
+	"claimPolicy":         1,
+	"assignPolicy":        2,
+	"setPolicyChaincodes": 1,
+	"getPolicyChaincodes": 0,
+}
 
-	helpers := lib.Helpers{}
-	var err error
-	if len(args) != 1 {
+func (t *HomelendChaincode) validateNumOfArgs(stub shim.ChaincodeStubInterface, args []string, count int) string {
+	if len(args) != count {
 		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
 		fmt.Println(str)
-		return shim.Error(str)
-	}
-
-	if len(args[0]) <= 0 {
-		str := fmt.Sprintf("JSON must be non-empty string %+v", args)
-		fmt.Println(str)
-		return shim.Error(str)
+		return str
 	}
-
-	userID := args[0]
-
-	mspid, err := cid.GetMSPID(stub)
-
-	if err != nil {
-		str := fmt.Sprintf("MSPID error %+v", args)
-		fmt.Println(str)
-		return shim.Error(str)
-	}
-
-	identity, err := cid.GetID(stub)
-
-	if err != nil {
-		str := fmt.Sprintf("MSPID error %+v", args)
-		fmt.Println(str)
-		return shim.Error(str)
-	}
-
-	if mspid != "POCHomelendMSP" {
-		str := fmt.Sprintf("Only POCHomelend Node can execute this method error %+v", mspid)
-		fmt.Println(str)
-		// return shim.Error(str)
-	}
-
-	offer := lib.InsuranceOffer{Amount: 100 + rand.Intn(200), InsuranceHash: identity, Timestamp: int(time.Now().Unix()), Hash: "someHash"}
-
-	offer.Amount = 12
-
-	currentRequest, requestArr, err := helpers.GetLastRequest(stub, userID)
-
-	currentRequest.InsuranceOffers = append(currentRequest.InsuranceOffers, offer)
-	if err != nil {
-		return helpers.PrintAndReturnError(stub, "could not get last user request for user "+userID)
-	}
-
-	err = helpers.UpdateLastRequest(stub, userID, requestArr)
-	if err != nil {
-		return helpers.PrintAndReturnError(stub, "could update user request "+userID)
-	}
-	return shim.Success(nil)
+	return ""
 }
 
 // ===================================================================================
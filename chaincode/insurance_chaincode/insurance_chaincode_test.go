@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// MSP IDs of the organizations taking part in the policies
+const (
+	buyerMSP     = "POCBuyerMSP"
+	bankMSP      = "POCBankMSP"
+	insuranceMSP = "POCInsuranceMSP"
+)
+
+// identities used by the tests, one per role
+const (
+	buyerID     = "buyer-1"
+	bankID      = "bank-1"
+	newBankID   = "bank-2"
+	insuranceID = "insurance-1"
+
+	policyHash = "policy-1"
+)
+
+//fakeIdentityProvider - lets a test impersonate any MSP
+type fakeIdentityProvider struct {
+	id    string
+	mspid string
+}
+
+func (p *fakeIdentityProvider) GetID(stub shim.ChaincodeStubInterface) (string, error) {
+	return p.id, nil
+}
+
+func (p *fakeIdentityProvider) GetMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return p.mspid, nil
+}
+
+//testNetwork - a MockStub running insurance_chaincode plus the identity it is invoked with
+type testNetwork struct {
+	t        *testing.T
+	stub     *shim.MockStub
+	identity *fakeIdentityProvider
+	// the chaincode the transaction proposal was sent to, empty when the client invoked insurance_chaincode itself
+	caller  string
+	txCount int
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	identity := &fakeIdentityProvider{}
+	cc := &HomelendChaincode{identityProvider: identity}
+	n := &testNetwork{t: t, stub: shim.NewMockStub("insurance_chaincode", cc), identity: identity}
+
+	res := n.stub.MockInit(n.nextTxID(), [][]byte{[]byte("init")})
+	if res.Status != shim.OK {
+		t.Fatalf("init failed: %s", res.Message)
+	}
+	return n
+}
+
+func (n *testNetwork) nextTxID() string {
+	n.txCount++
+	return fmt.Sprintf("tx%d", n.txCount)
+}
+
+//as - switches the identity used by the following invocations, sent by the client itself
+func (n *testNetwork) as(mspid string, id string) *testNetwork {
+	n.identity.mspid = mspid
+	n.identity.id = id
+	n.caller = ""
+	return n
+}
+
+//through - the following invocations come from the client of chaincode, which invokes insurance_chaincode
+func (n *testNetwork) through(chaincode string) *testNetwork {
+	n.caller = chaincode
+	return n
+}
+
+//signedProposal - a proposal sent to chaincode, as GetCallingChaincode reads it
+func (n *testNetwork) signedProposal(chaincode string) *pb.SignedProposal {
+	n.t.Helper()
+	extension, err := proto.Marshal(&pb.ChaincodeHeaderExtension{ChaincodeId: &pb.ChaincodeID{Name: chaincode}})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{Extension: extension})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	header, err := proto.Marshal(&common.Header{ChannelHeader: channelHeader})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	proposal, err := proto.Marshal(&pb.Proposal{Header: header})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return &pb.SignedProposal{ProposalBytes: proposal}
+}
+
+func (n *testNetwork) invoke(function string, args ...string) pb.Response {
+	input := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+	if n.caller == "" {
+		return n.stub.MockInvoke(n.nextTxID(), input)
+	}
+	return n.stub.MockInvokeWithSignedProposal(n.nextTxID(), input, n.signedProposal(n.caller))
+}
+
+func (n *testNetwork) mustInvoke(function string, args ...string) []byte {
+	n.t.Helper()
+	res := n.invoke(function, args...)
+	if res.Status != shim.OK {
+		n.t.Fatalf("%s as %s failed: %s", function, n.identity.mspid, res.Message)
+	}
+	return res.Payload
+}
+
+func (n *testNetwork) mustFail(function string, args ...string) string {
+	n.t.Helper()
+	res := n.invoke(function, args...)
+	if res.Status == shim.OK {
+		n.t.Fatalf("%s as %s was expected to fail", function, n.identity.mspid)
+	}
+	return res.Message
+}
+
+//issue - lending_chaincode issues the policy of the buyer to the bank, with a premium of 100 due on every due date
+func (n *testNetwork) issue(dueDates ...time.Time) *lib.Policy {
+	n.t.Helper()
+	request, err := json.Marshal(lib.PolicyRequest{
+		Hash:            policyHash,
+		InsurerHash:     insuranceID,
+		HolderHash:      buyerID,
+		BeneficiaryHash: bankID,
+		Coverage:        lib.NewMoney(20000000, lib.DefaultCurrency),
+		Premium:         lib.NewMoney(10000, lib.DefaultCurrency),
+		DueDates:        dueDates,
+	})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return n.decodePolicy(n.as(buyerMSP, buyerID).through("lending_chaincode").mustInvoke("issuePolicy", string(request)))
+}
+
+func (n *testNetwork) decodePolicy(payload []byte) *lib.Policy {
+	n.t.Helper()
+	policy := &lib.Policy{}
+	err := json.Unmarshal(payload, policy)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	return policy
+}
+
+//policy - the policy as getPolicy assesses it now
+func (n *testNetwork) policy() *lib.Policy {
+	n.t.Helper()
+	return n.decodePolicy(n.as(buyerMSP, buyerID).mustInvoke("getPolicy", policyHash))
+}
+
+//monthly - count due dates a month apart, the first one at from
+func monthly(from time.Time, count int) []time.Time {
+	dueDates := make([]time.Time, 0, count)
+	for month := 0; month < count; month++ {
+		dueDates = append(dueDates, from.AddDate(0, month, 0))
+	}
+	return dueDates
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//the chaincodes that issue the policies of the loans they close and service them
+const policyChaincodesKey = "policyChaincodes"
+
+var defaultPolicyChaincodes = []string{"lending_chaincode"}
+
+//the MSP that decides which chaincodes service policies
+const adminMSP = "POCHomelendMSP"
+
+//getPolicyChaincodes - returns defaultPolicyChaincodes until Homelend sets its own
+func (t *HomelendChaincode) getPolicyChaincodes(stub shim.ChaincodeStubInterface) ([]string, error) {
+	dataAsBytes, err := stub.GetState(policyChaincodesKey)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		return defaultPolicyChaincodes, nil
+	}
+
+	chaincodes := make([]string, 0)
+	err = json.Unmarshal(dataAsBytes, &chaincodes)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal policy chaincodes %+v", err)
+		return nil, errors.New(str)
+	}
+	return chaincodes, nil
+}
+
+//requirePolicyChaincode - the transaction was sent to one of the policy chaincodes, which invoked this one
+func (t *HomelendChaincode) requirePolicyChaincode(stub shim.ChaincodeStubInterface) error {
+	helpers := lib.Helpers{}
+	caller, err := helpers.GetCallingChaincode(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetCallingChaincode error %+v", err)
+		return errors.New(str)
+	}
+
+	chaincodes, err := t.getPolicyChaincodes(stub)
+	if err != nil {
+		return err
+	}
+	for _, chaincode := range chaincodes {
+		if chaincode == caller {
+			return nil
+		}
+	}
+	str := fmt.Sprintf("%s may not service policies", caller)
+	return errors.New(str)
+}
+
+//authorizeParty - the caller is one of identities or a policy chaincode
+func (t *HomelendChaincode) authorizeParty(stub shim.ChaincodeStubInterface, identities []string) error {
+	identity, err := t.identities().GetID(stub)
+	if err != nil {
+		str := fmt.Sprintf("Identity error %+v", err)
+		return errors.New(str)
+	}
+	for _, allowed := range identities {
+		if identity == allowed {
+			return nil
+		}
+	}
+	return t.requirePolicyChaincode(stub)
+}
+
+//changePolicy - lets change modify the policy assessed at the transaction time, if the caller is one of the parties
+//returns or a policy chaincode, and stores it
+func (t *HomelendChaincode) changePolicy(stub shim.ChaincodeStubInterface, policyHash string, parties func(policy *lib.Policy) []string, change func(policy *lib.Policy, timestamp time.Time) error) pb.Response {
+	policy, err := t.getPolicy(stub, policyHash)
+	if err != nil {
+		str := fmt.Sprintf("getPolicy error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.authorizeParty(stub, parties(policy))
+	if err != nil {
+		str := fmt.Sprintf("authorizeParty error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	policy.Assess(timestamp)
+	err = change(policy, timestamp)
+	if err != nil {
+		str := fmt.Sprintf("Could not change policy %s %+v", policyHash, err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putDocument(stub, policyIndex, policy.Hash, policy)
+	if err != nil {
+		str := fmt.Sprintf("putDocument error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(policy)
+}
+
+//issuePolicy - args: lib.PolicyRequest JSON. A policy chaincode issues the policy of a loan it closes,
+//with a premium due on every due date it gives
+func (t *HomelendChaincode) issuePolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("issuePolicy executed with args: %+v", args))
+
+	err := t.requirePolicyChaincode(stub)
+	if err != nil {
+		str := fmt.Sprintf("requirePolicyChaincode error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request := lib.PolicyRequest{}
+	err = json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	existing := &lib.Policy{}
+	found, err := t.getDocument(stub, policyIndex, request.Hash, existing)
+	if err != nil || found {
+		str := fmt.Sprintf("policy %s already exists %+v", request.Hash, err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	policy, err := lib.IssuePolicy(request, timestamp)
+	if err != nil {
+		str := fmt.Sprintf("Could not issue the policy %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putDocument(stub, policyIndex, policy.Hash, policy)
+	if err != nil {
+		str := fmt.Sprintf("putDocument error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(policy)
+}
+
+//cancelPolicy - args: policyHash. The holder or a policy chaincode ends the cover, e.g. once the loan is paid off
+func (t *HomelendChaincode) cancelPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("cancelPolicy executed with args: %+v", args))
+
+	return t.changePolicy(stub, args[0], func(policy *lib.Policy) []string {
+		return []string{policy.HolderHash}
+	}, func(policy *lib.Policy, timestamp time.Time) error {
+		return policy.Cancel(timestamp)
+	})
+}
+
+//claimPolicy - args: policyHash. The beneficiary claims the coverage of an active policy
+func (t *HomelendChaincode) claimPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("claimPolicy executed with args: %+v", args))
+
+	return t.changePolicy(stub, args[0], func(policy *lib.Policy) []string {
+		return []string{policy.BeneficiaryHash}
+	}, func(policy *lib.Policy, timestamp time.Time) error {
+		return policy.Claim(timestamp)
+	})
+}
+
+//assignPolicy - args: policyHash, beneficiaryHash. A policy chaincode assigns the policy to the bank that refinanced the loan
+func (t *HomelendChaincode) assignPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("assignPolicy executed with args: %+v", args))
+
+	beneficiaryHash := args[1]
+	return t.changePolicy(stub, args[0], func(policy *lib.Policy) []string {
+		return nil
+	}, func(policy *lib.Policy, timestamp time.Time) error {
+		if policy.Status != lib.PolicyActive && policy.Status != lib.PolicyLapsed {
+			return fmt.Errorf("policy %s is %s", policy.Hash, policy.Status)
+		}
+		if beneficiaryHash == "" {
+			return errors.New("the beneficiary can not be empty")
+		}
+		policy.BeneficiaryHash = beneficiaryHash
+		policy.Timestamp = timestamp
+		return nil
+	})
+}
+
+//setPolicyChaincodes - args: JSON array of the chaincode names allowed to issue and service policies
+func (t *HomelendChaincode) setPolicyChaincodes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setPolicyChaincodes executed with args: %+v", args))
+
+	mspid, err := t.identities().GetMSPID(stub)
+	if err != nil || mspid != adminMSP {
+		str := fmt.Sprintf("only %s sets the policy chaincodes %+v", adminMSP, err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	chaincodes := make([]string, 0)
+	err = json.Unmarshal([]byte(args[0]), &chaincodes)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(policyChaincodesKey, []byte(args[0]))
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(chaincodes)
+}
+
+//getPolicyChaincodesInfo - returns the chaincode names allowed to issue and service policies
+func (t *HomelendChaincode) getPolicyChaincodesInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	chaincodes, err := t.getPolicyChaincodes(stub)
+	if err != nil {
+		str := fmt.Sprintf("getPolicyChaincodes error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(chaincodes)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestIssuePolicy(t *testing.T) {
+	n := newTestNetwork(t)
+	policy := n.issue(monthly(time.Now().AddDate(0, 1, 0), 12)...)
+
+	if policy.Status != lib.PolicyActive || len(policy.Premiums) != 12 {
+		t.Fatalf("expected an active policy with 12 premiums, got %s with %d", policy.Status, len(policy.Premiums))
+	}
+	if policy.HolderHash != buyerID || policy.BeneficiaryHash != bankID || policy.InsurerHash != insuranceID {
+		t.Fatalf("unexpected parties %+v", policy)
+	}
+	if stored := n.policy(); stored.Hash != policyHash || stored.Status != lib.PolicyActive {
+		t.Fatalf("expected the policy to be stored, got %+v", stored)
+	}
+
+	// a policy is issued once
+	if msg := n.through("lending_chaincode").mustFail("issuePolicy", fmt.Sprintf(`{"Hash":%q}`, policyHash)); !strings.Contains(msg, "already exists") {
+		t.Fatalf("unexpected error %s", msg)
+	}
+}
+
+func TestIssuePolicyOnlyThroughPolicyChaincode(t *testing.T) {
+	n := newTestNetwork(t)
+	request := fmt.Sprintf(`{"Hash":%q,"InsurerHash":%q,"HolderHash":%q,"BeneficiaryHash":%q,"Premium":"100","DueDates":[%q]}`,
+		policyHash, insuranceID, buyerID, bankID, time.Now().AddDate(0, 1, 0).Format(time.RFC3339))
+
+	n.as(insuranceMSP, insuranceID).mustFail("issuePolicy", request)
+	if msg := n.through("mortgage_chaincode").mustFail("issuePolicy", request); !strings.Contains(msg, "may not service policies") {
+		t.Fatalf("unexpected error %s", msg)
+	}
+
+	// Homelend decides which chaincodes service policies
+	n.as(bankMSP, bankID).mustFail("setPolicyChaincodes", `["mortgage_chaincode"]`)
+	n.as("POCHomelendMSP", "homelend-1").mustInvoke("setPolicyChaincodes", `["mortgage_chaincode"]`)
+	n.as(buyerMSP, buyerID).through("lending_chaincode").mustFail("issuePolicy", request)
+	n.through("mortgage_chaincode").mustInvoke("issuePolicy", request)
+}
+
+func TestCancelPolicy(t *testing.T) {
+	n := newTestNetwork(t)
+	n.issue(monthly(time.Now().AddDate(0, 1, 0), 12)...)
+
+	// only the holder or a policy chaincode cancels the policy
+	n.as(bankMSP, bankID).mustFail("cancelPolicy", policyHash)
+	n.as(insuranceMSP, insuranceID).mustFail("cancelPolicy", policyHash)
+
+	policy := n.decodePolicy(n.as(buyerMSP, buyerID).mustInvoke("cancelPolicy", policyHash))
+	if policy.Status != lib.PolicyCancelled {
+		t.Fatalf("expected a cancelled policy, got %s", policy.Status)
+	}
+	if stored := n.policy(); stored.Status != lib.PolicyCancelled {
+		t.Fatalf("expected the cancelled policy to be stored, got %s", stored.Status)
+	}
+
+	// a cancelled policy stays so
+	n.as(bankMSP, bankID).through("lending_chaincode").mustFail("cancelPolicy", policyHash)
+	n.through("lending_chaincode").mustFail("assignPolicy", policyHash, newBankID)
+}
+
+func TestCancelPolicyThroughPolicyChaincode(t *testing.T) {
+	n := newTestNetwork(t)
+	n.issue(monthly(time.Now().AddDate(0, 1, 0), 12)...)
+
+	policy := n.decodePolicy(n.as(bankMSP, bankID).through("lending_chaincode").mustInvoke("cancelPolicy", policyHash))
+	if policy.Status != lib.PolicyCancelled {
+		t.Fatalf("expected a cancelled policy, got %s", policy.Status)
+	}
+}
+
+func TestAssignPolicy(t *testing.T) {
+	n := newTestNetwork(t)
+	n.issue(monthly(time.Now().AddDate(0, 1, 0), 12)...)
+
+	// the parties of the policy do not assign it themselves
+	n.as(bankMSP, bankID).mustFail("assignPolicy", policyHash, newBankID)
+	n.as(buyerMSP, buyerID).mustFail("assignPolicy", policyHash, newBankID)
+	if msg := n.through("lending_chaincode").mustFail("assignPolicy", policyHash, ""); !strings.Contains(msg, "beneficiary") {
+		t.Fatalf("unexpected error %s", msg)
+	}
+
+	policy := n.decodePolicy(n.as(bankMSP, newBankID).through("lending_chaincode").mustInvoke("assignPolicy", policyHash, newBankID))
+	if policy.BeneficiaryHash != newBankID || policy.Status != lib.PolicyActive {
+		t.Fatalf("expected an active policy of %s, got %s of %s", newBankID, policy.Status, policy.BeneficiaryHash)
+	}
+	if stored := n.policy(); stored.BeneficiaryHash != newBankID || stored.HolderHash != buyerID {
+		t.Fatalf("expected the assigned policy to be stored, got %+v", stored)
+	}
+
+	// the new beneficiary claims the policy, the previous one no longer does
+	n.as(bankMSP, bankID).mustFail("claimPolicy", policyHash)
+	policy = n.decodePolicy(n.as(bankMSP, newBankID).mustInvoke("claimPolicy", policyHash))
+	if policy.Status != lib.PolicyClaimed {
+		t.Fatalf("expected a claimed policy, got %s", policy.Status)
+	}
+}
+
+func TestPolicyLapses(t *testing.T) {
+	n := newTestNetwork(t)
+	// the first two premiums are past their grace period
+	n.issue(monthly(time.Now().AddDate(0, -1, -lib.PremiumGraceDays-1), 12)...)
+
+	policy := n.policy()
+	if policy.Status != lib.PolicyLapsed {
+		t.Fatalf("expected a lapsed policy, got %s", policy.Status)
+	}
+
+	// a lapsed policy does not pay out
+	n.as(bankMSP, bankID).mustFail("claimPolicy", policyHash)
+
+	// paying one of the overdue premiums leaves the policy lapsed
+	n.as(buyerMSP, buyerID).mustFail("payPremium", policyHash, "100")
+	policy = n.decodePolicy(n.as(insuranceMSP, insuranceID).mustInvoke("payPremium", policyHash, "100"))
+	if policy.Status != lib.PolicyLapsed {
+		t.Fatalf("expected the policy to stay lapsed, got %s", policy.Status)
+	}
+
+	// a lapsed policy is still assigned when the loan is refinanced
+	policy = n.decodePolicy(n.through("lending_chaincode").mustInvoke("assignPolicy", policyHash, newBankID))
+	if policy.Status != lib.PolicyLapsed || policy.BeneficiaryHash != newBankID {
+		t.Fatalf("expected a lapsed policy of %s, got %s of %s", newBankID, policy.Status, policy.BeneficiaryHash)
+	}
+
+	// the policy is active again once the overdue premiums are paid
+	policy = n.decodePolicy(n.as(insuranceMSP, insuranceID).mustInvoke("payPremium", policyHash, "100"))
+	if policy.Status != lib.PolicyActive {
+		t.Fatalf("expected an active policy, got %s", policy.Status)
+	}
+	if stored := n.policy(); stored.Status != lib.PolicyActive || len(stored.Payments) != 2 {
+		t.Fatalf("expected an active policy with 2 payments, got %s with %d", stored.Status, len(stored.Payments))
+	}
+}
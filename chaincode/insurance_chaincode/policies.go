@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//every insurer has one rating table, quotes and policies are stored under their hash
+const (
+	ratingTableIndex = "ratingTable~insurer"
+	quoteIndex       = "quote~hash"
+	policyIndex      = "policy~hash"
+)
+
+//the MSP of the insurers
+const insurerMSP = "POCInsuranceMSP"
+
+//requireInsurer - returns the identity of the calling insurer
+func (t *HomelendChaincode) requireInsurer(stub shim.ChaincodeStubInterface) (string, error) {
+	mspid, err := t.identities().GetMSPID(stub)
+	if err != nil {
+		str := fmt.Sprintf("MSPID error %+v", err)
+		return "", errors.New(str)
+	}
+	if mspid != insurerMSP {
+		str := fmt.Sprintf("%s is not an insurer", mspid)
+		return "", errors.New(str)
+	}
+	return t.identities().GetID(stub)
+}
+
+//getDocument - unmarshals the document stored under key of index into value, false when there is none
+func (t *HomelendChaincode) getDocument(stub shim.ChaincodeStubInterface, index string, key string, value interface{}) (bool, error) {
+	compositeKey, err := stub.CreateCompositeKey(index, []string{key})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return false, errors.New(str)
+	}
+
+	dataAsBytes, err := stub.GetState(compositeKey)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return false, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		return false, nil
+	}
+
+	err = json.Unmarshal(dataAsBytes, value)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal %s %s: %s", index, key, err)
+		return false, errors.New(str)
+	}
+	return true, nil
+}
+
+func (t *HomelendChaincode) putDocument(stub shim.ChaincodeStubInterface, index string, key string, value interface{}) error {
+	compositeKey, err := stub.CreateCompositeKey(index, []string{key})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return errors.New(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(value)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(compositeKey, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+//getPolicy - the policy stored under policyHash, an error when there is none
+func (t *HomelendChaincode) getPolicy(stub shim.ChaincodeStubInterface, policyHash string) (*lib.Policy, error) {
+	policy := &lib.Policy{}
+	found, err := t.getDocument(stub, policyIndex, policyHash, policy)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		str := fmt.Sprintf("policy %s does not exist", policyHash)
+		return nil, errors.New(str)
+	}
+	return policy, nil
+}
+
+func (t *HomelendChaincode) marshalResponse(value interface{}) pb.Response {
+	dataAsBytes, err := json.Marshal(value)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return shim.Success(dataAsBytes)
+}
+
+//putRatingTable - args: RatingTable JSON, MinPremium may be a plain amount of the Currency of the table (default USD).
+//The insurer replaces its own table, quotes keep the version they were priced with.
+func (t *HomelendChaincode) putRatingTable(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("putRatingTable executed with args: %+v", args))
+
+	identity, err := t.requireInsurer(stub)
+	if err != nil {
+		str := fmt.Sprintf("requireInsurer error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	header := struct {
+		Currency string `json:"Currency"`
+	}{Currency: lib.DefaultCurrency}
+	err = json.Unmarshal([]byte(args[0]), &header)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	document, err := lib.DenominateJSON([]byte(args[0]), header.Currency, "MinPremium")
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	table := &lib.RatingTable{}
+	err = json.Unmarshal(document, table)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	table.Currency = header.Currency
+	if table.MinPremium.Currency == "" {
+		table.MinPremium = lib.NewMoney(0, table.Currency)
+	}
+
+	err = table.Validate()
+	if err != nil {
+		str := fmt.Sprintf("Invalid rating table %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	previous := &lib.RatingTable{}
+	_, err = t.getDocument(stub, ratingTableIndex, identity, previous)
+	if err != nil {
+		str := fmt.Sprintf("getDocument error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	table.InsurerHash = identity
+	table.Version = previous.Version + 1
+	table.Timestamp = timestamp
+	err = t.putDocument(stub, ratingTableIndex, identity, table)
+	if err != nil {
+		str := fmt.Sprintf("putDocument error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(table)
+}
+
+//getRatingTableInfo - args: insurerHash
+func (t *HomelendChaincode) getRatingTableInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	table := &lib.RatingTable{}
+	found, err := t.getDocument(stub, ratingTableIndex, args[0], table)
+	if err != nil || !found {
+		str := fmt.Sprintf("insurer %s has no rating table %+v", args[0], err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(table)
+}
+
+//quote - args: insurerHash, QuoteRequest JSON, PropertyValue and LoanAmount may be plain amounts of the currency of the table.
+//Prices the request with the rating table of the insurer and stores the quote under the transaction ID
+func (t *HomelendChaincode) quote(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("quote executed with args: %+v", args))
+
+	table := &lib.RatingTable{}
+	found, err := t.getDocument(stub, ratingTableIndex, args[0], table)
+	if err != nil || !found {
+		str := fmt.Sprintf("insurer %s has no rating table %+v", args[0], err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	document, err := lib.DenominateJSON([]byte(args[1]), table.Currency, "PropertyValue", "LoanAmount")
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request := lib.QuoteRequest{}
+	err = json.Unmarshal(document, &request)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if request.RequestHash == "" || request.BuyerHash == "" || request.BankHash == "" {
+		str := "RequestHash, BuyerHash and BankHash are required"
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	quote, err := table.Quote(stub.GetTxID(), request, timestamp)
+	if err != nil {
+		str := fmt.Sprintf("Could not quote %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putDocument(stub, quoteIndex, quote.Hash, &quote)
+	if err != nil {
+		str := fmt.Sprintf("putDocument error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(quote)
+}
+
+//getQuoteInfo - args: quoteHash
+func (t *HomelendChaincode) getQuoteInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	quote := &lib.InsuranceQuote{}
+	found, err := t.getDocument(stub, quoteIndex, args[0], quote)
+	if err != nil || !found {
+		str := fmt.Sprintf("quote %s does not exist %+v", args[0], err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(quote)
+}
+
+//acceptQuote - args: quoteHash. The buyer of the quote accepts it before it expires and it becomes a Policy
+//under the same hash, with the bank of the loan as beneficiary and a premium due every month from now on
+func (t *HomelendChaincode) acceptQuote(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("acceptQuote executed with args: %+v", args))
+
+	identity, err := t.identities().GetID(stub)
+	if err != nil {
+		str := fmt.Sprintf("Identity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	quote := &lib.InsuranceQuote{}
+	found, err := t.getDocument(stub, quoteIndex, args[0], quote)
+	if err != nil || !found {
+		str := fmt.Sprintf("quote %s does not exist %+v", args[0], err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if quote.BuyerHash != identity {
+		str := fmt.Sprintf("quote %s was not made for %s", quote.Hash, identity)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	policy, err := lib.NewPolicy(quote, timestamp)
+	if err != nil {
+		str := fmt.Sprintf("Could not accept the quote %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	quote.PolicyHash = policy.Hash
+	err = t.putDocument(stub, quoteIndex, quote.Hash, quote)
+	if err != nil {
+		str := fmt.Sprintf("putDocument error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putDocument(stub, policyIndex, policy.Hash, policy)
+	if err != nil {
+		str := fmt.Sprintf("putDocument error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(policy)
+}
+
+//getPolicyInfo - args: policyHash. The policy with its premium schedule and payments, assessed at the transaction time
+func (t *HomelendChaincode) getPolicyInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	policy, err := t.getPolicy(stub, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getPolicy error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	policy.Assess(timestamp)
+	return t.marshalResponse(policy)
+}
+
+//payPremium - args: policyHash, amount in the currency of the policy. The insurer of the policy records a premium it received,
+//a policy chaincode one it collected. The amount pays the oldest premiums that are not paid in full
+func (t *HomelendChaincode) payPremium(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("payPremium executed with args: %+v", args))
+
+	return t.changePolicy(stub, args[0], func(policy *lib.Policy) []string {
+		return []string{policy.InsurerHash}
+	}, func(policy *lib.Policy, timestamp time.Time) error {
+		amount, err := lib.ParseMoney(args[1], policy.MonthlyPremium.Currency)
+		if err != nil {
+			return err
+		}
+		return policy.PayPremium(amount, stub.GetTxID(), timestamp)
+	})
+}
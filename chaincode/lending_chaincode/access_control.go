@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//Role - what a caller is allowed to do, read from the role attribute of its certificate
+type Role string
+
+// Roles of the participants of the buying process
+const (
+	RoleBuyer        Role = "buyer"
+	RoleSeller       Role = "seller"
+	RoleLoanOfficer  Role = "loan-officer"
+	RoleAppraiser    Role = "appraiser"
+	RoleInsurer      Role = "insurer"
+	RoleGovernment   Role = "government"
+	RoleCreditAgency Role = "credit-agency"
+	RoleAdmin        Role = "admin"
+	RoleFXOracle     Role = "fx-oracle"
+)
+
+//the certificate attribute holding the Role, e.g. fabric-ca-client register --id.attrs 'role=loan-officer:ecert'
+const roleAttribute = "role"
+
+//the role -> MSP IDs mapping, written at Init
+const roleMSPsKey = "roleMSPs"
+
+//RoleMSPs - the MSPs whose members may hold each role
+type RoleMSPs map[Role][]string
+
+//defaultRoleMSPs - used by Init when no mapping is passed and none is stored yet
+var defaultRoleMSPs = RoleMSPs{
+	RoleBuyer:        {"POCBuyerMSP"},
+	RoleSeller:       {"POCSellerMSP"},
+	RoleLoanOfficer:  {"POCBankMSP"},
+	RoleAppraiser:    {"POCAppraiserMSP"},
+	RoleInsurer:      {"POCInsuranceMSP"},
+	RoleGovernment:   {"POCGovernmentMSP"},
+	RoleCreditAgency: {"POCCreditRatingAgencyMSP"},
+	RoleAdmin:        {"POCHomelendMSP"},
+	RoleFXOracle:     {"POCExchangeRateOracleMSP"},
+}
+
+var participantRoles = []Role{RoleBuyer, RoleSeller, RoleLoanOfficer, RoleAppraiser, RoleInsurer, RoleGovernment, RoleCreditAgency, RoleAdmin}
+
+//the fx-oracle only publishes exchange rates, it reads nothing but them
+var exchangeRateReaderRoles = append([]Role{RoleFXOracle}, participantRoles...)
+
+//functionRoles - the roles that may call each Invoke function, Invoke rejects any other caller
+var functionRoles = map[string][]Role{
+	"advertise":                      {RoleSeller},
+	"buy":                            {RoleBuyer},
+	"putBuyerPersonalInfo":           {RoleBuyer},
+	"putSellerPersonalInfo":          {RoleSeller},
+	"appraiserputPersonalInfo":       {RoleAppraiser},
+	"appraiserProvideAmount":         {RoleAppraiser},
+	"putBankInfo":                    {RoleLoanOfficer},
+	"creditRatingPull":               {RoleCreditAgency},
+	"putCreditRatingAgencyInfo":      {RoleCreditAgency},
+	"putInsuranceCompanyInfo":        {RoleInsurer},
+	"insurancePutOffer":              {RoleInsurer},
+	"buyerSelectAppraiser":           {RoleBuyer},
+	"creditScore":                    {RoleCreditAgency},
+	"getProperties":                  {RoleBuyer, RoleSeller},
+	"getRequestInfo":                 participantRoles,
+	"appraiserPullPendingRequests":   {RoleAppraiser},
+	"buyerGetMyRequests":             {RoleBuyer},
+	"governmentPullPending":          {RoleGovernment},
+	"governmentPutData":              {RoleGovernment},
+	"governmentVerify":               {RoleGovernment, RoleAdmin},
+	"bankApprove":                    {RoleLoanOfficer},
+	"bankRunChaincode":               {RoleLoanOfficer},
+	"bankPullOpen4bankOffers":        {RoleLoanOfficer},
+	"bankPutOffer":                   {RoleLoanOfficer},
+	"buyerGetAllAppraisers":          {RoleBuyer},
+	"buyerSelectBankOffer":           {RoleBuyer},
+	"bankPullPending4FinalAppproval": {RoleLoanOfficer},
+	"buyerSelectInsuranceOffer":      {RoleBuyer},
+	"getProperties4Sale":             {RoleBuyer},
+	"insuranceGetOpenRequests":       {RoleInsurer},
+	"getMyInfo":                      {RoleBuyer, RoleSeller},
+	"query":                          participantRoles,
+	"getRoleMSPs":                    participantRoles,
+	"listParticipants":               participantRoles,
+	"approveParticipant":             {RoleAdmin},
+	"suspendParticipant":             {RoleAdmin},
+	"setParticipantApprovalRequired": {RoleAdmin},
+	"bankDeposit":                    {RoleLoanOfficer},
+	"buyerDeposit":                   {RoleBuyer},
+	"confirmDeposit":                 {RoleAdmin},
+	"rejectDeposit":                  {RoleAdmin},
+	"getPendingDeposits":             {RoleAdmin},
+	"buyerPayDownPayment":            {RoleBuyer},
+	"getAccountStatement":            participantRoles,
+	"buyerPayInstallment":            {RoleBuyer},
+	"getMortgageInfo":                {RoleBuyer, RoleLoanOfficer, RoleAdmin},
+	"getAmortizationSchedule":        {RoleBuyer, RoleLoanOfficer, RoleAdmin},
+	"getPaymentHistory":              {RoleBuyer, RoleLoanOfficer, RoleAdmin},
+	"bankAssessDelinquency":          {RoleLoanOfficer},
+	"bankForeclose":                  {RoleLoanOfficer},
+	"setServicingTerms":              {RoleAdmin},
+	"getServicingTerms":              participantRoles,
+	"getPayoffQuote":                 {RoleBuyer, RoleLoanOfficer, RoleAdmin},
+	"buyerPayOff":                    {RoleBuyer},
+	"buyerRequestRefinance":          {RoleBuyer},
+	"bankPullOpen4Refinance":         {RoleLoanOfficer},
+	"bankPutRefinanceOffer":          {RoleLoanOfficer},
+	"getRefinanceOffers":             {RoleBuyer, RoleLoanOfficer, RoleAdmin},
+	"buyerAcceptRefinanceOffer":      {RoleBuyer},
+	"buyerPayPremium":                {RoleBuyer},
+	"setBaseRate":                    {RoleAdmin},
+	"getBaseRate":                    participantRoles,
+	"setExchangeRate":                {RoleFXOracle},
+	"getExchangeRate":                exchangeRateReaderRoles,
+	"setChaincodeLinks":              {RoleAdmin},
+	"getChaincodeLinks":              participantRoles,
+	"setCreditScorePolicy":           {RoleAdmin},
+	"getCreditScorePolicy":           participantRoles,
+	"setUnderwritingRules":           {RoleLoanOfficer},
+	"getUnderwritingRules":           participantRoles,
+	"migrateToCompositeKeys":         {RoleAdmin},
+	"migrateQueuesToCompositeKeys":   {RoleAdmin},
+}
+
+//initRoleMSPs - stores the role mapping passed to Init as JSON, or the default one on first instantiation.
+//An upgrade without arguments keeps the stored mapping.
+func (t *HomelendChaincode) initRoleMSPs(stub shim.ChaincodeStubInterface, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Incorrect number of arguments %d.", len(args))
+	}
+
+	roleMSPs := defaultRoleMSPs
+	if len(args) == 1 && len(args[0]) > 0 {
+		roleMSPs = RoleMSPs{}
+		err := json.Unmarshal([]byte(args[0]), &roleMSPs)
+		if err != nil {
+			str := fmt.Sprintf("Failed to parse role mapping: %+v", err)
+			fmt.Println(str)
+			return errors.New(str)
+		}
+	} else {
+		dataAsBytes, err := stub.GetState(roleMSPsKey)
+		if err != nil {
+			str := fmt.Sprintf("Failed to get state %+v", err.Error())
+			return errors.New(str)
+		}
+		if len(dataAsBytes) > 0 {
+			return nil
+		}
+	}
+
+	dataJSONasBytes, err := json.Marshal(roleMSPs)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(roleMSPsKey, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+func (t *HomelendChaincode) getRoleMSPs(stub shim.ChaincodeStubInterface) (RoleMSPs, error) {
+	dataAsBytes, err := stub.GetState(roleMSPsKey)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		return nil, errors.New("role mapping was not initialized")
+	}
+
+	roleMSPs := RoleMSPs{}
+	err = json.Unmarshal(dataAsBytes, &roleMSPs)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal role mapping: %s", err)
+		return nil, errors.New(str)
+	}
+	return roleMSPs, nil
+}
+
+//authorize - returns the caller identity if its certificate holds one of the roles
+//and its MSP is mapped to that role
+func (t *HomelendChaincode) authorize(stub shim.ChaincodeStubInterface, roles []Role) (string, error) {
+	mspid, err := t.identities().GetMSPID(stub)
+	if err != nil {
+		str := fmt.Sprintf("MSPID error %+v", err)
+		fmt.Println(str)
+		return "", errors.New(str)
+	}
+
+	identity, err := t.identities().GetID(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetID error %+v", err)
+		fmt.Println(str)
+		return "", errors.New(str)
+	}
+
+	value, found, err := t.identities().GetAttributeValue(stub, roleAttribute)
+	if err != nil {
+		str := fmt.Sprintf("GetAttributeValue error %+v", err)
+		fmt.Println(str)
+		return "", errors.New(str)
+	}
+	if !found {
+		str := fmt.Sprintf("the certificate of %s has no %s attribute", identity, roleAttribute)
+		fmt.Println(str)
+		return "", errors.New(str)
+	}
+
+	role := Role(value)
+	allowed := false
+	for _, r := range roles {
+		if r == role {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		str := fmt.Sprintf("role %s can not execute this method, required one of %v", role, roles)
+		fmt.Println(str)
+		return "", errors.New(str)
+	}
+
+	roleMSPs, err := t.getRoleMSPs(stub)
+	if err != nil {
+		return "", err
+	}
+	for _, msp := range roleMSPs[role] {
+		if msp == mspid {
+			return identity, nil
+		}
+	}
+
+	str := fmt.Sprintf("MSP %s is not allowed to hold role %s", mspid, role)
+	fmt.Println(str)
+	return "", errors.New(str)
+}
+
+//getRoleMapping - returns the stored role -> MSP IDs mapping
+func (t *HomelendChaincode) getRoleMapping(stub shim.ChaincodeStubInterface) pb.Response {
+	roleMSPs, err := t.getRoleMSPs(stub)
+	if err != nil {
+		str := fmt.Sprintf("getRoleMSPs error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(roleMSPs)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(dataJSONasBytes)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/* *
+* ACCOUNTS
+*
+* {identity}         - the money of a buyer, a seller or an insurer
+* bank_{identity}    - the money a bank can lend
+* escrow_{request}   - the down payment and the loan held for a request until closing
+* external           - money entering or leaving the network, the only account allowed to be negative
+*
+* An account holds one balance per currency, kept under balance~{account}~{currency} as a lib.Money.
+* Balances only change through postJournalEntry, so all balances of a currency always sum up to zero.
+* Money changes currency only through the external account, at the rates of the fx-oracle, see exchangeLines.
+ */
+
+const bankAccountPrefix = "bank_"
+const escrowAccountPrefix = "escrow_"
+const externalAccount = "external"
+
+//every journal entry is stored under the transaction that posted it
+const journalIndex = "journal~tx"
+
+//the balance of an account in one currency
+const balanceIndex = "balance~account~currency"
+
+//one line per account and currency per journal entry, ordered by time, to build statements.
+//Lines posted before currencies were kept apart have no currency attribute.
+const statementIndex = "statement~account~time~tx"
+
+func bankAccount(bankHash string) string {
+	return bankAccountPrefix + bankHash
+}
+
+func escrowAccount(requestHash string) string {
+	return escrowAccountPrefix + requestHash
+}
+
+//JournalLine - one side of a journal entry, a debit adds to the balance of the account and a credit takes from it
+type JournalLine struct {
+	Account string    `json:"Account"`
+	Debit   lib.Money `json:"Debit"`
+	Credit  lib.Money `json:"Credit"`
+}
+
+//currency - the currency of the line, a zero debit or credit without one takes the currency of the other
+func (line JournalLine) currency() string {
+	if line.Debit.Currency != "" {
+		return line.Debit.Currency
+	}
+	return line.Credit.Currency
+}
+
+//JournalEntry - the money movements of one transaction, debits and credits are always equal
+type JournalEntry struct {
+	TxID        string        `json:"TxID"`
+	Description string        `json:"Description"`
+	RequestHash string        `json:"RequestHash"`
+	Lines       []JournalLine `json:"Lines"`
+	Timestamp   time.Time     `json:"Timestamp"`
+}
+
+//StatementLine - a journal line as seen from its account, with the balance after it
+type StatementLine struct {
+	TxID        string    `json:"TxID"`
+	Description string    `json:"Description"`
+	RequestHash string    `json:"RequestHash"`
+	Debit       lib.Money `json:"Debit"`
+	Credit      lib.Money `json:"Credit"`
+	Balance     lib.Money `json:"Balance"`
+	Timestamp   time.Time `json:"Timestamp"`
+}
+
+//transferLines - the journal lines moving sum from one account to another
+func transferLines(from string, to string, sum lib.Money) []JournalLine {
+	zero := lib.NewMoney(0, sum.Currency)
+	return []JournalLine{{Account: from, Debit: zero, Credit: sum}, {Account: to, Debit: sum, Credit: zero}}
+}
+
+//getBalance - the balance of the account in currency, zero when it never held that currency.
+//Balances written before currencies were kept apart are under money_{account} in the lib.DefaultCurrency.
+func (t *HomelendChaincode) getBalance(stub shim.ChaincodeStubInterface, account string, currency string) (lib.Money, error) {
+	key, err := stub.CreateCompositeKey(balanceIndex, []string{account, currency})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return lib.Money{}, errors.New(str)
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err == nil && len(dataAsBytes) == 0 && currency == lib.DefaultCurrency {
+		dataAsBytes, err = stub.GetState(money + account)
+	}
+	if err != nil {
+		str := fmt.Sprintf("Could not get balance of %s %+v", account, err.Error())
+		fmt.Println(str)
+		return lib.Money{}, errors.New(str)
+	}
+
+	if len(dataAsBytes) == 0 {
+		return lib.NewMoney(0, currency), nil
+	}
+
+	balance := lib.Money{}
+	err = json.Unmarshal(dataAsBytes, &balance)
+	if err != nil {
+		str := fmt.Sprintf("Could not parse balance of %s %+v", account, err.Error())
+		fmt.Println(str)
+		return lib.Money{}, errors.New(str)
+	}
+	return balance, nil
+}
+
+//getBalances - the balances of the account in every currency it holds
+func (t *HomelendChaincode) getBalances(stub shim.ChaincodeStubInterface, account string) ([]lib.Money, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(balanceIndex, []string{account})
+	if err != nil {
+		str := fmt.Sprintf("Could not get balances of %s %+v", account, err.Error())
+		fmt.Println(str)
+		return nil, errors.New(str)
+	}
+	defer resultsIterator.Close()
+
+	balances := make([]lib.Money, 0)
+	hasDefault := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		balance := lib.Money{}
+		err = json.Unmarshal(queryResponse.Value, &balance)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", queryResponse.Key, err)
+			return nil, errors.New(str)
+		}
+		hasDefault = hasDefault || balance.Currency == lib.DefaultCurrency
+		balances = append(balances, balance)
+	}
+
+	if !hasDefault {
+		balance, err := t.getBalance(stub, account, lib.DefaultCurrency)
+		if err != nil {
+			return nil, err
+		}
+		if !balance.IsZero() {
+			balances = append(balances, balance)
+		}
+	}
+	return balances, nil
+}
+
+//putBalance - stores the balance under the account and its currency, a legacy money_{account} balance moves with it
+func (t *HomelendChaincode) putBalance(stub shim.ChaincodeStubInterface, account string, balance lib.Money) error {
+	key, err := stub.CreateCompositeKey(balanceIndex, []string{account, balance.Currency})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return errors.New(str)
+	}
+
+	balanceAsBytes, err := json.Marshal(balance)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(key, balanceAsBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+
+	if balance.Currency == lib.DefaultCurrency {
+		err = stub.DelState(money + account)
+		if err != nil {
+			str := fmt.Sprintf("Could not delete state %+v", err.Error())
+			return errors.New(str)
+		}
+	}
+	return nil
+}
+
+//accountCurrency - the balance a journal line changes
+type accountCurrency struct {
+	Account  string
+	Currency string
+}
+
+//postJournalEntry - validates the entry, applies it to the balances and stores it with a statement line per account and currency.
+//Fabric does not let a transaction read its own writes, so a handler posts all its movements in one entry.
+func (t *HomelendChaincode) postJournalEntry(stub shim.ChaincodeStubInterface, description string, requestHash string, lines []JournalLine) error {
+	if len(lines) < 2 {
+		return errors.New("a journal entry needs at least two lines")
+	}
+
+	// debits and credits balance to the cent in every currency of the entry,
+	// an exchange moves one currency into external and the other out of it, see exchangeLines
+	debits := make(map[string]lib.Money)
+	credits := make(map[string]lib.Money)
+	changes := make(map[accountCurrency]lib.Money)
+	for _, line := range lines {
+		if line.Account == "" || line.Debit.IsNegative() || line.Credit.IsNegative() || line.Debit.IsZero() == line.Credit.IsZero() || !line.Debit.SameCurrency(line.Credit) {
+			str := fmt.Sprintf("invalid journal line %+v", line)
+			fmt.Println(str)
+			return errors.New(str)
+		}
+		currency := line.currency()
+		if !lib.ValidCurrency(currency) {
+			str := fmt.Sprintf("journal line %+v has no valid currency", line)
+			fmt.Println(str)
+			return errors.New(str)
+		}
+
+		var err error
+		balance := accountCurrency{Account: line.Account, Currency: currency}
+		debits[currency], err = lib.Sum(debits[currency], line.Debit)
+		if err != nil {
+			return err
+		}
+		credits[currency], err = lib.Sum(credits[currency], line.Credit)
+		if err != nil {
+			return err
+		}
+		changes[balance], err = lib.Sum(changes[balance], line.Debit, line.Credit.Neg())
+		if err != nil {
+			return err
+		}
+	}
+
+	for currency := range debits {
+		cmp, err := debits[currency].Cmp(credits[currency])
+		if err != nil {
+			return err
+		}
+		if cmp != 0 {
+			str := fmt.Sprintf("unbalanced journal entry debits %s credits %s", debits[currency], credits[currency])
+			fmt.Println(str)
+			return errors.New(str)
+		}
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	entry := &JournalEntry{TxID: stub.GetTxID(), Description: description, RequestHash: requestHash, Lines: lines, Timestamp: timestamp}
+
+	var accounts []accountCurrency
+	for balance := range changes {
+		accounts = append(accounts, balance)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Account != accounts[j].Account {
+			return accounts[i].Account < accounts[j].Account
+		}
+		return accounts[i].Currency < accounts[j].Currency
+	})
+
+	balances := make(map[accountCurrency]lib.Money)
+	for _, account := range accounts {
+		balance, err := t.getBalance(stub, account.Account, account.Currency)
+		if err != nil {
+			return err
+		}
+
+		balance, err = balance.Add(changes[account])
+		if err != nil {
+			return err
+		}
+		if balance.IsNegative() && account.Account != externalAccount {
+			str := fmt.Sprintf("not enough money in %s, missing %s", account.Account, balance.Neg())
+			fmt.Println(str)
+			return errors.New(str)
+		}
+		balances[account] = balance
+	}
+
+	for _, account := range accounts {
+		err = t.putBalance(stub, account.Account, balances[account])
+		if err != nil {
+			return err
+		}
+
+		statementLine := &StatementLine{TxID: entry.TxID, Description: description, RequestHash: requestHash, Debit: lib.NewMoney(0, account.Currency), Credit: lib.NewMoney(0, account.Currency), Balance: balances[account], Timestamp: timestamp}
+		for _, line := range lines {
+			if line.Account == account.Account && line.currency() == account.Currency {
+				statementLine.Debit, err = statementLine.Debit.Add(line.Debit)
+				if err != nil {
+					return err
+				}
+				statementLine.Credit, err = statementLine.Credit.Add(line.Credit)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = t.putStatementLine(stub, account.Account, statementLine)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := stub.CreateCompositeKey(journalIndex, []string{entry.TxID})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return errors.New(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(entry)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+func (t *HomelendChaincode) putStatementLine(stub shim.ChaincodeStubInterface, account string, line *StatementLine) error {
+	// zero padded so the keys of an account sort by time
+	key, err := stub.CreateCompositeKey(statementIndex, []string{account, fmt.Sprintf("%020d", line.Timestamp.UnixNano()), line.TxID, line.Balance.Currency})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return errors.New(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(line)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+//canReadAccount - the owner of an account, the buyer of an escrowed request and Homelend may read its statement
+func (t *HomelendChaincode) canReadAccount(stub shim.ChaincodeStubInterface, identity string, account string) bool {
+	if account == identity || account == bankAccount(identity) {
+		return true
+	}
+
+	if _, err := t.getIdentity(stub, RoleAdmin); err == nil {
+		return true
+	}
+
+	if len(account) > len(escrowAccountPrefix) && account[:len(escrowAccountPrefix)] == escrowAccountPrefix {
+		_, err := t.getRequest(stub, identity, account[len(escrowAccountPrefix):])
+		return err == nil
+	}
+	return false
+}
+
+//getAccountStatement - args: account, optional pageSize and bookmark as in the pull functions
+func (t *HomelendChaincode) getAccountStatement(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("getAccountStatement executed with args: %+v", args))
+
+	if len(args) < 1 || len(args[0]) == 0 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	account := args[0]
+
+	identity, err := t.getIdentity(stub, "")
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if !t.canReadAccount(stub, identity, account) {
+		str := fmt.Sprintf("%s can not read the statement of %s", identity, account)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	pageSize, bookmark, err := t.parsePagination(args[1:])
+	if err != nil {
+		str := fmt.Sprintf("parsePagination error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	lines := make([]*StatementLine, 0)
+	next, err := t.readPage(stub, statementIndex, []string{account}, pageSize, bookmark, func(key string, value []byte) error {
+		line := &StatementLine{}
+		err := json.Unmarshal(value, line)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", key, err)
+			return errors.New(str)
+		}
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		str := fmt.Sprintf("Failed to get statement of %s %+v", account, err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.pullResponse(lines, next)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+//statement - the statement lines of the account as seen by the current identity
+func (n *testNetwork) statement(account string) []*StatementLine {
+	n.t.Helper()
+	var lines []*StatementLine
+	n.pull(&lines, "getAccountStatement", account)
+	return lines
+}
+
+//deposit - announces a deposit as the current identity and has Homelend confirm it
+func (n *testNetwork) deposit(function string, args ...string) *Deposit {
+	n.t.Helper()
+	deposit := &Deposit{}
+	err := json.Unmarshal(n.mustInvoke(function, args...), deposit)
+	if err != nil {
+		n.t.Fatalf("could not unmarshal %s result: %s", function, err)
+	}
+
+	identity := *n.identity
+	n.as(homelendMSP, homelendID).mustInvoke("confirmDeposit", deposit.TxID)
+	n.asRole(identity.mspid, identity.id, identity.role)
+	return deposit
+}
+
+func TestAccounting(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	balances := map[string]lib.Money{
+		externalAccount:            usd(-bankDepositAmount - (sellingPrice - loanAmount)),
+		bankAccount(bankID):        usd(bankDepositAmount - loanAmount),
+		escrowAccount(requestHash): usd(0),
+		buyerID:                    usd(0),
+		sellerID:                   usd(sellingPrice),
+	}
+	cc := &HomelendChaincode{}
+	for account, expected := range balances {
+		balance, err := cc.getBalance(n.stub, account, lib.DefaultCurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance != expected {
+			t.Errorf("expected %s balance %s got %s", account, expected, balance)
+		}
+	}
+
+	// every balance comes from a balanced journal entry, so together they are zero
+	sum := lib.Money{}
+	for key, value := range n.stub.State {
+		if strings.HasPrefix(key, money) {
+			t.Errorf("balance %s is still under its legacy key", key)
+		}
+		// composite keys start with a zero byte and the object type
+		if strings.HasPrefix(key, "\x00"+balanceIndex+"\x00") {
+			balance := lib.Money{}
+			err := json.Unmarshal(value, &balance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum = sumOf(t, sum, balance)
+		}
+	}
+	if !sum.IsZero() {
+		t.Errorf("balances sum up to %s", sum)
+	}
+
+	lines := n.as(bankMSP, bankID).statement(bankAccount(bankID))
+	if len(lines) != 2 || lines[0].Debit != usd(bankDepositAmount) || lines[1].Credit != usd(loanAmount) || lines[1].Balance != usd(bankDepositAmount-loanAmount) {
+		t.Errorf("unexpected bank statement %+v", lines)
+	}
+
+	lines = n.as(buyerMSP, buyerID).statement(escrowAccount(requestHash))
+	if len(lines) != 3 || lines[0].Debit != usd(sellingPrice-loanAmount) || lines[1].Debit != usd(loanAmount) || lines[2].Credit != usd(sellingPrice) || !lines[2].Balance.IsZero() {
+		t.Errorf("unexpected escrow statement %+v", lines)
+	}
+
+	lines = n.as(sellerMSP, sellerID).statement(sellerID)
+	if len(lines) != 1 || lines[0].RequestHash != requestHash {
+		t.Errorf("unexpected seller statement %+v", lines)
+	}
+
+	n.as(sellerMSP, sellerID).mustFail("getAccountStatement", bankAccount(bankID))
+	n.as(buyerMSP, "buyer-2").mustFail("getAccountStatement", escrowAccount(requestHash))
+	n.as(homelendMSP, homelendID).statement(bankAccount(bankID))
+
+	var page []*StatementLine
+	bookmark := n.as(bankMSP, bankID).pull(&page, "getAccountStatement", bankAccount(bankID), "1")
+	if len(page) != 1 || bookmark == "" {
+		t.Fatalf("expected a first page of one line got %d", len(page))
+	}
+	n.as(bankMSP, bankID).pull(&page, "getAccountStatement", bankAccount(bankID), "1", bookmark)
+	if len(page) != 1 || page[0].Credit != usd(loanAmount) {
+		t.Errorf("unexpected second page %+v", page)
+	}
+
+	n.as(bankMSP, bankID).mustFail("bankDeposit", "-5")
+}
+
+func TestDownPayment(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(sellerMSP, sellerID).mustInvoke("advertise", fmt.Sprintf(`{"Hash":"%s","SellingPrice":%d}`, propertyHash, sellingPrice))
+	n.as(buyerMSP, buyerID).mustFail("buy", fmt.Sprintf(`{"Hash":"%s","PropertyHash":"%s","SellerHash":"%s","LoanAmount":%d}`, requestHash, propertyHash, sellerID, sellingPrice+1))
+	n.as(buyerMSP, buyerID).mustInvoke("buy", fmt.Sprintf(`{"Hash":"%s","PropertyHash":"%s","SellerHash":"%s","Salary":15000,"LoanAmount":%d,"Duration":240,"DownPaymentPaid":true}`, requestHash, propertyHash, sellerID, loanAmount))
+
+	request := n.request()
+	if request.DownPaymentPaid || request.DownPayment != usd(sellingPrice-loanAmount) || request.SellingPrice != usd(sellingPrice) {
+		t.Fatalf("unexpected down payment %+v", request)
+	}
+
+	// the buyer has not deposited anything yet
+	n.as(buyerMSP, buyerID).mustFail("buyerPayDownPayment", requestHash)
+
+	// the first step of the happy path ran above, without the down payment
+	n.steps = 1
+	n.advanceTo(StatusGovernmentProvided)
+	msg := n.as(bankMSP, bankID).mustFail("bankApprove", requestLinkJSON())
+	if !strings.Contains(msg, "down payment") {
+		t.Errorf("expected a down payment error got %s", msg)
+	}
+
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", fmt.Sprint(sellingPrice))
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayDownPayment", requestHash)
+	n.as(buyerMSP, buyerID).mustFail("buyerPayDownPayment", requestHash)
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	cc := &HomelendChaincode{}
+	if balance, _ := cc.getBalance(n.stub, buyerID, lib.DefaultCurrency); balance != usd(loanAmount) {
+		t.Errorf("expected the buyer to keep %d got %s", loanAmount, balance)
+	}
+	if balance, _ := cc.getBalance(n.stub, sellerID, lib.DefaultCurrency); balance != usd(sellingPrice) {
+		t.Errorf("expected the seller to get %d got %s", sellingPrice, balance)
+	}
+}
+
+func TestJournalEntryInvariants(t *testing.T) {
+	n := newTestNetwork(t)
+	cc := &HomelendChaincode{}
+
+	n.stub.MockTransactionStart("journal")
+	defer n.stub.MockTransactionEnd("journal")
+	stub := newPeerStub(n.stub)
+
+	err := cc.postJournalEntry(stub, "overdraft", "", transferLines(bankAccount("bank-2"), sellerID, usd(1)))
+	if err == nil {
+		t.Errorf("an account without money was debited")
+	}
+
+	err = cc.postJournalEntry(stub, "unbalanced", "", []JournalLine{{Account: externalAccount, Credit: usd(10)}, {Account: sellerID, Debit: usd(5)}})
+	if err == nil {
+		t.Errorf("an unbalanced entry was posted")
+	}
+
+	err = cc.postJournalEntry(stub, "both sides", "", []JournalLine{{Account: externalAccount, Credit: usd(10), Debit: usd(10)}, {Account: sellerID, Debit: usd(0)}})
+	if err == nil {
+		t.Errorf("an invalid line was posted")
+	}
+}
+
+func TestMultiCurrency(t *testing.T) {
+	n := newTestNetwork(t)
+
+	// a property listed in EUR bought with a USD loan
+	n.as(sellerMSP, sellerID).mustInvoke("advertise", fmt.Sprintf(`{"Hash":"%s","SellingPrice":"250000","Currency":"EUR"}`, propertyHash))
+	buy := fmt.Sprintf(`{"Hash":"%s","PropertyHash":"%s","SellerHash":"%s","Salary":15000,"LoanAmount":180000,"Duration":240}`, requestHash, propertyHash, sellerID)
+	msg := n.as(buyerMSP, buyerID).mustFail("buy", buy)
+	if !strings.Contains(msg, "no exchange rate") {
+		t.Errorf("expected a missing rate error got %s", msg)
+	}
+
+	n.as(sellerMSP, sellerID).mustFail("setExchangeRate", "EUR", "USD", "1.2")
+	n.as(oracleMSP, oracleID).mustFail("setExchangeRate", "EUR", "EUR", "1")
+	n.as(oracleMSP, oracleID).mustFail("setExchangeRate", "EUR", "USD", "-1.2")
+	n.as(oracleMSP, oracleID).mustFail("getRequestInfo", buyerID, requestHash)
+	n.as(oracleMSP, oracleID).mustInvoke("setExchangeRate", "EUR", "USD", "1.2")
+
+	rate := &ExchangeRate{}
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getExchangeRate", "USD", "EUR"), rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Base != "EUR" || rate.Quote != "USD" || rate.Rate != "1.2" || rate.Oracle != oracleID {
+		t.Errorf("unexpected rate %+v", rate)
+	}
+
+	// 180000 USD buy 150000 EUR of the price
+	n.as(buyerMSP, buyerID).mustInvoke("buy", buy)
+	request := n.request()
+	if request.Currency != "USD" || request.LoanAmount != usd(180000) || request.DownPayment != lib.MajorUnits(100000, "EUR") {
+		t.Fatalf("unexpected request %+v", request)
+	}
+
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", "100000", "EUR")
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayDownPayment", requestHash)
+
+	n.steps = 1
+	n.advanceTo(StatusAppraiserChosen)
+	// 170000 EUR are 204000 USD, enough for the loan only once converted
+	n.as(appraiserMSP, appraiserID).mustInvoke("appraiserProvideAmount", buyerID, requestHash, "170000")
+	n.steps++
+	n.advanceTo(StatusApprovedByBank)
+
+	request = n.request()
+	if request.AppraiserAmount != lib.MajorUnits(170000, "EUR") || request.InsuranceOffers[0].Currency != "USD" || request.BankOffers[0].Currency != "USD" {
+		t.Errorf("unexpected currencies %+v", request)
+	}
+
+	// the bank paid the loan in USD, the escrow holds the whole price in EUR
+	cc := &HomelendChaincode{}
+	if balance, _ := cc.getBalance(n.stub, escrowAccount(requestHash), "EUR"); balance != lib.MajorUnits(250000, "EUR") {
+		t.Errorf("expected the escrow to hold 250000 EUR got %s", balance)
+	}
+	if balance, _ := cc.getBalance(n.stub, bankAccount(bankID), lib.DefaultCurrency); balance != usd(bankDepositAmount-180000) {
+		t.Errorf("expected the bank to pay 180000 USD got %s", balance)
+	}
+
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	myInfo := &MyInfo{}
+	err = json.Unmarshal(n.as(sellerMSP, sellerID).mustInvoke("getMyInfo"), myInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !myInfo.Balance.IsZero() || len(myInfo.Balances) != 1 || myInfo.Balances[0] != lib.MajorUnits(250000, "EUR") {
+		t.Errorf("unexpected seller balances %+v", myInfo)
+	}
+
+	mortgage, err := cc.getMortgage(n.stub, buyerID, requestHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mortgage.Principal != usd(180000) {
+		t.Errorf("expected a mortgage of 180000 USD got %s", mortgage.Principal)
+	}
+}
+
+func TestExchangeRateAppraisal(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(oracleMSP, oracleID).mustInvoke("setExchangeRate", "USD", "EUR", "0.5")
+	n.advanceTo(StatusAppraiserChosen)
+
+	// at 0.5 EUR per USD the appraisal is 200000 USD, the loan is above the default maximum LTV
+	n.as(appraiserMSP, appraiserID).mustInvoke("appraiserProvideAmount", buyerID, requestHash, "100000", "EUR")
+	n.steps++
+	n.advanceTo(StatusGovernmentProvided)
+
+	n.as(bankMSP, bankID).mustInvoke("bankApprove", requestLinkJSON())
+	request := n.request()
+	if request.Status != StatusDeclinedByBank || request.DeclineInfo != "loan to value 100.00% is above the maximum of 90.00%" {
+		t.Errorf("expected 100000 EUR to be too low for %s got %s %s", request.LoanAmount, request.Status, request.DeclineInfo)
+	}
+}
+
+func TestLegacyBalance(t *testing.T) {
+	n := newTestNetwork(t)
+	cc := &HomelendChaincode{}
+
+	n.stub.MockTransactionStart("seed")
+	n.stub.PutState(money+sellerID, []byte("500"))
+	n.stub.MockTransactionEnd("seed")
+
+	if balance, _ := cc.getBalance(n.stub, sellerID, lib.DefaultCurrency); balance != usd(500) {
+		t.Errorf("expected the legacy balance 500 got %s", balance)
+	}
+
+	n.stub.MockTransactionStart("journal")
+	err := cc.postJournalEntry(newPeerStub(n.stub), "deposit", "", transferLines(externalAccount, sellerID, usd(10)))
+	n.stub.MockTransactionEnd("journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance, _ := cc.getBalance(n.stub, sellerID, lib.DefaultCurrency); balance != usd(510) {
+		t.Errorf("expected 510 got %s", balance)
+	}
+	if _, ok := n.stub.State[money+sellerID]; ok {
+		t.Errorf("the legacy balance was not removed")
+	}
+}
+
+func TestUnreadableBalance(t *testing.T) {
+	n := newTestNetwork(t)
+
+	n.stub.MockTransactionStart("seed")
+	n.stub.PutState(money+buyerID, []byte("not a balance"))
+	n.stub.MockTransactionEnd("seed")
+
+	// a balance that can not be read is an error, not a balance of -1
+	msg := n.as(buyerMSP, buyerID).mustFail("getMyInfo")
+	if !strings.Contains(msg, "Could not parse balance") {
+		t.Errorf("expected the balance to be unreadable got %s", msg)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//ProductType - how the rate of a BankOffer behaves over the loan
+type ProductType string
+
+// Mortgage products a bank can offer
+const (
+	// ProductFixed keeps Interest for the whole loan
+	ProductFixed ProductType = "fixed"
+	// ProductVariable pays the base rate of BaseRateIndex plus Margin, reset every ResetMonths
+	ProductVariable ProductType = "variable"
+	// ProductHybrid keeps Interest for FixedMonths, then behaves as ProductVariable
+	ProductHybrid ProductType = "hybrid"
+)
+
+//variable rates reset yearly unless the offer says otherwise
+const defaultResetMonths = 12
+
+//every base rate index is stored under its own key
+const baseRateIndex = "baseRate~index"
+
+//BaseRate - a rate index variable products are priced on, published by a Homelend admin
+type BaseRate struct {
+	Index     string    `json:"Index"`
+	Rate      float32   `json:"Rate"`
+	Timestamp time.Time `json:"Timestamp"`
+}
+
+//RateReset - a change of the rate of a variable or hybrid mortgage
+type RateReset struct {
+	Installment    int       `json:"Installment"`
+	BaseRate       float32   `json:"BaseRate"`
+	Interest       float32   `json:"Interest"`
+	MonthlyPayment lib.Money `json:"MonthlyPayment"`
+	Timestamp      time.Time `json:"Timestamp"`
+}
+
+//selectedBankOffer - nil when the buyer did not select an offer
+func selectedBankOffer(request *Request) *BankOffer {
+	for i := range request.BankOffers {
+		if request.BankOffers[i].Hash == request.SelectedBankOfferHash {
+			return &request.BankOffers[i]
+		}
+	}
+	return nil
+}
+
+//expired - whether the offer can no longer be accepted at now
+func (offer *BankOffer) expired(now time.Time) bool {
+	return !offer.ExpiresAt.IsZero() && now.After(offer.ExpiresAt)
+}
+
+//ltvExceeded - whether the loan is above the loan to value cap of the offer, the value is in the currency of the loan
+func (offer *BankOffer) ltvExceeded(loanAmount lib.Money, value lib.Money) (bool, error) {
+	if offer.MaxLTV <= 0 {
+		return false, nil
+	}
+	if !value.IsPositive() {
+		return true, nil
+	}
+	cmp, err := loanAmount.Cmp(value.Percent(offer.MaxLTV))
+	return cmp > 0, err
+}
+
+func (t *HomelendChaincode) getBaseRate(stub shim.ChaincodeStubInterface, index string) (*BaseRate, error) {
+	key, err := stub.CreateCompositeKey(baseRateIndex, []string{index})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		str := fmt.Sprintf("base rate %s was not published", index)
+		return nil, errors.New(str)
+	}
+
+	baseRate := &BaseRate{}
+	err = json.Unmarshal(dataAsBytes, baseRate)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal base rate: %s", err)
+		return nil, errors.New(str)
+	}
+	return baseRate, nil
+}
+
+//parseBankOffer - reads and validates the offer document a bank submits for a loan of principal over months.
+//Months is the duration when the offer does not set one, the rate of a variable offer starts at the current base rate.
+//The offer lends in the currency of the principal, plain Fees are amounts of that currency.
+func (t *HomelendChaincode) parseBankOffer(stub shim.ChaincodeStubInterface, document string, bankIdentity string, months int, principal lib.Money) (*BankOffer, error) {
+	dataAsBytes, currency, err := denominate(document, principal.Currency, "Fees")
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse offer JSON: %+v", err)
+		return nil, errors.New(str)
+	}
+	if currency != principal.Currency {
+		return nil, fmt.Errorf("an offer in %s for a loan in %s", currency, principal.Currency)
+	}
+
+	offer := &BankOffer{}
+	err = json.Unmarshal(dataAsBytes, offer)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse offer JSON: %+v", err)
+		return nil, errors.New(str)
+	}
+
+	helpers := lib.Helpers{}
+	offer.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	offer.BankHash = bankIdentity
+	offer.Currency = currency
+
+	if len(offer.Hash) == 0 {
+		return nil, errors.New("Provide Bank Offer Hash")
+	}
+	if offer.Product == "" {
+		offer.Product = ProductFixed
+	}
+	if offer.Duration == 0 {
+		offer.Duration = months
+	}
+	if offer.Fees.IsNegative() || offer.MaxLTV < 0 || offer.PrepaymentPenalty < 0 || offer.PrepaymentPenalty > 100 {
+		return nil, errors.New("fees, max LTV and prepayment penalty can not be negative")
+	}
+	if offer.expired(offer.Timestamp) {
+		return nil, fmt.Errorf("the offer expired at %s", offer.ExpiresAt)
+	}
+
+	switch offer.Product {
+	case ProductFixed:
+		offer.BaseRateIndex = ""
+		offer.Margin = 0
+		offer.FixedMonths = 0
+		offer.ResetMonths = 0
+	case ProductVariable, ProductHybrid:
+		baseRate, err := t.getBaseRate(stub, offer.BaseRateIndex)
+		if err != nil {
+			return nil, err
+		}
+		if offer.ResetMonths == 0 {
+			offer.ResetMonths = defaultResetMonths
+		}
+		if offer.ResetMonths < 0 {
+			return nil, errors.New("invalid: reset months")
+		}
+		if offer.Product == ProductVariable {
+			offer.FixedMonths = 0
+			offer.Interest = baseRate.Rate + offer.Margin
+		} else if offer.FixedMonths < 1 || offer.FixedMonths >= offer.Duration {
+			return nil, errors.New("a hybrid offer needs a fixed period shorter than its duration")
+		}
+	default:
+		return nil, fmt.Errorf("unknown product %s", offer.Product)
+	}
+
+	// fees given without a currency are in the currency of the loan
+	fees, err := offer.Fees.Add(lib.NewMoney(0, principal.Currency))
+	if err != nil {
+		return nil, fmt.Errorf("fees in %s for a loan in %s", offer.Fees.Currency, principal.Currency)
+	}
+	offer.Fees = fees
+
+	offer.MonthlyPayment, err = t.mortgagePayment(offer.Interest, offer.Duration, principal)
+	if err != nil {
+		return nil, err
+	}
+	return offer, nil
+}
+
+//applyOfferTerms - the mortgage continues on the rate terms of the offer from the installment after the paid ones
+func (m *Mortgage) applyOfferTerms(offer *BankOffer) {
+	m.BankHash = offer.BankHash
+	m.BankOfferHash = offer.Hash
+	m.Product = offer.Product
+	m.Interest = offer.Interest
+	m.BaseRateIndex = offer.BaseRateIndex
+	m.Margin = offer.Margin
+	m.ResetMonths = offer.ResetMonths
+	m.PrepaymentPenalty = offer.PrepaymentPenalty
+
+	switch offer.Product {
+	case ProductVariable:
+		m.NextRateReset = m.InstallmentsPaid + 1
+	case ProductHybrid:
+		m.NextRateReset = m.InstallmentsPaid + offer.FixedMonths + 1
+	default:
+		m.NextRateReset = 0
+	}
+}
+
+//resetRate - when the next installment starts a new rate period of a variable or hybrid mortgage,
+//prices it on the current base rate and spreads the outstanding principal over the remaining months
+func (t *HomelendChaincode) resetRate(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	next := mortgage.InstallmentsPaid + 1
+	if mortgage.NextRateReset == 0 || next < mortgage.NextRateReset || !mortgage.OutstandingPrincipal.IsPositive() {
+		return nil
+	}
+
+	baseRate, err := t.getBaseRate(stub, mortgage.BaseRateIndex)
+	if err != nil {
+		return err
+	}
+
+	helpers := lib.Helpers{}
+	now, err := helpers.GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	interest := baseRate.Rate + mortgage.Margin
+	if interest < 0 {
+		interest = 0
+	}
+	payment, err := t.mortgagePayment(interest, mortgage.remainingMonths(), mortgage.OutstandingPrincipal)
+	if err != nil {
+		return err
+	}
+
+	mortgage.Interest = interest
+	mortgage.MonthlyPayment = payment
+	mortgage.RateResets = append(mortgage.RateResets, RateReset{Installment: next, BaseRate: baseRate.Rate, Interest: interest, MonthlyPayment: payment, Timestamp: now})
+	for mortgage.NextRateReset <= next {
+		mortgage.NextRateReset += mortgage.ResetMonths
+	}
+	return nil
+}
+
+//setBaseRate - Homelend admin publishes a base rate, args: index, rate
+func (t *HomelendChaincode) setBaseRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setBaseRate executed with args: %+v", args))
+
+	if len(args) != 2 || len(args[0]) == 0 {
+		str := fmt.Sprintf("Incorrect arguments %+v.", args)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	_, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	rate, err := strconv.ParseFloat(args[1], 32)
+	if err != nil || rate < -100 || rate > 100 {
+		str := fmt.Sprintf("rate args[1] - invalid input %+v", args[1])
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	key, err := stub.CreateCompositeKey(baseRateIndex, []string{args[0]})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(&BaseRate{Index: args[0], Rate: float32(rate), Timestamp: timestamp})
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(nil)
+}
+
+//getBaseRateInfo - args: index
+func (t *HomelendChaincode) getBaseRateInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	baseRate, err := t.getBaseRate(stub, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getBaseRate error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(baseRate)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestBaseRate(t *testing.T) {
+	n := newTestNetwork(t)
+
+	// there is no default, a base rate exists once Homelend publishes it
+	msg := n.as(bankMSP, bankID).mustFail("getBaseRate", "prime")
+	if !strings.Contains(msg, "was not published") {
+		t.Errorf("expected an unpublished base rate got %s", msg)
+	}
+	n.as(bankMSP, bankID).mustFail("getBaseRate")
+
+	n.as(bankMSP, bankID).mustFail("setBaseRate", "prime", "2.25")
+	n.as(homelendMSP, homelendID).mustFail("setBaseRate", "prime", "high")
+	n.as(homelendMSP, homelendID).mustInvoke("setBaseRate", "prime", "2.25")
+
+	baseRate := &BaseRate{}
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getBaseRate", "prime"), baseRate)
+	if err != nil || baseRate.Index != "prime" || baseRate.Rate != 2.25 || baseRate.Timestamp.IsZero() {
+		t.Errorf("unexpected base rate %+v %v", baseRate, err)
+	}
+	n.as(buyerMSP, buyerID).mustFail("getBaseRate", "libor")
+}
+
+func TestBankOfferProducts(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusBankOfferInstalled)
+	n.as(homelendMSP, homelendID).mustInvoke("setBaseRate", "prime", "2")
+
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), `{"Hash":"v-1","Product":"variable","BaseRateIndex":"libor","Margin":1}`)
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), `{"Hash":"x-1","Product":"balloon","Interest":3}`)
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), `{"Hash":"h-0","Product":"hybrid","Interest":3,"BaseRateIndex":"prime","FixedMonths":240}`)
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), `{"Hash":"e-1","Interest":3,"ExpiresAt":"2000-01-01T00:00:00Z"}`)
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), `{"Hash":"l-1","Interest":3,"MaxLTV":50}`)
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), offerJSON(bankOfferHash, 3))
+
+	n.as(bankMSP, bankID).mustInvoke("bankPutOffer", requestLinkJSON(), `{"Hash":"v-1","Product":"variable","BaseRateIndex":"prime","Margin":1,"MaxLTV":70}`)
+	n.as(bankMSP, bankID).mustInvoke("bankPutOffer", requestLinkJSON(), `{"Hash":"h-1","Product":"hybrid","Interest":3.5,"BaseRateIndex":"prime","Margin":1.5,"FixedMonths":2,"ResetMonths":1,"Duration":120,"Fees":500}`)
+
+	// 200000 at 3.5% over 120 months
+	hybridPayment := lib.NewMoney(197772, lib.DefaultCurrency)
+	request := n.request()
+	variable, hybrid := request.BankOffers[1], request.BankOffers[2]
+	if variable.Interest != 3 || variable.ResetMonths != defaultResetMonths || variable.Duration != 240 {
+		t.Errorf("unexpected variable offer %+v", variable)
+	}
+	if hybrid.Duration != 120 || hybrid.MonthlyPayment != hybridPayment {
+		t.Errorf("unexpected hybrid offer %+v", hybrid)
+	}
+
+	n.as(buyerMSP, buyerID).mustInvoke("buyerSelectBankOffer", requestHash, "h-1")
+	n.steps = 4
+	n.advanceTo(StatusGovernmentProvided)
+
+	// the escrow holds the fees from approval on, the buyer needs them besides the down payment
+	msg := n.as(bankMSP, bankID).mustFail("bankApprove", requestLinkJSON())
+	if !strings.Contains(msg, "fees") {
+		t.Errorf("expected the fees to be missing got %s", msg)
+	}
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", "10000")
+	n.advanceTo(StatusApprovedByBank)
+
+	cc := &HomelendChaincode{}
+	if balance, _ := cc.getBalance(n.stub, escrowAccount(requestHash), lib.DefaultCurrency); balance != usd(sellingPrice+500) {
+		t.Errorf("expected the escrow to hold the price and the fees got %s", balance)
+	}
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	if balance, _ := cc.getBalance(n.stub, bankAccount(bankID), lib.DefaultCurrency); balance != usd(bankDepositAmount-loanAmount+500) {
+		t.Errorf("expected the bank to collect the fees got %s", sumOf(t, balance, usd(bankDepositAmount-loanAmount).Neg()))
+	}
+
+	mortgage := n.mortgageInfo().Mortgage
+	if mortgage.Product != ProductHybrid || mortgage.TermMonths != 120 || mortgage.Interest != 3.5 || mortgage.NextRateReset != 3 || mortgage.MonthlyPayment != hybridPayment {
+		t.Fatalf("unexpected hybrid mortgage %+v", mortgage)
+	}
+
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", "100000")
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayInstallment", requestHash)
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayInstallment", requestHash)
+	n.as(homelendMSP, homelendID).mustInvoke("setBaseRate", "prime", "4")
+
+	// the fixed period is over, the third installment is priced on the new base rate
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayInstallment", requestHash)
+	mortgage, _ = cc.getMortgage(n.stub, buyerID, requestHash)
+	if len(mortgage.RateResets) != 1 || mortgage.RateResets[0].Installment != 3 || mortgage.Interest != 5.5 || mortgage.NextRateReset != 4 {
+		t.Fatalf("unexpected rate reset %+v", mortgage.RateResets)
+	}
+	if mortgage.Payments[2].Amount != mortgage.RateResets[0].MonthlyPayment || mortgage.RateResets[0].MonthlyPayment.Amount <= hybridPayment.Amount {
+		t.Errorf("expected the third installment to pay %s got %s", mortgage.RateResets[0].MonthlyPayment, mortgage.Payments[2].Amount)
+	}
+
+	var schedule []Installment
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getAmortizationSchedule", buyerID, requestHash), &schedule)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	principal := usd(0)
+	for _, installment := range schedule {
+		principal = sumOf(t, principal, installment.Principal)
+	}
+	if len(schedule) != 120 || principal != usd(loanAmount) || schedule[0].Payment != hybridPayment || !schedule[119].Balance.IsZero() {
+		t.Errorf("unexpected schedule of %d installments repaying %s", len(schedule), principal)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//the chaincodes lending_chaincode invokes, written by a Homelend admin
+const chaincodeLinksKey = "chaincodeLinks"
+
+//ChaincodeLink - the name a chaincode was instantiated with and its channel, an empty Channel is the channel of lending_chaincode
+type ChaincodeLink struct {
+	Chaincode string `json:"Chaincode"`
+	Channel   string `json:"Channel"`
+}
+
+//ChaincodeLinks - where the other Homelend chaincodes run.
+//CreditAgencies are the scoring chaincodes of single credit agencies by identity, the others use CreditScore.
+//An empty CreditScore chaincode scores requests with the model built into lending_chaincode.
+//Government is the land registry governmentVerify checks the title of a property in, closing, payoff,
+//refinancing and foreclosure record the changes of the title and of the lien of the bank in it.
+//Insurance issues the policy of the selected insurance offer at closing and keeps its premiums and status.
+type ChaincodeLinks struct {
+	CreditScore    ChaincodeLink            `json:"CreditScore"`
+	CreditAgencies map[string]ChaincodeLink `json:"CreditAgencies"`
+	Government     ChaincodeLink            `json:"Government"`
+	Insurance      ChaincodeLink            `json:"Insurance"`
+}
+
+//validate - the chaincodes every closing invokes need a name, an empty CreditScore falls back to the built-in model
+func (links *ChaincodeLinks) validate() error {
+	if links.Government.Chaincode == "" {
+		return errors.New("the Government chaincode can not be empty")
+	}
+	if links.Insurance.Chaincode == "" {
+		return errors.New("the Insurance chaincode can not be empty")
+	}
+	return nil
+}
+
+//creditScoreLink - the scoring chaincode of the credit agency
+func (links *ChaincodeLinks) creditScoreLink(agencyHash string) ChaincodeLink {
+	if link, ok := links.CreditAgencies[agencyHash]; ok {
+		return link
+	}
+	return links.CreditScore
+}
+
+//defaultChaincodeLinks - the names scripts/script.sh instantiates the chaincodes with
+var defaultChaincodeLinks = ChaincodeLinks{
+	CreditScore: ChaincodeLink{Chaincode: "creditscore_chaincode"},
+	Government:  ChaincodeLink{Chaincode: "government_chaincode"},
+	Insurance:   ChaincodeLink{Chaincode: "insurance_chaincode"},
+}
+
+func (t *HomelendChaincode) getChaincodeLinks(stub shim.ChaincodeStubInterface) (*ChaincodeLinks, error) {
+	dataAsBytes, err := stub.GetState(chaincodeLinksKey)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	links := defaultChaincodeLinks
+	if len(dataAsBytes) == 0 {
+		return &links, nil
+	}
+
+	err = json.Unmarshal(dataAsBytes, &links)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal chaincode links: %s", err)
+		return nil, errors.New(str)
+	}
+	return &links, nil
+}
+
+//invokeLinkedChaincode - calls function of the linked chaincode with a JSON argument and unmarshals the JSON payload into result
+func (t *HomelendChaincode) invokeLinkedChaincode(stub shim.ChaincodeStubInterface, link ChaincodeLink, function string, argument interface{}, result interface{}) error {
+	argumentAsBytes, err := json.Marshal(argument)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	return t.callLinkedChaincode(stub, link, result, function, string(argumentAsBytes))
+}
+
+//callLinkedChaincode - calls function of the linked chaincode with plain string arguments and unmarshals the JSON payload into result
+func (t *HomelendChaincode) callLinkedChaincode(stub shim.ChaincodeStubInterface, link ChaincodeLink, result interface{}, function string, args ...string) error {
+	input := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+
+	res := stub.InvokeChaincode(link.Chaincode, input, link.Channel)
+	if res.Status != shim.OK {
+		str := fmt.Sprintf("%s of %s failed: %s", function, link.Chaincode, res.Message)
+		return errors.New(str)
+	}
+
+	err := json.Unmarshal(res.Payload, result)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal the response of %s: %s", link.Chaincode, err)
+		return errors.New(str)
+	}
+	return nil
+}
+
+//setChaincodeLinks - Homelend admin sets the names and channels of the linked chaincodes, args: ChaincodeLinks JSON.
+//The links left out keep the ones in force, CreditAgencies replaces all agency links when it is given.
+func (t *HomelendChaincode) setChaincodeLinks(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setChaincodeLinks executed with args: %+v", args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	_, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	links, err := t.getChaincodeLinks(stub)
+	if err != nil {
+		str := fmt.Sprintf("getChaincodeLinks error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal([]byte(args[0]), &fields)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if _, ok := fields["CreditAgencies"]; ok {
+		links.CreditAgencies = nil
+	}
+
+	err = json.Unmarshal([]byte(args[0]), links)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = links.validate()
+	if err != nil {
+		str := fmt.Sprintf("Invalid chaincode links %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(links)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(chaincodeLinksKey, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(nil)
+}
+
+//getChaincodeLinksInfo - returns the linked chaincodes in force
+func (t *HomelendChaincode) getChaincodeLinksInfo(stub shim.ChaincodeStubInterface) pb.Response {
+	links, err := t.getChaincodeLinks(stub)
+	if err != nil {
+		str := fmt.Sprintf("getChaincodeLinks error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(links)
+}
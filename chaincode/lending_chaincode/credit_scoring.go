@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//getMortgagesByBuyer - every mortgage the buyer ever had, whatever its status
+func (t *HomelendChaincode) getMortgagesByBuyer(stub shim.ChaincodeStubInterface, buyerHash string) ([]*Mortgage, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(mortgageIndex, []string{buyerHash})
+	if err != nil {
+		str := fmt.Sprintf("Failed to get mortgages of %s %+v", buyerHash, err.Error())
+		return nil, errors.New(str)
+	}
+	defer resultsIterator.Close()
+
+	mortgages := make([]*Mortgage, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		mortgage := &Mortgage{}
+		err = json.Unmarshal(queryResponse.Value, mortgage)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", queryResponse.Key, err)
+			return nil, errors.New(str)
+		}
+		mortgages = append(mortgages, mortgage)
+	}
+	return mortgages, nil
+}
+
+//creditInput - the request with the obligations and repayment history of its buyer on the ledger.
+//Payments of open mortgages count as obligations, installments paid past the grace period of the servicing terms as late.
+func (t *HomelendChaincode) creditInput(stub shim.ChaincodeStubInterface, request *Request) (lib.CreditInput, error) {
+	currency := request.LoanAmount.Currency
+	salary, err := t.convert(stub, request.Salary, currency)
+	if err != nil {
+		return lib.CreditInput{}, err
+	}
+	input := lib.CreditInput{Salary: salary, LoanAmount: request.LoanAmount, MonthlyObligations: lib.NewMoney(0, currency)}
+
+	terms, err := t.getServicingTerms(stub)
+	if err != nil {
+		return lib.CreditInput{}, err
+	}
+
+	mortgages, err := t.getMortgagesByBuyer(stub, request.BuyerHash)
+	if err != nil {
+		return lib.CreditInput{}, err
+	}
+
+	for _, mortgage := range mortgages {
+		if mortgage.RequestHash == request.Hash {
+			continue
+		}
+
+		switch mortgage.Status {
+		case MortgageDefaulted, MortgageForeclosed:
+			input.Defaults++
+		}
+		switch mortgage.Status {
+		case MortgageActive, MortgageLate, MortgageDelinquent, MortgageDefaulted:
+			payment, err := t.convert(stub, mortgage.MonthlyPayment, currency)
+			if err != nil {
+				return lib.CreditInput{}, err
+			}
+			input.MonthlyObligations, err = input.MonthlyObligations.Add(payment)
+			if err != nil {
+				return lib.CreditInput{}, err
+			}
+		}
+
+		for _, payment := range mortgage.Payments {
+			if payment.DueDate.IsZero() {
+				continue
+			}
+			if payment.Timestamp.After(payment.DueDate.AddDate(0, 0, terms.LateAfterDays)) {
+				input.PaymentsLate++
+			} else {
+				input.PaymentsOnTime++
+			}
+		}
+	}
+	return input, nil
+}
+
+//scoreRequest - the credit score of the request by the creditscore chaincode linked for the agency,
+//or by the model built into lending_chaincode (lib.ScoreCredit) when no chaincode is linked
+func (t *HomelendChaincode) scoreRequest(stub shim.ChaincodeStubInterface, request *Request, agencyHash string) (*lib.CreditScoreResponse, error) {
+	input, err := t.creditInput(stub, request)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := t.getChaincodeLinks(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	link := links.creditScoreLink(agencyHash)
+	if link.Chaincode == "" {
+		score, err := lib.ScoreCredit(input)
+		if err != nil {
+			return nil, err
+		}
+		return &lib.CreditScoreResponse{RequestHash: request.Hash, Model: lib.CreditModel, CreditScore: score}, nil
+	}
+
+	response := &lib.CreditScoreResponse{}
+	err = t.invokeLinkedChaincode(stub, link, "score", &lib.CreditScoreRequest{RequestHash: request.Hash, Input: input}, response)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.Validate(request.Hash)
+	if err != nil {
+		str := fmt.Sprintf("invalid response of %s: %s", link.Chaincode, err)
+		return nil, errors.New(str)
+	}
+	return response, nil
+}
+
+//the policy combining the scores of the credit agencies, written by a Homelend admin
+const creditScorePolicyKey = "creditScorePolicy"
+
+// Aggregations of the scores of several credit agencies
+const (
+	AggregateMedian = "median"
+	AggregateLowest = "lowest"
+)
+
+//CreditScorePolicy - how many credit agencies score a request before it is open to bank offers and how their scores are combined.
+//The median of an even number of scores is the lower of the two middle ones, so the aggregate is always the score of one agency.
+type CreditScorePolicy struct {
+	RequiredScores int    `json:"RequiredScores"`
+	Aggregation    string `json:"Aggregation"`
+}
+
+//defaultCreditScorePolicy - the first agency decides, as before several agencies could score a request
+var defaultCreditScorePolicy = CreditScorePolicy{RequiredScores: 1, Aggregation: AggregateMedian}
+
+func (policy *CreditScorePolicy) validate() error {
+	if policy.RequiredScores < 1 {
+		return errors.New("RequiredScores must be at least 1")
+	}
+	if policy.Aggregation != AggregateMedian && policy.Aggregation != AggregateLowest {
+		return fmt.Errorf("unknown aggregation %q, expected %s or %s", policy.Aggregation, AggregateMedian, AggregateLowest)
+	}
+	return nil
+}
+
+//AgencyCreditScore - the score one credit agency gave a request
+type AgencyCreditScore struct {
+	AgencyHash string                   `json:"AgencyHash"`
+	Details    *lib.CreditScoreResponse `json:"Details"`
+	Timestamp  time.Time                `json:"Timestamp"`
+}
+
+func (t *HomelendChaincode) getCreditScorePolicy(stub shim.ChaincodeStubInterface) (*CreditScorePolicy, error) {
+	dataAsBytes, err := stub.GetState(creditScorePolicyKey)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	policy := defaultCreditScorePolicy
+	if len(dataAsBytes) == 0 {
+		return &policy, nil
+	}
+
+	err = json.Unmarshal(dataAsBytes, &policy)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal credit score policy: %s", err)
+		return nil, errors.New(str)
+	}
+	return &policy, nil
+}
+
+//aggregateCreditScores - the score of the agency the aggregation selects, ties are broken by the agency hash so every peer selects the same one
+func aggregateCreditScores(scores []AgencyCreditScore, aggregation string) AgencyCreditScore {
+	sorted := append([]AgencyCreditScore{}, scores...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Details.Score != sorted[j].Details.Score {
+			return sorted[i].Details.Score < sorted[j].Details.Score
+		}
+		return sorted[i].AgencyHash < sorted[j].AgencyHash
+	})
+
+	if aggregation == AggregateLowest {
+		return sorted[0]
+	}
+	return sorted[(len(sorted)-1)/2]
+}
+
+//setCreditScorePolicy - Homelend admin sets how many agencies score a request and the aggregation, args: CreditScorePolicy JSON.
+//Requests already scored by enough agencies under the previous policy are not scored again.
+func (t *HomelendChaincode) setCreditScorePolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setCreditScorePolicy executed with args: %+v", args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	_, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	policy := &CreditScorePolicy{}
+	err = json.Unmarshal([]byte(args[0]), policy)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = policy.validate()
+	if err != nil {
+		str := fmt.Sprintf("invalid credit score policy %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(policy)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(creditScorePolicyKey, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(nil)
+}
+
+//getCreditScorePolicyInfo - returns the credit score policy in force
+func (t *HomelendChaincode) getCreditScorePolicyInfo(stub shim.ChaincodeStubInterface) pb.Response {
+	policy, err := t.getCreditScorePolicy(stub)
+	if err != nil {
+		str := fmt.Sprintf("getCreditScorePolicy error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(policy)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestCreditScore(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusInitialized)
+	n.as(buyerMSP, buyerID).mustFail("creditScore", requestLinkJSON())
+
+	n.advanceTo(StatusCreditScoreInstalled)
+	request := n.request()
+	if request.CreditScore != "B" || request.CreditScoreIdentity != creditAgencyID {
+		t.Errorf("unexpected credit score %s by %s", request.CreditScore, request.CreditScoreIdentity)
+	}
+	if details := request.CreditScoreDetails; details == nil || details.Score != 700 || len(details.Reasons) != 1 || details.Reasons[0] != lib.ReasonNoRepaymentHistory || details.Model != lib.CreditModel {
+		t.Errorf("unexpected credit score details %+v", details)
+	}
+	if len(n.creditScore.requests) != 1 || n.creditScore.requests[0].RequestHash != requestHash || n.creditScore.requests[0].Input.Salary != usd(15000) {
+		t.Errorf("creditscore_chaincode was not invoked with the request: %+v", n.creditScore.requests)
+	}
+	if hasLink(n.requestLinks(creditRankOpenRequests), requestHash) || !hasLink(n.requestLinks(open4bankOffers), requestHash) {
+		t.Errorf("request was not moved to %s", open4bankOffers)
+	}
+
+	var links []*RequestLink
+	n.as(bankMSP, bankID).pull(&links, "bankPullOpen4bankOffers")
+	if !hasLink(links, requestHash) {
+		t.Errorf("bankPullOpen4bankOffers did not return the request")
+	}
+}
+
+func TestCreditScoreHistory(t *testing.T) {
+	n := newTestNetwork(t)
+
+	// an open mortgage of the buyer with one installment paid on time and one a month late
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	existing := &Mortgage{RequestHash: "request-0", BuyerHash: buyerID, Status: MortgageActive, MonthlyPayment: usd(4000), Payments: []InstallmentPayment{
+		{Number: 1, DueDate: start.AddDate(0, 1, 0), Timestamp: start.AddDate(0, 1, 0)},
+		{Number: 2, DueDate: start.AddDate(0, 2, 0), Timestamp: start.AddDate(0, 3, 0)},
+	}}
+	cc := &HomelendChaincode{}
+	n.stub.MockTransactionStart("seed")
+	err := cc.putMortgage(n.stub, existing)
+	n.stub.MockTransactionEnd("seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.advanceTo(StatusCreditScoreInstalled)
+
+	// 550 + 50 income + 50 loan to income + 0 for obligations of 26.67% + 1 on time - 15 late
+	details := n.request().CreditScoreDetails
+	if details == nil || details.Score != 636 || details.Grade != "C" || len(details.Reasons) != 1 || details.Reasons[0] != lib.ReasonLatePayments {
+		t.Errorf("unexpected credit score details %+v", details)
+	}
+}
+
+func TestCreditScoreChaincodeLink(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusInitialized)
+
+	link := ChaincodeLink{Chaincode: "bureau_chaincode", Channel: "scores"}
+	bureau := n.peerCreditScore(link, "bureau-2", 50)
+	n.as(bankMSP, bankID).mustFail("setChaincodeLinks", `{"CreditScore":{"Chaincode":"bureau_chaincode","Channel":"scores"}}`)
+	n.as(homelendMSP, homelendID).mustInvoke("setChaincodeLinks", `{"CreditScore":{"Chaincode":"bureau_chaincode","Channel":"scores"}}`)
+
+	links := &ChaincodeLinks{}
+	err := json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("getChaincodeLinks"), links)
+	if err != nil || links.CreditScore != link {
+		t.Fatalf("unexpected chaincode links %+v %v", links, err)
+	}
+	// the links left out keep the ones in force, the land registry can not be unlinked
+	if links.Government != defaultChaincodeLinks.Government {
+		t.Errorf("setting the credit score link changed the government link to %+v", links.Government)
+	}
+	n.as(homelendMSP, homelendID).mustFail("setChaincodeLinks", `{"Government":{"Chaincode":""}}`)
+
+	n.advanceTo(StatusCreditScoreInstalled)
+	request := n.request()
+	if details := request.CreditScoreDetails; request.CreditScore != "A" || details == nil || details.Score != 750 || details.Model != "bureau-2" {
+		t.Errorf("the linked model was not used: %s %+v", request.CreditScore, details)
+	}
+	if len(bureau.requests) != 1 || len(n.creditScore.requests) != 0 {
+		t.Errorf("expected one call to the linked chaincode, got %d and %d to the default", len(bureau.requests), len(n.creditScore.requests))
+	}
+}
+
+func TestCreditScoreChaincodeResponse(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusInitialized)
+
+	// a score outside 300..850 is refused and the request stays with the credit agencies
+	n.creditScore.offset = 500
+	message := n.as(creditAgencyMSP, creditAgencyID).mustFail("creditScore", requestLinkJSON())
+	if !strings.Contains(message, "outside") {
+		t.Errorf("unexpected error %s", message)
+	}
+	if n.request().Status != StatusInitialized {
+		t.Errorf("an invalid score was stored")
+	}
+
+	// without a linked chaincode the built-in model scores the request
+	n.as(homelendMSP, homelendID).mustInvoke("setChaincodeLinks", `{"CreditScore":{"Chaincode":""}}`)
+	n.advanceTo(StatusCreditScoreInstalled)
+	if details := n.request().CreditScoreDetails; details == nil || details.Score != 700 || details.Model != lib.CreditModel {
+		t.Errorf("unexpected credit score details %+v", details)
+	}
+	if len(n.creditScore.requests) != 1 {
+		t.Errorf("expected no further call to creditscore_chaincode, got %d", len(n.creditScore.requests))
+	}
+}
+
+//scoringAgencies - registers two more credit agencies whose own chaincodes score 50 points higher and 100 points lower
+func (n *testNetwork) scoringAgencies() {
+	n.t.Helper()
+	n.peerCreditScore(ChaincodeLink{Chaincode: "optimist_chaincode"}, "optimist", 50)
+	n.peerCreditScore(ChaincodeLink{Chaincode: "pessimist_chaincode"}, "pessimist", -100)
+	n.as(homelendMSP, homelendID).mustInvoke("setChaincodeLinks", `{"CreditScore":{"Chaincode":"creditscore_chaincode"},"CreditAgencies":{"credit-agency-2":{"Chaincode":"optimist_chaincode"},"credit-agency-3":{"Chaincode":"pessimist_chaincode"}}}`)
+	n.as(creditAgencyMSP, "credit-agency-2").mustInvoke("putCreditRatingAgencyInfo", `{"Name":"Optimist"}`)
+	n.as(creditAgencyMSP, "credit-agency-3").mustInvoke("putCreditRatingAgencyInfo", `{"Name":"Pessimist"}`)
+}
+
+func TestCreditScoreAgencies(t *testing.T) {
+	n := newTestNetwork(t)
+	n.scoringAgencies()
+	n.as(homelendMSP, homelendID).mustFail("setCreditScorePolicy", `{"RequiredScores":0,"Aggregation":"median"}`)
+	n.as(homelendMSP, homelendID).mustFail("setCreditScorePolicy", `{"RequiredScores":3,"Aggregation":"average"}`)
+	n.as(creditAgencyMSP, creditAgencyID).mustFail("setCreditScorePolicy", `{"RequiredScores":3,"Aggregation":"median"}`)
+	n.as(homelendMSP, homelendID).mustInvoke("setCreditScorePolicy", `{"RequiredScores":3,"Aggregation":"median"}`)
+	n.advanceTo(StatusInitialized)
+
+	// the first two scores are stored while the request waits for the third agency
+	n.as(creditAgencyMSP, creditAgencyID).mustInvoke("creditScore", requestLinkJSON())
+	n.as(creditAgencyMSP, creditAgencyID).mustFail("creditScore", requestLinkJSON())
+	n.as(creditAgencyMSP, "credit-agency-3").mustInvoke("creditScore", requestLinkJSON())
+	request := n.request()
+	if request.Status != StatusInitialized || len(request.CreditScores) != 2 || request.CreditScore != "" {
+		t.Fatalf("expected 2 pending scores, got %s %+v", request.Status, request.CreditScores)
+	}
+	if !hasLink(n.requestLinks(creditRankOpenRequests), requestHash) {
+		t.Errorf("request left %s before the last score", creditRankOpenRequests)
+	}
+
+	// 700, 600 and 750, the median is the score of the default agency
+	n.as(creditAgencyMSP, "credit-agency-2").mustInvoke("creditScore", requestLinkJSON())
+	request = n.request()
+	if request.Status != StatusCreditScoreInstalled || request.CreditScore != "B" || request.CreditScoreIdentity != creditAgencyID || request.CreditScoreDetails.Score != 700 {
+		t.Errorf("unexpected aggregate %s %s by %s", request.Status, request.CreditScore, request.CreditScoreIdentity)
+	}
+	scores := map[string]int{}
+	for _, score := range request.CreditScores {
+		scores[score.AgencyHash] = score.Details.Score
+		if score.Timestamp.IsZero() {
+			t.Errorf("score of %s has no timestamp", score.AgencyHash)
+		}
+	}
+	if scores[creditAgencyID] != 700 || scores["credit-agency-2"] != 750 || scores["credit-agency-3"] != 600 {
+		t.Errorf("unexpected agency scores %+v", scores)
+	}
+	if hasLink(n.requestLinks(creditRankOpenRequests), requestHash) || !hasLink(n.requestLinks(open4bankOffers), requestHash) {
+		t.Errorf("request was not moved to %s", open4bankOffers)
+	}
+}
+
+func TestCreditScoreLowest(t *testing.T) {
+	n := newTestNetwork(t)
+	n.scoringAgencies()
+	n.as(homelendMSP, homelendID).mustInvoke("setCreditScorePolicy", `{"RequiredScores":2,"Aggregation":"lowest"}`)
+	n.advanceTo(StatusInitialized)
+
+	n.as(creditAgencyMSP, "credit-agency-2").mustInvoke("creditScore", requestLinkJSON())
+	n.as(creditAgencyMSP, "credit-agency-3").mustInvoke("creditScore", requestLinkJSON())
+	request := n.request()
+	if request.Status != StatusCreditScoreInstalled || request.CreditScore != "C" || request.CreditScoreIdentity != "credit-agency-3" || request.CreditScoreDetails.Model != "pessimist" {
+		t.Errorf("unexpected aggregate %s %s by %s", request.Status, request.CreditScore, request.CreditScoreIdentity)
+	}
+
+	// a late agency can not score a request that is already open to bank offers
+	n.as(creditAgencyMSP, creditAgencyID).mustFail("creditScore", requestLinkJSON())
+}
+
+func TestCreditScorePolicy(t *testing.T) {
+	n := newTestNetwork(t)
+
+	policy := &CreditScorePolicy{}
+	err := json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("getCreditScorePolicy"), policy)
+	if err != nil || *policy != defaultCreditScorePolicy {
+		t.Fatalf("expected the default credit score policy got %+v %v", policy, err)
+	}
+
+	n.as(bankMSP, bankID).mustFail("setCreditScorePolicy", `{"RequiredScores":2,"Aggregation":"lowest"}`)
+	n.as(homelendMSP, homelendID).mustFail("setCreditScorePolicy", `{"RequiredScores":0,"Aggregation":"lowest"}`)
+	n.as(homelendMSP, homelendID).mustInvoke("setCreditScorePolicy", `{"RequiredScores":2,"Aggregation":"lowest"}`)
+
+	err = json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getCreditScorePolicy"), policy)
+	if err != nil || policy.RequiredScores != 2 || policy.Aggregation != AggregateLowest {
+		t.Errorf("unexpected credit score policy %+v %v", policy, err)
+	}
+}
+
+func TestAggregateCreditScores(t *testing.T) {
+	score := func(agency string, points int) AgencyCreditScore {
+		return AgencyCreditScore{AgencyHash: agency, Details: &lib.CreditScoreResponse{CreditScore: lib.CreditScore{Score: points}}}
+	}
+	scores := []AgencyCreditScore{score("d", 700), score("a", 640), score("c", 700), score("b", 800)}
+
+	// the lower of the two middle scores, the tie between c and d goes to c
+	if got := aggregateCreditScores(scores, AggregateMedian); got.AgencyHash != "c" {
+		t.Errorf("expected the median of c got %+v", got)
+	}
+	if got := aggregateCreditScores(scores, AggregateLowest); got.AgencyHash != "a" {
+		t.Errorf("expected the lowest of a got %+v", got)
+	}
+	if scores[0].AgencyHash != "d" {
+		t.Errorf("the scores of the request were reordered")
+	}
+}
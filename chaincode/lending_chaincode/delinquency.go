@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//the grace periods and late fee, written by a Homelend admin
+const servicingTermsKey = "servicingTerms"
+
+//ServicingTerms - days past the due date of the oldest unpaid installment after which
+//a mortgage is late, delinquent and defaulted, and the fee charged on every installment paid late.
+//A mortgage in another currency is charged the LateFee converted at the exchange rate of the fx-oracle.
+type ServicingTerms struct {
+	LateAfterDays       int       `json:"LateAfterDays"`
+	DelinquentAfterDays int       `json:"DelinquentAfterDays"`
+	DefaultAfterDays    int       `json:"DefaultAfterDays"`
+	LateFee             lib.Money `json:"LateFee"`
+}
+
+//defaultServicingTerms - used until an admin sets the terms
+var defaultServicingTerms = ServicingTerms{LateAfterDays: 15, DelinquentAfterDays: 30, DefaultAfterDays: 90, LateFee: lib.MajorUnits(100, lib.DefaultCurrency)}
+
+func (terms *ServicingTerms) validate() error {
+	if terms.LateAfterDays < 0 || terms.LateFee.IsNegative() {
+		return errors.New("grace periods and the late fee can not be negative")
+	}
+	if terms.DelinquentAfterDays < terms.LateAfterDays || terms.DefaultAfterDays < terms.DelinquentAfterDays {
+		return errors.New("expected LateAfterDays <= DelinquentAfterDays <= DefaultAfterDays")
+	}
+	return nil
+}
+
+func (t *HomelendChaincode) getServicingTerms(stub shim.ChaincodeStubInterface) (*ServicingTerms, error) {
+	dataAsBytes, err := stub.GetState(servicingTermsKey)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	terms := defaultServicingTerms
+	if len(dataAsBytes) == 0 {
+		return &terms, nil
+	}
+
+	err = json.Unmarshal(dataAsBytes, &terms)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal servicing terms: %s", err)
+		return nil, errors.New(str)
+	}
+	return &terms, nil
+}
+
+//daysPastDue - how late the oldest unpaid installment is at now, 0 when nothing is overdue
+func (m *Mortgage) daysPastDue(now time.Time) int {
+	if !m.OutstandingPrincipal.IsPositive() || !now.After(m.nextDueDate()) {
+		return 0
+	}
+	return int(now.Sub(m.nextDueDate()).Hours() / 24)
+}
+
+//chargeableInstallments - the installments of an open mortgage unpaid past the grace period at now
+//that were not charged the late fee yet
+func (m *Mortgage) chargeableInstallments(terms *ServicingTerms, now time.Time) int {
+	switch m.Status {
+	case MortgageActive, MortgageLate, MortgageDelinquent, MortgageDefaulted:
+	default:
+		return 0
+	}
+
+	number := m.InstallmentsPaid
+	if m.LateFeesCharged > number {
+		number = m.LateFeesCharged
+	}
+	count := 0
+	for number++; number <= m.TermMonths && m.OutstandingPrincipal.IsPositive(); number++ {
+		graceEnd := m.StartDate.AddDate(0, number, terms.LateAfterDays)
+		if !now.After(graceEnd) {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+//assessDelinquency - charges lateFee, the late fee of the terms in the currency of the mortgage, once on every
+//installment unpaid past the grace period and moves the mortgage between active, late, delinquent and defaulted
+func (m *Mortgage) assessDelinquency(terms *ServicingTerms, lateFee lib.Money, now time.Time) error {
+	switch m.Status {
+	case MortgageActive, MortgageLate, MortgageDelinquent, MortgageDefaulted:
+	default:
+		return nil
+	}
+
+	if count := m.chargeableInstallments(terms, now); count > 0 {
+		lateFees := m.LateFees
+		for i := 0; i < count; i++ {
+			var err error
+			lateFees, err = lateFees.Add(lateFee)
+			if err != nil {
+				return err
+			}
+		}
+		m.LateFees = lateFees
+		if m.InstallmentsPaid > m.LateFeesCharged {
+			m.LateFeesCharged = m.InstallmentsPaid
+		}
+		m.LateFeesCharged += count
+	}
+
+	days := m.daysPastDue(now)
+	switch {
+	case days > terms.DefaultAfterDays:
+		m.Status = MortgageDefaulted
+	case days > terms.DelinquentAfterDays:
+		m.Status = MortgageDelinquent
+	case days > terms.LateAfterDays:
+		m.Status = MortgageLate
+	default:
+		m.Status = MortgageActive
+	}
+	return nil
+}
+
+//assessMortgage - the mortgage assessed at the transaction time, after the rate reset due on its next installment,
+//with the status of its insurance policy
+func (t *HomelendChaincode) assessMortgage(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	err := t.resetRate(stub, mortgage)
+	if err != nil {
+		return err
+	}
+	err = t.assessCoverage(stub, mortgage)
+	if err != nil {
+		return err
+	}
+	return t.updateDelinquency(stub, mortgage)
+}
+
+//updateDelinquency - the late fees and delinquency status of the mortgage at the transaction time
+func (t *HomelendChaincode) updateDelinquency(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	terms, err := t.getServicingTerms(stub)
+	if err != nil {
+		return err
+	}
+
+	helpers := lib.Helpers{}
+	now, err := helpers.GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	// the late fee is converted into the currency of the mortgage at the rate in force when it is charged
+	lateFee := lib.NewMoney(0, mortgage.Principal.Currency)
+	if mortgage.chargeableInstallments(terms, now) > 0 {
+		lateFee, err = t.convert(stub, terms.LateFee, mortgage.Principal.Currency)
+		if err != nil {
+			return err
+		}
+	}
+	return mortgage.assessDelinquency(terms, lateFee, now)
+}
+
+//getBankMortgage - the mortgage of the request link in args[0], if the caller is its bank
+func (t *HomelendChaincode) getBankMortgage(stub shim.ChaincodeStubInterface, args []string) (*Mortgage, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("Incorrect number of arguments %d.", len(args))
+	}
+
+	bankIdentity, err := t.getIdentity(stub, RoleLoanOfficer)
+	if err != nil {
+		return nil, err
+	}
+
+	requestLink := &RequestLink{}
+	err = json.Unmarshal([]byte(args[0]), requestLink)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal requestLinkStr: %s", err)
+		return nil, errors.New(str)
+	}
+
+	mortgage, err := t.getMortgage(stub, requestLink.UserHash, requestLink.RequestHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if mortgage.BankHash != bankIdentity {
+		str := fmt.Sprintf("%s does not hold the mortgage of request %s", bankIdentity, mortgage.RequestHash)
+		return nil, errors.New(str)
+	}
+	return mortgage, nil
+}
+
+//bankAssessDelinquency - the bank records the late fees and the delinquency status of a mortgage at the transaction time, args: requestLink
+func (t *HomelendChaincode) bankAssessDelinquency(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("bankAssessDelinquency executed with args: %+v", args))
+
+	mortgage, err := t.getBankMortgage(stub, args)
+	if err != nil {
+		str := fmt.Sprintf("getBankMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.assessMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("assessMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("putMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(mortgage)
+}
+
+//bankForeclose - the bank takes the property of a defaulted mortgage back from the buyer, args: requestLink
+func (t *HomelendChaincode) bankForeclose(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("bankForeclose executed with args: %+v", args))
+
+	mortgage, err := t.getBankMortgage(stub, args)
+	if err != nil {
+		str := fmt.Sprintf("getBankMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.assessMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("assessMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if mortgage.Status != MortgageDefaulted {
+		str := fmt.Sprintf("the mortgage of request %s is %s, only a defaulted mortgage can be foreclosed", mortgage.RequestHash, mortgage.Status)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request, err := t.getRequest(stub, mortgage.BuyerHash, mortgage.RequestHash)
+	if err != nil {
+		str := fmt.Sprintf("getRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.transitionRequest(stub, request, StatusForeclosed)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.transferProperty(stub, mortgage.BuyerHash, mortgage.BankHash, mortgage.PropertyHash)
+	if err != nil {
+		str := fmt.Sprintf("Failed to transferProperty: %s", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// the bank releases its lien as it takes the title
+	if mortgage.LienID != "" {
+		change := lib.TitleChange{ReleaseLienID: mortgage.LienID, SellerHash: mortgage.BuyerHash, BuyerHash: mortgage.BankHash}
+		err = t.settleTitle(stub, mortgage, change)
+		if err != nil {
+			str := fmt.Sprintf("Could not transfer the title to %s %+v", mortgage.BankHash, err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	// the buyer no longer owns the property, nor owes premiums for its cover
+	err = t.cancelMortgagePolicy(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("Could not cancel the insurance policy %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.closeRefinanceRequest(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("closeRefinanceRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage.Status = MortgageForeclosed
+	err = t.putMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("putMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.addOrUpdateRequest(stub, request)
+	if err != nil {
+		str := fmt.Sprintf("addOrUpdateRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("bankForeclose Sucessfully executed")
+	return shim.Success(nil)
+}
+
+//setServicingTerms - Homelend admin sets the grace periods and the late fee, args: ServicingTerms JSON
+func (t *HomelendChaincode) setServicingTerms(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setServicingTerms executed with args: %+v", args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	_, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	terms := &ServicingTerms{}
+	err = json.Unmarshal([]byte(args[0]), terms)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = terms.validate()
+	if err != nil {
+		str := fmt.Sprintf("invalid servicing terms %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(terms)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(servicingTermsKey, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(nil)
+}
+
+//getServicingTermsInfo - returns the grace periods and the late fee in force
+func (t *HomelendChaincode) getServicingTermsInfo(stub shim.ChaincodeStubInterface) pb.Response {
+	terms, err := t.getServicingTerms(stub)
+	if err != nil {
+		str := fmt.Sprintf("getServicingTerms error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(terms)
+}
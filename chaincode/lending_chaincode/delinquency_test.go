@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestDelinquencyAndForeclosure(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+	n.as(homelendMSP, homelendID).mustFail("setServicingTerms", `{"LateAfterDays":30,"DelinquentAfterDays":15,"DefaultAfterDays":90}`)
+	n.as(homelendMSP, homelendID).mustInvoke("setServicingTerms", `{"LateAfterDays":15,"DelinquentAfterDays":30,"DefaultAfterDays":90,"LateFee":100}`)
+
+	if info := n.mortgageInfo(); info.Mortgage.Status != MortgageActive || !info.Mortgage.LateFees.IsZero() {
+		t.Fatalf("unexpected new mortgage %+v", info.Mortgage)
+	}
+
+	// the first installment is 20 days overdue
+	n.backdateMortgage(51)
+	info := n.mortgageInfo()
+	if info.Mortgage.Status != MortgageLate || info.Mortgage.LateFees != usd(100) {
+		t.Fatalf("expected a late mortgage with one late fee got %s %s", info.Mortgage.Status, info.Mortgage.LateFees)
+	}
+
+	n.asRole(bankMSP, "bank-2", RoleLoanOfficer).mustFail("bankAssessDelinquency", requestLinkJSON())
+	n.as(bankMSP, bankID).mustInvoke("bankAssessDelinquency", requestLinkJSON())
+	n.as(bankMSP, bankID).mustInvoke("bankAssessDelinquency", requestLinkJSON())
+	cc := &HomelendChaincode{}
+	mortgage, _ := cc.getMortgage(n.stub, buyerID, requestHash)
+	if mortgage.Status != MortgageLate || mortgage.LateFees != usd(100) {
+		t.Fatalf("expected the late fee to be charged once got %s %s", mortgage.Status, mortgage.LateFees)
+	}
+	n.as(bankMSP, bankID).mustFail("bankForeclose", requestLinkJSON())
+
+	// paying the installment with its late fee brings the mortgage back to active
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", sumOf(t, info.NextInstallment.Payment, usd(100)).Decimal())
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayInstallment", requestHash)
+	mortgage, _ = cc.getMortgage(n.stub, buyerID, requestHash)
+	if mortgage.Status != MortgageActive || !mortgage.LateFees.IsZero() || mortgage.Payments[0].LateFees != usd(100) {
+		t.Fatalf("unexpected mortgage after the late payment %+v", mortgage)
+	}
+	if balance, _ := cc.getBalance(n.stub, buyerID, lib.DefaultCurrency); !balance.IsZero() {
+		t.Errorf("expected the late fee to be paid, %s left", balance)
+	}
+
+	// the second installment is 120 days overdue, the refinance request of the buyer ends with the foreclosure
+	n.as(buyerMSP, buyerID).mustInvoke("buyerRequestRefinance", requestHash)
+	n.backdateMortgage(120)
+	n.as(bankMSP, bankID).mustInvoke("bankForeclose", requestLinkJSON())
+
+	mortgage, _ = cc.getMortgage(n.stub, buyerID, requestHash)
+	if mortgage.Status != MortgageForeclosed {
+		t.Errorf("expected a foreclosed mortgage got %s", mortgage.Status)
+	}
+	if hasLink(n.requestLinks(open4refinance), requestHash) || mortgage.RefinanceRequested {
+		t.Errorf("the foreclosed mortgage is still open to refinance offers")
+	}
+	if status := n.insurance.policies[requestHash].Status; status != lib.PolicyCancelled || mortgage.PolicyStatus != status {
+		t.Errorf("expected the policy of the foreclosed mortgage to be cancelled got %s", status)
+	}
+	if status := n.request().Status; status != StatusForeclosed {
+		t.Errorf("expected status %s got %s", StatusForeclosed, status)
+	}
+	if property, _ := cc.getProperty(n.stub, buyerID, propertyHash); property != nil {
+		t.Errorf("the buyer still owns the property")
+	}
+	if property, _ := cc.getProperty(n.stub, bankID, propertyHash); property == nil {
+		t.Errorf("the bank does not own the property")
+	}
+	if title := n.registry.titles[propertyHash]; title.OwnerHash != bankID || len(title.ActiveLiens()) != 0 {
+		t.Errorf("the registry did not transfer the title to the bank %+v", title)
+	}
+	n.as(buyerMSP, buyerID).mustFail("buyerPayInstallment", requestHash)
+	n.as(bankMSP, bankID).mustFail("bankForeclose", requestLinkJSON())
+}
+
+func TestLateFeeCurrency(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+	// a mortgage in yen under the default late fee of 100 USD
+	n.updateMortgage(func(mortgage *Mortgage) {
+		mortgage.Principal = lib.NewMoney(30000000, "JPY")
+		mortgage.OutstandingPrincipal = mortgage.Principal
+		mortgage.MonthlyPayment = lib.NewMoney(174000, "JPY")
+		mortgage.LateFees = lib.NewMoney(0, "JPY")
+	})
+
+	// the first installment is 20 days overdue, the fee can not be charged without a rate
+	n.backdateMortgage(51)
+	msg := n.as(buyerMSP, buyerID).mustFail("getMortgageInfo", buyerID, requestHash)
+	if !strings.Contains(msg, "no exchange rate") {
+		t.Errorf("expected a missing rate error got %s", msg)
+	}
+
+	n.as(oracleMSP, oracleID).mustInvoke("setExchangeRate", "USD", "JPY", "150")
+	info := n.mortgageInfo()
+	if info.Mortgage.Status != MortgageLate || info.Mortgage.LateFees != lib.NewMoney(15000, "JPY") {
+		t.Fatalf("expected a late fee of 15000 JPY got %s %s", info.Mortgage.Status, info.Mortgage.LateFees)
+	}
+
+	amount := sumOf(t, info.NextInstallment.Payment, lib.NewMoney(15000, "JPY"))
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", amount.Decimal(), "JPY")
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayInstallment", requestHash)
+	statement := n.statement(buyerID)
+	last := statement[len(statement)-1]
+	if !strings.Contains(last.Description, "late fees 15000 JPY") {
+		t.Errorf("expected the late fee in yen on the ledger got %q", last.Description)
+	}
+}
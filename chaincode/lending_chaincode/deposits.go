@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//every deposit is stored under the transaction that announced it
+const depositIndex = "deposit~tx"
+
+//DepositStatus - whether Homelend saw the money of a deposit arrive
+type DepositStatus string
+
+// Deposit statuses, only a confirmed deposit moves money from the external account
+const (
+	DepositPending   DepositStatus = "PENDING"
+	DepositConfirmed DepositStatus = "CONFIRMED"
+	DepositRejected  DepositStatus = "REJECTED"
+)
+
+//Deposit - money a participant announced to move into Account. It stays PENDING until
+//Homelend, which holds the money outside the network, confirms or rejects it.
+type Deposit struct {
+	TxID        string        `json:"TxID"`
+	Account     string        `json:"Account"`
+	Identity    string        `json:"Identity"`
+	Description string        `json:"Description"`
+	Amount      lib.Money     `json:"Amount"`
+	Status      DepositStatus `json:"Status"`
+	Timestamp   time.Time     `json:"Timestamp"`
+	DecidedBy   string        `json:"DecidedBy"`
+	DecidedAt   time.Time     `json:"DecidedAt"`
+}
+
+func (t *HomelendChaincode) getDeposit(stub shim.ChaincodeStubInterface, txID string) (*Deposit, error) {
+	key, err := stub.CreateCompositeKey(depositIndex, []string{txID})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		str := fmt.Sprintf("Deposit does not exist %s", txID)
+		return nil, errors.New(str)
+	}
+
+	deposit := &Deposit{}
+	err = json.Unmarshal(dataAsBytes, deposit)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal deposit: %s", err)
+		return nil, errors.New(str)
+	}
+	return deposit, nil
+}
+
+func (t *HomelendChaincode) putDeposit(stub shim.ChaincodeStubInterface, deposit *Deposit) error {
+	key, err := stub.CreateCompositeKey(depositIndex, []string{deposit.TxID})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return errors.New(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(deposit)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+//parseDepositAmount - args: amount, optional currency
+func parseDepositAmount(args []string) (lib.Money, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return lib.Money{}, fmt.Errorf("Incorrect number of arguments %d.", len(args))
+	}
+
+	currency, err := parseCurrencyArg(args, 1, lib.DefaultCurrency)
+	if err != nil {
+		return lib.Money{}, err
+	}
+
+	amount, err := lib.ParseMoney(args[0], currency)
+	if err != nil || !amount.IsPositive() {
+		return lib.Money{}, fmt.Errorf("amount must be a positive number %+v", args[0])
+	}
+	return amount, nil
+}
+
+//announceDeposit - stores a PENDING deposit of amount into account under the transaction ID and returns it
+func (t *HomelendChaincode) announceDeposit(stub shim.ChaincodeStubInterface, identity string, account string, description string, amount lib.Money) pb.Response {
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	deposit := &Deposit{
+		TxID:        stub.GetTxID(),
+		Account:     account,
+		Identity:    identity,
+		Description: description,
+		Amount:      amount,
+		Status:      DepositPending,
+		Timestamp:   timestamp,
+	}
+	err = t.putDeposit(stub, deposit)
+	if err != nil {
+		str := fmt.Sprintf("putDeposit error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(deposit)
+}
+
+//bankDeposit - a bank announces money it can lend moving into the network, args: amount, optional currency.
+//The money reaches the lending account of the bank once Homelend confirms the deposit.
+func (t *HomelendChaincode) bankDeposit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("bankDeposit executed with args: %+v", args))
+
+	amount, err := parseDepositAmount(args)
+	if err != nil {
+		fmt.Println(err.Error())
+		return shim.Error(err.Error())
+	}
+
+	bankIdentity, err := t.getIdentity(stub, RoleLoanOfficer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.requireActiveParticipant(stub, ParticipantBank, bankIdentity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.announceDeposit(stub, bankIdentity, bankAccount(bankIdentity), "bank deposit", amount)
+}
+
+//buyerDeposit - a buyer announces money for down payments moving into the network, args: amount, optional currency.
+//The money reaches the account of the buyer once Homelend confirms the deposit.
+func (t *HomelendChaincode) buyerDeposit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("buyerDeposit executed with args: %+v", args))
+
+	amount, err := parseDepositAmount(args)
+	if err != nil {
+		fmt.Println(err.Error())
+		return shim.Error(err.Error())
+	}
+
+	identity, err := t.getIdentity(stub, RoleBuyer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.announceDeposit(stub, identity, identity, "buyer deposit", amount)
+}
+
+//decideDeposit - Homelend confirms or rejects the pending deposit of args[0], a confirmed one moves its amount from external
+func (t *HomelendChaincode) decideDeposit(stub shim.ChaincodeStubInterface, args []string, status DepositStatus) pb.Response {
+	fmt.Println(fmt.Sprintf("decideDeposit %s executed with args: %+v", status, args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	deposit, err := t.getDeposit(stub, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getDeposit error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if deposit.Status != DepositPending {
+		str := fmt.Sprintf("deposit %s is %s", deposit.TxID, deposit.Status)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if status == DepositConfirmed {
+		err = t.postJournalEntry(stub, deposit.Description, "", transferLines(externalAccount, deposit.Account, deposit.Amount))
+		if err != nil {
+			str := fmt.Sprintf("postJournalEntry error %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	helpers := lib.Helpers{}
+	deposit.DecidedAt, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	deposit.Status = status
+	deposit.DecidedBy = identity
+	err = t.putDeposit(stub, deposit)
+	if err != nil {
+		str := fmt.Sprintf("putDeposit error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(deposit)
+}
+
+//getPendingDeposits - the deposits waiting for Homelend to confirm them
+func (t *HomelendChaincode) getPendingDeposits(stub shim.ChaincodeStubInterface) pb.Response {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(depositIndex, []string{})
+	if err != nil {
+		str := fmt.Sprintf("Failed to get deposits %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	defer resultsIterator.Close()
+
+	deposits := make([]*Deposit, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		deposit := &Deposit{}
+		err = json.Unmarshal(queryResponse.Value, deposit)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", queryResponse.Key, err)
+			return shim.Error(str)
+		}
+		if deposit.Status == DepositPending {
+			deposits = append(deposits, deposit)
+		}
+	}
+
+	return t.marshalResponse(deposits)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestDepositConfirmation(t *testing.T) {
+	n := newTestNetwork(t)
+	cc := &HomelendChaincode{}
+
+	deposit := &Deposit{}
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("buyerDeposit", "5000"), deposit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deposit.Status != DepositPending || deposit.Account != buyerID || deposit.Amount != usd(5000) {
+		t.Fatalf("unexpected deposit %+v", deposit)
+	}
+
+	// the buyer can not fund their own account
+	if balance, _ := cc.getBalance(n.stub, buyerID, lib.DefaultCurrency); !balance.IsZero() {
+		t.Errorf("an unconfirmed deposit credited %s", balance)
+	}
+	n.as(buyerMSP, buyerID).mustFail("confirmDeposit", deposit.TxID)
+	n.as(bankMSP, bankID).mustFail("confirmDeposit", deposit.TxID)
+	n.as(buyerMSP, buyerID).mustFail("getPendingDeposits")
+
+	var pending []*Deposit
+	err = json.Unmarshal(n.as(homelendMSP, homelendID).mustInvoke("getPendingDeposits"), &pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].TxID != deposit.TxID {
+		t.Fatalf("unexpected pending deposits %+v", pending)
+	}
+
+	n.as(homelendMSP, homelendID).mustInvoke("confirmDeposit", deposit.TxID)
+	n.as(homelendMSP, homelendID).mustFail("confirmDeposit", deposit.TxID)
+	n.as(homelendMSP, homelendID).mustFail("rejectDeposit", deposit.TxID)
+	if balance, _ := cc.getBalance(n.stub, buyerID, lib.DefaultCurrency); balance != usd(5000) {
+		t.Errorf("expected the confirmed deposit of 5000 got %s", balance)
+	}
+
+	err = json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("buyerDeposit", "7000"), deposit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.as(homelendMSP, homelendID).mustInvoke("rejectDeposit", deposit.TxID)
+	n.as(homelendMSP, homelendID).mustFail("confirmDeposit", deposit.TxID)
+	if balance, _ := cc.getBalance(n.stub, buyerID, lib.DefaultCurrency); balance != usd(5000) {
+		t.Errorf("a rejected deposit changed the balance to %s", balance)
+	}
+	n.as(homelendMSP, homelendID).mustFail("confirmDeposit", "unknown")
+
+	// nor can a bank fund its lending account
+	err = json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("bankDeposit", "5000"), deposit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deposit.Account != bankAccount(bankID) {
+		t.Errorf("unexpected bank deposit %+v", deposit)
+	}
+	n.as(bankMSP, bankID).mustFail("confirmDeposit", deposit.TxID)
+	if balance, _ := cc.getBalance(n.stub, bankAccount(bankID), lib.DefaultCurrency); balance != usd(bankDepositAmount) {
+		t.Errorf("an unconfirmed bank deposit changed the balance to %s", balance)
+	}
+}
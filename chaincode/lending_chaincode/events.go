@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//RequestEvent - payload of the chaincode event emitted when a Request changes status.
+//The event name is the new status, so clients can subscribe to the steps they handle.
+type RequestEvent struct {
+	RequestLink RequestLink          `json:"RequestLink"`
+	From        RequestStatus        `json:"From"`
+	Status      RequestStatus        `json:"Status"`
+	Identity    string               `json:"Identity"`
+	Timestamp   time.Time            `json:"Timestamp"`
+	Amounts     map[string]lib.Money `json:"Amounts"`
+	Interest    float32              `json:"Interest,omitempty"`
+}
+
+//eventAmounts - the amounts a client handling the new status needs, and the interest of the bank offer it concerns
+func eventAmounts(request *Request, status RequestStatus) (map[string]lib.Money, float32) {
+	amounts := map[string]lib.Money{"LoanAmount": request.LoanAmount}
+	var interest float32
+
+	switch status {
+	case StatusBankOfferInstalled:
+		if len(request.BankOffers) > 0 {
+			offer := request.BankOffers[len(request.BankOffers)-1]
+			interest = offer.Interest
+			amounts["MonthlyPayment"] = offer.MonthlyPayment
+		}
+	case StatusBuyerSelectedBankOffer:
+		for _, offer := range request.BankOffers {
+			if offer.Hash == request.SelectedBankOfferHash {
+				interest = offer.Interest
+				amounts["MonthlyPayment"] = offer.MonthlyPayment
+			}
+		}
+	case StatusAppraiserProvidedAmount:
+		amounts["AppraiserAmount"] = request.AppraiserAmount
+	case StatusInsuranceOfferProvided:
+		if len(request.InsuranceOffers) > 0 {
+			amounts["InsuranceAmount"] = request.InsuranceOffers[len(request.InsuranceOffers)-1].InsuranceAmount
+		}
+	case StatusInsuranceOfferSelected:
+		for _, offer := range request.InsuranceOffers {
+			if offer.Hash == request.SelectedInsuranceOfferHash {
+				amounts["InsuranceAmount"] = offer.InsuranceAmount
+			}
+		}
+	case StatusApprovedByBank, StatusCompletedActiveMortgage, StatusMortgagePaidOff, StatusForeclosed:
+		amounts["AppraiserAmount"] = request.AppraiserAmount
+		amounts["LoanAmountLeftToRefund"] = request.LoanAmountLeftToRefund
+	}
+
+	return amounts, interest
+}
+
+//emitTransitionEvent - sets the chaincode event of the last transition of the request.
+//Called when the request is saved, after the handler filled in the fields of the new status.
+//Fabric keeps one event per transaction, the last transition wins.
+func (t *HomelendChaincode) emitTransitionEvent(stub shim.ChaincodeStubInterface, request *Request) error {
+	transition := request.transition
+	if transition == nil {
+		return nil
+	}
+
+	event := &RequestEvent{
+		RequestLink: RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash},
+		From:        transition.From,
+		Status:      transition.To,
+		Identity:    transition.Identity,
+		Timestamp:   transition.Timestamp,
+	}
+	event.Amounts, event.Interest = eventAmounts(request, transition.To)
+
+	eventAsBytes, err := json.Marshal(event)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal event %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.SetEvent(string(transition.To), eventAsBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not set event %+v", err.Error())
+		fmt.Println(str)
+		return errors.New(str)
+	}
+
+	request.transition = nil
+	return nil
+}
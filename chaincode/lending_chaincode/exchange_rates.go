@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//every currency pair is stored under its own key, the rate of the opposite pair is its inverse
+const exchangeRateIndex = "exchangeRate~base~quote"
+
+//ExchangeRate - 1 Base is Rate Quote, published by an fx-oracle.
+//Rate is kept as the decimal the oracle sent so every peer converts with the exact same fraction.
+type ExchangeRate struct {
+	Base      string    `json:"Base"`
+	Quote     string    `json:"Quote"`
+	Rate      string    `json:"Rate"`
+	Oracle    string    `json:"Oracle"`
+	Timestamp time.Time `json:"Timestamp"`
+}
+
+func (t *HomelendChaincode) getStoredExchangeRate(stub shim.ChaincodeStubInterface, base string, quote string) (*ExchangeRate, error) {
+	key, err := stub.CreateCompositeKey(exchangeRateIndex, []string{base, quote})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		return nil, nil
+	}
+
+	exchangeRate := &ExchangeRate{}
+	err = json.Unmarshal(dataAsBytes, exchangeRate)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal exchange rate: %s", err)
+		return nil, errors.New(str)
+	}
+	return exchangeRate, nil
+}
+
+//getExchangeRate - units of quote per unit of base, from the pair or the inverse of the opposite pair
+func (t *HomelendChaincode) getExchangeRate(stub shim.ChaincodeStubInterface, base string, quote string) (*big.Rat, error) {
+	if base == quote {
+		return big.NewRat(1, 1), nil
+	}
+
+	exchangeRate, err := t.getStoredExchangeRate(stub, base, quote)
+	if err != nil {
+		return nil, err
+	}
+	inverse := false
+	if exchangeRate == nil {
+		exchangeRate, err = t.getStoredExchangeRate(stub, quote, base)
+		if err != nil {
+			return nil, err
+		}
+		inverse = true
+	}
+	if exchangeRate == nil {
+		str := fmt.Sprintf("no exchange rate was published for %s/%s", base, quote)
+		return nil, errors.New(str)
+	}
+
+	rate, err := lib.ParseRate(exchangeRate.Rate)
+	if err != nil {
+		return nil, err
+	}
+	if inverse {
+		rate.Inv(rate)
+	}
+	return rate, nil
+}
+
+//convert - amount in currency at the published rate, rounded half up to a minor unit
+func (t *HomelendChaincode) convert(stub shim.ChaincodeStubInterface, amount lib.Money, currency string) (lib.Money, error) {
+	if amount.SameCurrency(lib.NewMoney(0, currency)) {
+		return lib.NewMoney(amount.Amount, currency), nil
+	}
+
+	rate, err := t.getExchangeRate(stub, amount.Currency, currency)
+	if err != nil {
+		return lib.Money{}, err
+	}
+	return amount.Convert(rate, currency), nil
+}
+
+//parseCurrencyArg - the currency code of the optional argument at index, fallback when it is missing or empty
+func parseCurrencyArg(args []string, index int, fallback string) (string, error) {
+	currency := fallback
+	if len(args) > index && len(args[index]) > 0 {
+		currency = args[index]
+	}
+	if !lib.ValidCurrency(currency) {
+		return "", fmt.Errorf("invalid currency %q", currency)
+	}
+	return currency, nil
+}
+
+//denominate - the Currency of a JSON document, fallback when it has none, and the document with the
+//plain amounts of fields turned into Money of that currency
+func denominate(document string, fallback string, fields ...string) ([]byte, string, error) {
+	header := struct {
+		Currency string `json:"Currency"`
+	}{}
+	err := json.Unmarshal([]byte(document), &header)
+	if err != nil {
+		return nil, "", err
+	}
+
+	currency := header.Currency
+	if currency == "" {
+		currency = fallback
+	}
+	if !lib.ValidCurrency(currency) {
+		return nil, "", fmt.Errorf("invalid currency %q", currency)
+	}
+
+	dataAsBytes, err := lib.DenominateJSON([]byte(document), currency, fields...)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataAsBytes, currency, nil
+}
+
+//exchangeLines - the journal lines moving sum out of from and converted into to, the external account takes the other side of the exchange
+func exchangeLines(from string, sum lib.Money, to string, converted lib.Money) []JournalLine {
+	return append(transferLines(from, externalAccount, sum), transferLines(externalAccount, to, converted)...)
+}
+
+//setExchangeRate - an fx-oracle publishes the rate of a currency pair, args: base, quote, rate as units of quote per unit of base
+func (t *HomelendChaincode) setExchangeRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setExchangeRate executed with args: %+v", args))
+
+	if len(args) != 3 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	base := args[0]
+	quote := args[1]
+	if !lib.ValidCurrency(base) || !lib.ValidCurrency(quote) || base == quote {
+		str := fmt.Sprintf("invalid currency pair %s/%s", base, quote)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	_, err := lib.ParseRate(args[2])
+	if err != nil {
+		str := fmt.Sprintf("rate args[2] - invalid input %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleFXOracle)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// a pair is stored one way only, so the opposite pair can not hold a stale rate
+	opposite, err := stub.CreateCompositeKey(exchangeRateIndex, []string{quote, base})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.DelState(opposite)
+	if err != nil {
+		str := fmt.Sprintf("Could not delete state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	key, err := stub.CreateCompositeKey(exchangeRateIndex, []string{base, quote})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(&ExchangeRate{Base: base, Quote: quote, Rate: args[2], Oracle: identity, Timestamp: timestamp})
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(nil)
+}
+
+//getExchangeRateInfo - args: base, quote. Returns the published pair, or the opposite pair with the inverse rate
+func (t *HomelendChaincode) getExchangeRateInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	exchangeRate, err := t.getStoredExchangeRate(stub, args[0], args[1])
+	if err == nil && exchangeRate == nil {
+		exchangeRate, err = t.getStoredExchangeRate(stub, args[1], args[0])
+	}
+	if err != nil {
+		str := fmt.Sprintf("getStoredExchangeRate error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if exchangeRate == nil {
+		str := fmt.Sprintf("no exchange rate was published for %s/%s", args[0], args[1])
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(exchangeRate)
+}
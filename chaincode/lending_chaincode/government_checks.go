@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Sources of the GovernmentResults of a request
+const (
+	GovernmentSourceManual   = "manual"
+	GovernmentSourceRegistry = "registry"
+)
+
+//governmentResultsFromRegistry - the results of the land registry checks, all answered from the same version of the title record
+func governmentResultsFromRegistry(checks []lib.TitleCheckResult, timestamp time.Time) (*GovernmentResults, error) {
+	result := &GovernmentResults{Source: GovernmentSourceRegistry, Checks: checks, Timestamp: timestamp}
+	passed := make(map[string]bool)
+	for i, check := range checks {
+		if i > 0 && check.Version != result.RegistryVersion {
+			str := fmt.Sprintf("the checks were answered from versions %d and %d of the title record", result.RegistryVersion, check.Version)
+			return nil, errors.New(str)
+		}
+		result.RegistryVersion = check.Version
+		result.ParcelID = check.ParcelID
+		passed[check.Check] = check.Passed
+	}
+
+	for _, check := range lib.TitleChecks {
+		if _, ok := passed[check]; !ok {
+			return nil, fmt.Errorf("the registry did not answer %s", check)
+		}
+	}
+	result.CheckHouseOwner = passed[lib.CheckHouseOwner]
+	result.CheckLien = passed[lib.CheckLien]
+	result.CheckWarningShot = passed[lib.CheckWarningShot]
+	return result, nil
+}
+
+//failure - why a check failed, "CheckLien is false" followed by the failures the registry reported for it
+func (results *GovernmentResults) failure(name string, check string) string {
+	str := name + " is false"
+	for _, result := range results.Checks {
+		if result.Check == check && len(result.Failures) > 0 {
+			str += ": " + strings.Join(result.Failures, ", ")
+		}
+	}
+	return str
+}
+
+//putGovernmentResults - stores the results and hands the request to its bank for approval.
+//Results may be replaced until the bank decides, the request is then already in the queue of the bank.
+func (t *HomelendChaincode) putGovernmentResults(stub shim.ChaincodeStubInterface, request *Request, results *GovernmentResults) error {
+	replaced := request.Status == StatusGovernmentProvided
+
+	request.GovernmentResultsData = results
+	err := t.transitionRequest(stub, request, StatusGovernmentProvided)
+	if err != nil {
+		return err
+	}
+
+	err = t.addOrUpdateRequest(stub, request)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		return nil
+	}
+
+	bankHash, err := t.getBankHash(request)
+	if err != nil {
+		return err
+	}
+
+	rl := &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash}
+	err = t.dequeueRequest(stub, pending4Government, rl)
+	if err != nil {
+		return err
+	}
+	return t.enqueueRequest(stub, pending4bankApproval+bankHash, rl)
+}
+
+//governmentVerify - args: buyerHash, requestHash. Answers the government checks of the request from the land registry
+//of the linked government chaincode, governmentPutData remains to enter or override the results by hand
+func (t *HomelendChaincode) governmentVerify(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("governmentVerify executed with args: %+v", args))
+
+	if len(args) != 2 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request, err := t.getRequest(stub, args[0], args[1])
+	if err != nil {
+		str := fmt.Sprintf("getRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = checkTransition(request, StatusGovernmentProvided)
+	if err != nil {
+		str := fmt.Sprintf("checkTransition error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	links, err := t.getChaincodeLinks(stub)
+	if err != nil {
+		str := fmt.Sprintf("getChaincodeLinks error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	var checks []lib.TitleCheckResult
+	titleCheck := &lib.TitleCheckRequest{RequestHash: request.Hash, PropertyHash: request.PropertyHash, SellerHash: request.SellerHash, BuyerHash: request.BuyerHash}
+	err = t.invokeLinkedChaincode(stub, links.Government, "checkTitle", titleCheck, &checks)
+	if err != nil {
+		str := fmt.Sprintf("Could not check the title %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	results, err := governmentResultsFromRegistry(checks, timestamp)
+	if err != nil {
+		str := fmt.Sprintf("invalid response of %s: %s", links.Government.Chaincode, err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putGovernmentResults(stub, request, results)
+	if err != nil {
+		str := fmt.Sprintf("putGovernmentResults error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(results)
+}
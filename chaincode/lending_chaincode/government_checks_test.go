@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestGovernmentPutData(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusInsuranceOfferSelected)
+	n.as(bankMSP, bankID).mustFail("governmentPutData", buyerID, requestHash, "true", "true", "true")
+	n.as(governmentMSP, governmentID).mustFail("governmentPutData", buyerID, requestHash, "true")
+
+	n.advanceTo(StatusGovernmentProvided)
+	if !n.request().GovernmentResultsData.CheckLien {
+		t.Errorf("government results were not stored")
+	}
+
+	var pending []*Request
+	n.as(bankMSP, bankID).pull(&pending, "bankPullPending4FinalAppproval")
+	if len(pending) != 1 || pending[0].Hash != requestHash {
+		t.Errorf("unexpected requests pending approval %+v", pending)
+	}
+}
+
+func TestGovernmentVerify(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusInsuranceOfferSelected)
+	n.as(buyerMSP, buyerID).mustFail("governmentVerify", buyerID, requestHash)
+
+	// a property missing from the registry fails every check
+	delete(n.registry.titles, propertyHash)
+	n.as(governmentMSP, governmentID).mustInvoke("governmentVerify", buyerID, requestHash)
+	results := n.request().GovernmentResultsData
+	if results.Source != GovernmentSourceRegistry || results.RegistryVersion != 0 || results.CheckHouseOwner || results.CheckLien || results.CheckWarningShot || len(results.Checks) != 3 {
+		t.Errorf("unexpected results of an unregistered property %+v", results)
+	}
+	if !hasLink(n.requestLinks(pending4bankApproval+bankID), requestHash) {
+		t.Errorf("request was not moved to %s", pending4bankApproval)
+	}
+
+	// checked again once the title is registered, with the lien of the mortgage of the seller still active
+	n.registry.titles[propertyHash] = &lib.TitleRecord{PropertyHash: propertyHash, ParcelID: "6106-52", OwnerHash: sellerID, Version: 3,
+		Liens:    []lib.Lien{{ID: "lien-1", HolderHash: "bank-0", Amount: usd(50000)}},
+		Warnings: []lib.Warning{{ID: "warning-1", BeneficiaryHash: buyerID, Description: "sale agreement"}},
+	}
+	n.as(homelendMSP, homelendID).mustInvoke("governmentVerify", buyerID, requestHash)
+	results = n.request().GovernmentResultsData
+	if results.RegistryVersion != 3 || results.ParcelID != "6106-52" || !results.CheckHouseOwner || results.CheckLien || !results.CheckWarningShot {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if len(n.requestLinks(pending4bankApproval+bankID)) != 1 {
+		t.Errorf("request was queued twice for approval")
+	}
+
+	n.as(bankMSP, bankID).mustInvoke("bankApprove", requestLinkJSON())
+	request := n.request()
+	if request.Status != StatusDeclinedByBank || !strings.Contains(request.DeclineInfo, "CheckLien is false: lien lien-1 of bank-0 for 50000.00 USD is not released") {
+		t.Errorf("unexpected decline %s %s", request.Status, request.DeclineInfo)
+	}
+}
+
+func TestGovernmentManualOverride(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusInsuranceOfferSelected)
+	delete(n.registry.titles, propertyHash)
+	n.as(governmentMSP, governmentID).mustInvoke("governmentVerify", buyerID, requestHash)
+
+	// the registry does not know the property, the government confirms the title by hand
+	n.as(governmentMSP, governmentID).mustInvoke("governmentPutData", buyerID, requestHash, "true", "true", "true")
+	results := n.request().GovernmentResultsData
+	if results.Source != GovernmentSourceManual || !results.CheckHouseOwner || !results.CheckLien || !results.CheckWarningShot || len(results.Checks) != 0 {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	n.as(bankMSP, bankID).mustInvoke("bankApprove", requestLinkJSON())
+	if status := n.request().Status; status != StatusApprovedByBank {
+		t.Errorf("expected %s got %s: %s", StatusApprovedByBank, status, n.request().DeclineInfo)
+	}
+	n.as(governmentMSP, governmentID).mustFail("governmentVerify", buyerID, requestHash)
+}
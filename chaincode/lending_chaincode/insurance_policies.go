@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//selectedInsuranceOffer - nil when the buyer did not select an offer
+func selectedInsuranceOffer(request *Request) *InsuranceOffer {
+	for i := range request.InsuranceOffers {
+		if request.InsuranceOffers[i].Hash == request.SelectedInsuranceOfferHash {
+			return &request.InsuranceOffers[i]
+		}
+	}
+	return nil
+}
+
+//issueMortgagePolicy - the linked insurance chaincode issues the policy of the selected insurance offer under the hash
+//of the request. The buyer holds it for the bank, the InsuranceAmount of the offer is due with every installment.
+func (t *HomelendChaincode) issueMortgagePolicy(stub shim.ChaincodeStubInterface, request *Request, mortgage *Mortgage) error {
+	offer := selectedInsuranceOffer(request)
+	if offer == nil {
+		return fmt.Errorf("insurance offer %s of request %s does not exist", request.SelectedInsuranceOfferHash, request.Hash)
+	}
+
+	links, err := t.getChaincodeLinks(stub)
+	if err != nil {
+		return err
+	}
+
+	dueDates := make([]time.Time, 0, mortgage.TermMonths)
+	schedule, err := mortgage.amortizationSchedule()
+	if err != nil {
+		return err
+	}
+	for _, installment := range schedule {
+		dueDates = append(dueDates, installment.DueDate)
+	}
+
+	policyRequest := lib.PolicyRequest{
+		Hash:            request.Hash,
+		QuoteHash:       offer.Hash,
+		InsurerHash:     offer.InsuranceHash,
+		HolderHash:      request.BuyerHash,
+		BeneficiaryHash: mortgage.BankHash,
+		RequestHash:     request.Hash,
+		PropertyHash:    request.PropertyHash,
+		Coverage:        offer.Coverage,
+		Premium:         offer.InsuranceAmount,
+		DueDates:        dueDates,
+	}
+	policy := &lib.Policy{}
+	err = t.invokeLinkedChaincode(stub, links.Insurance, "issuePolicy", &policyRequest, policy)
+	if err != nil {
+		return err
+	}
+
+	mortgage.PolicyHash = policy.Hash
+	mortgage.PolicyStatus = policy.Status
+	return nil
+}
+
+//callMortgagePolicy - calls function of the linked insurance chaincode on the policy of the mortgage with the rest of args
+//and keeps the status the policy has after it
+func (t *HomelendChaincode) callMortgagePolicy(stub shim.ChaincodeStubInterface, mortgage *Mortgage, function string, args ...string) (*lib.Policy, error) {
+	links, err := t.getChaincodeLinks(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &lib.Policy{}
+	err = t.callLinkedChaincode(stub, links.Insurance, policy, function, append([]string{mortgage.PolicyHash}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	mortgage.PolicyStatus = policy.Status
+	return policy, nil
+}
+
+//assessCoverage - the status of the policy of the mortgage at the transaction time, a premium unpaid past
+//the grace period of the insurer lapses it. Mortgages closed before policies were issued have none.
+func (t *HomelendChaincode) assessCoverage(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	if mortgage.PolicyHash == "" {
+		return nil
+	}
+	_, err := t.callMortgagePolicy(stub, mortgage, "getPolicy")
+	return err
+}
+
+//cancelMortgagePolicy - ends the cover once the mortgage is paid off or foreclosed,
+//a policy that was already claimed or cancelled has no cover left to end
+func (t *HomelendChaincode) cancelMortgagePolicy(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	if mortgage.PolicyHash == "" || (mortgage.PolicyStatus != lib.PolicyActive && mortgage.PolicyStatus != lib.PolicyLapsed) {
+		return nil
+	}
+	_, err := t.callMortgagePolicy(stub, mortgage, "cancelPolicy")
+	return err
+}
+
+//assignMortgagePolicy - the bank that refinanced the mortgage becomes the beneficiary of its policy
+func (t *HomelendChaincode) assignMortgagePolicy(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	if mortgage.PolicyHash == "" {
+		return nil
+	}
+	_, err := t.callMortgagePolicy(stub, mortgage, "assignPolicy", mortgage.BankHash)
+	return err
+}
+
+//lapsedCoverage - the reasons to decline a new loan of the buyer: an open mortgage whose policy lapsed
+func (t *HomelendChaincode) lapsedCoverage(stub shim.ChaincodeStubInterface, buyerHash string) ([]string, error) {
+	mortgages, err := t.getMortgagesByBuyer(stub, buyerHash)
+	if err != nil {
+		return nil, err
+	}
+
+	failures := make([]string, 0)
+	for _, mortgage := range mortgages {
+		if mortgage.requireOpenMortgage() != nil {
+			continue
+		}
+		err = t.assessCoverage(stub, mortgage)
+		if err != nil {
+			return nil, err
+		}
+		if mortgage.PolicyStatus == lib.PolicyLapsed {
+			failures = append(failures, fmt.Sprintf("the insurance policy of the mortgage of request %s lapsed", mortgage.RequestHash))
+		}
+	}
+	return failures, nil
+}
+
+//buyerPayPremium - the buyer pays the insurer the premiums due by the next installment of the mortgage, args: requestHash
+func (t *HomelendChaincode) buyerPayPremium(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("buyerPayPremium executed with args: %+v", args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleBuyer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage, err := t.getMortgage(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = mortgage.requireOpenMortgage()
+	if err != nil {
+		str := fmt.Sprintf("requireOpenMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if mortgage.PolicyHash == "" {
+		str := fmt.Sprintf("the mortgage of request %s has no insurance policy", mortgage.RequestHash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	policy, err := t.callMortgagePolicy(stub, mortgage, "getPolicy")
+	if err != nil {
+		str := fmt.Sprintf("getPolicy error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// the premiums are due with the installments, the buyer pays them before the next one
+	dueDate := mortgage.nextDueDate()
+	amount := policy.PremiumsDue(dueDate)
+	if !amount.IsPositive() {
+		str := fmt.Sprintf("no premium of policy %s is due by %s", policy.Hash, dueDate)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	description := fmt.Sprintf("insurance premium of policy %s", policy.Hash)
+	err = t.postJournalEntry(stub, description, mortgage.RequestHash, transferLines(identity, policy.InsurerHash, amount))
+	if err != nil {
+		str := fmt.Sprintf("postJournalEntry error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	policy, err = t.callMortgagePolicy(stub, mortgage, "payPremium", amount.Decimal())
+	if err != nil {
+		str := fmt.Sprintf("payPremium error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("putMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("buyerPayPremium Sucessfully executed")
+	return t.marshalResponse(policy)
+}
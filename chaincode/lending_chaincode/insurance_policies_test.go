@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+//backdatePolicy - moves the premiums of the policy of the request the given number of days into the past
+func (n *testNetwork) backdatePolicy(days int) {
+	policy := n.insurance.policies[requestHash]
+	for i := range policy.Premiums {
+		policy.Premiums[i].DueDate = policy.Premiums[i].DueDate.AddDate(0, 0, -days)
+	}
+}
+
+func TestInsurancePolicy(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	// the closing issues the policy of the selected offer, its premiums fall due with the installments
+	info := n.mortgageInfo()
+	policy := n.insurance.policies[requestHash]
+	if policy == nil || policy.InsurerHash != insuranceID || policy.HolderHash != buyerID || policy.BeneficiaryHash != bankID || policy.MonthlyPremium != usd(1200) {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+	if len(policy.Premiums) != info.Mortgage.TermMonths || !policy.Premiums[0].DueDate.Equal(info.NextInstallment.DueDate) {
+		t.Fatalf("the premiums do not follow the installments %+v", policy.Premiums[0])
+	}
+	if info.Mortgage.PolicyHash != requestHash || info.Mortgage.PolicyStatus != lib.PolicyActive {
+		t.Fatalf("unexpected policy of the mortgage %s %s", info.Mortgage.PolicyHash, info.Mortgage.PolicyStatus)
+	}
+
+	// the first premium is due with the first installment and paid to the insurer
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", "1200")
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayPremium", requestHash)
+	n.as(buyerMSP, buyerID).mustFail("buyerPayPremium", requestHash)
+	if policy := n.insurance.policies[requestHash]; policy.Premiums[0].PaidAt.IsZero() || policy.NextPremium().Number != 2 {
+		t.Errorf("the first premium was not paid %+v", policy.Premiums[0])
+	}
+	cc := &HomelendChaincode{}
+	if balance, _ := cc.getBalance(n.stub, insuranceID, lib.DefaultCurrency); balance != usd(1200) {
+		t.Errorf("expected the insurer to hold 1200 got %s", balance)
+	}
+
+	// the second premium is unpaid for more than the grace period of the insurer
+	n.backdatePolicy(100)
+	if status := n.mortgageInfo().Mortgage.PolicyStatus; status != lib.PolicyLapsed {
+		t.Fatalf("expected a lapsed policy got %s", status)
+	}
+	n.stub.MockTransactionStart("coverage")
+	failures, err := cc.lapsedCoverage(n.stub, buyerID)
+	n.stub.MockTransactionEnd("coverage")
+	if err != nil || len(failures) != 1 || !strings.Contains(failures[0], requestHash) {
+		t.Errorf("the lapsed policy was not reported: %v %v", failures, err)
+	}
+
+	// the premiums due by the first installment reinstate the cover
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", "3600")
+	paid := &lib.Policy{}
+	err = json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("buyerPayPremium", requestHash), paid)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if paid.Status != lib.PolicyActive || paid.NextPremium().Number != 5 {
+		t.Errorf("the policy was not reinstated %s, next premium %d", paid.Status, paid.NextPremium().Number)
+	}
+	if balance, _ := cc.getBalance(n.stub, insuranceID, lib.DefaultCurrency); balance != usd(4800) {
+		t.Errorf("expected the insurer to hold 4800 got %s", balance)
+	}
+
+	// paying off the mortgage ends the cover
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", fmt.Sprint(loanAmount+loanAmount/10))
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayOff", requestHash)
+	if status := n.insurance.policies[requestHash].Status; status != lib.PolicyCancelled || n.mortgageInfo().Mortgage.PolicyStatus != status {
+		t.Errorf("expected a cancelled policy got %s", status)
+	}
+	n.as(buyerMSP, buyerID).mustFail("buyerPayPremium", requestHash)
+}
+
+func TestChaincodeLinksKeptAtClosing(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(homelendMSP, homelendID).mustInvoke("setChaincodeLinks", `{"CreditScore":{"Chaincode":"creditscore_chaincode"}}`)
+	n.as(homelendMSP, homelendID).mustFail("setChaincodeLinks", `{"Insurance":{"Chaincode":""}}`)
+
+	// the closing still reaches the land registry and the insurer
+	n.advanceTo(StatusCompletedActiveMortgage)
+	if n.registry.titles[propertyHash].OwnerHash != buyerID || n.insurance.policies[requestHash] == nil {
+		t.Errorf("the closing did not reach the linked chaincodes")
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
-	"strconv"
+	"strings"
 	"time"
 
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
 	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -32,13 +32,47 @@ import (
 * 11  - REQUEST_DECLINED_BY_BANK
 * 12  - REQUEST_APPROVED_BY_BANK
 * 13  - REQUEST_COMPLETED-ACTIVE-MORTGAGE
-
+*
+* The allowed moves between them are defined in request_status.go
  */
 
 // HomelendChaincode basic struct to provide an API
 type HomelendChaincode struct {
+	// identityProvider resolves the caller, cid is used when it is nil
+	identityProvider IdentityProvider
+}
+
+//IdentityProvider - resolves the identity and MSP of the transaction creator
+type IdentityProvider interface {
+	GetID(stub shim.ChaincodeStubInterface) (string, error)
+	GetMSPID(stub shim.ChaincodeStubInterface) (string, error)
+	GetAttributeValue(stub shim.ChaincodeStubInterface, attrName string) (string, bool, error)
+}
+
+//cidIdentityProvider - IdentityProvider backed by the client identity library
+type cidIdentityProvider struct {
+}
+
+func (p cidIdentityProvider) GetID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetID(stub)
 }
 
+func (p cidIdentityProvider) GetMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return cid.GetMSPID(stub)
+}
+
+func (p cidIdentityProvider) GetAttributeValue(stub shim.ChaincodeStubInterface, attrName string) (string, bool, error) {
+	return cid.GetAttributeValue(stub, attrName)
+}
+
+func (t *HomelendChaincode) identities() IdentityProvider {
+	if t.identityProvider == nil {
+		return cidIdentityProvider{}
+	}
+	return t.identityProvider
+}
+
+// requests_{buyerHash} held all requests of a buyer in one array before requestIndex, kept for migrateToCompositeKeys
 const requests = "requests_"
 const bank = "bank_"
 const appraiser = "appraiser_"
@@ -47,7 +81,6 @@ const money = "money_"
 
 const appraiserList = "appraiserList"
 const properties4sale = "properties4sale"
-const moneyTransferKey = "moneyTransfer"
 
 //states
 const creditRankOpenRequests = "creditRankOpenRequests"
@@ -58,25 +91,34 @@ const open4InsuranceOffers = "open4InsuranceOffers"
 const pending4Government = "pending4Government"
 const pending4bankApproval = "pending4bankApproval"
 const pending4ChaincodeExecute = "pending4ChaincodeExecute"
+const open4refinance = "open4refinance"
 
 //include appraiser hash as suffix
 const pendingForAppraiserEstimation = "pendingForAppraiserEstimation_"
 
+//composite key indexes - every request and property is stored under its own key
+const requestIndex = "request~buyer~hash"
+const propertyIndex = "property~owner~hash"
+
 // Property describes structure of real estate
 type Property struct {
 	Hash         string    `json:"Hash"`
 	SellerHash   string    `json:"SellerHash"`
 	Address      string    `json:"Address"`
 	ImageBase64  string    `json:"ImageBase64"`
-	SellingPrice float32   `json:"SellingPrice"`
+	SellingPrice lib.Money `json:"SellingPrice"`
+	Currency     string    `json:"Currency"`
 	Timestamp    time.Time `json:"Timestamp"`
 }
 
 // InsuranceOffer describes fields of offer
+// InsuranceAmount is the premium, Coverage what the policy pays out, both in Currency
 type InsuranceOffer struct {
 	Hash            string    `json:"Hash"`
 	InsuranceHash   string    `json:"InsuranceHash"`
-	InsuranceAmount float32   `json:"InsuranceAmount"`
+	InsuranceAmount lib.Money `json:"InsuranceAmount"`
+	Coverage        lib.Money `json:"Coverage"`
+	Currency        string    `json:"Currency"`
 	Timestamp       time.Time `json:"Timestamp"`
 }
 
@@ -91,48 +133,65 @@ type AppraiserPullResultItem struct {
 type InsurancePullResultItem struct {
 	BuyerHash    string    `json:"BuyerHash"`
 	RequestHash  string    `json:"RequestHash"`
-	LoanAmount   int       `json:"LoanAmount"`
+	LoanAmount   lib.Money `json:"LoanAmount"`
 	PropertyItem *Property `json:"PropertyItem"`
 }
 
-//MyInfo - the data of the user
+//MyInfo - the data of the user, Balance is in the lib.DefaultCurrency and Balances in every currency the user holds
 type MyInfo struct {
 	UserHash   string      `json:"UserHash"`
-	Balance    int         `json:"Balance"`
+	Balance    lib.Money   `json:"Balance"`
+	Balances   []lib.Money `json:"Balances"`
 	Properties []*Property `json:"Properties"`
 }
 
 // Request defines buy processing and contains
+// Currency is the currency of the loan, SellingPrice and DownPayment are in the currency of the property
 type Request struct {
-	Hash                       string             `json:"Hash"`
-	PropertyHash               string             `json:"PropertyHash"`
-	BuyerHash                  string             `json:"BuyerHash"`
-	SellerHash                 string             `json:"SellerHash"`
-	AppraiserHash              string             `json:"AppraiserHash"`
-	AppraiserAmount            int                `json:"AppraiserAmount"`
-	CreditScore                string             `json:"CreditScore"`
-	CreditScoreIdentity        string             `json:"CreditScoreIdentity"`
-	LoanAmountLeftToRefund     int                `json:"LoanAmountLeftToRefund"`
-	GovernmentResultsData      *GovernmentResults `json:"GovernmentResultsData"`
-	InsuranceOffers            []InsuranceOffer   `json:"InsuranceOffers"`
-	BankOffers                 []BankOffer        `json:"BankOffers"`
-	SelectedBankOfferHash      string             `json:"SelectedBankOfferHash"`
-	SelectedInsuranceOfferHash string             `json:"SelectedInsuranceOfferHash"`
-	Salary                     int                `json:"Salary"`
-	SalaryBase64               string             `json:"SalaryBase64"`
-	LoanAmount                 int                `json:"LoanAmount"`
-	Duration                   int                `json:"Duration"`
-	Status                     string             `json:"Status"`
-	DeclineInfo                string             `json:"DeclineInfo"`
-	Timestamp                  time.Time          `json:"Timestamp"`
-}
-
-//GovernmentResults - The results from the government
+	Hash                       string                   `json:"Hash"`
+	PropertyHash               string                   `json:"PropertyHash"`
+	BuyerHash                  string                   `json:"BuyerHash"`
+	SellerHash                 string                   `json:"SellerHash"`
+	AppraiserHash              string                   `json:"AppraiserHash"`
+	AppraiserAmount            lib.Money                `json:"AppraiserAmount"`
+	CreditScore                string                   `json:"CreditScore"`
+	CreditScoreIdentity        string                   `json:"CreditScoreIdentity"`
+	CreditScoreDetails         *lib.CreditScoreResponse `json:"CreditScoreDetails"`
+	CreditScores               []AgencyCreditScore      `json:"CreditScores"`
+	LoanAmountLeftToRefund     lib.Money                `json:"LoanAmountLeftToRefund"`
+	GovernmentResultsData      *GovernmentResults       `json:"GovernmentResultsData"`
+	InsuranceOffers            []InsuranceOffer         `json:"InsuranceOffers"`
+	BankOffers                 []BankOffer              `json:"BankOffers"`
+	SelectedBankOfferHash      string                   `json:"SelectedBankOfferHash"`
+	SelectedInsuranceOfferHash string                   `json:"SelectedInsuranceOfferHash"`
+	Salary                     lib.Money                `json:"Salary"`
+	SalaryBase64               string                   `json:"SalaryBase64"`
+	LoanAmount                 lib.Money                `json:"LoanAmount"`
+	Currency                   string                   `json:"Currency"`
+	SellingPrice               lib.Money                `json:"SellingPrice"`
+	DownPayment                lib.Money                `json:"DownPayment"`
+	DownPaymentPaid            bool                     `json:"DownPaymentPaid"`
+	Duration                   int                      `json:"Duration"`
+	Status                     RequestStatus            `json:"Status"`
+	StatusHistory              []StatusTransition       `json:"StatusHistory"`
+	DeclineInfo                string                   `json:"DeclineInfo"`
+	Timestamp                  time.Time                `json:"Timestamp"`
+	// the transition not yet announced by a chaincode event, see emitTransitionEvent
+	transition *StatusTransition
+}
+
+//GovernmentResults - The results from the government.
+//Results answered from the land registry (Source "registry") carry the version of the title record they were
+//checked against and the structured result of every check, results entered with governmentPutData are "manual".
 type GovernmentResults struct {
-	CheckLien        bool      `json:"CheckLien"`
-	CheckHouseOwner  bool      `json:"CheckHouseOwner"`
-	CheckWarningShot bool      `json:"CheckWarningShot"`
-	Timestamp        time.Time `json:"Timestamp"`
+	CheckLien        bool                   `json:"CheckLien"`
+	CheckHouseOwner  bool                   `json:"CheckHouseOwner"`
+	CheckWarningShot bool                   `json:"CheckWarningShot"`
+	Source           string                 `json:"Source"`
+	ParcelID         string                 `json:"ParcelID"`
+	RegistryVersion  int                    `json:"RegistryVersion"`
+	Checks           []lib.TitleCheckResult `json:"Checks"`
+	Timestamp        time.Time              `json:"Timestamp"`
 }
 
 //RequestLink - pointer to request
@@ -142,19 +201,34 @@ type RequestLink struct {
 }
 
 //BankOffer Bank offer
+//Interest is the rate of a fixed offer and of the fixed period of a hybrid one,
+//a variable offer pays the base rate of BaseRateIndex plus Margin.
+//Fees are held in escrow from the buyer at approval and paid to the bank at closing, MaxLTV and PrepaymentPenalty are percents.
+//Currency is the currency of the loan, all amounts of the offer are in it.
 type BankOffer struct {
-	Hash           string    `json:"Hash"`
-	BankHash       string    `json:"BankHash"`
-	Interest       float32   `json:"Interest"`
-	MonthlyPayment float64   `json:"MonthlyPayment"`
-	Timestamp      time.Time `json:"Timestamp"`
+	Hash              string      `json:"Hash"`
+	BankHash          string      `json:"BankHash"`
+	Product           ProductType `json:"Product"`
+	Interest          float32     `json:"Interest"`
+	BaseRateIndex     string      `json:"BaseRateIndex"`
+	Margin            float32     `json:"Margin"`
+	FixedMonths       int         `json:"FixedMonths"`
+	ResetMonths       int         `json:"ResetMonths"`
+	Duration          int         `json:"Duration"`
+	Fees              lib.Money   `json:"Fees"`
+	Currency          string      `json:"Currency"`
+	MaxLTV            float32     `json:"MaxLTV"`
+	ExpiresAt         time.Time   `json:"ExpiresAt"`
+	MonthlyPayment    lib.Money   `json:"MonthlyPayment"`
+	PrepaymentPenalty float32     `json:"PrepaymentPenalty"`
+	Timestamp         time.Time   `json:"Timestamp"`
 }
 
 // Bank describes fields of Bank
 type Bank struct {
 	SwiftNumber string    `json:"SwiftNumber"`
 	Name        string    `json:"Name"`
-	TotalSupply int       `json:"TotalSupply"`
+	TotalSupply lib.Money `json:"TotalSupply"`
 	Timestamp   time.Time `json:"Timestamp"`
 }
 
@@ -205,6 +279,14 @@ type CreditRatingAgency struct {
 // Init initializes chaincode
 // ===========================
 func (t *HomelendChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+
+	err := t.initRoleMSPs(stub, args)
+	if err != nil {
+		str := fmt.Sprintf("initRoleMSPs error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	return shim.Success(nil)
 }
 
@@ -213,7 +295,7 @@ func (t *HomelendChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
-	identity, err := cid.GetID(stub)
+	identity, err := t.identities().GetID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("Identity error %+v", args)
@@ -221,7 +303,7 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return shim.Error(str)
 	}
 
-	mspid, err := cid.GetMSPID(stub)
+	mspid, err := t.identities().GetMSPID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", args)
@@ -231,6 +313,15 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 
 	fmt.Println(fmt.Printf("Access log %s %s", identity, mspid))
 
+	if roles, ok := functionRoles[function]; ok {
+		_, err = t.authorize(stub, roles)
+		if err != nil {
+			str := fmt.Sprintf("%s access denied %+v", function, err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
 	// steps
 	if function == "advertise" {
 		return t.advertise(stub, args)
@@ -247,7 +338,7 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 	} else if function == "putBankInfo" {
 		return t.putBankInfo(stub, args)
 	} else if function == "creditRatingPull" {
-		return t.getArray(stub, "POCCreditRatingAgencyMSP", creditRankOpenRequests, false)
+		return t.pullQueue(stub, RoleCreditAgency, creditRankOpenRequests, args)
 	} else if function == "putCreditRatingAgencyInfo" {
 		return t.putCreditRatingAgencyInfo(stub, args)
 	} else if function == "putInsuranceCompanyInfo" {
@@ -259,15 +350,17 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 	} else if function == "creditScore" {
 		return t.calcCreditScore(stub, args)
 	} else if function == "getProperties" {
-		return t.getArray(stub, "", "", true)
+		return t.getProperties(stub)
 	} else if function == "getRequestInfo" {
 		return t.getRequestForSpecificPlayer(stub, args[0], args[1])
 	} else if function == "appraiserPullPendingRequests" {
 		return t.appraiserPullPendingRequests(stub, args)
 	} else if function == "buyerGetMyRequests" {
-		return t.getArray(stub, "POCBuyerMSP", requests, true)
+		return t.buyerGetMyRequests(stub)
 	} else if function == "governmentPullPending" {
-		return t.getArray(stub, "POCGovernmentMSP", pending4Government, false)
+		return t.pullQueue(stub, RoleGovernment, pending4Government, args)
+	} else if function == "governmentVerify" {
+		return t.governmentVerify(stub, args)
 	} else if function == "governmentPutData" {
 		return t.governmentPutData(stub, args)
 	} else if function == "bankApprove" {
@@ -275,11 +368,11 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 	} else if function == "bankRunChaincode" {
 		return t.bankRunChaincode(stub, args)
 	} else if function == "bankPullOpen4bankOffers" {
-		return t.getArray(stub, "POCBankMSP", open4bankOffers, false)
+		return t.pullQueue(stub, RoleLoanOfficer, open4bankOffers, args)
 	} else if function == "bankPutOffer" {
 		return t.bankPutOffer(stub, args)
 	} else if function == "buyerGetAllAppraisers" {
-		return t.getArray(stub, "POCBuyerMSP", appraiserList, false)
+		return t.getArray(stub, RoleBuyer, appraiserList, false)
 	} else if function == "buyerSelectBankOffer" {
 		return t.buyerSelectBankOffer(stub, args)
 	} else if function == "bankPullPending4FinalAppproval" {
@@ -287,13 +380,93 @@ func (t *HomelendChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 	} else if function == "buyerSelectInsuranceOffer" {
 		return t.buyerSelectInsuranceOffer(stub, args)
 	} else if function == "getProperties4Sale" {
-		return t.getArray(stub, "POCBuyerMSP", properties4sale, false)
+		return t.getArray(stub, RoleBuyer, properties4sale, false)
 	} else if function == "insuranceGetOpenRequests" {
 		return t.insurancePullPendingRequests(stub, args)
 	} else if function == "getMyInfo" {
 		return t.getMyInfo(stub)
 	} else if function == "query" {
 		return t.query(stub, args[0])
+	} else if function == "getRoleMSPs" {
+		return t.getRoleMapping(stub)
+	} else if function == "listParticipants" {
+		return t.listParticipants(stub, args)
+	} else if function == "approveParticipant" {
+		return t.setParticipantStatus(stub, args, ParticipantActive)
+	} else if function == "suspendParticipant" {
+		return t.setParticipantStatus(stub, args, ParticipantSuspended)
+	} else if function == "setParticipantApprovalRequired" {
+		return t.setParticipantApprovalRequired(stub, args)
+	} else if function == "buyerDeposit" {
+		return t.buyerDeposit(stub, args)
+	} else if function == "confirmDeposit" {
+		return t.decideDeposit(stub, args, DepositConfirmed)
+	} else if function == "rejectDeposit" {
+		return t.decideDeposit(stub, args, DepositRejected)
+	} else if function == "getPendingDeposits" {
+		return t.getPendingDeposits(stub)
+	} else if function == "buyerPayDownPayment" {
+		return t.buyerPayDownPayment(stub, args)
+	} else if function == "bankDeposit" {
+		return t.bankDeposit(stub, args)
+	} else if function == "getAccountStatement" {
+		return t.getAccountStatement(stub, args)
+	} else if function == "buyerPayInstallment" {
+		return t.buyerPayInstallment(stub, args)
+	} else if function == "getMortgageInfo" {
+		return t.getMortgageInfo(stub, args)
+	} else if function == "getAmortizationSchedule" {
+		return t.getAmortizationSchedule(stub, args)
+	} else if function == "getPaymentHistory" {
+		return t.getPaymentHistory(stub, args)
+	} else if function == "bankAssessDelinquency" {
+		return t.bankAssessDelinquency(stub, args)
+	} else if function == "bankForeclose" {
+		return t.bankForeclose(stub, args)
+	} else if function == "setServicingTerms" {
+		return t.setServicingTerms(stub, args)
+	} else if function == "getServicingTerms" {
+		return t.getServicingTermsInfo(stub)
+	} else if function == "getPayoffQuote" {
+		return t.getPayoffQuote(stub, args)
+	} else if function == "buyerPayOff" {
+		return t.buyerPayOff(stub, args)
+	} else if function == "buyerRequestRefinance" {
+		return t.buyerRequestRefinance(stub, args)
+	} else if function == "bankPullOpen4Refinance" {
+		return t.pullQueue(stub, RoleLoanOfficer, open4refinance, args)
+	} else if function == "bankPutRefinanceOffer" {
+		return t.bankPutRefinanceOffer(stub, args)
+	} else if function == "getRefinanceOffers" {
+		return t.getRefinanceOffers(stub, args)
+	} else if function == "buyerAcceptRefinanceOffer" {
+		return t.buyerAcceptRefinanceOffer(stub, args)
+	} else if function == "buyerPayPremium" {
+		return t.buyerPayPremium(stub, args)
+	} else if function == "setBaseRate" {
+		return t.setBaseRate(stub, args)
+	} else if function == "getBaseRate" {
+		return t.getBaseRateInfo(stub, args)
+	} else if function == "setExchangeRate" {
+		return t.setExchangeRate(stub, args)
+	} else if function == "getExchangeRate" {
+		return t.getExchangeRateInfo(stub, args)
+	} else if function == "setChaincodeLinks" {
+		return t.setChaincodeLinks(stub, args)
+	} else if function == "getChaincodeLinks" {
+		return t.getChaincodeLinksInfo(stub)
+	} else if function == "setCreditScorePolicy" {
+		return t.setCreditScorePolicy(stub, args)
+	} else if function == "getCreditScorePolicy" {
+		return t.getCreditScorePolicyInfo(stub)
+	} else if function == "setUnderwritingRules" {
+		return t.setUnderwritingRules(stub, args)
+	} else if function == "getUnderwritingRules" {
+		return t.getUnderwritingRulesInfo(stub, args)
+	} else if function == "migrateToCompositeKeys" {
+		return t.migrateToCompositeKeys(stub, args)
+	} else if function == "migrateQueuesToCompositeKeys" {
+		return t.migrateQueuesToCompositeKeys(stub, args)
 	}
 
 	fmt.Println("invoke did not find func: " + function) //error
@@ -311,25 +484,28 @@ func (t *HomelendChaincode) getMyInfo(stub shim.ChaincodeStubInterface) pb.Respo
 		return shim.Error(str)
 	}
 
-	money := t.getMoney(stub, identity)
-	var list []*Property
+	money, err := t.getMoney(stub, identity)
+	if err != nil {
+		str := fmt.Sprintf("getMoney error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 
-	dataAsBytes, err := stub.GetState(identity)
+	balances, err := t.getBalances(stub, identity)
 	if err != nil {
-		str := fmt.Sprintf("stub.GetState(id) error %+v", err)
+		str := fmt.Sprintf("getBalances error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
-	if len(dataAsBytes) > 0 {
-		err = json.Unmarshal(dataAsBytes, &list)
-		if err != nil {
-			str := fmt.Sprintf("json.Unmarshal(dataAsBytes, &list) error %+v", err)
-			fmt.Println(str)
-			return shim.Error(str)
-		}
+
+	list, err := t.getPropertiesByOwner(stub, identity)
+	if err != nil {
+		str := fmt.Sprintf("getPropertiesByOwner error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
 	}
 
-	myInfo := &MyInfo{Properties: list, UserHash: identity, Balance: money}
+	myInfo := &MyInfo{Properties: list, UserHash: identity, Balance: money, Balances: balances}
 	byteResult, err := json.Marshal(myInfo)
 	if err != nil {
 		str := fmt.Sprintf("json.Marshal(myInfo) error %+v", err)
@@ -357,7 +533,7 @@ func (t *HomelendChaincode) putSellerPersonalInfo(stub shim.ChaincodeStubInterfa
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCSellerMSP")
+	identity, err := t.getIdentity(stub, RoleSeller)
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", args)
 		fmt.Println(str)
@@ -372,7 +548,13 @@ func (t *HomelendChaincode) putSellerPersonalInfo(stub shim.ChaincodeStubInterfa
 		return shim.Error(str)
 	}
 
-	data.Timestamp = time.Now()
+	helpers := lib.Helpers{}
+	data.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
 		str := fmt.Sprintf("Could not marshal %+v", err.Error())
@@ -407,70 +589,60 @@ func (t *HomelendChaincode) advertise(stub shim.ChaincodeStubInterface, args []s
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCSellerMSP")
+	identity, err := t.getIdentity(stub, RoleSeller)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
+	// a plain SellingPrice is an amount of the Currency of the property
+	dataAsBytes, currency, err := denominate(args[0], lib.DefaultCurrency, "SellingPrice")
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	data := &Property{}
-	err = json.Unmarshal([]byte(args[0]), data)
+	err = json.Unmarshal(dataAsBytes, data)
 	if err != nil {
 		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
+	data.Currency = currency
+	if data.SellingPrice.Currency != currency || !data.SellingPrice.IsPositive() {
+		str := fmt.Sprintf("SellingPrice must be a positive amount of %s %+v", currency, data.SellingPrice)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	data.SellerHash = identity
-	data.Timestamp = time.Now()
-	fmt.Println(fmt.Printf("Getting state for %+s", identity))
-	dataAsBytes, err := stub.GetState(identity)
+	helpers := lib.Helpers{}
+	data.Timestamp, err = helpers.GetTxTime(stub)
 	if err != nil {
-		str := fmt.Sprintf("Failed to get: %s", identity)
+		str := fmt.Sprintf("GetTxTime error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	if dataAsBytes == nil {
-		fmt.Println("Does not have houses. Creating first one")
-		var arrayOfData []*Property
-		arrayOfData = append(arrayOfData, data)
-
-		dataJSONasBytes, err := json.Marshal(arrayOfData)
-		if err != nil {
-			str := fmt.Sprintf("Could not marshal %+v", err.Error())
-			fmt.Println(str)
-			return shim.Error(str)
-		}
-
-		err = stub.PutState(identity, dataJSONasBytes)
-		if err != nil {
-			str := fmt.Sprintf("Could not put state %+v", err.Error())
-			fmt.Println(str)
-			return shim.Error(str)
-		}
-
-		fmt.Println("Sucessfully executed")
-	} else {
-		fmt.Println("Already has houses. Appending one")
-		var arrayOfData []*Property
-		err = json.Unmarshal(dataAsBytes, &arrayOfData)
-
-		if err != nil {
-			str := fmt.Sprintf("Failed to unmarshal: %s", err)
-			fmt.Println(str)
-			return shim.Error(str)
-		}
-
-		arrayOfData = append(arrayOfData, data)
-		arrayOfDataAsBytes, err := json.Marshal(arrayOfData)
+	existing, err := t.getProperty(stub, identity, data.Hash)
+	if err != nil {
+		str := fmt.Sprintf("getProperty error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if existing != nil {
+		str := fmt.Sprintf("property already exists hash: %s", data.Hash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 
-		err = stub.PutState(identity, arrayOfDataAsBytes)
-		if err != nil {
-			str := fmt.Sprintf("Could not put state %+v", err.Error())
-			fmt.Println(str)
-			return shim.Error(str)
-		}
+	err = t.putProperty(stub, identity, data)
+	if err != nil {
+		str := fmt.Sprintf("Could not putProperty %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
 	}
 
 	dataAsBytes, err = stub.GetState(properties4sale)
@@ -552,7 +724,7 @@ func (t *HomelendChaincode) governmentPutData(stub shim.ChaincodeStubInterface,
 	checkLien := args[3] == "true"
 	checkWarningShot := args[4] == "true"
 
-	_, err = t.getIdentity(stub, "POCGovernmentMSP")
+	_, err = t.getIdentity(stub, RoleGovernment)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
@@ -566,33 +738,17 @@ func (t *HomelendChaincode) governmentPutData(stub shim.ChaincodeStubInterface,
 		return shim.Error(str)
 	}
 
-	request.GovernmentResultsData = t.govResultsGetter(checkHouseOwner, checkLien, checkWarningShot)
-	request.Status = "REQUEST_GOVERNMENT_PROVIDED"
-
-	err = t.addOrUpdateRequest(stub, request)
-	if err != nil {
-		str := fmt.Sprintf("Could not addOrUpdateRequest %+v", err.Error())
-		fmt.Println(str)
-		return shim.Error(str)
-	}
-
-	bankHash, err := t.getBankHash(request)
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
 	if err != nil {
-		str := fmt.Sprintf("getBankHash %s", err)
+		str := fmt.Sprintf("GetTxTime error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	rl := &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash}
-	t.removeFromRequestArray(stub, pending4Government, rl)
+	err = t.putGovernmentResults(stub, request, t.govResultsGetter(checkHouseOwner, checkLien, checkWarningShot, timestamp))
 	if err != nil {
-		str := fmt.Sprintf("Could not removeFromRequestArray %+v", err.Error())
-		fmt.Println(str)
-		return shim.Error(str)
-	}
-	err = t.addRequestToArray(stub, pending4bankApproval+bankHash, rl)
-	if err != nil {
-		str := fmt.Sprintf("Could not addRequestToArray %+v", err.Error())
+		str := fmt.Sprintf("putGovernmentResults error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
@@ -618,7 +774,7 @@ func (t *HomelendChaincode) appraiserPutPersonalInfo(stub shim.ChaincodeStubInte
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCAppraiserMSP")
+	identity, err := t.getIdentity(stub, RoleAppraiser)
 	if err != nil {
 		str := fmt.Sprintf("error getIdentity %+v", args)
 		fmt.Println(str)
@@ -634,7 +790,13 @@ func (t *HomelendChaincode) appraiserPutPersonalInfo(stub shim.ChaincodeStubInte
 	}
 
 	appraiser.AppraiserHash = identity
-	appraiser.Timestamp = time.Now()
+	helpers := lib.Helpers{}
+	appraiser.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	dataJSONasBytes, err := json.Marshal(appraiser)
 	if err != nil {
 		str := fmt.Sprintf("Could not marshal %+v", err.Error())
@@ -692,6 +854,13 @@ func (t *HomelendChaincode) appraiserPutPersonalInfo(stub shim.ChaincodeStubInte
 		return shim.Error(str)
 	}
 
+	err = t.registerParticipant(stub, ParticipantAppraiser, identity, appraiser.FirstName+" "+appraiser.LastName)
+	if err != nil {
+		str := fmt.Sprintf("registerParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	fmt.Println("putAppraiserPersonalInfo Sucessfully executed")
 	return shim.Success(nil)
 }
@@ -699,32 +868,53 @@ func (t *HomelendChaincode) appraiserPutPersonalInfo(stub shim.ChaincodeStubInte
 func (t *HomelendChaincode) appraiserProvideAmount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println(fmt.Sprintf("appraiserProvideAmount executed with args"))
 
-	if len(args) != 3 {
+	if len(args) != 3 && len(args) != 4 {
 		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCAppraiserMSP")
+	identity, err := t.getIdentity(stub, RoleAppraiser)
 	if err != nil {
 		str := fmt.Sprintf("error getIdentity %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
+	err = t.requireActiveParticipant(stub, ParticipantAppraiser, identity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	buyerHash := args[0]
 	requestHash := args[1]
 	amount := args[2]
-	appraiserAmount, err := strconv.Atoi(amount)
+
+	request, err := t.getRequest(stub, buyerHash, requestHash)
 	if err != nil {
-		str := fmt.Sprintf("Could not parse amount %+v", err.Error())
+		str := fmt.Sprintf("Could not getRequest %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	request, err := t.getRequest(stub, buyerHash, requestHash)
+	// the property is appraised in the currency of its price unless the appraiser says otherwise,
+	// requests created before down payments have no SellingPrice and use the currency of the loan
+	propertyCurrency := request.SellingPrice.Currency
+	if propertyCurrency == "" {
+		propertyCurrency = request.LoanAmount.Currency
+	}
+	currency, err := parseCurrencyArg(args, 3, propertyCurrency)
 	if err != nil {
-		str := fmt.Sprintf("Could not getRequest %+v", err.Error())
+		str := fmt.Sprintf("parseCurrencyArg error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	appraiserAmount, err := lib.ParseMoney(amount, currency)
+	if err != nil || !appraiserAmount.IsPositive() {
+		str := fmt.Sprintf("Could not parse amount %+v", amount)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
@@ -735,9 +925,14 @@ func (t *HomelendChaincode) appraiserProvideAmount(stub shim.ChaincodeStubInterf
 		return shim.Error(str)
 	}
 
-	request.Status = "APPRAISER_PROVIEDED_AMOUNT"
+	err = t.transitionRequest(stub, request, StatusAppraiserProvidedAmount)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	request.AppraiserAmount = appraiserAmount
-	t.addOrUpdateRequest(stub, request)
+	err = t.addOrUpdateRequest(stub, request)
 	if err != nil {
 		str := fmt.Sprintf("Could not addOrUpdateRequest %+v", err.Error())
 		fmt.Println(str)
@@ -745,16 +940,16 @@ func (t *HomelendChaincode) appraiserProvideAmount(stub shim.ChaincodeStubInterf
 	}
 
 	rl := &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash}
-	err = t.removeFromRequestArray(stub, pendingForAppraiserEstimation+identity, rl)
+	err = t.dequeueRequest(stub, pendingForAppraiserEstimation+identity, rl)
 	if err != nil {
-		str := fmt.Sprintf("Could not removeFromRequestArray %+v", err.Error())
+		str := fmt.Sprintf("Could not dequeueRequest %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	err = t.addRequestToArray(stub, open4InsuranceOffers, rl)
+	err = t.enqueueRequest(stub, open4InsuranceOffers, rl)
 	if err != nil {
-		str := fmt.Sprintf("Could not addRequestToArray %+v", err.Error())
+		str := fmt.Sprintf("Could not enqueueRequest %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
@@ -765,33 +960,28 @@ func (t *HomelendChaincode) appraiserProvideAmount(stub shim.ChaincodeStubInterf
 func (t *HomelendChaincode) appraiserPullPendingRequests(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println(fmt.Sprintf("appraiserPullPendingRequests executed with args %+v", args))
 
-	identity, err := t.getIdentity(stub, "POCAppraiserMSP")
+	identity, err := t.getIdentity(stub, RoleAppraiser)
 	if err != nil {
 		str := fmt.Sprintf("error getIdentity %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	myPendingByteArray, err := stub.GetState(pendingForAppraiserEstimation + identity)
+	pageSize, bookmark, err := t.parsePagination(args)
 	if err != nil {
-		str := fmt.Sprintf("faild to getState pendingForAppraiserEstimation + identity %+v", args)
+		str := fmt.Sprintf("parsePagination error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	if len(myPendingByteArray) == 0 {
-		return shim.Success(nil)
-	}
-
-	var myPending []*RequestLink
-	err = json.Unmarshal(myPendingByteArray, &myPending)
+	myPending, nextBookmark, err := t.listQueue(stub, pendingForAppraiserEstimation+identity, pageSize, bookmark)
 	if err != nil {
-		str := fmt.Sprintf("Failed to Unmarshal myPendingByteArray: %+v", err)
+		str := fmt.Sprintf("faild to listQueue %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	var result []*AppraiserPullResultItem
+	result := make([]*AppraiserPullResultItem, 0)
 	for i := 0; i < len(myPending); i++ {
 		userHash := myPending[i].UserHash
 		requestHash := myPending[i].RequestHash
@@ -802,29 +992,23 @@ func (t *HomelendChaincode) appraiserPullPendingRequests(stub shim.ChaincodeStub
 			return shim.Error(str)
 		}
 
-		property, _, _, err := t.getProperty(stub, request.SellerHash, request.PropertyHash)
+		property, err := t.getProperty(stub, request.SellerHash, request.PropertyHash)
 		if err != nil {
 			str := fmt.Sprintf("Failed to getProperty %+v", err.Error())
 			fmt.Println(str)
 			return shim.Error(str)
 		}
+		if property == nil {
+			str := fmt.Sprintf("Property %s of seller %s was not found", request.PropertyHash, request.SellerHash)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
 
 		item2Add := &AppraiserPullResultItem{BuyerHash: userHash, RequestHash: requestHash, PropertyItem: property}
 		result = append(result, item2Add)
 	}
 
-	if len(result) == 0 {
-		return shim.Success(nil)
-	}
-
-	dataJSONasBytes, err := json.Marshal(result)
-	if err != nil {
-		str := fmt.Sprintf("Could not marshal result %+v", err.Error())
-		fmt.Println(str)
-		return shim.Error(str)
-	}
-
-	return shim.Success(dataJSONasBytes)
+	return t.pullResponse(result, nextBookmark)
 }
 
 //insurance
@@ -844,7 +1028,7 @@ func (t *HomelendChaincode) putInsuranceCompanyInfo(stub shim.ChaincodeStubInter
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCInsuranceMSP")
+	identity, err := t.getIdentity(stub, RoleInsurer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
@@ -860,7 +1044,13 @@ func (t *HomelendChaincode) putInsuranceCompanyInfo(stub shim.ChaincodeStubInter
 	}
 
 	data.Hash = identity
-	data.Timestamp = time.Now()
+	helpers := lib.Helpers{}
+	data.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
 		str := fmt.Sprintf("Could not marshal %+v", err.Error())
@@ -875,15 +1065,24 @@ func (t *HomelendChaincode) putInsuranceCompanyInfo(stub shim.ChaincodeStubInter
 		return shim.Error(str)
 	}
 
+	err = t.registerParticipant(stub, ParticipantInsurer, identity, data.Name)
+	if err != nil {
+		str := fmt.Sprintf("registerParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	fmt.Println("Sucessfully executed")
 
 	return shim.Success(nil)
 }
+
+//insurancePutOffer - args: userHash, requestHash, premium, offer hash, optional currency and coverage
 func (t *HomelendChaincode) insurancePutOffer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println(fmt.Sprintf("updateInsuranceOffers executed with args: %+v", args))
 
 	var err error
-	if len(args) != 4 {
+	if len(args) < 4 || len(args) > 6 {
 		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
 		fmt.Println(str)
 		return shim.Error(str)
@@ -912,13 +1111,20 @@ func (t *HomelendChaincode) insurancePutOffer(stub shim.ChaincodeStubInterface,
 	amountStr := args[2]
 	newHash := args[3]
 
-	identity, err := t.getIdentity(stub, "POCInsuranceMSP")
+	identity, err := t.getIdentity(stub, RoleInsurer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
+	err = t.requireActiveParticipant(stub, ParticipantInsurer, identity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	request, err := t.getRequest(stub, userHash, requestHash)
 	if err != nil {
 		str := fmt.Sprintf("getRequest error %+v", args)
@@ -926,16 +1132,46 @@ func (t *HomelendChaincode) insurancePutOffer(stub shim.ChaincodeStubInterface,
 		return shim.Error(str)
 	}
 
-	amount, err := strconv.ParseFloat(amountStr, 32)
+	// the premium is in the currency of the loan unless the insurer says otherwise
+	currency, err := parseCurrencyArg(args, 4, request.LoanAmount.Currency)
 	if err != nil {
+		str := fmt.Sprintf("parseCurrencyArg error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	amount, err := lib.ParseMoney(amountStr, currency)
+	if err != nil || amount.IsNegative() {
 		str := fmt.Sprintf("Amount value is wrong %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	//TODO: check if insurance is registered
-	request.Status = "INSURANCE_OFFER_PROVIDED"
-	request.InsuranceOffers = append(request.InsuranceOffers, InsuranceOffer{Hash: newHash, InsuranceHash: identity, InsuranceAmount: float32(amount), Timestamp: time.Now()})
+	coverage := lib.NewMoney(0, currency)
+	if len(args) > 5 {
+		coverage, err = lib.ParseMoney(args[5], currency)
+		if err != nil || coverage.IsNegative() {
+			str := fmt.Sprintf("Coverage value is wrong %+v", args[5])
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	err = t.transitionRequest(stub, request, StatusInsuranceOfferProvided)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request.InsuranceOffers = append(request.InsuranceOffers, InsuranceOffer{Hash: newHash, InsuranceHash: identity, InsuranceAmount: amount, Coverage: coverage, Currency: currency, Timestamp: timestamp})
 
 	err = t.addOrUpdateRequest(stub, request)
 	if err != nil {
@@ -951,33 +1187,28 @@ func (t *HomelendChaincode) insurancePutOffer(stub shim.ChaincodeStubInterface,
 func (t *HomelendChaincode) insurancePullPendingRequests(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println(fmt.Sprintf("insurancePullPendingRequests executed with args %+v", args))
 
-	_, err := t.getIdentity(stub, "POCInsuranceMSP")
+	_, err := t.getIdentity(stub, RoleInsurer)
 	if err != nil {
 		str := fmt.Sprintf("error getIdentity %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	myPendingByteArray, err := stub.GetState(open4InsuranceOffers)
+	pageSize, bookmark, err := t.parsePagination(args)
 	if err != nil {
-		str := fmt.Sprintf("faild to getState pendingForAppraiserEstimation + identity %+v", args)
+		str := fmt.Sprintf("parsePagination error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	if len(myPendingByteArray) == 0 {
-		return shim.Success(nil)
-	}
-
-	var myPending []*RequestLink
-	err = json.Unmarshal(myPendingByteArray, &myPending)
+	myPending, nextBookmark, err := t.listQueue(stub, open4InsuranceOffers, pageSize, bookmark)
 	if err != nil {
-		str := fmt.Sprintf("Failed to Unmarshal myPendingByteArray: %+v", err)
+		str := fmt.Sprintf("faild to listQueue %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	var result []*InsurancePullResultItem
+	result := make([]*InsurancePullResultItem, 0)
 	for i := 0; i < len(myPending); i++ {
 		userHash := myPending[i].UserHash
 		requestHash := myPending[i].RequestHash
@@ -988,29 +1219,23 @@ func (t *HomelendChaincode) insurancePullPendingRequests(stub shim.ChaincodeStub
 			return shim.Error(str)
 		}
 
-		property, _, _, err := t.getProperty(stub, request.SellerHash, request.PropertyHash)
+		property, err := t.getProperty(stub, request.SellerHash, request.PropertyHash)
 		if err != nil {
 			str := fmt.Sprintf("Failed to getProperty %+v", err.Error())
 			fmt.Println(str)
 			return shim.Error(str)
 		}
+		if property == nil {
+			str := fmt.Sprintf("Property %s of seller %s was not found", request.PropertyHash, request.SellerHash)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
 
 		item2Add := &InsurancePullResultItem{BuyerHash: userHash, RequestHash: requestHash, PropertyItem: property, LoanAmount: request.LoanAmount}
 		result = append(result, item2Add)
 	}
 
-	if len(result) == 0 {
-		return shim.Success(nil)
-	}
-
-	dataJSONasBytes, err := json.Marshal(result)
-	if err != nil {
-		str := fmt.Sprintf("Could not marshal result %+v", err.Error())
-		fmt.Println(str)
-		return shim.Error(str)
-	}
-
-	return shim.Success(dataJSONasBytes)
+	return t.pullResponse(result, nextBookmark)
 }
 
 //Bank
@@ -1030,7 +1255,7 @@ func (t *HomelendChaincode) putBankInfo(stub shim.ChaincodeStubInterface, args [
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCBankMSP")
+	identity, err := t.getIdentity(stub, RoleLoanOfficer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
@@ -1045,7 +1270,13 @@ func (t *HomelendChaincode) putBankInfo(stub shim.ChaincodeStubInterface, args [
 		return shim.Error(str)
 	}
 
-	data.Timestamp = time.Now()
+	helpers := lib.Helpers{}
+	data.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
 		str := fmt.Sprintf("Could not marshal %+v", err.Error())
@@ -1060,28 +1291,43 @@ func (t *HomelendChaincode) putBankInfo(stub shim.ChaincodeStubInterface, args [
 		return shim.Error(str)
 	}
 
+	err = t.registerParticipant(stub, ParticipantBank, identity, data.Name)
+	if err != nil {
+		str := fmt.Sprintf("registerParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	fmt.Println("Sucessfully executed")
 
 	return shim.Success(nil)
 }
 
+//bankPutOffer - args: requestLink, BankOffer JSON
 func (t *HomelendChaincode) bankPutOffer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println(fmt.Sprintf("bankPutOffer executed with args: %+v", args))
 
 	var err error
-	if len(args) != 3 {
+	if len(args) != 2 {
 		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCBankMSP")
+	identity, err := t.getIdentity(stub, RoleLoanOfficer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
+	err = t.requireActiveParticipant(stub, ParticipantBank, identity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	//input validations
 	if len(args[0]) <= 0 {
 		str := fmt.Sprintf("Provide RequestLink for the request")
@@ -1089,12 +1335,7 @@ func (t *HomelendChaincode) bankPutOffer(stub shim.ChaincodeStubInterface, args
 		return shim.Error(str)
 	}
 	if len(args[1]) <= 0 {
-		str := fmt.Sprintf("Provide Bank Offer Hash for the request %+v", args[0])
-		fmt.Println(str)
-		return shim.Error(str)
-	}
-	if len(args[2]) <= 0 {
-		str := fmt.Sprintf("Provide Bank Interest for the request  %+v", args[0])
+		str := fmt.Sprintf("Provide Bank Offer JSON for the request %+v", args[0])
 		fmt.Println(str)
 		return shim.Error(str)
 	}
@@ -1108,31 +1349,53 @@ func (t *HomelendChaincode) bankPutOffer(stub shim.ChaincodeStubInterface, args
 		return shim.Error(str)
 	}
 
-	hash := args[1]
-	interest, err := strconv.ParseFloat(args[2], 32)
+	request, err := t.getRequest(stub, requestLink.UserHash, requestLink.RequestHash)
 	if err != nil {
-		str := fmt.Sprintf("interest args[2] - invalid input %+v", args[0])
+		str := fmt.Sprintf("Failed to getRequest: %s", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	offer := &BankOffer{BankHash: identity, Hash: hash, Interest: float32(interest), Timestamp: time.Now()}
+	offer, err := t.parseBankOffer(stub, args[1], identity, request.Duration, request.LoanAmount)
+	if err != nil {
+		str := fmt.Sprintf("Invalid bank offer: %s", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 
-	request, err := t.getRequest(stub, requestLink.UserHash, requestLink.RequestHash)
+	for _, existing := range request.BankOffers {
+		if existing.Hash == offer.Hash {
+			str := fmt.Sprintf("Bank Offer %s already exists", offer.Hash)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	price, err := t.convert(stub, request.SellingPrice, request.LoanAmount.Currency)
 	if err != nil {
-		str := fmt.Sprintf("Failed to getRequest: %s", err)
+		str := fmt.Sprintf("convert error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	offer.MonthlyPayment, err = t.calcPmt(float64(interest), request.Duration, float64(request.LoanAmount))
+	exceeded, err := offer.ltvExceeded(request.LoanAmount, price)
 	if err != nil {
-		str := fmt.Sprintf("Failed calcPmt: %s", err)
+		str := fmt.Sprintf("ltvExceeded error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if exceeded {
+		str := fmt.Sprintf("loan %s is above %.2f%% of the selling price %s", request.LoanAmount, offer.MaxLTV, price)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	request.Status = "BANK_OFFER_INSTALLED"
+	err = t.transitionRequest(stub, request, StatusBankOfferInstalled)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	request.BankOffers = append(request.BankOffers, *offer)
 	err = t.addOrUpdateRequest(stub, request)
 	if err != nil {
@@ -1148,33 +1411,28 @@ func (t *HomelendChaincode) bankPutOffer(stub shim.ChaincodeStubInterface, args
 func (t *HomelendChaincode) bankPullPending4FinalAppproval(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println(fmt.Sprintf("bankPullPending4FinalAppproval executed with args %+v", args))
 
-	identity, err := t.getIdentity(stub, "POCBankMSP")
+	identity, err := t.getIdentity(stub, RoleLoanOfficer)
 	if err != nil {
 		str := fmt.Sprintf("error getIdentity %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	myPendingByteArray, err := stub.GetState(pending4bankApproval + identity)
+	pageSize, bookmark, err := t.parsePagination(args)
 	if err != nil {
-		str := fmt.Sprintf("faild to getState pendingForAppraiserEstimation + identity %+v", args)
+		str := fmt.Sprintf("parsePagination error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	if len(myPendingByteArray) == 0 {
-		return shim.Success(nil)
-	}
-
-	var myPending []*RequestLink
-	err = json.Unmarshal(myPendingByteArray, &myPending)
+	myPending, nextBookmark, err := t.listQueue(stub, pending4bankApproval+identity, pageSize, bookmark)
 	if err != nil {
-		str := fmt.Sprintf("Failed to Unmarshal myPendingByteArray: %+v", err)
+		str := fmt.Sprintf("faild to listQueue %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	var result []*Request
+	result := make([]*Request, 0)
 	for i := 0; i < len(myPending); i++ {
 		userHash := myPending[i].UserHash
 		requestHash := myPending[i].RequestHash
@@ -1188,18 +1446,7 @@ func (t *HomelendChaincode) bankPullPending4FinalAppproval(stub shim.ChaincodeSt
 		result = append(result, request)
 	}
 
-	if len(result) == 0 {
-		return shim.Success(nil)
-	}
-
-	dataJSONasBytes, err := json.Marshal(result)
-	if err != nil {
-		str := fmt.Sprintf("Could not marshal result %+v", err.Error())
-		fmt.Println(str)
-		return shim.Error(str)
-	}
-
-	return shim.Success(dataJSONasBytes)
+	return t.pullResponse(result, nextBookmark)
 }
 
 func (t *HomelendChaincode) bankApprove(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -1212,13 +1459,20 @@ func (t *HomelendChaincode) bankApprove(stub shim.ChaincodeStubInterface, args [
 		return shim.Error(str)
 	}
 
-	bankIdentity, err := t.getIdentity(stub, "POCBankMSP")
+	bankIdentity, err := t.getIdentity(stub, RoleLoanOfficer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
+	err = t.requireActiveParticipant(stub, ParticipantBank, bankIdentity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	requestLinkStr := args[0]
 	requestLink := &RequestLink{}
 	err = json.Unmarshal([]byte(requestLinkStr), requestLink)
@@ -1235,7 +1489,21 @@ func (t *HomelendChaincode) bankApprove(stub shim.ChaincodeStubInterface, args [
 		return shim.Error(str)
 	}
 
-	validations, err := t.bankValidateBeforeApprove(request, bankIdentity)
+	// approve and decline are both reachable from the same status, so checking one is enough
+	err = checkTransition(request, StatusApprovedByBank)
+	if err != nil {
+		str := fmt.Sprintf("checkTransition error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if !request.DownPaymentPaid {
+		str := fmt.Sprintf("the down payment of request %s is not in escrow", request.Hash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	validations, err := t.bankValidateBeforeApprove(stub, request, bankIdentity)
 	if err != nil {
 		str := fmt.Sprintf("error in bankValidateBeforeApprove: %s", err)
 		fmt.Println(str)
@@ -1243,30 +1511,92 @@ func (t *HomelendChaincode) bankApprove(stub shim.ChaincodeStubInterface, args [
 	}
 
 	if len(validations) != 0 {
-		request.Status = "REQUEST_DECLINED_BY_BANK"
-		request.DeclineInfo = validations
-		err = t.addOrUpdateRequest(stub, request)
+		err = t.transitionRequest(stub, request, StatusDeclinedByBank)
 		if err != nil {
+			str := fmt.Sprintf("transitionRequest error %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+
+		if request.DownPayment.IsPositive() {
+			err = t.postJournalEntry(stub, "down payment refund", request.Hash, transferLines(escrowAccount(request.Hash), request.BuyerHash, request.DownPayment))
 			if err != nil {
-				str := fmt.Sprintf("saddOrUpdateRequest - unapproved %s", err)
+				str := fmt.Sprintf("postJournalEntry error %s", err)
 				fmt.Println(str)
 				return shim.Error(str)
 			}
 		}
+		request.DeclineInfo = validations
+		err = t.addOrUpdateRequest(stub, request)
+		if err != nil {
+			str := fmt.Sprintf("saddOrUpdateRequest - unapproved %s", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+
+		// a declined request never reaches bankRunChaincode, it leaves the queue of the bank here
+		err = t.dequeueRequest(stub, pending4bankApproval+bankIdentity, &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash})
+		if err != nil {
+			str := fmt.Sprintf("dequeueRequest %s", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
 
 		return shim.Success(nil)
 	}
 
-	escrowAccountKey := money + "escrow_" + request.Hash
-	err = stub.PutState(escrowAccountKey, []byte(strconv.Itoa(request.LoanAmount)))
+	// the fees of the offer are due at closing, the buyer holds them in escrow from now on
+	offer := selectedBankOffer(request)
+	if offer != nil && offer.Fees.IsPositive() {
+		balance, err := t.getBalance(stub, request.BuyerHash, offer.Fees.Currency)
+		if err != nil {
+			str := fmt.Sprintf("Could not getBalance %+v", err.Error())
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		cmp, err := balance.Cmp(offer.Fees)
+		if err != nil {
+			str := fmt.Sprintf("Could not compare the fees %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		if cmp < 0 {
+			str := fmt.Sprintf("the buyer holds %s, the fees of offer %s are %s", balance, offer.Hash, offer.Fees)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	err = t.transitionRequest(stub, request, StatusApprovedByBank)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// the bank funds the escrow of the request, it fails when the bank did not deposit enough.
+	// A loan in another currency buys the rest of the price, the bank bears the change of the rate since the request.
+	lines := transferLines(bankAccount(bankIdentity), escrowAccount(request.Hash), request.LoanAmount)
+	if !request.SellingPrice.SameCurrency(request.LoanAmount) {
+		financed, err := request.SellingPrice.Sub(request.DownPayment)
+		if err != nil {
+			str := fmt.Sprintf("Could not subtract the down payment %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		lines = exchangeLines(bankAccount(bankIdentity), request.LoanAmount, escrowAccount(request.Hash), financed)
+	}
+	if offer != nil && offer.Fees.IsPositive() {
+		lines = append(lines, transferLines(request.BuyerHash, escrowAccount(request.Hash), offer.Fees)...)
+	}
+	err = t.postJournalEntry(stub, "escrow funding", request.Hash, lines)
 	if err != nil {
-		str := fmt.Sprintf("PutState %s", err)
+		str := fmt.Sprintf("postJournalEntry error %s", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
 	request.LoanAmountLeftToRefund = request.LoanAmount
-	request.Status = "REQUEST_APPROVED_BY_BANK"
 
 	err = t.addOrUpdateRequest(stub, request)
 	if err != nil {
@@ -1275,27 +1605,6 @@ func (t *HomelendChaincode) bankApprove(stub shim.ChaincodeStubInterface, args [
 		return shim.Error(str)
 	}
 
-	// bankHash, err := t.getBankHash(request)
-	// if err != nil {
-	// 	str := fmt.Sprintf("getBankHash %s", err)
-	// 	fmt.Println(str)
-	// 	return shim.Error(str)
-	// }
-
-	// err = t.removeFromRequestArray(stub, pending4bankApproval+bankHash, requestLink)
-	// if err != nil {
-	// 	str := fmt.Sprintf("removeFromRequestArray %s", err)
-	// 	fmt.Println(str)
-	// 	return shim.Error(str)
-	// }
-
-	// err = t.addRequestToArray(stub, pending4ChaincodeExecute+bankHash, requestLink)
-	// if err != nil {
-	// 	str := fmt.Sprintf("addRequestToArray %s", err)
-	// 	fmt.Println(str)
-	// 	return shim.Error(str)
-	// }
-
 	fmt.Println("bankApprove -> Successfully updated")
 	return shim.Success(nil)
 }
@@ -1310,13 +1619,20 @@ func (t *HomelendChaincode) bankRunChaincode(stub shim.ChaincodeStubInterface, a
 		return shim.Error(str)
 	}
 
-	bankIdentity, err := t.getIdentity(stub, "POCBankMSP")
+	bankIdentity, err := t.getIdentity(stub, RoleLoanOfficer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
+	err = t.requireActiveParticipant(stub, ParticipantBank, bankIdentity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	requestLinkStr := args[0]
 	requestLink := &RequestLink{}
 	err = json.Unmarshal([]byte(requestLinkStr), &requestLink)
@@ -1340,49 +1656,93 @@ func (t *HomelendChaincode) bankRunChaincode(stub shim.ChaincodeStubInterface, a
 		return shim.Error(str)
 	}
 
-	property, err := t.getPropertyAndRemove(stub, request.SellerHash, request.PropertyHash)
+	err = t.transitionRequest(stub, request, StatusCompletedActiveMortgage)
 	if err != nil {
-		str := fmt.Sprintf("Failed to getPropertyAndRemove: %s", err)
+		str := fmt.Sprintf("transitionRequest error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	dataAsBytes, err := stub.GetState(request.BuyerHash)
+	err = t.transferProperty(stub, request.SellerHash, request.BuyerHash, request.PropertyHash)
 	if err != nil {
-		str := fmt.Sprintf("Failed to get Property from user: %s", request.BuyerHash)
+		str := fmt.Sprintf("Failed to transferProperty: %s", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	var buyerPropertylist []*Property
-	if len(dataAsBytes) > 0 {
-		err = json.Unmarshal(dataAsBytes, &buyerPropertylist)
-		if err != nil {
-			str := fmt.Sprintf("Could not Unmarshal %+v", err.Error())
-			return shim.Error(str)
-		}
+	// requests created before down payments have no SellingPrice, the loan was the whole price
+	price := request.SellingPrice
+	if price.IsZero() {
+		price = request.LoanAmount
 	}
 
-	buyerPropertylist = append(buyerPropertylist, property)
-	buyerPropertylistBytes, err := json.Marshal(buyerPropertylist)
+	escrowBalance, err := t.getBalance(stub, escrowAccount(request.Hash), price.Currency)
 	if err != nil {
-		str := fmt.Sprintf("Could not Marshal %+v", err.Error())
+		str := fmt.Sprintf("Could not getBalance %+v", err.Error())
+		return shim.Error(str)
+	}
+	cmp, err := escrowBalance.Cmp(price)
+	if err != nil {
+		str := fmt.Sprintf("Could not compare the escrow %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if cmp < 0 {
+		str := fmt.Sprintf("escrow of request %s holds %s, the price is %s", request.Hash, escrowBalance, price)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// the fees of the offer were held in escrow at approval, they go to the bank in the same entry
+	lines := transferLines(escrowAccount(request.Hash), request.SellerHash, price)
+	if offer := selectedBankOffer(request); offer != nil && offer.Fees.IsPositive() {
+		lines = append(lines, transferLines(escrowAccount(request.Hash), bankAccount(offer.BankHash), offer.Fees)...)
+	}
+
+	err = t.postJournalEntry(stub, "escrow release to seller", request.Hash, lines)
+	if err != nil {
+		str := fmt.Sprintf("Could not postJournalEntry %+v", err.Error())
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	startDate, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage, err := t.newMortgage(request, startDate)
+	if err != nil {
+		str := fmt.Sprintf("Could not create the mortgage %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// the land registry records the sale and the lien of the bank with the closing
+	change := lib.TitleChange{SellerHash: request.SellerHash, BuyerHash: request.BuyerHash, Lien: mortgageLien(mortgage)}
+	err = t.settleTitle(stub, mortgage, change)
+	if err != nil {
+		str := fmt.Sprintf("Could not register the title change %+v", err)
+		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	err = stub.PutState(request.BuyerHash, buyerPropertylistBytes)
+	// the selected insurance offer becomes the policy of the mortgage, its premiums fall due with the installments
+	err = t.issueMortgagePolicy(stub, request, mortgage)
 	if err != nil {
-		str := fmt.Sprintf("Could not PutState %+v", err.Error())
+		str := fmt.Sprintf("Could not issue the insurance policy %+v", err)
+		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	err = t.moveMoney(stub, "escrow_"+request.Hash, request.SellerHash, request.LoanAmount)
+	err = t.putMortgage(stub, mortgage)
 	if err != nil {
-		str := fmt.Sprintf("Could not moveMoney %+v", err.Error())
+		str := fmt.Sprintf("Could not putMortgage %+v", err.Error())
 		return shim.Error(str)
 	}
 
-	request.Status = "REQUEST_COMPLETED-ACTIVE-MORTGAGE"
 	err = t.addOrUpdateRequest(stub, request)
 	if err != nil {
 		str := fmt.Sprintf("Could not addOrUpdateRequest %+v", err.Error())
@@ -1396,9 +1756,9 @@ func (t *HomelendChaincode) bankRunChaincode(stub shim.ChaincodeStubInterface, a
 		return shim.Error(str)
 	}
 
-	err = t.removeFromRequestArray(stub, pending4bankApproval+bankHash, requestLink)
+	err = t.dequeueRequest(stub, pending4bankApproval+bankHash, requestLink)
 	if err != nil {
-		str := fmt.Sprintf("removeFromRequestArray %s", err)
+		str := fmt.Sprintf("dequeueRequest %s", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
@@ -1424,7 +1784,7 @@ func (t *HomelendChaincode) putCreditRatingAgencyInfo(stub shim.ChaincodeStubInt
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCCreditRatingAgencyMSP")
+	identity, err := t.getIdentity(stub, RoleCreditAgency)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", args)
 		fmt.Println(str)
@@ -1439,7 +1799,13 @@ func (t *HomelendChaincode) putCreditRatingAgencyInfo(stub shim.ChaincodeStubInt
 		return shim.Error(str)
 	}
 
-	data.Timestamp = time.Now()
+	helpers := lib.Helpers{}
+	data.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
 		str := fmt.Sprintf("Could not marshal %+v", err.Error())
@@ -1454,6 +1820,13 @@ func (t *HomelendChaincode) putCreditRatingAgencyInfo(stub shim.ChaincodeStubInt
 		return shim.Error(str)
 	}
 
+	err = t.registerParticipant(stub, ParticipantCreditAgency, identity, data.Name)
+	if err != nil {
+		str := fmt.Sprintf("registerParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	fmt.Println("Sucessfully executed")
 
 	return shim.Success(nil)
@@ -1477,13 +1850,20 @@ func (t *HomelendChaincode) calcCreditScore(stub shim.ChaincodeStubInterface, ar
 
 	requestLinkStr := args[0]
 
-	identity, err := t.getIdentity(stub, "POCCreditRatingAgencyMSP")
+	identity, err := t.getIdentity(stub, RoleCreditAgency)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
+	err = t.requireActiveParticipant(stub, ParticipantCreditAgency, identity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	requestLink := &RequestLink{}
 	err = json.Unmarshal([]byte(requestLinkStr), requestLink)
 	if err != nil {
@@ -1505,27 +1885,65 @@ func (t *HomelendChaincode) calcCreditScore(stub shim.ChaincodeStubInterface, ar
 		return shim.Error(str)
 	}
 
-	// bargs := make([][]byte, 2)
-	// bargs[0] = []byte(strconv.Itoa(request.Salary))
-	// bargs[1] = []byte(strconv.Itoa(request.LoanAmount))
+	err = checkTransition(request, StatusCreditScoreInstalled)
+	if err != nil {
+		str := fmt.Sprintf("checkTransition error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 
-	// if err != nil {
-	// 	str := fmt.Sprintf("Could not marshal array %+v", err.Error())
-	// 	fmt.Println(str)
-	// 	return shim.Error(str)
-	// }
-	// resp := stub.InvokeChaincode("creditscore_chaincode", bargs, "mainchannel")
-	// if resp.Status != 200 {
-	// 	fmt.Println("Could InvokeChaincode creditscore_chaincode")
-	// 	return resp
-	// }
+	for _, agencyScore := range request.CreditScores {
+		if agencyScore.AgencyHash == identity {
+			str := fmt.Sprintf("%s already scored request %s", identity, request.Hash)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	policy, err := t.getCreditScorePolicy(stub)
+	if err != nil {
+		str := fmt.Sprintf("getCreditScorePolicy error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	score, err := t.scoreRequest(stub, request, identity)
+	if err != nil {
+		str := fmt.Sprintf("Could not score the request %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	request.CreditScores = append(request.CreditScores, AgencyCreditScore{AgencyHash: identity, Details: score, Timestamp: timestamp})
 
-	// strResult := string(resp.Payload)
-	strResult, _ := t.getCreditRankScore(stub, request.Salary, request.LoanAmount)
+	// the request waits for the scores of more agencies
+	if len(request.CreditScores) < policy.RequiredScores {
+		err = t.addOrUpdateRequest(stub, request)
+		if err != nil {
+			str := fmt.Sprintf("Could not updateRequest %+v", err.Error())
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		return shim.Success(nil)
+	}
 
-	request.CreditScore = strResult
-	request.CreditScoreIdentity = identity
-	request.Status = "REQUEST_CREDIT_SCORE_INSTALLED"
+	aggregate := aggregateCreditScores(request.CreditScores, policy.Aggregation)
+	request.CreditScore = aggregate.Details.Grade
+	request.CreditScoreDetails = aggregate.Details
+	request.CreditScoreIdentity = aggregate.AgencyHash
+	err = t.transitionRequest(stub, request, StatusCreditScoreInstalled)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	err = t.addOrUpdateRequest(stub, request)
 	if err != nil {
 		str := fmt.Sprintf("Could not updateRequest %+v", err.Error())
@@ -1534,16 +1952,16 @@ func (t *HomelendChaincode) calcCreditScore(stub shim.ChaincodeStubInterface, ar
 	}
 
 	rl := &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash}
-	err = t.removeFromRequestArray(stub, creditRankOpenRequests, rl)
+	err = t.dequeueRequest(stub, creditRankOpenRequests, rl)
 	if err != nil {
-		str := fmt.Sprintf("Could not removeFromRequestArray %+v", err.Error())
+		str := fmt.Sprintf("Could not dequeueRequest %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	err = t.addRequestToArray(stub, open4bankOffers, rl)
+	err = t.enqueueRequest(stub, open4bankOffers, rl)
 	if err != nil {
-		str := fmt.Sprintf("Could not addRequestToArray open4bankOffers %+v", err.Error())
+		str := fmt.Sprintf("Could not enqueueRequest open4bankOffers %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
@@ -1568,7 +1986,7 @@ func (t *HomelendChaincode) putBuyerPersonalInfo(stub shim.ChaincodeStubInterfac
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCBuyerMSP")
+	identity, err := t.getIdentity(stub, RoleBuyer)
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", args)
 		fmt.Println(str)
@@ -1583,7 +2001,13 @@ func (t *HomelendChaincode) putBuyerPersonalInfo(stub shim.ChaincodeStubInterfac
 		return shim.Error(str)
 	}
 
-	data.Timestamp = time.Now()
+	helpers := lib.Helpers{}
+	data.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	dataJSONasBytes, err := json.Marshal(data)
 	if err != nil {
 		str := fmt.Sprintf("Could not marshal %+v", err.Error())
@@ -1618,34 +2042,60 @@ func (t *HomelendChaincode) buy(stub shim.ChaincodeStubInterface, args []string)
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCBuyerMSP")
+	identity, err := t.getIdentity(stub, RoleBuyer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
+	// a plain LoanAmount or Salary is an amount of the Currency of the loan
+	requestAsBytes, currency, err := denominate(args[0], lib.DefaultCurrency, "LoanAmount", "Salary")
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	data := &Request{}
-	err = json.Unmarshal([]byte(args[0]), data)
+	err = json.Unmarshal(requestAsBytes, data)
 	if err != nil {
 		str := fmt.Sprintf("Failed to parse JSON: %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
+	data.Currency = currency
+	loanAmount, err := data.LoanAmount.Add(lib.NewMoney(0, currency))
+	if err != nil {
+		str := fmt.Sprintf("LoanAmount %s is not in the currency of the loan %s", data.LoanAmount, currency)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	data.LoanAmount = loanAmount
 
-	data.Status = "REQUEST_INITIALIZED"
-	data.BuyerHash = identity
-	data.Timestamp = time.Now()
+	existing, _ := t.getRequest(stub, identity, data.Hash)
+	if existing != nil {
+		str := fmt.Sprintf("Request already exists %s", data.Hash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 
-	fmt.Println(fmt.Printf("Getting state for %+s", identity))
-	err = t.addOrUpdateRequest(stub, data)
+	data.Status = StatusNone
+	data.StatusHistory = nil
+	err = t.transitionRequest(stub, data, StatusInitialized)
 	if err != nil {
-		str := fmt.Sprintf("Failed to parse JSON: %+v", err.Error())
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	data.BuyerHash = identity
+	helpers := lib.Helpers{}
+	data.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
-	rl := &RequestLink{UserHash: identity, RequestHash: data.Hash}
-	t.addRequestToArray(stub, creditRankOpenRequests, rl)
 
 	//check if the property exists and remove from array
 	dataAsBytes, err := stub.GetState(properties4sale)
@@ -1677,6 +2127,28 @@ func (t *HomelendChaincode) buy(stub shim.ChaincodeStubInterface, args []string)
 		return shim.Error(str)
 	}
 
+	// the buyer locks the rest of the price in escrow before the bank approves, see buyerPayDownPayment.
+	// A loan in another currency covers the price at the rate of the day of the request.
+	data.SellingPrice = properties4saleArray[proptyIndex].SellingPrice
+	financed, err := t.convert(stub, data.LoanAmount, data.SellingPrice.Currency)
+	if err != nil {
+		str := fmt.Sprintf("convert error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	data.DownPayment, err = data.SellingPrice.Sub(financed)
+	if err != nil {
+		str := fmt.Sprintf("Could not subtract the loan %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	data.DownPaymentPaid = false
+	if data.DownPayment.IsNegative() {
+		str := fmt.Sprintf("LoanAmount %s is higher than the SellingPrice %s", data.LoanAmount, data.SellingPrice)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	properties4saleArray = append(properties4saleArray[:proptyIndex], properties4saleArray[proptyIndex+1:]...)
 
 	dataAsBytes, err = json.Marshal(properties4saleArray)
@@ -1687,11 +2159,25 @@ func (t *HomelendChaincode) buy(stub shim.ChaincodeStubInterface, args []string)
 	}
 	stub.PutState(properties4sale, dataAsBytes)
 
+	err = t.addOrUpdateRequest(stub, data)
+	if err != nil {
+		str := fmt.Sprintf("Failed to addOrUpdateRequest: %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	rl := &RequestLink{UserHash: identity, RequestHash: data.Hash}
+	err = t.enqueueRequest(stub, creditRankOpenRequests, rl)
+	if err != nil {
+		str := fmt.Sprintf("Could not enqueueRequest %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	return shim.Success([]byte(identity))
 }
 
 func (t *HomelendChaincode) getProperties(stub shim.ChaincodeStubInterface) pb.Response {
-	identity, err := t.getIdentity(stub, "POCBuyerMSP")
+	identity, err := t.getIdentity(stub, "")
 
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", err)
@@ -1699,14 +2185,51 @@ func (t *HomelendChaincode) getProperties(stub shim.ChaincodeStubInterface) pb.R
 		return shim.Error(str)
 	}
 
-	valAsBytes, err := stub.GetState(identity)
+	list, err := t.getPropertiesByOwner(stub, identity)
+	if err != nil {
+		str := fmt.Sprintf("getPropertiesByOwner error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if len(list) == 0 {
+		return shim.Success(nil)
+	}
 
+	valAsBytes, err := json.Marshal(list)
 	if err != nil {
-		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		str := fmt.Sprintf("Could not marshal properties %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
-	} else if valAsBytes == nil {
-		str := fmt.Sprintf("Record does not exist %s", identity)
+	}
+
+	fmt.Println("Successfully got")
+	return shim.Success(valAsBytes)
+}
+
+func (t *HomelendChaincode) buyerGetMyRequests(stub shim.ChaincodeStubInterface) pb.Response {
+	identity, err := t.getIdentity(stub, RoleBuyer)
+
+	if err != nil {
+		str := fmt.Sprintf("MSPID error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	list, err := t.getRequestsByBuyer(stub, identity)
+	if err != nil {
+		str := fmt.Sprintf("getRequestsByBuyer error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if len(list) == 0 {
+		return shim.Success(nil)
+	}
+
+	valAsBytes, err := json.Marshal(list)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal requests %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
@@ -1716,7 +2239,7 @@ func (t *HomelendChaincode) getProperties(stub shim.ChaincodeStubInterface) pb.R
 }
 
 func (t *HomelendChaincode) buyerSelectBankOffer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	identity, err := t.getIdentity(stub, "POCBuyerMSP")
+	identity, err := t.getIdentity(stub, RoleBuyer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", err)
 		fmt.Println(str)
@@ -1745,42 +2268,65 @@ func (t *HomelendChaincode) buyerSelectBankOffer(stub shim.ChaincodeStubInterfac
 		return shim.Error(str)
 	}
 
-	found := false
+	var selected *BankOffer
 
 	for i := 0; i < len(request.BankOffers); i++ {
 		if request.BankOffers[i].Hash == selectedBankOfferHash {
-			found = true
+			selected = &request.BankOffers[i]
 		}
 	}
 
-	if !found {
+	if selected == nil {
 		str := fmt.Sprintf("Bank Offer was not found %+v", selectedBankOfferHash)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	request.Status = "BUYER_SELECTED_BANK_OFFER"
-	request.SelectedBankOfferHash = selectedBankOfferHash
-	t.addOrUpdateRequest(stub, request)
-
-	rl := &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash}
-	err = t.removeFromRequestArray(stub, open4bankOffers, rl)
+	helpers := lib.Helpers{}
+	now, err := helpers.GetTxTime(stub)
 	if err != nil {
-		str := fmt.Sprintf("Could not removeFromRequestArray %+v", err.Error())
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if selected.expired(now) {
+		str := fmt.Sprintf("Bank Offer %s expired at %s", selectedBankOfferHash, selected.ExpiresAt)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	err = t.addRequestToArray(stub, selectAppraiser, rl)
+	err = t.transitionRequest(stub, request, StatusBuyerSelectedBankOffer)
 	if err != nil {
-		str := fmt.Sprintf("Could not addRequestToArray open4bankOffers %+v", err.Error())
+		str := fmt.Sprintf("transitionRequest error %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
-	return shim.Success(nil)
-}
-
-func (t *HomelendChaincode) buyerSelectAppraiser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	request.SelectedBankOfferHash = selectedBankOfferHash
+	err = t.addOrUpdateRequest(stub, request)
+	if err != nil {
+		str := fmt.Sprintf("Could not addOrUpdateRequest %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	rl := &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash}
+	err = t.dequeueRequest(stub, open4bankOffers, rl)
+	if err != nil {
+		str := fmt.Sprintf("Could not dequeueRequest %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.enqueueRequest(stub, selectAppraiser, rl)
+	if err != nil {
+		str := fmt.Sprintf("Could not enqueueRequest selectAppraiser %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return shim.Success(nil)
+}
+
+func (t *HomelendChaincode) buyerSelectAppraiser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println(fmt.Sprintf("buyerSelectAppraiser executed with args %+v", args))
 
 	if len(args) != 2 {
@@ -1789,7 +2335,7 @@ func (t *HomelendChaincode) buyerSelectAppraiser(stub shim.ChaincodeStubInterfac
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCBuyerMSP")
+	identity, err := t.getIdentity(stub, RoleBuyer)
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", err)
 		fmt.Println(str)
@@ -1806,22 +2352,38 @@ func (t *HomelendChaincode) buyerSelectAppraiser(stub shim.ChaincodeStubInterfac
 		return shim.Error(str)
 	}
 
-	//TODO: check if exists in appraiser list
+	err = t.requireActiveParticipant(stub, ParticipantAppraiser, appraiserHash)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
 	request.AppraiserHash = appraiserHash
-	request.Status = "REQUEST_APPRAISER_CHOSEN"
-	t.addOrUpdateRequest(stub, request)
+	err = t.transitionRequest(stub, request, StatusAppraiserChosen)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	err = t.addOrUpdateRequest(stub, request)
+	if err != nil {
+		str := fmt.Sprintf("Could not addOrUpdateRequest %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 
 	rl := &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash}
-	err = t.removeFromRequestArray(stub, selectAppraiser, rl)
+	err = t.dequeueRequest(stub, selectAppraiser, rl)
 	if err != nil {
-		str := fmt.Sprintf("Could not removeFromRequestArray %+v", err.Error())
+		str := fmt.Sprintf("Could not dequeueRequest %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
 
-	err = t.addRequestToArray(stub, pendingForAppraiserEstimation+appraiserHash, rl)
+	err = t.enqueueRequest(stub, pendingForAppraiserEstimation+appraiserHash, rl)
 	if err != nil {
-		str := fmt.Sprintf("Could not addRequestToArray pendingForAppraiserEstimation %+v", err.Error())
+		str := fmt.Sprintf("Could not enqueueRequest pendingForAppraiserEstimation %+v", err.Error())
 		fmt.Println(str)
 		return shim.Error(str)
 	}
@@ -1839,7 +2401,7 @@ func (t *HomelendChaincode) buyerSelectInsuranceOffer(stub shim.ChaincodeStubInt
 		return shim.Error(str)
 	}
 
-	identity, err := t.getIdentity(stub, "POCBuyerMSP")
+	identity, err := t.getIdentity(stub, RoleBuyer)
 	if err != nil {
 		str := fmt.Sprintf("getIdentity error %+v", err)
 		fmt.Println(str)
@@ -1867,7 +2429,12 @@ func (t *HomelendChaincode) buyerSelectInsuranceOffer(stub shim.ChaincodeStubInt
 		return shim.Error("offer was not found")
 	}
 
-	request.Status = "INSURANCE_OFFER_SELECTED"
+	err = t.transitionRequest(stub, request, StatusInsuranceOfferSelected)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
 	request.SelectedInsuranceOfferHash = offerHash
 	err = t.addOrUpdateRequest(stub, request)
 	if err != nil {
@@ -1877,18 +2444,77 @@ func (t *HomelendChaincode) buyerSelectInsuranceOffer(stub shim.ChaincodeStubInt
 	}
 
 	rl := &RequestLink{UserHash: request.BuyerHash, RequestHash: request.Hash}
-	err = t.addRequestToArray(stub, pending4Government, rl)
+	err = t.enqueueRequest(stub, pending4Government, rl)
+	if err != nil {
+		str := fmt.Sprintf("error: enqueueRequest:pending4Government  %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	err = t.dequeueRequest(stub, open4InsuranceOffers, rl)
 	if err != nil {
-		str := fmt.Sprintf("error: addRequestToArray:pending4Government  %+v", err)
+		str := fmt.Sprintf("error: dequeueRequest:open4InsuranceOffers  %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return shim.Success(nil)
+}
+
+//buyerPayDownPayment - the buyer locks the part of the SellingPrice the loan does not cover in the escrow of the request, args: requestHash
+func (t *HomelendChaincode) buyerPayDownPayment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("buyerPayDownPayment executed with args: %+v", args))
+
+	var err error
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleBuyer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request, err := t.getRequest(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getRequest:  %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if request.DownPaymentPaid {
+		str := fmt.Sprintf("the down payment of request %s was already paid", request.Hash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	switch request.Status {
+	case StatusApprovedByBank, StatusDeclinedByBank, StatusCompletedActiveMortgage, StatusMortgagePaidOff, StatusForeclosed:
+		str := fmt.Sprintf("can not pay the down payment of a request in status %s", request.Status)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
-	err = t.removeFromRequestArray(stub, open4InsuranceOffers, rl)
+
+	if request.DownPayment.IsPositive() {
+		err = t.postJournalEntry(stub, "down payment", request.Hash, transferLines(identity, escrowAccount(request.Hash), request.DownPayment))
+		if err != nil {
+			str := fmt.Sprintf("postJournalEntry error %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	request.DownPaymentPaid = true
+	err = t.addOrUpdateRequest(stub, request)
 	if err != nil {
-		str := fmt.Sprintf("error: removeFromRequestArray:open4InsuranceOffers  %+v", err)
+		str := fmt.Sprintf("addOrUpdateRequest:  %+v", err)
 		fmt.Println(str)
 		return shim.Error(str)
 	}
+
+	fmt.Println("buyerPayDownPayment Sucessfully executed")
 	return shim.Success(nil)
 }
 
@@ -1896,7 +2522,7 @@ func (t *HomelendChaincode) buyerSelectInsuranceOffer(stub shim.ChaincodeStubInt
 func (t *HomelendChaincode) buyerUploadDocuments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println(fmt.Sprintf("confirmCreditScore executed with args"))
 
-	mspid, err := cid.GetMSPID(stub)
+	mspid, err := t.identities().GetMSPID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", err)
@@ -1904,7 +2530,7 @@ func (t *HomelendChaincode) buyerUploadDocuments(stub shim.ChaincodeStubInterfac
 		return shim.Error(str)
 	}
 
-	identity, err := cid.GetID(stub)
+	identity, err := t.identities().GetID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("MSPID error %+v", err)
@@ -2014,118 +2640,13 @@ func main() {
 }
 
 //helper
-func (t *HomelendChaincode) addRequestToArray(stub shim.ChaincodeStubInterface, key string, data *RequestLink) error {
-	dataAsBytes, err := stub.GetState(key)
-	if err != nil {
-		return err
-	}
-
-	if dataAsBytes == nil {
-		var arrayOfData []*RequestLink
-		arrayOfData = append(arrayOfData, data)
-
-		dataJSONasBytes, err := json.Marshal(arrayOfData)
-		if err != nil {
-			str := fmt.Sprintf("Could not marshal %+v", err.Error())
-			return errors.New(str)
-		}
-
-		err = stub.PutState(key, dataJSONasBytes)
-		if err != nil {
-			str := fmt.Sprintf("Could not put state %+v", err.Error())
-			return errors.New(str)
-		}
-
-	} else {
-		fmt.Println("Already has requests. Appending one")
-		var arrayOfData []*RequestLink
-		err = json.Unmarshal(dataAsBytes, &arrayOfData)
-
-		if err != nil {
-			str := fmt.Sprintf("Failed to unmarshal: %s", err)
-			return errors.New(str)
-		}
-
-		for i := 0; i < len(arrayOfData); i++ {
-			if arrayOfData[i].UserHash == data.UserHash && arrayOfData[i].RequestHash == data.RequestHash {
-				str := fmt.Sprintf("item already exists in array: %s : %s", key, err)
-				fmt.Println(str)
-				return errors.New(str)
-			}
-		}
-
-		arrayOfData = append(arrayOfData, data)
-		arrayOfDataAsBytes, err := json.Marshal(arrayOfData)
-		if err != nil {
-			str := fmt.Sprintf("Could not Marshal %+v", err.Error())
-			return errors.New(str)
-		}
-
-		err = stub.PutState(key, arrayOfDataAsBytes)
-		if err != nil {
-			str := fmt.Sprintf("Could not put state %+v", err.Error())
-			return errors.New(str)
-		}
-	}
-
-	return nil
-}
-
-func (t *HomelendChaincode) removeFromRequestArray(stub shim.ChaincodeStubInterface, arrayName string, data *RequestLink) error {
-
-	dataAsBytes, err := stub.GetState(arrayName)
-	if err != nil {
-		return err
-	}
-	if dataAsBytes == nil {
-		return errors.New("removeFromRequestArray -> src is empty")
-	}
-
-	var arrayOfData []*RequestLink
-	err = json.Unmarshal(dataAsBytes, &arrayOfData)
-
-	if err != nil {
-		str := fmt.Sprintf("Failed to unmarshal: %s", err)
-		return errors.New(str)
-	}
-
-	found := false
-	for i := 0; len(arrayOfData) > i; i++ {
-		if data.RequestHash == arrayOfData[i].RequestHash {
-			arrayOfData = append(arrayOfData[:i], arrayOfData[i+1:]...)
-			found = true
-		}
-	}
-
-	if !found {
-		return errors.New("RequestLink was not found in array " + arrayName)
-	}
-
-	arrayOfDataAsBytes, err := json.Marshal(arrayOfData)
-	if err != nil {
-		str := fmt.Sprintf("Failed to marshal: %s", err)
-		return errors.New(str)
-	}
-
-	err = stub.PutState(arrayName, arrayOfDataAsBytes)
-	if err != nil {
-		str := fmt.Sprintf("Could not put state %+v", err.Error())
-		return errors.New(str)
+//getIdentity - returns the caller identity, when a role is given the caller must hold it (see authorize)
+func (t *HomelendChaincode) getIdentity(stub shim.ChaincodeStubInterface, role Role) (string, error) {
+	if role != "" {
+		return t.authorize(stub, []Role{role})
 	}
-	return nil
-}
 
-func (t *HomelendChaincode) getIdentity(stub shim.ChaincodeStubInterface, mspidValue string) (string, error) {
-
-	mspid, err := cid.GetMSPID(stub)
-
-	if err != nil {
-		str := fmt.Sprintf("MSPID error %+v", err)
-		fmt.Println(str)
-		return "", errors.New(str)
-	}
-
-	identity, err := cid.GetID(stub)
+	identity, err := t.identities().GetID(stub)
 
 	if err != nil {
 		str := fmt.Sprintf("GetID error %+v", err)
@@ -2133,101 +2654,97 @@ func (t *HomelendChaincode) getIdentity(stub shim.ChaincodeStubInterface, mspidV
 		return "", errors.New(str)
 	}
 
-	if mspidValue != "" && mspid != mspidValue {
-		str := fmt.Sprintf("Only "+mspidValue+" Node can execute this method not "+mspid+" error %+v", mspid)
-		fmt.Println(str)
-		return "", errors.New(str)
-	}
-
 	return identity, nil
 }
 
 func (t *HomelendChaincode) getRequest(stub shim.ChaincodeStubInterface, userHash string, requestHash string) (*Request, error) {
 
-	key := requests + userHash
-	dataAsBytes, err := stub.GetState(key)
+	key, err := stub.CreateCompositeKey(requestIndex, []string{userHash, requestHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		fmt.Println(str)
+		return nil, errors.New(str)
+	}
 
+	dataAsBytes, err := stub.GetState(key)
 	if err != nil {
 		str := fmt.Sprintf("Failed to get state %+v", err.Error())
 		fmt.Println(str)
 		return nil, errors.New(str)
 	} else if dataAsBytes == nil {
-		str := fmt.Sprintf("Record does not exist - empty array %s", key)
+		str := fmt.Sprintf("Request does not exist %s %s", userHash, requestHash)
 		fmt.Println(str)
 		return nil, errors.New(str)
 	}
 
-	var arrayOfData []*Request
-	err = json.Unmarshal(dataAsBytes, &arrayOfData)
-
+	request := &Request{}
+	err = json.Unmarshal(dataAsBytes, request)
 	if err != nil {
 		str := fmt.Sprintf("Failed to unmarshal: %s", err)
 		return nil, errors.New(str)
 	}
 
-	for i := 0; len(arrayOfData) > i; i++ {
-		if arrayOfData[i].Hash == requestHash {
-			return arrayOfData[i], nil
-		}
-	}
-
-	return nil, errors.New("Not found")
+	return request, nil
 }
 
-func (t *HomelendChaincode) addOrUpdateRequest(stub shim.ChaincodeStubInterface, request *Request) error {
-
-	key := requests + request.BuyerHash
-	dataAsBytes, err := stub.GetState(key)
+func (t *HomelendChaincode) getRequestsByBuyer(stub shim.ChaincodeStubInterface, buyerHash string) ([]*Request, error) {
 
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(requestIndex, []string{buyerHash})
 	if err != nil {
-		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		str := fmt.Sprintf("Failed to get requests of %s %+v", buyerHash, err.Error())
 		fmt.Println(str)
-		return errors.New(str)
+		return nil, errors.New(str)
 	}
+	defer resultsIterator.Close()
 
-	var arrayOfData []*Request
-	if dataAsBytes == nil {
-		arrayOfData = make([]*Request, 0)
-	} else {
-		err = json.Unmarshal(dataAsBytes, &arrayOfData)
+	var list []*Request
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			str := fmt.Sprintf("Failed to unmarshal: %s", err)
-			return errors.New(str)
+			return nil, err
 		}
-	}
 
-	found := false
-	for i := 0; len(arrayOfData) > i; i++ {
-		if arrayOfData[i].Hash == request.Hash {
-			arrayOfData[i] = request
-			found = true
+		request := &Request{}
+		err = json.Unmarshal(queryResponse.Value, request)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", queryResponse.Key, err)
+			return nil, errors.New(str)
 		}
+		list = append(list, request)
 	}
 
-	if !found {
-		arrayOfData = append(arrayOfData, request)
+	return list, nil
+}
+
+func (t *HomelendChaincode) addOrUpdateRequest(stub shim.ChaincodeStubInterface, request *Request) error {
+
+	key, err := stub.CreateCompositeKey(requestIndex, []string{request.BuyerHash, request.Hash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		fmt.Println(str)
+		return errors.New(str)
 	}
 
-	arrayOfDataAsBytes, err := json.Marshal(arrayOfData)
+	dataAsBytes, err := json.Marshal(request)
 	if err != nil {
 		str := fmt.Sprintf("Could not Marshal %+v", err.Error())
 		return errors.New(str)
 	}
 
-	err = stub.PutState(key, arrayOfDataAsBytes)
+	err = stub.PutState(key, dataAsBytes)
 	if err != nil {
 		str := fmt.Sprintf("Could not put state %+v", err.Error())
 		return errors.New(str)
 	}
 
-	return nil
+	return t.emitTransitionEvent(stub, request)
 }
 
-func (t *HomelendChaincode) getArray(stub shim.ChaincodeStubInterface, msp string, arrayName string, addIdentityasSuffix bool) pb.Response {
-	str := fmt.Sprintf("getArray= %s MSP= %s", arrayName, msp)
+func (t *HomelendChaincode) getArray(stub shim.ChaincodeStubInterface, role Role, arrayName string, addIdentityasSuffix bool) pb.Response {
+	str := fmt.Sprintf("getArray= %s role= %s", arrayName, role)
 	fmt.Println(str)
 
-	identity, err := t.getIdentity(stub, msp)
+	identity, err := t.getIdentity(stub, role)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -2255,7 +2772,7 @@ func (t *HomelendChaincode) getRequestForSpecificPlayer(stub shim.ChaincodeStubI
 	str := fmt.Sprintf("getRequestForSpecificPlayer= userHash %s requestHash= %s", userHash, requestHash)
 	fmt.Println(str)
 
-	mspid, err := cid.GetMSPID(stub)
+	mspid, err := t.identities().GetMSPID(stub)
 
 	request, err := t.getRequest(stub, userHash, requestHash)
 	if err != nil {
@@ -2278,58 +2795,88 @@ func (t *HomelendChaincode) getRequestForSpecificPlayer(stub shim.ChaincodeStubI
 	return shim.Success(byteArr)
 }
 
-func (t *HomelendChaincode) getCreditRankScore(stub shim.ChaincodeStubInterface, salary int, loanAmount int) (string, error) {
-	switch {
-	case salary < 10000:
-		return "C", nil
-	case salary > 10000 && salary < 20000:
-		return "B", nil
-	case salary > 20000:
-		return "A", nil
-	default:
-		return "", errors.New("invalid salary?")
-	}
-}
-
-func (t *HomelendChaincode) govResultsGetter(checkHouseOwner bool, checkLien bool, checkWarningShot bool) *GovernmentResults {
+func (t *HomelendChaincode) govResultsGetter(checkHouseOwner bool, checkLien bool, checkWarningShot bool, timestamp time.Time) *GovernmentResults {
 
 	result := &GovernmentResults{}
 
 	result.CheckHouseOwner = checkHouseOwner
 	result.CheckLien = checkLien
 	result.CheckWarningShot = checkWarningShot
-	result.Timestamp = time.Now()
+	result.Source = GovernmentSourceManual
+	result.Timestamp = timestamp
 
 	return result
 }
 
-func (t *HomelendChaincode) bankValidateBeforeApprove(request *Request, bankIdentity string) (string, error) {
+//bankValidateBeforeApprove - every reason to decline the request, joined into the DeclineInfo, empty when it can be approved.
+//The government checks, the selected insurance, the cover of the open mortgages of the buyer and the cap of the bank offer always apply,
+//the rest comes from the underwriting rules of the bank, see evaluateUnderwritingRules.
+func (t *HomelendChaincode) bankValidateBeforeApprove(stub shim.ChaincodeStubInterface, request *Request, bankIdentity string) (string, error) {
 
-	if !request.GovernmentResultsData.CheckHouseOwner {
-		return "CheckHouseOwner is false", nil
+	err := t.validateBankOwner(request, bankIdentity)
+	if err != nil {
+		return "", err
 	}
-	if !request.GovernmentResultsData.CheckLien {
-		return "CheckLien is false", nil
+
+	var failures []string
+	government := request.GovernmentResultsData
+	if !government.CheckHouseOwner {
+		failures = append(failures, government.failure("CheckHouseOwner", lib.CheckHouseOwner))
 	}
-	if !request.GovernmentResultsData.CheckWarningShot {
-		return "CheckWarningShot is false", nil
+	if !government.CheckLien {
+		failures = append(failures, government.failure("CheckLien", lib.CheckLien))
 	}
-
-	delta := float32(request.AppraiserAmount) * 0.1
-	if float32(request.AppraiserAmount) < (float32(request.LoanAmount) + delta) {
-		return "appraiser amount is too low for this loan", nil
+	if !government.CheckWarningShot {
+		failures = append(failures, government.failure("CheckWarningShot", lib.CheckWarningShot))
 	}
 
 	if len(request.SelectedInsuranceOfferHash) == 0 {
-		return "No insurance offer was selected", nil
+		failures = append(failures, "No insurance offer was selected")
 	}
 
-	err := t.validateBankOwner(request, bankIdentity)
+	coverageFailures, err := t.lapsedCoverage(stub, request.BuyerHash)
+	if err != nil {
+		return "", err
+	}
+	failures = append(failures, coverageFailures...)
+
+	// the cap of the offer applies to the lower of the price and the appraisal, in the currency of the loan
+	value, err := t.convert(stub, request.AppraiserAmount, request.LoanAmount.Currency)
+	if err != nil {
+		return "", err
+	}
+	price, err := t.convert(stub, request.SellingPrice, request.LoanAmount.Currency)
+	if err != nil {
+		return "", err
+	}
+	cmp, err := price.Cmp(value)
 	if err != nil {
 		return "", err
 	}
+	if price.IsPositive() && cmp < 0 {
+		value = price
+	}
+	if offer := selectedBankOffer(request); offer != nil {
+		exceeded, err := offer.ltvExceeded(request.LoanAmount, value)
+		if err != nil {
+			return "", err
+		}
+		if exceeded {
+			failures = append(failures, "loan to value is above the cap of the bank offer")
+		}
+	}
 
-	return "", nil
+	rules, err := t.getUnderwritingRules(stub, bankIdentity)
+	if err != nil {
+		return "", err
+	}
+	ruleFailures, err := t.evaluateUnderwritingRules(stub, rules, request)
+	if err != nil {
+		return "", err
+	}
+	failures = append(failures, ruleFailures...)
+
+	return strings.Join(failures, "; "), nil
 }
 
 func (t *HomelendChaincode) validateBankOwner(request *Request, bankIdentity string) error {
@@ -2343,155 +2890,144 @@ func (t *HomelendChaincode) validateBankOwner(request *Request, bankIdentity str
 	return nil
 }
 
-func (t *HomelendChaincode) moveMoney(stub shim.ChaincodeStubInterface, srcUserID string, destUserID string, sum int) error {
+func (t *HomelendChaincode) getMoney(stub shim.ChaincodeStubInterface, userID string) (lib.Money, error) {
+	return t.getBalance(stub, userID, lib.DefaultCurrency)
+}
 
-	srcMoney := t.getMoney(stub, srcUserID)
-	if srcMoney < 0 {
-		str := fmt.Sprintf("Could not get money from srcUserID" + srcUserID)
-		fmt.Println(str)
-		return errors.New(str)
-	}
+//getProperty - returns nil without an error when the owner does not have the property
+func (t *HomelendChaincode) getProperty(stub shim.ChaincodeStubInterface, ownerHash string, propertyHash string) (*Property, error) {
 
-	destMoney := t.getMoney(stub, destUserID)
-	if srcMoney < 0 {
-		str := fmt.Sprintf("Could not get money from srcUserID" + destUserID)
+	key, err := stub.CreateCompositeKey(propertyIndex, []string{ownerHash, propertyHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
 		fmt.Println(str)
-		return errors.New(str)
+		return nil, errors.New(str)
 	}
 
-	if srcMoney < sum {
-		str := fmt.Sprintf("not enough money in srcMoney %d and price is %d", srcMoney, sum)
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get Property from user: %s", ownerHash)
 		fmt.Println(str)
-		return errors.New(str)
+		return nil, err
 	}
 
-	srcMoney -= sum
-	destMoney += sum
-
-	srcMoneyStr := strconv.Itoa(srcMoney)
-	destMoneyStr := strconv.Itoa(destMoney)
-
-	stub.PutState(money+srcUserID, []byte(srcMoneyStr))
-	stub.PutState(money+destUserID, []byte(destMoneyStr))
-
-	return nil
-
-	// moneyTransferBytes, err := stub.GetState(moneyTransferKey)
-
-	// if err != nil {
-	// 	return err
-	// }
-
-	// var moneyTransferArr []string
-	// err = json.Unmarshal(moneyTransferBytes, &moneyTransferArr)
-	// if err != nil {
-	// 	return err
-	// }
+	if len(dataAsBytes) <= 0 {
+		return nil, nil
+	}
 
-	// tx2Append := srcUserID + "_" + destUserID + "_" + strconv.Itoa(sum)
-	// moneyTransferArr = append(moneyTransferArr, tx2Append)
+	property := &Property{}
+	err = json.Unmarshal(dataAsBytes, property)
+	if err != nil {
+		return nil, err
+	}
 
-	// moneyTransferBytes, err = json.Marshal(moneyTransferArr)
-	// if err != nil {
-	// 	return err
-	// }
-	// stub.PutState(moneyTransferKey, moneyTransferBytes)
-	// return nil
+	return property, nil
 }
 
-func (t *HomelendChaincode) getMoney(stub shim.ChaincodeStubInterface, userID string) int {
+func (t *HomelendChaincode) getPropertiesByOwner(stub shim.ChaincodeStubInterface, ownerHash string) ([]*Property, error) {
 
-	dataAsBytes, err := stub.GetState(money + userID)
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(propertyIndex, []string{ownerHash})
 	if err != nil {
-		str := fmt.Sprintf("Could not getMoney userID=" + userID)
+		str := fmt.Sprintf("Failed to get properties of %s %+v", ownerHash, err.Error())
 		fmt.Println(str)
-		return -1
+		return nil, errors.New(str)
 	}
+	defer resultsIterator.Close()
 
-	if len(dataAsBytes) == 0 {
-		return 0
-	}
+	var list []*Property
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
 
-	money, err := strconv.Atoi(string(dataAsBytes))
-	if err != nil {
-		str := fmt.Sprintf("Could not getMoney - Atoi userID=" + userID)
-		fmt.Println(str)
-		return -1
+		property := &Property{}
+		err = json.Unmarshal(queryResponse.Value, property)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", queryResponse.Key, err)
+			return nil, errors.New(str)
+		}
+		list = append(list, property)
 	}
 
-	return money
+	return list, nil
 }
 
-func (t *HomelendChaincode) getProperty(stub shim.ChaincodeStubInterface, userHash string, propertyHash string) (*Property, int, []*Property, error) {
+func (t *HomelendChaincode) putProperty(stub shim.ChaincodeStubInterface, ownerHash string, property *Property) error {
 
-	dataAsBytes, err := stub.GetState(userHash)
+	key, err := stub.CreateCompositeKey(propertyIndex, []string{ownerHash, property.Hash})
 	if err != nil {
-		str := fmt.Sprintf("Failed to get Property from user: %s", userHash)
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
 		fmt.Println(str)
-		return nil, 0, nil, err
-	}
-
-	if len(dataAsBytes) <= 0 {
-		str := fmt.Sprintf("Empty properties for user: %s", userHash)
-		fmt.Println(str)
-		return nil, 0, nil, err
+		return errors.New(str)
 	}
 
-	var list []*Property
-	err = json.Unmarshal(dataAsBytes, &list)
+	dataAsBytes, err := json.Marshal(property)
 	if err != nil {
-		return nil, 0, nil, err
+		str := fmt.Sprintf("Could not Marshal %+v", err.Error())
+		return errors.New(str)
 	}
 
-	for i := 0; i < len(list); i++ {
-		if list[i].Hash == propertyHash {
-			return list[i], i, list, nil
-		}
+	err = stub.PutState(key, dataAsBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
 	}
 
-	return nil, 0, nil, errors.New("Could not found property: " + propertyHash + "in property array of user" + userHash)
+	return nil
 }
 
-func (t *HomelendChaincode) getPropertyAndRemove(stub shim.ChaincodeStubInterface, userHash string, propertyHash string) (*Property, error) {
+func (t *HomelendChaincode) getPropertyAndRemove(stub shim.ChaincodeStubInterface, ownerHash string, propertyHash string) (*Property, error) {
 
-	property, index, properties, err := t.getProperty(stub, userHash, propertyHash)
+	property, err := t.getProperty(stub, ownerHash, propertyHash)
 	if err != nil {
 		str := fmt.Sprintf("Failed to getProperty %+v", err.Error())
 		fmt.Println(str)
 		return nil, errors.New(str)
 	}
 
-	properties = append(properties[:index], properties[index+1:]...)
-	propertiesArrayBytes, err := json.Marshal(properties)
+	if property == nil {
+		return nil, errors.New("Could not found property: " + propertyHash + " of user " + ownerHash)
+	}
+
+	key, err := stub.CreateCompositeKey(propertyIndex, []string{ownerHash, propertyHash})
 	if err != nil {
-		return nil, errors.New("Failed Marshal: properties")
+		return nil, errors.New("Failed CreateCompositeKey: " + propertyHash)
 	}
 
-	err = stub.PutState(userHash, propertiesArrayBytes)
+	err = stub.DelState(key)
 	if err != nil {
-		return nil, errors.New("Failed PutState: propertiesArrayBytes")
+		return nil, errors.New("Failed DelState: " + propertyHash)
 	}
 	return property, nil
 }
 
-func (t *HomelendChaincode) calcPmt(yearlyInterestRate float64, totalNumberOfMonths int, loanAmount float64) (float64, error) {
+//transferProperty - moves the property from the property list of one owner to another's
+func (t *HomelendChaincode) transferProperty(stub shim.ChaincodeStubInterface, fromHash string, toHash string, propertyHash string) error {
+	property, err := t.getPropertyAndRemove(stub, fromHash, propertyHash)
+	if err != nil {
+		return err
+	}
+
+	return t.putProperty(stub, toHash, property)
+}
+
+//calcPmt - the monthly payment of the loan, exact and rounded half up to a cent so every peer agrees on it
+func (t *HomelendChaincode) calcPmt(yearlyInterestRate float32, totalNumberOfMonths int, loanAmount lib.Money) (lib.Money, error) {
 	fmt.Println("calcPmt", yearlyInterestRate, totalNumberOfMonths, loanAmount)
 	if yearlyInterestRate > 100 || yearlyInterestRate < 0 {
-		return 0, errors.New("invalid: interest")
+		return lib.Money{}, errors.New("invalid: interest")
 	}
 
 	if totalNumberOfMonths < 1 || totalNumberOfMonths > 500 {
-		return 0, errors.New("invalid: duration")
+		return lib.Money{}, errors.New("invalid: duration")
 	}
 
-	if loanAmount < 1 || loanAmount > 100000000 {
-		return 0, errors.New("invalid: loanAmount")
+	if loanAmount.Amount < lib.MajorUnits(1, loanAmount.Currency).Amount || loanAmount.Amount > lib.MajorUnits(100000000, loanAmount.Currency).Amount {
+		return lib.Money{}, errors.New("invalid: loanAmount")
 	}
 
-	rate := yearlyInterestRate / 100 / 12
-	denominator := math.Pow((1+rate), float64(totalNumberOfMonths)) - 1
-	result := (rate + (rate / denominator)) * loanAmount
-	return result, nil
+	return lib.MonthlyPayment(loanAmount, yearlyInterestRate, totalNumberOfMonths)
 }
 
 func (t *HomelendChaincode) getBankHash(requst *Request) (string, error) {
@@ -0,0 +1,778 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// MSP IDs of the organizations taking part in the buying process
+const (
+	buyerMSP        = "POCBuyerMSP"
+	sellerMSP       = "POCSellerMSP"
+	bankMSP         = "POCBankMSP"
+	appraiserMSP    = "POCAppraiserMSP"
+	insuranceMSP    = "POCInsuranceMSP"
+	governmentMSP   = "POCGovernmentMSP"
+	creditAgencyMSP = "POCCreditRatingAgencyMSP"
+	homelendMSP     = "POCHomelendMSP"
+	oracleMSP       = "POCExchangeRateOracleMSP"
+)
+
+// identities used by the tests, one per role
+const (
+	buyerID        = "buyer-1"
+	sellerID       = "seller-1"
+	bankID         = "bank-1"
+	appraiserID    = "appraiser-1"
+	insuranceID    = "insurance-1"
+	governmentID   = "government-1"
+	creditAgencyID = "credit-agency-1"
+	homelendID     = "homelend-1"
+	oracleID       = "fx-oracle-1"
+
+	propertyHash       = "property-1"
+	requestHash        = "request-1"
+	bankOfferHash      = "bank-offer-1"
+	insuranceOfferHash = "insurance-offer-1"
+
+	sellingPrice      = 300000
+	bankDepositAmount = 1000000
+	loanAmount        = 200000
+	appraiserAmount   = 300000
+)
+
+//the role attribute every test MSP enrolls its users with
+var mspRoles = map[string]Role{
+	buyerMSP:        RoleBuyer,
+	sellerMSP:       RoleSeller,
+	bankMSP:         RoleLoanOfficer,
+	appraiserMSP:    RoleAppraiser,
+	insuranceMSP:    RoleInsurer,
+	governmentMSP:   RoleGovernment,
+	creditAgencyMSP: RoleCreditAgency,
+	homelendMSP:     RoleAdmin,
+	oracleMSP:       RoleFXOracle,
+}
+
+//fakeIdentityProvider - lets a test impersonate any MSP and role
+type fakeIdentityProvider struct {
+	id    string
+	mspid string
+	role  Role
+}
+
+func (p *fakeIdentityProvider) GetID(stub shim.ChaincodeStubInterface) (string, error) {
+	return p.id, nil
+}
+
+func (p *fakeIdentityProvider) GetMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	return p.mspid, nil
+}
+
+func (p *fakeIdentityProvider) GetAttributeValue(stub shim.ChaincodeStubInterface, attrName string) (string, bool, error) {
+	if attrName != roleAttribute || p.role == "" {
+		return "", false, nil
+	}
+	return string(p.role), true, nil
+}
+
+//testNetwork - a MockStub running lending_chaincode plus the identity it is invoked with
+type testNetwork struct {
+	t        *testing.T
+	stub     *shim.MockStub
+	identity *fakeIdentityProvider
+	// the creditscore chaincode lending_chaincode invokes by default
+	creditScore *creditScoreChaincode
+	// the land registry of the government chaincode
+	registry *registryChaincode
+	// the policies of the insurance chaincode
+	insurance *insuranceChaincode
+	txCount   int
+	// steps of the happy path that already ran
+	steps int
+	// chaincode events emitted so far, in order
+	events []*pb.ChaincodeEvent
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	identity := &fakeIdentityProvider{}
+	cc := &HomelendChaincode{identityProvider: identity}
+	n := &testNetwork{t: t, stub: shim.NewMockStub("lending_chaincode", &peerChaincode{cc: cc}), identity: identity}
+	n.creditScore = n.peerCreditScore(defaultChaincodeLinks.CreditScore, lib.CreditModel, 0)
+	// the property of the happy path is registered to the seller
+	n.registry = &registryChaincode{titles: map[string]*lib.TitleRecord{
+		propertyHash: {PropertyHash: propertyHash, ParcelID: "6106-52", OwnerHash: sellerID, Version: 1},
+	}}
+	n.stub.MockPeerChaincode(defaultChaincodeLinks.Government.Chaincode, shim.NewMockStub(defaultChaincodeLinks.Government.Chaincode, n.registry))
+	n.insurance = &insuranceChaincode{policies: map[string]*lib.Policy{}}
+	n.stub.MockPeerChaincode(defaultChaincodeLinks.Insurance.Chaincode, shim.NewMockStub(defaultChaincodeLinks.Insurance.Chaincode, n.insurance))
+
+	res := n.stub.MockInit(n.nextTxID(), [][]byte{[]byte("init")})
+	if res.Status != shim.OK {
+		t.Fatalf("init failed: %s", res.Message)
+	}
+
+	// the organizations of the happy path register before taking part
+	n.as(bankMSP, bankID).mustInvoke("putBankInfo", `{"Name":"Bank"}`)
+	n.as(insuranceMSP, insuranceID).mustInvoke("putInsuranceCompanyInfo", `{"Name":"Insure"}`)
+	n.as(creditAgencyMSP, creditAgencyID).mustInvoke("putCreditRatingAgencyInfo", `{"Name":"Rating"}`)
+	n.as(appraiserMSP, appraiserID).mustInvoke("appraiserputPersonalInfo", `{"FirstName":"Dana","LastName":"Levi"}`)
+	n.as(bankMSP, bankID).deposit("bankDeposit", fmt.Sprint(bankDepositAmount))
+	return n
+}
+
+//peerCreditScore - registers a creditscore chaincode under link
+func (n *testNetwork) peerCreditScore(link ChaincodeLink, model string, offset int) *creditScoreChaincode {
+	name := link.Chaincode
+	if link.Channel != "" {
+		name += "/" + link.Channel
+	}
+	cc := &creditScoreChaincode{model: model, offset: offset}
+	n.stub.MockPeerChaincode(name, shim.NewMockStub(name, cc))
+	return cc
+}
+
+func (n *testNetwork) nextTxID() string {
+	n.txCount++
+	return fmt.Sprintf("tx%d", n.txCount)
+}
+
+//as - switches the identity used by the following invocations, with the usual role of the MSP
+func (n *testNetwork) as(mspid string, id string) *testNetwork {
+	return n.asRole(mspid, id, mspRoles[mspid])
+}
+
+//asRole - like as, with a certificate holding the given role attribute
+func (n *testNetwork) asRole(mspid string, id string, role Role) *testNetwork {
+	n.identity.mspid = mspid
+	n.identity.id = id
+	n.identity.role = role
+	return n
+}
+
+func (n *testNetwork) invoke(function string, args ...string) pb.Response {
+	input := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+	res := n.stub.MockInvoke(n.nextTxID(), input)
+
+	// drain the events so SetEvent never blocks on the buffered channel
+	for {
+		select {
+		case event := <-n.stub.ChaincodeEventsChannel:
+			n.events = append(n.events, event)
+		default:
+			return res
+		}
+	}
+}
+
+func (n *testNetwork) mustInvoke(function string, args ...string) []byte {
+	n.t.Helper()
+	res := n.invoke(function, args...)
+	if res.Status != shim.OK {
+		n.t.Fatalf("%s as %s failed: %s", function, n.identity.mspid, res.Message)
+	}
+	return res.Payload
+}
+
+func (n *testNetwork) mustFail(function string, args ...string) string {
+	n.t.Helper()
+	res := n.invoke(function, args...)
+	if res.Status == shim.OK {
+		n.t.Fatalf("%s as %s was expected to fail", function, n.identity.mspid)
+	}
+	return res.Message
+}
+
+func (n *testNetwork) request() *Request {
+	n.t.Helper()
+	cc := &HomelendChaincode{}
+	request, err := cc.getRequest(n.stub, buyerID, requestHash)
+	if err != nil {
+		n.t.Fatalf("getRequest failed: %s", err)
+	}
+	return request
+}
+
+func (n *testNetwork) requestLinks(queue string) []*RequestLink {
+	n.t.Helper()
+	cc := &HomelendChaincode{}
+	links, _, err := cc.listQueue(newPeerStub(n.stub), queue, maxPageSize, "")
+	if err != nil {
+		n.t.Fatalf("could not list %s: %s", queue, err)
+	}
+	return links
+}
+
+//pull - invokes a pull function, decodes the page items into items and returns the next bookmark
+func (n *testNetwork) pull(items interface{}, function string, args ...string) string {
+	n.t.Helper()
+	page := &PullResult{Items: items}
+	err := json.Unmarshal(n.mustInvoke(function, args...), page)
+	if err != nil {
+		n.t.Fatalf("could not unmarshal %s result: %s", function, err)
+	}
+	return page.Bookmark
+}
+
+//usd - a whole amount of the default currency
+func usd(major int64) lib.Money {
+	return lib.MajorUnits(major, lib.DefaultCurrency)
+}
+
+//sumOf - the amounts added up, a currency mismatch fails the test
+func sumOf(t *testing.T, amounts ...lib.Money) lib.Money {
+	t.Helper()
+	sum, err := lib.Sum(amounts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
+
+//offerJSON - a fixed rate BankOffer document
+func offerJSON(hash string, interest float32) string {
+	return fmt.Sprintf(`{"Hash":"%s","Product":"fixed","Interest":%g}`, hash, interest)
+}
+
+func requestLinkJSON() string {
+	bytes, _ := json.Marshal(&RequestLink{UserHash: buyerID, RequestHash: requestHash})
+	return string(bytes)
+}
+
+func hasLink(links []*RequestLink, requestHash string) bool {
+	for _, link := range links {
+		if link.RequestHash == requestHash {
+			return true
+		}
+	}
+	return false
+}
+
+//happyPath - every step of the buying process in order, with the status it leads to
+var happyPath = []struct {
+	status RequestStatus
+	run    func(n *testNetwork)
+}{
+	{StatusInitialized, func(n *testNetwork) {
+		n.as(sellerMSP, sellerID).mustInvoke("advertise", fmt.Sprintf(`{"Hash":"%s","Address":"Shahal 5","SellingPrice":%d}`, propertyHash, sellingPrice))
+		n.as(buyerMSP, buyerID).mustInvoke("buy", fmt.Sprintf(`{"Hash":"%s","PropertyHash":"%s","SellerHash":"%s","Salary":15000,"LoanAmount":%d,"Duration":240}`, requestHash, propertyHash, sellerID, loanAmount))
+		n.as(buyerMSP, buyerID).deposit("buyerDeposit", fmt.Sprint(sellingPrice-loanAmount))
+		n.as(buyerMSP, buyerID).mustInvoke("buyerPayDownPayment", requestHash)
+	}},
+	{StatusCreditScoreInstalled, func(n *testNetwork) {
+		n.as(creditAgencyMSP, creditAgencyID).mustInvoke("creditScore", requestLinkJSON())
+	}},
+	{StatusBankOfferInstalled, func(n *testNetwork) {
+		n.as(bankMSP, bankID).mustInvoke("bankPutOffer", requestLinkJSON(), offerJSON(bankOfferHash, 3.5))
+	}},
+	{StatusBuyerSelectedBankOffer, func(n *testNetwork) {
+		n.as(buyerMSP, buyerID).mustInvoke("buyerSelectBankOffer", requestHash, bankOfferHash)
+	}},
+	{StatusAppraiserChosen, func(n *testNetwork) {
+		n.as(buyerMSP, buyerID).mustInvoke("buyerSelectAppraiser", requestHash, appraiserID)
+	}},
+	{StatusAppraiserProvidedAmount, func(n *testNetwork) {
+		n.as(appraiserMSP, appraiserID).mustInvoke("appraiserProvideAmount", buyerID, requestHash, fmt.Sprint(appraiserAmount))
+	}},
+	{StatusInsuranceOfferProvided, func(n *testNetwork) {
+		n.as(insuranceMSP, insuranceID).mustInvoke("insurancePutOffer", buyerID, requestHash, "1200", insuranceOfferHash)
+	}},
+	{StatusInsuranceOfferSelected, func(n *testNetwork) {
+		n.as(buyerMSP, buyerID).mustInvoke("buyerSelectInsuranceOffer", requestHash, insuranceOfferHash)
+	}},
+	{StatusGovernmentProvided, func(n *testNetwork) {
+		n.as(governmentMSP, governmentID).mustInvoke("governmentPutData", buyerID, requestHash, "true", "true", "true")
+	}},
+	{StatusApprovedByBank, func(n *testNetwork) {
+		n.as(bankMSP, bankID).mustInvoke("bankApprove", requestLinkJSON())
+	}},
+	{StatusCompletedActiveMortgage, func(n *testNetwork) {
+		n.as(bankMSP, bankID).mustInvoke("bankRunChaincode", requestLinkJSON())
+	}},
+}
+
+//advanceTo - continues the happy path until the request reaches the given status
+func (n *testNetwork) advanceTo(status RequestStatus) {
+	n.t.Helper()
+	for ; n.steps < len(happyPath); n.steps++ {
+		step := happyPath[n.steps]
+		step.run(n)
+		if got := n.request().Status; got != step.status {
+			n.t.Fatalf("expected status %s got %s", step.status, got)
+		}
+		if step.status == status {
+			n.steps++
+			return
+		}
+	}
+	n.t.Fatalf("status %s is not on the happy path", status)
+}
+
+func TestHappyPath(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	request := n.request()
+	if len(request.StatusHistory) != len(happyPath) {
+		t.Fatalf("expected %d transitions got %d", len(happyPath), len(request.StatusHistory))
+	}
+	for i, step := range happyPath {
+		if request.StatusHistory[i].To != step.status {
+			t.Errorf("transition %d: expected %s got %s", i, step.status, request.StatusHistory[i].To)
+		}
+	}
+	if request.LoanAmountLeftToRefund != usd(loanAmount) {
+		t.Errorf("expected LoanAmountLeftToRefund %d got %s", loanAmount, request.LoanAmountLeftToRefund)
+	}
+
+	myInfo := &MyInfo{}
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getMyInfo"), myInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(myInfo.Properties) != 1 || myInfo.Properties[0].Hash != propertyHash {
+		t.Errorf("buyer does not own the property %+v", myInfo.Properties)
+	}
+
+	err = json.Unmarshal(n.as(sellerMSP, sellerID).mustInvoke("getMyInfo"), myInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(myInfo.Properties) != 0 {
+		t.Errorf("seller still owns %+v", myInfo.Properties)
+	}
+	if myInfo.Balance != usd(sellingPrice) {
+		t.Errorf("expected seller balance %d got %s", sellingPrice, myInfo.Balance)
+	}
+
+	if hasLink(n.requestLinks(pending4bankApproval+bankID), requestHash) {
+		t.Errorf("request was not removed from %s", pending4bankApproval)
+	}
+}
+
+func TestBankApproveDecline(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusInsuranceOfferSelected)
+
+	n.as(governmentMSP, governmentID).mustInvoke("governmentPutData", buyerID, requestHash, "false", "true", "true")
+	n.as(bankMSP, bankID).mustInvoke("bankApprove", requestLinkJSON())
+
+	request := n.request()
+	if request.Status != StatusDeclinedByBank {
+		t.Fatalf("expected %s got %s", StatusDeclinedByBank, request.Status)
+	}
+	if request.DeclineInfo != "CheckHouseOwner is false" {
+		t.Errorf("unexpected DeclineInfo %s", request.DeclineInfo)
+	}
+	cc := &HomelendChaincode{}
+	if balance, _ := cc.getBalance(n.stub, escrowAccount(requestHash), lib.DefaultCurrency); !balance.IsZero() {
+		t.Errorf("escrow of a declined request holds %s", balance)
+	}
+	if balance, _ := cc.getBalance(n.stub, buyerID, lib.DefaultCurrency); balance != usd(sellingPrice-loanAmount) {
+		t.Errorf("down payment was not refunded, buyer holds %s", balance)
+	}
+	if hasLink(n.requestLinks(pending4bankApproval+bankID), requestHash) {
+		t.Errorf("declined request was not removed from %s", pending4bankApproval)
+	}
+
+	n.as(bankMSP, bankID).mustFail("bankRunChaincode", requestLinkJSON())
+}
+
+func TestIllegalTransitions(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusAppraiserProvidedAmount)
+
+	msg := n.as(appraiserMSP, appraiserID).mustFail("appraiserProvideAmount", buyerID, requestHash, "1")
+	if !strings.Contains(msg, "ILLEGAL_TRANSITION") {
+		t.Errorf("expected a transition error got %s", msg)
+	}
+	n.as(bankMSP, bankID).mustFail("bankRunChaincode", requestLinkJSON())
+	n.as(bankMSP, bankID).mustFail("bankApprove", requestLinkJSON())
+
+	if got := n.request().AppraiserAmount; got != usd(appraiserAmount) {
+		t.Errorf("appraiser amount was overwritten %s", got)
+	}
+}
+
+func TestAdvertise(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(buyerMSP, buyerID).mustFail("advertise", `{"Hash":"h"}`)
+	n.as(sellerMSP, sellerID).mustFail("advertise")
+	n.as(sellerMSP, sellerID).mustInvoke("advertise", `{"Hash":"h","SellingPrice":10}`)
+	n.as(sellerMSP, sellerID).mustFail("advertise", `{"Hash":"h","SellingPrice":10}`)
+
+	var properties []*Property
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getProperties4Sale"), &properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(properties) != 1 || properties[0].SellerHash != sellerID {
+		t.Errorf("unexpected properties4sale %+v", properties)
+	}
+
+	err = json.Unmarshal(n.as(sellerMSP, sellerID).mustInvoke("getProperties"), &properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(properties) != 1 {
+		t.Errorf("expected the seller to hold one property got %d", len(properties))
+	}
+}
+
+func TestBuy(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(buyerMSP, buyerID).mustFail("buy", fmt.Sprintf(`{"Hash":"%s","PropertyHash":"%s","SellerHash":"%s"}`, requestHash, propertyHash, sellerID))
+
+	n.advanceTo(StatusInitialized)
+	if !hasLink(n.requestLinks(creditRankOpenRequests), requestHash) {
+		t.Errorf("request is not in %s", creditRankOpenRequests)
+	}
+	if strings.Contains(string(n.stub.State[properties4sale]), propertyHash) {
+		t.Errorf("property is still for sale")
+	}
+
+	var links []*RequestLink
+	n.as(creditAgencyMSP, creditAgencyID).pull(&links, "creditRatingPull")
+	if !hasLink(links, requestHash) {
+		t.Errorf("creditRatingPull did not return the request")
+	}
+
+	var requests []*Request
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("buyerGetMyRequests"), &requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].BuyerHash != buyerID {
+		t.Errorf("unexpected buyer requests %+v", requests)
+	}
+}
+
+func TestBankPutOffer(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCreditScoreInstalled)
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), bankOfferHash, "3.5")
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), `{"Hash":"bank-offer-1","Interest":"not-a-number"}`)
+
+	n.advanceTo(StatusBankOfferInstalled)
+	n.as(bankMSP, "bank-2").mustInvoke("putBankInfo", `{"Name":"Second bank"}`)
+	n.as(bankMSP, "bank-2").mustFail("bankPutOffer", requestLinkJSON(), `{"Hash":"bank-offer-2","Interest":4,"PrepaymentPenalty":-1}`)
+	n.as(bankMSP, "bank-2").mustInvoke("bankPutOffer", requestLinkJSON(), `{"Hash":"bank-offer-2","Interest":4,"PrepaymentPenalty":2}`)
+
+	request := n.request()
+	if len(request.BankOffers) != 2 {
+		t.Fatalf("expected 2 bank offers got %d", len(request.BankOffers))
+	}
+	if !request.BankOffers[0].MonthlyPayment.IsPositive() {
+		t.Errorf("monthly payment was not calculated")
+	}
+	if request.BankOffers[0].PrepaymentPenalty != 0 || request.BankOffers[1].PrepaymentPenalty != 2 {
+		t.Errorf("unexpected prepayment penalties %+v", request.BankOffers)
+	}
+}
+
+func TestBuyerSelectBankOffer(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusBankOfferInstalled)
+	n.as(buyerMSP, buyerID).mustFail("buyerSelectBankOffer", requestHash, "unknown-offer")
+
+	n.advanceTo(StatusBuyerSelectedBankOffer)
+	if n.request().SelectedBankOfferHash != bankOfferHash {
+		t.Errorf("bank offer was not selected")
+	}
+	if !hasLink(n.requestLinks(selectAppraiser), requestHash) {
+		t.Errorf("request is not in %s", selectAppraiser)
+	}
+}
+
+func TestAppraiser(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(appraiserMSP, appraiserID).mustInvoke("appraiserputPersonalInfo", `{"FirstName":"Dana","LastName":"Levi"}`)
+
+	var appraisers []*Appraiser
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("buyerGetAllAppraisers"), &appraisers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(appraisers) != 1 || appraisers[0].AppraiserHash != appraiserID {
+		t.Errorf("unexpected appraisers %+v", appraisers)
+	}
+
+	n.advanceTo(StatusAppraiserChosen)
+	var pending []*AppraiserPullResultItem
+	n.as(appraiserMSP, appraiserID).pull(&pending, "appraiserPullPendingRequests")
+	if len(pending) != 1 || pending[0].PropertyItem.Hash != propertyHash {
+		t.Errorf("unexpected pending appraisals %+v", pending)
+	}
+
+	n.as(appraiserMSP, "appraiser-2").mustFail("appraiserProvideAmount", buyerID, requestHash, "1")
+	n.advanceTo(StatusAppraiserProvidedAmount)
+	n.as(appraiserMSP, appraiserID).pull(&pending, "appraiserPullPendingRequests")
+	if len(pending) != 0 {
+		t.Errorf("appraisal is still pending")
+	}
+}
+
+func TestInsurance(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(insuranceMSP, insuranceID).mustInvoke("putInsuranceCompanyInfo", `{"Name":"Insure"}`)
+	n.advanceTo(StatusAppraiserProvidedAmount)
+
+	var open []*InsurancePullResultItem
+	n.as(insuranceMSP, insuranceID).pull(&open, "insuranceGetOpenRequests")
+	if len(open) != 1 || open[0].LoanAmount != usd(loanAmount) {
+		t.Errorf("unexpected open insurance requests %+v", open)
+	}
+
+	n.advanceTo(StatusInsuranceOfferProvided)
+	n.as(buyerMSP, buyerID).mustFail("buyerSelectInsuranceOffer", requestHash, "unknown-offer")
+	n.advanceTo(StatusInsuranceOfferSelected)
+
+	if hasLink(n.requestLinks(open4InsuranceOffers), requestHash) {
+		t.Errorf("request is still open for insurance offers")
+	}
+
+	var pending []*RequestLink
+	n.as(governmentMSP, governmentID).pull(&pending, "governmentPullPending")
+	if !hasLink(pending, requestHash) {
+		t.Errorf("governmentPullPending did not return the request")
+	}
+}
+
+func TestPersonalInfo(t *testing.T) {
+	tests := []struct {
+		function string
+		mspid    string
+		id       string
+		key      string
+	}{
+		{"putBuyerPersonalInfo", buyerMSP, buyerID, "buyer-" + buyerID},
+		{"putSellerPersonalInfo", sellerMSP, sellerID, "seller-" + sellerID},
+		{"putBankInfo", bankMSP, bankID, bank + bankID},
+		{"putInsuranceCompanyInfo", insuranceMSP, insuranceID, insuranceCompany + insuranceID},
+		{"putCreditRatingAgencyInfo", creditAgencyMSP, creditAgencyID, "credit-rating-agency-" + creditAgencyID},
+	}
+
+	for _, test := range tests {
+		n := newTestNetwork(t)
+		n.as(governmentMSP, governmentID).mustFail(test.function, `{"Name":"x"}`)
+		n.as(test.mspid, test.id).mustFail(test.function, "")
+		n.as(test.mspid, test.id).mustInvoke(test.function, `{"Name":"x"}`)
+		if len(n.stub.State[test.key]) == 0 {
+			t.Errorf("%s did not write %s", test.function, test.key)
+		}
+	}
+}
+
+func TestGetRequestInfo(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusInitialized)
+
+	request := &Request{}
+	err := json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("getRequestInfo", buyerID, requestHash), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Hash != requestHash {
+		t.Errorf("unexpected request %+v", request)
+	}
+	n.as(bankMSP, bankID).mustFail("getRequestInfo", buyerID, "unknown-request")
+}
+
+func TestQueryAndUnknownFunction(t *testing.T) {
+	n := newTestNetwork(t)
+	// MockStub has no rich query engine, so the query must surface its error
+	n.as(bankMSP, bankID).mustFail("query", "{}")
+	n.as(bankMSP, bankID).mustFail("noSuchFunction")
+}
+
+func TestBuyTwice(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCreditScoreInstalled)
+	n.as(buyerMSP, buyerID).mustFail("buy", fmt.Sprintf(`{"Hash":"%s","PropertyHash":"%s","SellerHash":"%s"}`, requestHash, propertyHash, sellerID))
+
+	if got := n.request().Status; got != StatusCreditScoreInstalled {
+		t.Errorf("request was overwritten, status %s", got)
+	}
+}
+
+func TestTimestampsFromTxHeader(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusGovernmentProvided)
+
+	// MockStub stamps every transaction with a fresh TxTimestamp, the last one is governmentPutData
+	txTime := time.Unix(n.stub.TxTimestamp.Seconds, int64(n.stub.TxTimestamp.Nanos)).UTC()
+
+	request := n.request()
+	if !request.GovernmentResultsData.Timestamp.Equal(txTime) {
+		t.Errorf("expected government results timestamp %s got %s", txTime, request.GovernmentResultsData.Timestamp)
+	}
+	last := request.StatusHistory[len(request.StatusHistory)-1]
+	if !last.Timestamp.Equal(txTime) {
+		t.Errorf("expected transition timestamp %s got %s", txTime, last.Timestamp)
+	}
+
+	n.as(bankMSP, bankID).mustInvoke("putBankInfo", `{"Name":"x"}`)
+	txTime = time.Unix(n.stub.TxTimestamp.Seconds, int64(n.stub.TxTimestamp.Nanos)).UTC()
+	data := &Bank{}
+	err := json.Unmarshal(n.stub.State[bank+bankID], data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.Timestamp.Equal(txTime) {
+		t.Errorf("expected bank timestamp %s got %s", txTime, data.Timestamp)
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCreditScoreInstalled)
+
+	n.asRole(bankMSP, bankID, "").mustFail("bankPutOffer", requestLinkJSON(), offerJSON(bankOfferHash, 3.5))
+	n.asRole(bankMSP, bankID, RoleBuyer).mustFail("bankPutOffer", requestLinkJSON(), offerJSON(bankOfferHash, 3.5))
+	msg := n.asRole(buyerMSP, buyerID, RoleLoanOfficer).mustFail("bankPutOffer", requestLinkJSON(), offerJSON(bankOfferHash, 3.5))
+	if !strings.Contains(msg, "is not allowed to hold role") {
+		t.Errorf("expected an MSP mapping error got %s", msg)
+	}
+	n.as("POCBank2MSP", "bank-2").mustFail("putBankInfo", `{"Name":"x"}`)
+
+	roleMSPs := RoleMSPs{}
+	for role, msps := range defaultRoleMSPs {
+		roleMSPs[role] = msps
+	}
+	roleMSPs[RoleLoanOfficer] = []string{bankMSP, "POCBank2MSP"}
+	mapping, _ := json.Marshal(roleMSPs)
+	res := n.stub.MockInit(n.nextTxID(), [][]byte{[]byte("init"), mapping})
+	if res.Status != shim.OK {
+		t.Fatalf("init failed: %s", res.Message)
+	}
+	// an upgrade without arguments keeps the stored mapping
+	res = n.stub.MockInit(n.nextTxID(), [][]byte{[]byte("init")})
+	if res.Status != shim.OK {
+		t.Fatalf("init failed: %s", res.Message)
+	}
+
+	n.asRole("POCBank2MSP", "bank-2", RoleLoanOfficer).mustInvoke("putBankInfo", `{"Name":"x"}`)
+
+	stored := RoleMSPs{}
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getRoleMSPs"), &stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored[RoleLoanOfficer]) != 2 {
+		t.Errorf("unexpected role mapping %+v", stored)
+	}
+}
+
+func TestParticipantRegistry(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCreditScoreInstalled)
+
+	// bank-2 is a loan officer of the bank MSP that never registered
+	msg := n.as(bankMSP, "bank-2").mustFail("bankPutOffer", requestLinkJSON(), offerJSON("bank-offer-2", 3.1))
+	if !strings.Contains(msg, "is not registered") {
+		t.Errorf("expected a registration error got %s", msg)
+	}
+
+	n.as(bankMSP, bankID).mustFail("setParticipantApprovalRequired", "true")
+	n.as(homelendMSP, homelendID).mustInvoke("setParticipantApprovalRequired", "true")
+	n.as(bankMSP, "bank-2").mustInvoke("putBankInfo", `{"Name":"Second bank"}`)
+	n.as(bankMSP, "bank-2").mustFail("bankPutOffer", requestLinkJSON(), offerJSON("bank-offer-2", 3.1))
+
+	n.as(bankMSP, bankID).mustFail("approveParticipant", string(ParticipantBank), "bank-2")
+	n.as(homelendMSP, homelendID).mustInvoke("approveParticipant", string(ParticipantBank), "bank-2")
+	n.as(bankMSP, "bank-2").mustInvoke("bankPutOffer", requestLinkJSON(), offerJSON("bank-offer-2", 3.1))
+
+	n.as(homelendMSP, homelendID).mustInvoke("suspendParticipant", string(ParticipantBank), bankID)
+	// registering again does not lift the suspension
+	n.as(bankMSP, bankID).mustInvoke("putBankInfo", `{"Name":"Bank"}`)
+	n.as(bankMSP, bankID).mustFail("bankPutOffer", requestLinkJSON(), offerJSON(bankOfferHash, 3.5))
+	n.as(homelendMSP, homelendID).mustFail("approveParticipant", string(ParticipantInsurer), bankID)
+
+	var banks []*Participant
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("listParticipants", string(ParticipantBank)), &banks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]ParticipantStatus)
+	for _, participant := range banks {
+		statuses[participant.Identity] = participant.Status
+	}
+	if len(banks) != 2 || statuses[bankID] != ParticipantSuspended || statuses["bank-2"] != ParticipantActive {
+		t.Errorf("unexpected banks %+v", statuses)
+	}
+
+	var all []*Participant
+	err = json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("listParticipants"), &all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 5 {
+		t.Errorf("expected 5 participants got %d", len(all))
+	}
+	n.as(buyerMSP, buyerID).mustFail("listParticipants", "plumber")
+
+	n.as(buyerMSP, buyerID).mustInvoke("buyerSelectBankOffer", requestHash, "bank-offer-2")
+	n.as(buyerMSP, buyerID).mustFail("buyerSelectAppraiser", requestHash, "appraiser-2")
+}
+
+func TestTransitionEvents(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	if len(n.events) != len(happyPath) {
+		t.Fatalf("expected %d events got %d", len(happyPath), len(n.events))
+	}
+
+	for i, step := range happyPath {
+		event := &RequestEvent{}
+		err := json.Unmarshal(n.events[i].Payload, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.events[i].EventName != string(step.status) || event.Status != step.status {
+			t.Errorf("event %d: expected %s got %s %s", i, step.status, n.events[i].EventName, event.Status)
+		}
+		if event.RequestLink.RequestHash != requestHash || event.RequestLink.UserHash != buyerID {
+			t.Errorf("event %d: unexpected RequestLink %+v", i, event.RequestLink)
+		}
+		if i > 0 && event.From != happyPath[i-1].status {
+			t.Errorf("event %d: expected From %s got %s", i, happyPath[i-1].status, event.From)
+		}
+
+		switch step.status {
+		case StatusAppraiserProvidedAmount:
+			if event.Amounts["AppraiserAmount"] != usd(appraiserAmount) {
+				t.Errorf("unexpected appraiser amount %+v", event.Amounts)
+			}
+		case StatusInsuranceOfferSelected:
+			if event.Amounts["InsuranceAmount"] != usd(1200) {
+				t.Errorf("unexpected insurance amount %+v", event.Amounts)
+			}
+		case StatusBuyerSelectedBankOffer:
+			if !event.Amounts["MonthlyPayment"].IsPositive() || event.Interest <= 0 {
+				t.Errorf("unexpected bank offer amounts %+v", event.Amounts)
+			}
+		case StatusCompletedActiveMortgage:
+			if event.Amounts["LoanAmountLeftToRefund"] != usd(loanAmount) {
+				t.Errorf("unexpected loan amounts %+v", event.Amounts)
+			}
+		}
+	}
+
+	// a failed transition does not emit anything
+	count := len(n.events)
+	n.as(bankMSP, bankID).mustFail("bankRunChaincode", requestLinkJSON())
+	if len(n.events) != count {
+		t.Errorf("a rejected transition emitted %s", n.events[len(n.events)-1].EventName)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//marks that migrateToCompositeKeys already ran
+const migrationCompositeKeysDone = "migration_compositeKeys"
+
+//MigrationResult - what migrateToCompositeKeys converted
+type MigrationResult struct {
+	Requests   int `json:"Requests"`
+	Properties int `json:"Properties"`
+}
+
+//migrateToCompositeKeys - one-shot conversion of the array-shaped state (requests_{buyer} and
+//the property array under the raw identity key) to one key per request and property.
+//Owners are collected from the migrated requests and properties4sale, args may add more owners.
+func (t *HomelendChaincode) migrateToCompositeKeys(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("migrateToCompositeKeys executed with args: %+v", args))
+
+	_, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	doneAsBytes, err := stub.GetState(migrationCompositeKeysDone)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if len(doneAsBytes) > 0 {
+		str := "migrateToCompositeKeys was already executed"
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	owners := make(map[string]bool)
+	for _, owner := range args {
+		owners[owner] = true
+	}
+
+	result := &MigrationResult{}
+
+	// "`" follows "_" so the range covers every requests_{buyer} key
+	resultsIterator, err := stub.GetStateByRange(requests, requests[:len(requests)-1]+"`")
+	if err != nil {
+		str := fmt.Sprintf("Failed to get range of %s %+v", requests, err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	var legacyKeys []string
+	var legacyRequests []*Request
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			resultsIterator.Close()
+			return shim.Error(err.Error())
+		}
+
+		var arrayOfData []*Request
+		err = json.Unmarshal(queryResponse.Value, &arrayOfData)
+		if err != nil {
+			resultsIterator.Close()
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", queryResponse.Key, err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+
+		legacyKeys = append(legacyKeys, queryResponse.Key)
+		legacyRequests = append(legacyRequests, arrayOfData...)
+	}
+	resultsIterator.Close()
+
+	for _, request := range legacyRequests {
+		err = t.addOrUpdateRequest(stub, request)
+		if err != nil {
+			str := fmt.Sprintf("Could not addOrUpdateRequest %+v", err.Error())
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		owners[request.BuyerHash] = true
+		owners[request.SellerHash] = true
+		result.Requests++
+	}
+
+	for _, key := range legacyKeys {
+		err = stub.DelState(key)
+		if err != nil {
+			str := fmt.Sprintf("Could not delete %s %+v", key, err.Error())
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	forSaleAsBytes, err := stub.GetState(properties4sale)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get: %s", properties4sale)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if len(forSaleAsBytes) > 0 {
+		var forSale []*Property
+		err = json.Unmarshal(forSaleAsBytes, &forSale)
+		if err != nil {
+			str := fmt.Sprintf("properties4sale Failed to unmarshal: %s", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		for _, property := range forSale {
+			owners[property.SellerHash] = true
+		}
+	}
+
+	var ownerList []string
+	for owner := range owners {
+		if owner != "" {
+			ownerList = append(ownerList, owner)
+		}
+	}
+	sort.Strings(ownerList)
+
+	for _, owner := range ownerList {
+		dataAsBytes, err := stub.GetState(owner)
+		if err != nil {
+			str := fmt.Sprintf("Failed to get Property from user: %s", owner)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		if len(dataAsBytes) == 0 {
+			continue
+		}
+
+		var list []*Property
+		err = json.Unmarshal(dataAsBytes, &list)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal properties of %s: %s", owner, err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+
+		for _, property := range list {
+			err = t.putProperty(stub, owner, property)
+			if err != nil {
+				str := fmt.Sprintf("Could not putProperty %+v", err.Error())
+				fmt.Println(str)
+				return shim.Error(str)
+			}
+			result.Properties++
+		}
+
+		err = stub.DelState(owner)
+		if err != nil {
+			str := fmt.Sprintf("Could not delete %s %+v", owner, err.Error())
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal result %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(migrationCompositeKeysDone, resultAsBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("migrateToCompositeKeys Sucessfully executed")
+	return shim.Success(resultAsBytes)
+}
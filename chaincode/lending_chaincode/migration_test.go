@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMigrateToCompositeKeys(t *testing.T) {
+	n := newTestNetwork(t)
+
+	legacyRequests, _ := json.Marshal([]*Request{
+		{Hash: requestHash, BuyerHash: buyerID, SellerHash: sellerID, PropertyHash: propertyHash, Status: StatusInitialized},
+		{Hash: "request-2", BuyerHash: buyerID, SellerHash: sellerID, Status: StatusCreditScoreInstalled},
+	})
+	sellerProperties, _ := json.Marshal([]*Property{{Hash: propertyHash, SellerHash: sellerID}, {Hash: "property-2", SellerHash: sellerID}})
+	otherProperties, _ := json.Marshal([]*Property{{Hash: "property-3", SellerHash: "seller-2"}})
+
+	n.stub.MockTransactionStart("seed")
+	n.stub.PutState(requests+buyerID, legacyRequests)
+	n.stub.PutState(sellerID, sellerProperties)
+	n.stub.PutState("seller-2", otherProperties)
+	n.stub.MockTransactionEnd("seed")
+
+	n.as(sellerMSP, sellerID).mustFail("migrateToCompositeKeys")
+
+	result := &MigrationResult{}
+	err := json.Unmarshal(n.as(homelendMSP, homelendID).mustInvoke("migrateToCompositeKeys", "seller-2"), result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requests != 2 || result.Properties != 3 {
+		t.Errorf("unexpected migration result %+v", result)
+	}
+
+	if n.request().Status != StatusInitialized {
+		t.Errorf("request was not migrated")
+	}
+	for _, key := range []string{requests + buyerID, sellerID, "seller-2"} {
+		if _, ok := n.stub.State[key]; ok {
+			t.Errorf("legacy key %s was not removed", key)
+		}
+	}
+
+	var properties []*Property
+	err = json.Unmarshal(n.as(sellerMSP, sellerID).mustInvoke("getProperties"), &properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(properties) != 2 {
+		t.Errorf("expected 2 migrated properties got %d", len(properties))
+	}
+
+	var list []*Request
+	err = json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("buyerGetMyRequests"), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("expected 2 migrated requests got %d", len(list))
+	}
+
+	n.as(homelendMSP, homelendID).mustFail("migrateToCompositeKeys")
+}
+
+func TestMigrateQueuesToCompositeKeys(t *testing.T) {
+	n := newTestNetwork(t)
+
+	links, _ := json.Marshal([]*RequestLink{{UserHash: buyerID, RequestHash: requestHash}, {UserHash: buyerID, RequestHash: "request-2"}})
+	n.stub.MockTransactionStart("seed")
+	n.stub.PutState(open4bankOffers, links)
+	n.stub.PutState(pending4bankApproval+bankID, links)
+	n.stub.MockTransactionEnd("seed")
+
+	n.as(bankMSP, bankID).mustFail("migrateQueuesToCompositeKeys")
+	if got := string(n.as(homelendMSP, homelendID).mustInvoke("migrateQueuesToCompositeKeys")); got != "4" {
+		t.Errorf("expected 4 migrated entries got %s", got)
+	}
+
+	if len(n.requestLinks(open4bankOffers)) != 2 || len(n.requestLinks(pending4bankApproval+bankID)) != 2 {
+		t.Errorf("queues were not migrated")
+	}
+	if _, ok := n.stub.State[open4bankOffers]; ok {
+		t.Errorf("legacy queue was not removed")
+	}
+	n.as(homelendMSP, homelendID).mustFail("migrateQueuesToCompositeKeys")
+}
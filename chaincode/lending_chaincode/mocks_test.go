@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//creditScoreChaincode - stands in for creditscore_chaincode, answers "score" with the shared model plus offset points
+type creditScoreChaincode struct {
+	model  string
+	offset int
+	// requests scored so far
+	requests []lib.CreditScoreRequest
+}
+
+func (c *creditScoreChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *creditScoreChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function != "score" || len(args) != 1 {
+		return shim.Error("expected score with one argument")
+	}
+
+	request := lib.CreditScoreRequest{}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	c.requests = append(c.requests, request)
+
+	score, err := lib.ScoreCredit(request.Input)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	score.Score += c.offset
+	score.Grade = lib.CreditGrade(score.Score)
+
+	dataAsBytes, _ := json.Marshal(&lib.CreditScoreResponse{RequestHash: request.RequestHash, Model: c.model, CreditScore: score})
+	return shim.Success(dataAsBytes)
+}
+
+//registryChaincode - stands in for government_chaincode, answers "checkTitle" from the title records it holds
+//and records the title changes lending_chaincode settles
+type registryChaincode struct {
+	titles map[string]*lib.TitleRecord
+}
+
+func (c *registryChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *registryChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if len(args) != 1 {
+		return shim.Error("expected one argument")
+	}
+	if function == "settle" {
+		return c.settle(args[0])
+	}
+	if function != "checkTitle" {
+		return shim.Error("expected checkTitle or settle")
+	}
+
+	request := lib.TitleCheckRequest{}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	results := make([]lib.TitleCheckResult, 0)
+	for _, check := range lib.TitleChecks {
+		result, err := lib.CheckTitle(c.titles[request.PropertyHash], check, request)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		results = append(results, result)
+	}
+
+	dataAsBytes, _ := json.Marshal(results)
+	return shim.Success(dataAsBytes)
+}
+
+func (c *registryChaincode) settle(arg string) pb.Response {
+	change := lib.TitleChange{}
+	err := json.Unmarshal([]byte(arg), &change)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	record, ok := c.titles[change.PropertyHash]
+	if !ok {
+		return shim.Error("property has no title record")
+	}
+
+	// the record is only replaced when the whole change applies
+	changed := *record
+	changed.Liens = append([]lib.Lien{}, record.Liens...)
+	changed.Warnings = append([]lib.Warning{}, record.Warnings...)
+	err = lib.ApplyTitleChange(&changed, change, time.Time{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	changed.Version++
+	c.titles[change.PropertyHash] = &changed
+
+	dataAsBytes, _ := json.Marshal(changed)
+	return shim.Success(dataAsBytes)
+}
+
+//insuranceChaincode - stands in for insurance_chaincode, keeps the policies lending_chaincode issues
+//and assesses them at the time of the transaction
+type insuranceChaincode struct {
+	policies map[string]*lib.Policy
+}
+
+func (c *insuranceChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *insuranceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if len(args) == 0 {
+		return shim.Error("expected at least one argument")
+	}
+	if function == "issuePolicy" {
+		request := lib.PolicyRequest{}
+		err := json.Unmarshal([]byte(args[0]), &request)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if _, ok := c.policies[request.Hash]; ok {
+			return shim.Error("policy already exists")
+		}
+		timestamp, err := txTime(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		policy, err := lib.IssuePolicy(request, timestamp)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		c.policies[policy.Hash] = policy
+		dataAsBytes, _ := json.Marshal(policy)
+		return shim.Success(dataAsBytes)
+	}
+
+	stored, ok := c.policies[args[0]]
+	if !ok {
+		return shim.Error("policy does not exist")
+	}
+	// the policy is only replaced when the whole change applies
+	policy := *stored
+	policy.Premiums = append([]lib.PremiumInstallment{}, stored.Premiums...)
+	policy.Payments = append([]lib.PremiumPayment{}, stored.Payments...)
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy.Assess(now)
+
+	switch {
+	case function == "getPolicy":
+	case function == "payPremium" && len(args) == 2:
+		var amount lib.Money
+		amount, err = lib.ParseMoney(args[1], policy.MonthlyPremium.Currency)
+		if err == nil {
+			err = policy.PayPremium(amount, stub.GetTxID(), now)
+		}
+	case function == "cancelPolicy":
+		err = policy.Cancel(now)
+	case function == "assignPolicy" && len(args) == 2:
+		policy.BeneficiaryHash = args[1]
+	default:
+		return shim.Error("unexpected function " + function)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if function != "getPolicy" {
+		c.policies[policy.Hash] = &policy
+	}
+
+	dataAsBytes, _ := json.Marshal(policy)
+	return shim.Success(dataAsBytes)
+}
+
+//txTime - the timestamp of the transaction, as the chaincodes the mocks stand in for read it
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	helpers := lib.Helpers{}
+	return helpers.GetTxTime(stub)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//ParticipantType - the kind of organization a Participant is registered as
+type ParticipantType string
+
+// Participant types kept in the registry
+const (
+	ParticipantBank         ParticipantType = "bank"
+	ParticipantInsurer      ParticipantType = "insurer"
+	ParticipantAppraiser    ParticipantType = "appraiser"
+	ParticipantCreditAgency ParticipantType = "credit-agency"
+)
+
+//ParticipantStatus - whether a registered Participant may take part in the buying process
+type ParticipantStatus string
+
+// Participant statuses, only active participants may submit offers, scores, amounts and approvals
+const (
+	ParticipantPending   ParticipantStatus = "PENDING"
+	ParticipantActive    ParticipantStatus = "ACTIVE"
+	ParticipantSuspended ParticipantStatus = "SUSPENDED"
+)
+
+//every registered participant is stored under its own key
+const participantIndex = "participant~type~identity"
+
+//when "true" new participants stay PENDING until a Homelend admin approves them
+const participantApprovalRequired = "participantApprovalRequired"
+
+// Participant - an organization registered to take part in the buying process
+type Participant struct {
+	Identity  string            `json:"Identity"`
+	Type      ParticipantType   `json:"Type"`
+	MSPID     string            `json:"MSPID"`
+	Name      string            `json:"Name"`
+	Status    ParticipantStatus `json:"Status"`
+	Timestamp time.Time         `json:"Timestamp"`
+}
+
+func validParticipantType(participantType ParticipantType) bool {
+	switch participantType {
+	case ParticipantBank, ParticipantInsurer, ParticipantAppraiser, ParticipantCreditAgency:
+		return true
+	}
+	return false
+}
+
+func (t *HomelendChaincode) participantKey(stub shim.ChaincodeStubInterface, participantType ParticipantType, identity string) (string, error) {
+	key, err := stub.CreateCompositeKey(participantIndex, []string{string(participantType), identity})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		fmt.Println(str)
+		return "", errors.New(str)
+	}
+	return key, nil
+}
+
+//getParticipant - returns nil, nil when the participant is not registered
+func (t *HomelendChaincode) getParticipant(stub shim.ChaincodeStubInterface, participantType ParticipantType, identity string) (*Participant, error) {
+	key, err := t.participantKey(stub, participantType, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		return nil, nil
+	}
+
+	participant := &Participant{}
+	err = json.Unmarshal(dataAsBytes, participant)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal participant %s: %s", identity, err)
+		return nil, errors.New(str)
+	}
+	return participant, nil
+}
+
+func (t *HomelendChaincode) putParticipant(stub shim.ChaincodeStubInterface, participant *Participant) error {
+	key, err := t.participantKey(stub, participant.Type, participant.Identity)
+	if err != nil {
+		return err
+	}
+
+	dataJSONasBytes, err := json.Marshal(participant)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+//registerParticipant - registers the caller, or updates its name when it is already registered.
+//The status of an existing participant is kept so a suspended one can not reactivate itself.
+func (t *HomelendChaincode) registerParticipant(stub shim.ChaincodeStubInterface, participantType ParticipantType, identity string, name string) error {
+	mspid, err := t.identities().GetMSPID(stub)
+	if err != nil {
+		str := fmt.Sprintf("MSPID error %+v", err)
+		return errors.New(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	participant, err := t.getParticipant(stub, participantType, identity)
+	if err != nil {
+		return err
+	}
+
+	if participant == nil {
+		approvalAsBytes, err := stub.GetState(participantApprovalRequired)
+		if err != nil {
+			str := fmt.Sprintf("Failed to get state %+v", err.Error())
+			return errors.New(str)
+		}
+
+		participant = &Participant{Identity: identity, Type: participantType, Status: ParticipantActive}
+		if string(approvalAsBytes) == "true" {
+			participant.Status = ParticipantPending
+		}
+	}
+
+	participant.MSPID = mspid
+	participant.Name = name
+	participant.Timestamp = timestamp
+	return t.putParticipant(stub, participant)
+}
+
+//requireActiveParticipant - fails unless the identity is registered as an active participant of the type
+func (t *HomelendChaincode) requireActiveParticipant(stub shim.ChaincodeStubInterface, participantType ParticipantType, identity string) error {
+	participant, err := t.getParticipant(stub, participantType, identity)
+	if err != nil {
+		return err
+	}
+
+	if participant == nil {
+		str := fmt.Sprintf("%s is not registered as %s", identity, participantType)
+		fmt.Println(str)
+		return errors.New(str)
+	}
+
+	if participant.Status != ParticipantActive {
+		str := fmt.Sprintf("%s %s is %s", participantType, identity, participant.Status)
+		fmt.Println(str)
+		return errors.New(str)
+	}
+	return nil
+}
+
+//setParticipantStatus - Homelend admin approves, reactivates or suspends a registered participant
+func (t *HomelendChaincode) setParticipantStatus(stub shim.ChaincodeStubInterface, args []string, status ParticipantStatus) pb.Response {
+	fmt.Println(fmt.Sprintf("setParticipantStatus %s executed with args: %+v", status, args))
+
+	if len(args) != 2 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	_, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	participant, err := t.getParticipant(stub, ParticipantType(args[0]), args[1])
+	if err != nil {
+		str := fmt.Sprintf("getParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if participant == nil {
+		str := fmt.Sprintf("%s %s is not registered", args[0], args[1])
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	participant.Status = status
+	err = t.putParticipant(stub, participant)
+	if err != nil {
+		str := fmt.Sprintf("putParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("setParticipantStatus Sucessfully executed")
+	return shim.Success(nil)
+}
+
+//setParticipantApprovalRequired - Homelend admin decides whether new participants must be approved, args: "true" or "false"
+func (t *HomelendChaincode) setParticipantApprovalRequired(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setParticipantApprovalRequired executed with args: %+v", args))
+
+	if len(args) != 1 || (args[0] != "true" && args[0] != "false") {
+		str := fmt.Sprintf("expected true or false %+v", args)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	_, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(participantApprovalRequired, []byte(args[0]))
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(nil)
+}
+
+//listParticipants - returns the registered participants of the type given in args[0], or all of them
+func (t *HomelendChaincode) listParticipants(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("listParticipants executed with args: %+v", args))
+
+	if len(args) > 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	var attributes []string
+	if len(args) == 1 && len(args[0]) > 0 {
+		if !validParticipantType(ParticipantType(args[0])) {
+			str := fmt.Sprintf("unknown participant type %s", args[0])
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		attributes = []string{args[0]}
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(participantIndex, attributes)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get participants %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	defer resultsIterator.Close()
+
+	list := make([]*Participant, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		participant := &Participant{}
+		err = json.Unmarshal(queryResponse.Value, participant)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", queryResponse.Key, err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+		list = append(list, participant)
+	}
+
+	dataJSONasBytes, err := json.Marshal(list)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(dataJSONasBytes)
+}
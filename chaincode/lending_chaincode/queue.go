@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//every entry of a work queue is stored under its own key, so requests advancing at once do not collide
+const queueIndex = "queue~name~user~request"
+
+const defaultPageSize = 50
+const maxPageSize = 500
+
+//marks that migrateQueuesToCompositeKeys already ran
+const migrationQueuesDone = "migration_queues"
+
+//PullResult - a page of a work queue, pass Bookmark back to get the next page
+type PullResult struct {
+	Items    interface{} `json:"Items"`
+	Bookmark string      `json:"Bookmark"`
+}
+
+func (t *HomelendChaincode) queueKey(stub shim.ChaincodeStubInterface, queue string, link *RequestLink) (string, error) {
+	key, err := stub.CreateCompositeKey(queueIndex, []string{queue, link.UserHash, link.RequestHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		fmt.Println(str)
+		return "", errors.New(str)
+	}
+	return key, nil
+}
+
+//enqueueRequest - adds the request to the queue, fails if it is already there
+func (t *HomelendChaincode) enqueueRequest(stub shim.ChaincodeStubInterface, queue string, link *RequestLink) error {
+	key, err := t.queueKey(stub, queue, link)
+	if err != nil {
+		return err
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return errors.New(str)
+	}
+	if len(dataAsBytes) > 0 {
+		str := fmt.Sprintf("item already exists in queue: %s : %s", queue, link.RequestHash)
+		fmt.Println(str)
+		return errors.New(str)
+	}
+
+	dataAsBytes, err = json.Marshal(link)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(key, dataAsBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+//dequeueRequest - removes the request from the queue, fails if it is not there
+func (t *HomelendChaincode) dequeueRequest(stub shim.ChaincodeStubInterface, queue string, link *RequestLink) error {
+	key, err := t.queueKey(stub, queue, link)
+	if err != nil {
+		return err
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		return errors.New("RequestLink was not found in queue " + queue)
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		str := fmt.Sprintf("Could not delete state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+//listQueue - returns up to pageSize entries starting at the bookmark and the bookmark of the next page.
+//The bookmark is empty on the last page.
+func (t *HomelendChaincode) listQueue(stub shim.ChaincodeStubInterface, queue string, pageSize int, bookmark string) ([]*RequestLink, string, error) {
+	list := make([]*RequestLink, 0)
+	next, err := t.readPage(stub, queueIndex, []string{queue}, pageSize, bookmark, func(key string, value []byte) error {
+		link := &RequestLink{}
+		err := json.Unmarshal(value, link)
+		if err != nil {
+			str := fmt.Sprintf("Failed to unmarshal %s: %s", key, err)
+			return errors.New(str)
+		}
+		list = append(list, link)
+		return nil
+	})
+	if err != nil {
+		str := fmt.Sprintf("Failed to get queue %s %+v", queue, err.Error())
+		fmt.Println(str)
+		return nil, "", errors.New(str)
+	}
+
+	return list, next, nil
+}
+
+//readPage - passes up to pageSize states under the partial composite key to add, starting at the bookmark.
+//The peer reads only the page, the returned bookmark of the next page is empty on the last page.
+func (t *HomelendChaincode) readPage(stub shim.ChaincodeStubInterface, objectType string, attributes []string, pageSize int, bookmark string, add func(key string, value []byte) error) (string, error) {
+	startKey, err := t.decodeBookmark(bookmark)
+	if err != nil {
+		return "", err
+	}
+
+	resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(objectType, attributes, int32(pageSize), startKey)
+	if err != nil {
+		return "", err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return "", err
+		}
+
+		err = add(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if metadata == nil || metadata.Bookmark == "" || int(metadata.FetchedRecordsCount) < pageSize {
+		return "", nil
+	}
+	return t.encodeBookmark(metadata.Bookmark), nil
+}
+
+//encodeBookmark - the bookmark of the peer as passed to the clients
+func (t *HomelendChaincode) encodeBookmark(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+//decodeBookmark - the bookmark of the peer, empty for the first page
+func (t *HomelendChaincode) decodeBookmark(bookmark string) (string, error) {
+	if bookmark == "" {
+		return "", nil
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(bookmark)
+	if err != nil {
+		return "", errors.New("invalid bookmark")
+	}
+	return string(keyBytes), nil
+}
+
+//parsePagination - reads the optional [pageSize, bookmark] arguments of the pull functions
+func (t *HomelendChaincode) parsePagination(args []string) (int, string, error) {
+	pageSize := defaultPageSize
+	bookmark := ""
+
+	if len(args) > 2 {
+		return 0, "", fmt.Errorf("Incorrect number of arguments %d.", len(args))
+	}
+
+	if len(args) > 0 && len(args[0]) > 0 {
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size < 1 || size > maxPageSize {
+			return 0, "", fmt.Errorf("pageSize must be between 1 and %d", maxPageSize)
+		}
+		pageSize = size
+	}
+
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+
+	return pageSize, bookmark, nil
+}
+
+//pullQueue - returns a page of RequestLinks of the queue to the given role
+func (t *HomelendChaincode) pullQueue(stub shim.ChaincodeStubInterface, role Role, queue string, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("pullQueue= %s role= %s", queue, role))
+
+	_, err := t.getIdentity(stub, role)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pageSize, bookmark, err := t.parsePagination(args)
+	if err != nil {
+		str := fmt.Sprintf("parsePagination error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	list, next, err := t.listQueue(stub, queue, pageSize, bookmark)
+	if err != nil {
+		str := fmt.Sprintf("listQueue error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.pullResponse(list, next)
+}
+
+func (t *HomelendChaincode) pullResponse(items interface{}, bookmark string) pb.Response {
+	dataJSONasBytes, err := json.Marshal(&PullResult{Items: items, Bookmark: bookmark})
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal result %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(dataJSONasBytes)
+}
+
+//migrateQueuesToCompositeKeys - one-shot conversion of the RequestLink arrays of every work queue
+//(including the per bank and per appraiser ones) to one key per entry
+func (t *HomelendChaincode) migrateQueuesToCompositeKeys(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("migrateQueuesToCompositeKeys executed with args: %+v", args))
+
+	_, err := t.getIdentity(stub, RoleAdmin)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	doneAsBytes, err := stub.GetState(migrationQueuesDone)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if len(doneAsBytes) > 0 {
+		str := "migrateQueuesToCompositeKeys was already executed"
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	legacyQueues := []string{creditRankOpenRequests, open4bankOffers, selectAppraiser, open4InsuranceOffers, pending4Government, pending4bankApproval, pendingForAppraiserEstimation}
+
+	// the per bank and per appraiser queues were stored as {prefix}{hash}, a range covers them too
+	legacy := make(map[string][]*RequestLink)
+	for _, prefix := range legacyQueues {
+		resultsIterator, err := stub.GetStateByRange(prefix, prefix+"~")
+		if err != nil {
+			str := fmt.Sprintf("Failed to get range of %s %+v", prefix, err.Error())
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+
+			var arrayOfData []*RequestLink
+			err = json.Unmarshal(queryResponse.Value, &arrayOfData)
+			if err != nil {
+				resultsIterator.Close()
+				str := fmt.Sprintf("Failed to unmarshal %s: %s", queryResponse.Key, err)
+				fmt.Println(str)
+				return shim.Error(str)
+			}
+			legacy[queryResponse.Key] = arrayOfData
+		}
+		resultsIterator.Close()
+	}
+
+	var keys []string
+	for key := range legacy {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	migrated := 0
+	for _, queue := range keys {
+		for _, link := range legacy[queue] {
+			err = t.enqueueRequest(stub, queue, link)
+			if err != nil {
+				str := fmt.Sprintf("Could not enqueueRequest %+v", err.Error())
+				fmt.Println(str)
+				return shim.Error(str)
+			}
+			migrated++
+		}
+
+		err = stub.DelState(queue)
+		if err != nil {
+			str := fmt.Sprintf("Could not delete %s %+v", queue, err.Error())
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	resultAsBytes := []byte(strconv.Itoa(migrated))
+	err = stub.PutState(migrationQueuesDone, resultAsBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("migrateQueuesToCompositeKeys Sucessfully executed")
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPullPagination(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(sellerMSP, sellerID)
+	for i := 0; i < 5; i++ {
+		n.mustInvoke("advertise", fmt.Sprintf(`{"Hash":"property-%d","SellingPrice":100}`, i))
+	}
+	n.as(buyerMSP, buyerID)
+	for i := 0; i < 5; i++ {
+		n.mustInvoke("buy", fmt.Sprintf(`{"Hash":"request-%d","PropertyHash":"property-%d","SellerHash":"%s"}`, i, i, sellerID))
+	}
+
+	n.as(creditAgencyMSP, creditAgencyID)
+	n.mustFail("creditRatingPull", "0")
+	n.mustFail("creditRatingPull", "2", "not-base64!")
+
+	seen := make(map[string]bool)
+	bookmark := ""
+	for page := 0; page < 3; page++ {
+		var links []*RequestLink
+		bookmark = n.pull(&links, "creditRatingPull", "2", bookmark)
+		if page < 2 && (len(links) != 2 || bookmark == "") {
+			t.Fatalf("page %d: got %d items bookmark %q", page, len(links), bookmark)
+		}
+		for _, link := range links {
+			seen[link.RequestHash] = true
+		}
+	}
+	if bookmark != "" || len(seen) != 5 {
+		t.Errorf("expected all 5 requests on 3 pages, saw %d, last bookmark %q", len(seen), bookmark)
+	}
+}
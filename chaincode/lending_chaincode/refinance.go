@@ -0,0 +1,584 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//PayoffQuote - what clears the mortgage at the quote time
+type PayoffQuote struct {
+	RequestHash          string    `json:"RequestHash"`
+	BankHash             string    `json:"BankHash"`
+	OutstandingPrincipal lib.Money `json:"OutstandingPrincipal"`
+	AccruedInterest      lib.Money `json:"AccruedInterest"`
+	PrepaymentPenalty    lib.Money `json:"PrepaymentPenalty"`
+	LateFees             lib.Money `json:"LateFees"`
+	Total                lib.Money `json:"Total"`
+	QuotedAt             time.Time `json:"QuotedAt"`
+}
+
+//Refinancing - a payoff of the mortgage by another bank, which became its lien holder
+type Refinancing struct {
+	FromBankHash  string    `json:"FromBankHash"`
+	ToBankHash    string    `json:"ToBankHash"`
+	BankOfferHash string    `json:"BankOfferHash"`
+	PayoffAmount  lib.Money `json:"PayoffAmount"`
+	TxID          string    `json:"TxID"`
+	Timestamp     time.Time `json:"Timestamp"`
+}
+
+//payoffQuote - interest accrues daily since the due date of the last paid installment,
+//the prepayment penalty of the BankOffer is a percent of the outstanding principal.
+//Both are rounded half up to a cent. Late fees in another currency than the loan are an error.
+func (m *Mortgage) payoffQuote(now time.Time) (*PayoffQuote, error) {
+	zero := lib.NewMoney(0, m.OutstandingPrincipal.Currency)
+	lateFees, err := m.LateFees.Add(zero)
+	if err != nil {
+		return nil, err
+	}
+	quote := &PayoffQuote{RequestHash: m.RequestHash, BankHash: m.BankHash, OutstandingPrincipal: m.OutstandingPrincipal, AccruedInterest: zero, LateFees: lateFees, QuotedAt: now}
+
+	accruedSince := m.StartDate.AddDate(0, m.InstallmentsPaid, 0)
+	if now.After(accruedSince) {
+		days := int64(now.Sub(accruedSince).Hours() / 24)
+		yearShare := new(big.Rat).Mul(lib.RatFromFloat32(m.Interest), big.NewRat(days, 36500))
+		quote.AccruedInterest = m.OutstandingPrincipal.MulRat(yearShare)
+	}
+	quote.PrepaymentPenalty = m.OutstandingPrincipal.Percent(m.PrepaymentPenalty)
+	quote.Total, err = lib.Sum(quote.OutstandingPrincipal, quote.AccruedInterest, quote.PrepaymentPenalty, quote.LateFees)
+	if err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+//requireOpenMortgage - fails once the mortgage is paid off or foreclosed
+func (m *Mortgage) requireOpenMortgage() error {
+	if m.Status == MortgagePaidOff || m.Status == MortgageForeclosed || !m.OutstandingPrincipal.IsPositive() {
+		return fmt.Errorf("the mortgage of request %s is %s", m.RequestHash, m.Status)
+	}
+	return nil
+}
+
+//payoffMortgage - the payer pays the quote to the lien holder and the mortgage is cleared
+func (t *HomelendChaincode) payoffMortgage(stub shim.ChaincodeStubInterface, mortgage *Mortgage, quote *PayoffQuote, payer string, description string) error {
+	err := t.postJournalEntry(stub, description, mortgage.RequestHash, transferLines(payer, bankAccount(mortgage.BankHash), quote.Total))
+	if err != nil {
+		return err
+	}
+
+	mortgage.Payments = append(mortgage.Payments, InstallmentPayment{
+		Number:            mortgage.InstallmentsPaid + 1,
+		TxID:              stub.GetTxID(),
+		Amount:            quote.Total,
+		Principal:         quote.OutstandingPrincipal,
+		Interest:          quote.AccruedInterest,
+		LateFees:          quote.LateFees,
+		PrepaymentPenalty: quote.PrepaymentPenalty,
+		DueDate:           quote.QuotedAt,
+		Timestamp:         quote.QuotedAt,
+	})
+	mortgage.OutstandingPrincipal = lib.NewMoney(0, quote.Total.Currency)
+	mortgage.LateFees = lib.NewMoney(0, quote.Total.Currency)
+
+	return t.closeRefinanceRequest(stub, mortgage)
+}
+
+//closeRefinanceRequest - a mortgage that is paid off or foreclosed is no longer open to refinance offers
+func (t *HomelendChaincode) closeRefinanceRequest(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	if !mortgage.RefinanceRequested {
+		return nil
+	}
+
+	mortgage.RefinanceRequested = false
+	mortgage.RefinanceOffers = nil
+	return t.dequeueRequest(stub, open4refinance, &RequestLink{UserHash: mortgage.BuyerHash, RequestHash: mortgage.RequestHash})
+}
+
+//getPayoffQuote - args: buyerHash, requestHash
+func (t *HomelendChaincode) getPayoffQuote(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	mortgage, err := t.getReadableMortgage(stub, args)
+	if err != nil {
+		str := fmt.Sprintf("getReadableMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = mortgage.requireOpenMortgage()
+	if err != nil {
+		str := fmt.Sprintf("requireOpenMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	now, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	quote, err := mortgage.payoffQuote(now)
+	if err != nil {
+		str := fmt.Sprintf("payoffQuote error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	return t.marshalResponse(quote)
+}
+
+//buyerPayOff - the buyer pays the payoff quote and the mortgage is paid off, args: requestHash
+func (t *HomelendChaincode) buyerPayOff(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("buyerPayOff executed with args: %+v", args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleBuyer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request, err := t.getRequest(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage, err := t.getMortgage(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = mortgage.requireOpenMortgage()
+	if err != nil {
+		str := fmt.Sprintf("requireOpenMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.assessMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("assessMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	now, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	quote, err := mortgage.payoffQuote(now)
+	if err != nil {
+		str := fmt.Sprintf("payoffQuote error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	err = t.payoffMortgage(stub, mortgage, quote, identity, "early payoff")
+	if err != nil {
+		str := fmt.Sprintf("payoffMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage.Status = MortgagePaidOff
+	err = t.releaseMortgageLien(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("Could not release the lien %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.cancelMortgagePolicy(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("Could not cancel the insurance policy %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("putMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.transitionRequest(stub, request, StatusMortgagePaidOff)
+	if err != nil {
+		str := fmt.Sprintf("transitionRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request.LoanAmountLeftToRefund = mortgage.OutstandingPrincipal
+	err = t.addOrUpdateRequest(stub, request)
+	if err != nil {
+		str := fmt.Sprintf("addOrUpdateRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("buyerPayOff Sucessfully executed")
+	return t.marshalResponse(quote)
+}
+
+//buyerRequestRefinance - opens an active mortgage to refinance offers of other banks, args: requestHash
+func (t *HomelendChaincode) buyerRequestRefinance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("buyerRequestRefinance executed with args: %+v", args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleBuyer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage, err := t.getMortgage(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.assessMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("assessMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if mortgage.Status != MortgageActive || !mortgage.OutstandingPrincipal.IsPositive() {
+		str := fmt.Sprintf("the mortgage of request %s is %s, only an active mortgage can be refinanced", mortgage.RequestHash, mortgage.Status)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if mortgage.RefinanceRequested {
+		str := fmt.Sprintf("refinance of request %s was already requested", mortgage.RequestHash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage.RefinanceRequested = true
+	err = t.putMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("putMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.enqueueRequest(stub, open4refinance, &RequestLink{UserHash: identity, RequestHash: mortgage.RequestHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not enqueueRequest open4refinance %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("buyerRequestRefinance Sucessfully executed")
+	return shim.Success(nil)
+}
+
+//bankPutRefinanceOffer - a bank other than the lien holder offers to refinance the outstanding principal,
+//over the remaining months unless the offer sets a duration, args: requestLink, BankOffer JSON
+func (t *HomelendChaincode) bankPutRefinanceOffer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("bankPutRefinanceOffer executed with args: %+v", args))
+
+	if len(args) != 2 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleLoanOfficer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.requireActiveParticipant(stub, ParticipantBank, identity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	requestLink := &RequestLink{}
+	err = json.Unmarshal([]byte(args[0]), requestLink)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal requestLinkStr: %s", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage, err := t.getMortgage(stub, requestLink.UserHash, requestLink.RequestHash)
+	if err != nil {
+		str := fmt.Sprintf("getMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if !mortgage.RefinanceRequested {
+		str := fmt.Sprintf("the buyer did not request to refinance request %s", mortgage.RequestHash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if mortgage.BankHash == identity {
+		str := fmt.Sprintf("%s already holds the mortgage of request %s", identity, mortgage.RequestHash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	offer, err := t.parseBankOffer(stub, args[1], identity, mortgage.remainingMonths(), mortgage.OutstandingPrincipal)
+	if err != nil {
+		str := fmt.Sprintf("Invalid bank offer: %s", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	for _, existing := range mortgage.RefinanceOffers {
+		if existing.Hash == offer.Hash {
+			str := fmt.Sprintf("refinance offer %s already exists", offer.Hash)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	mortgage.RefinanceOffers = append(mortgage.RefinanceOffers, *offer)
+	err = t.putMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("putMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("bankPutRefinanceOffer Sucessfully executed")
+	return shim.Success(nil)
+}
+
+//remainingMonths - the months left of the term, at least one for an overdue mortgage
+func (m *Mortgage) remainingMonths() int {
+	if m.TermMonths-m.InstallmentsPaid < 1 {
+		return 1
+	}
+	return m.TermMonths - m.InstallmentsPaid
+}
+
+//buyerAcceptRefinanceOffer - the new bank pays off the lien holder and the mortgage continues
+//with the new bank on the terms of its offer, args: requestHash, offerHash
+func (t *HomelendChaincode) buyerAcceptRefinanceOffer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("buyerAcceptRefinanceOffer executed with args: %+v", args))
+
+	if len(args) != 2 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleBuyer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request, err := t.getRequest(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage, err := t.getMortgage(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	var offer *BankOffer
+	for i := range mortgage.RefinanceOffers {
+		if mortgage.RefinanceOffers[i].Hash == args[1] {
+			offer = &mortgage.RefinanceOffers[i]
+		}
+	}
+	if !mortgage.RefinanceRequested || offer == nil {
+		str := fmt.Sprintf("refinance offer %s of request %s does not exist", args[1], args[0])
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	selected := *offer
+	for _, bankOffer := range request.BankOffers {
+		if bankOffer.Hash == selected.Hash {
+			str := fmt.Sprintf("request %s already has a bank offer %s", request.Hash, selected.Hash)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	err = t.requireActiveParticipant(stub, ParticipantBank, selected.BankHash)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.assessMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("assessMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if mortgage.Status != MortgageActive {
+		str := fmt.Sprintf("the mortgage of request %s is %s, only an active mortgage can be refinanced", mortgage.RequestHash, mortgage.Status)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	now, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if selected.expired(now) {
+		str := fmt.Sprintf("refinance offer %s expired at %s", selected.Hash, selected.ExpiresAt)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	quote, err := mortgage.payoffQuote(now)
+	if err != nil {
+		str := fmt.Sprintf("payoffQuote error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	err = t.payoffMortgage(stub, mortgage, quote, bankAccount(selected.BankHash), "refinance payoff")
+	if err != nil {
+		str := fmt.Sprintf("payoffMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// the new bank finances the fees of its offer on top of the payoff
+	principal, err := quote.Total.Add(selected.Fees)
+	if err != nil {
+		str := fmt.Sprintf("Could not add the fees %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	payment, err := t.mortgagePayment(selected.Interest, selected.Duration, principal)
+	if err != nil {
+		str := fmt.Sprintf("mortgagePayment error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage.Refinancings = append(mortgage.Refinancings, Refinancing{
+		FromBankHash:  mortgage.BankHash,
+		ToBankHash:    selected.BankHash,
+		BankOfferHash: selected.Hash,
+		PayoffAmount:  quote.Total,
+		TxID:          stub.GetTxID(),
+		Timestamp:     now,
+	})
+	mortgage.Principal = principal
+	mortgage.OutstandingPrincipal = principal
+	mortgage.TermMonths = selected.Duration
+	mortgage.MonthlyPayment = payment
+	mortgage.InstallmentsPaid = 0
+	mortgage.LateFeesCharged = 0
+	mortgage.StartDate = now
+	mortgage.applyOfferTerms(&selected)
+
+	// the lien passes from the old bank to the new one, mortgages closed before closings were registered have none
+	if mortgage.LienID != "" {
+		err = t.settleTitle(stub, mortgage, lib.TitleChange{ReleaseLienID: mortgage.LienID, Lien: mortgageLien(mortgage)})
+		if err != nil {
+			str := fmt.Sprintf("Could not move the lien to %s %+v", selected.BankHash, err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	err = t.assignMortgagePolicy(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("Could not assign the insurance policy to %s %+v", selected.BankHash, err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.putMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("putMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// the refinance offer becomes the selected offer of the request, so its readers see the new lender
+	request.BankOffers = append(request.BankOffers, selected)
+	request.SelectedBankOfferHash = selected.Hash
+	request.LoanAmountLeftToRefund = mortgage.OutstandingPrincipal
+	err = t.addOrUpdateRequest(stub, request)
+	if err != nil {
+		str := fmt.Sprintf("addOrUpdateRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("buyerAcceptRefinanceOffer Sucessfully executed")
+	return shim.Success(nil)
+}
+
+//getRefinanceOffers - args: buyerHash, requestHash
+func (t *HomelendChaincode) getRefinanceOffers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	mortgage, err := t.getReadableMortgage(stub, args)
+	if err != nil {
+		str := fmt.Sprintf("getReadableMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	offers := mortgage.RefinanceOffers
+	if offers == nil {
+		offers = make([]BankOffer, 0)
+	}
+	return t.marshalResponse(offers)
+}
+
+//refinanceCandidate - any active bank may read a mortgage its buyer opened to refinance offers
+func (t *HomelendChaincode) refinanceCandidate(stub shim.ChaincodeStubInterface, mortgage *Mortgage, identity string) error {
+	if !mortgage.RefinanceRequested {
+		return errors.New("the mortgage is not open to refinance offers")
+	}
+	if _, err := t.getIdentity(stub, RoleLoanOfficer); err != nil {
+		return err
+	}
+	return t.requireActiveParticipant(stub, ParticipantBank, identity)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestPayOff(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+	n.updateMortgage(func(mortgage *Mortgage) {
+		mortgage.PrepaymentPenalty = 1
+	})
+
+	// 40 days after closing, the first installment is overdue but within the grace period
+	n.backdateMortgage(40)
+	n.as(sellerMSP, sellerID).mustFail("getPayoffQuote", buyerID, requestHash)
+	quote := &PayoffQuote{}
+	err := json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("getPayoffQuote", buyerID, requestHash), quote)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	interest := lib.NewMoney(76712, lib.DefaultCurrency) // 3.5% on the loan for 40 days, 767.123 rounded to the cent
+	if quote.OutstandingPrincipal != usd(loanAmount) || quote.AccruedInterest != interest || quote.PrepaymentPenalty != usd(loanAmount/100) || !quote.LateFees.IsZero() {
+		t.Fatalf("unexpected quote %+v", quote)
+	}
+	if expected := sumOf(t, usd(loanAmount+loanAmount/100), interest); quote.Total != expected {
+		t.Fatalf("expected total %s got %s", expected, quote.Total)
+	}
+
+	n.as(buyerMSP, buyerID).mustFail("buyerPayOff", requestHash)
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", quote.Total.Decimal())
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayOff", requestHash)
+	n.as(buyerMSP, buyerID).mustFail("buyerPayOff", requestHash)
+	n.as(buyerMSP, buyerID).mustFail("getPayoffQuote", buyerID, requestHash)
+
+	request := n.request()
+	if request.Status != StatusMortgagePaidOff || !request.LoanAmountLeftToRefund.IsZero() {
+		t.Fatalf("unexpected request after payoff %s %s", request.Status, request.LoanAmountLeftToRefund)
+	}
+	info := n.mortgageInfo()
+	if info.Mortgage.Status != MortgagePaidOff || info.NextInstallment != nil || info.Mortgage.Payments[0].PrepaymentPenalty != usd(loanAmount/100) {
+		t.Fatalf("unexpected mortgage after payoff %+v", info.Mortgage)
+	}
+
+	cc := &HomelendChaincode{}
+	if balance, _ := cc.getBalance(n.stub, bankAccount(bankID), lib.DefaultCurrency); balance != sumOf(t, usd(bankDepositAmount-loanAmount), quote.Total) {
+		t.Errorf("expected the bank to hold %s got %s", sumOf(t, usd(bankDepositAmount-loanAmount), quote.Total), balance)
+	}
+
+	// the payoff releases the lien of the bank in the registry
+	title := n.registry.titles[propertyHash]
+	if title.OwnerHash != buyerID || len(title.ActiveLiens()) != 0 || !title.Liens[0].Released || title.Version != 3 {
+		t.Errorf("unexpected title record after payoff %+v", title)
+	}
+	if info.Mortgage.LienID != "" || info.Mortgage.TitleVersion != 3 {
+		t.Errorf("unexpected lien of the mortgage %s version %d", info.Mortgage.LienID, info.Mortgage.TitleVersion)
+	}
+}
+
+func TestRefinance(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+	n.as(bankMSP, "bank-2").mustInvoke("putBankInfo", `{"Name":"Second bank"}`)
+	n.as(bankMSP, "bank-2").deposit("bankDeposit", fmt.Sprint(bankDepositAmount))
+
+	// the buyer did not ask to refinance yet
+	n.as(bankMSP, "bank-2").mustFail("bankPutRefinanceOffer", requestLinkJSON(), offerJSON("refinance-1", 2.5))
+	n.as(bankMSP, "bank-2").mustFail("getMortgageInfo", buyerID, requestHash)
+
+	n.as(buyerMSP, buyerID).mustInvoke("buyerRequestRefinance", requestHash)
+	n.as(buyerMSP, buyerID).mustFail("buyerRequestRefinance", requestHash)
+	var links []*RequestLink
+	n.as(bankMSP, "bank-2").pull(&links, "bankPullOpen4Refinance")
+	if !hasLink(links, requestHash) {
+		t.Fatalf("the mortgage is not open to refinance offers")
+	}
+	n.as(bankMSP, "bank-2").mustInvoke("getMortgageInfo", buyerID, requestHash)
+
+	// the lien holder can not refinance its own mortgage
+	n.as(bankMSP, bankID).mustFail("bankPutRefinanceOffer", requestLinkJSON(), offerJSON("refinance-1", 2.5))
+	n.as(bankMSP, "bank-2").mustInvoke("bankPutRefinanceOffer", requestLinkJSON(), offerJSON("refinance-1", 2.5))
+	n.as(bankMSP, "bank-2").mustFail("bankPutRefinanceOffer", requestLinkJSON(), offerJSON("refinance-1", 2.5))
+
+	var offers []BankOffer
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getRefinanceOffers", buyerID, requestHash), &offers)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if len(offers) != 1 || offers[0].BankHash != "bank-2" || !offers[0].MonthlyPayment.IsPositive() {
+		t.Fatalf("unexpected refinance offers %+v", offers)
+	}
+
+	n.as(buyerMSP, buyerID).mustFail("buyerAcceptRefinanceOffer", requestHash, "refinance-2")
+	n.as(buyerMSP, buyerID).mustInvoke("buyerAcceptRefinanceOffer", requestHash, "refinance-1")
+
+	info := n.mortgageInfo()
+	mortgage := info.Mortgage
+	if mortgage.BankHash != "bank-2" || mortgage.Interest != 2.5 || mortgage.OutstandingPrincipal != usd(loanAmount) || mortgage.TermMonths != 240 {
+		t.Fatalf("unexpected refinanced mortgage %+v", mortgage)
+	}
+	if len(mortgage.Refinancings) != 1 || mortgage.Refinancings[0].FromBankHash != bankID || mortgage.RefinanceRequested {
+		t.Fatalf("unexpected refinancing record %+v", mortgage.Refinancings)
+	}
+	if n.request().LoanAmountLeftToRefund != usd(loanAmount) {
+		t.Errorf("expected %d left to refund got %s", loanAmount, n.request().LoanAmountLeftToRefund)
+	}
+	cc := &HomelendChaincode{}
+	if bankHash, _ := cc.getBankHash(n.request()); bankHash != "bank-2" || n.request().SelectedBankOfferHash != "refinance-1" {
+		t.Errorf("expected the request to select the refinance offer of bank-2 got %s %s", n.request().SelectedBankOfferHash, bankHash)
+	}
+	n.as(bankMSP, "bank-2").pull(&links, "bankPullOpen4Refinance")
+	if hasLink(links, requestHash) {
+		t.Errorf("the refinanced mortgage is still open to offers")
+	}
+
+	if balance, _ := cc.getBalance(n.stub, bankAccount(bankID), lib.DefaultCurrency); balance != usd(bankDepositAmount) {
+		t.Errorf("expected the old bank to be paid off got %s", balance)
+	}
+	if balance, _ := cc.getBalance(n.stub, bankAccount("bank-2"), lib.DefaultCurrency); balance != usd(bankDepositAmount-loanAmount) {
+		t.Errorf("expected the new bank to pay %d got %s", loanAmount, sumOf(t, usd(bankDepositAmount), balance.Neg()))
+	}
+
+	// the new bank is now the lien holder
+	liens := n.registry.titles[propertyHash].ActiveLiens()
+	if len(liens) != 1 || liens[0].ID != requestHash+"-1" || liens[0].HolderHash != "bank-2" || mortgage.LienID != liens[0].ID {
+		t.Errorf("unexpected liens after the refinancing %+v", liens)
+	}
+	if beneficiary := n.insurance.policies[requestHash].BeneficiaryHash; beneficiary != "bank-2" {
+		t.Errorf("the policy was not assigned to the new bank, the beneficiary is %s", beneficiary)
+	}
+	n.as(bankMSP, bankID).mustFail("bankAssessDelinquency", requestLinkJSON())
+	n.as(bankMSP, "bank-2").mustInvoke("bankAssessDelinquency", requestLinkJSON())
+}
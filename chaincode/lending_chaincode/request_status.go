@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//RequestStatus - a step of the buying process a Request can be in
+type RequestStatus string
+
+// Statuses of the buying process, in the order they are normally reached
+const (
+	StatusNone                    RequestStatus = ""
+	StatusInitialized             RequestStatus = "REQUEST_INITIALIZED"
+	StatusDataProvided            RequestStatus = "REQUEST_DATA_PROVIDED"
+	StatusCreditScoreInstalled    RequestStatus = "REQUEST_CREDIT_SCORE_INSTALLED"
+	StatusBankOfferInstalled      RequestStatus = "BANK_OFFER_INSTALLED"
+	StatusBuyerSelectedBankOffer  RequestStatus = "BUYER_SELECTED_BANK_OFFER"
+	StatusAppraiserChosen         RequestStatus = "REQUEST_APPRAISER_CHOSEN"
+	StatusAppraiserProvidedAmount RequestStatus = "APPRAISER_PROVIEDED_AMOUNT"
+	StatusInsuranceOfferProvided  RequestStatus = "INSURANCE_OFFER_PROVIDED"
+	StatusInsuranceOfferSelected  RequestStatus = "INSURANCE_OFFER_SELECTED"
+	StatusGovernmentProvided      RequestStatus = "REQUEST_GOVERNMENT_PROVIDED"
+	StatusDeclinedByBank          RequestStatus = "REQUEST_DECLINED_BY_BANK"
+	StatusApprovedByBank          RequestStatus = "REQUEST_APPROVED_BY_BANK"
+	StatusCompletedActiveMortgage RequestStatus = "REQUEST_COMPLETED-ACTIVE-MORTGAGE"
+	StatusMortgagePaidOff         RequestStatus = "REQUEST_MORTGAGE_PAID_OFF"
+	StatusForeclosed              RequestStatus = "REQUEST_FORECLOSED"
+)
+
+//requestTransitions - the statuses a Request may move to from each status.
+//Statuses that are missing from the table are final.
+var requestTransitions = map[RequestStatus][]RequestStatus{
+	StatusNone:                    {StatusInitialized},
+	StatusInitialized:             {StatusDataProvided, StatusCreditScoreInstalled},
+	StatusDataProvided:            {StatusCreditScoreInstalled},
+	StatusCreditScoreInstalled:    {StatusBankOfferInstalled},
+	StatusBankOfferInstalled:      {StatusBankOfferInstalled, StatusBuyerSelectedBankOffer},
+	StatusBuyerSelectedBankOffer:  {StatusAppraiserChosen},
+	StatusAppraiserChosen:         {StatusAppraiserProvidedAmount},
+	StatusAppraiserProvidedAmount: {StatusInsuranceOfferProvided},
+	StatusInsuranceOfferProvided:  {StatusInsuranceOfferProvided, StatusInsuranceOfferSelected},
+	StatusInsuranceOfferSelected:  {StatusGovernmentProvided},
+	StatusGovernmentProvided:      {StatusGovernmentProvided, StatusApprovedByBank, StatusDeclinedByBank},
+	StatusApprovedByBank:          {StatusCompletedActiveMortgage},
+	StatusCompletedActiveMortgage: {StatusMortgagePaidOff, StatusForeclosed},
+}
+
+//StatusTransition - one entry in the audit trail of a Request
+type StatusTransition struct {
+	From      RequestStatus `json:"From"`
+	To        RequestStatus `json:"To"`
+	Identity  string        `json:"Identity"`
+	Timestamp time.Time     `json:"Timestamp"`
+}
+
+//TransitionError - returned when a handler tries to move a Request to a status
+//that is not reachable from its current one
+type TransitionError struct {
+	Code        string          `json:"Code"`
+	RequestHash string          `json:"RequestHash"`
+	From        RequestStatus   `json:"From"`
+	To          RequestStatus   `json:"To"`
+	Allowed     []RequestStatus `json:"Allowed"`
+}
+
+func (e *TransitionError) Error() string {
+	bytes, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf("illegal status transition of request %s from %s to %s", e.RequestHash, e.From, e.To)
+	}
+	return string(bytes)
+}
+
+//checkTransition - returns a TransitionError if the request cannot move to the given status
+func checkTransition(request *Request, to RequestStatus) error {
+	for _, allowed := range requestTransitions[request.Status] {
+		if allowed == to {
+			return nil
+		}
+	}
+
+	err := &TransitionError{Code: "ILLEGAL_TRANSITION", RequestHash: request.Hash, From: request.Status, To: to, Allowed: requestTransitions[request.Status]}
+	fmt.Println(err.Error())
+	return err
+}
+
+//transitionRequest - moves the request to the new status and records it in the history.
+//Every handler that changes Request.Status must go through here, the chaincode event
+//of the transition is emitted when the request is saved by addOrUpdateRequest.
+func (t *HomelendChaincode) transitionRequest(stub shim.ChaincodeStubInterface, request *Request, to RequestStatus) error {
+	err := checkTransition(request, to)
+	if err != nil {
+		return err
+	}
+
+	identity, err := t.getIdentity(stub, "")
+	if err != nil {
+		return err
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	transition := StatusTransition{From: request.Status, To: to, Identity: identity, Timestamp: timestamp}
+	request.StatusHistory = append(request.StatusHistory, transition)
+	request.Status = to
+	request.transition = &transition
+	return nil
+}
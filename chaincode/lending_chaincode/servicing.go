@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//every mortgage is stored under the request it was created for
+const mortgageIndex = "mortgage~buyer~request"
+
+//MortgageStatus - where an active mortgage is in its repayment
+type MortgageStatus string
+
+// Mortgage statuses, a late, delinquent or defaulted mortgage returns to ACTIVE once the buyer catches up
+const (
+	MortgageActive     MortgageStatus = "ACTIVE"
+	MortgageLate       MortgageStatus = "LATE"
+	MortgageDelinquent MortgageStatus = "DELINQUENT"
+	MortgageDefaulted  MortgageStatus = "DEFAULTED"
+	MortgageForeclosed MortgageStatus = "FORECLOSED"
+	MortgagePaidOff    MortgageStatus = "PAID_OFF"
+)
+
+//Mortgage - the loan of a completed request, created by bankRunChaincode from the selected BankOffer
+type Mortgage struct {
+	RequestHash          string               `json:"RequestHash"`
+	BuyerHash            string               `json:"BuyerHash"`
+	PropertyHash         string               `json:"PropertyHash"`
+	BankHash             string               `json:"BankHash"`
+	BankOfferHash        string               `json:"BankOfferHash"`
+	Product              ProductType          `json:"Product"`
+	BaseRateIndex        string               `json:"BaseRateIndex"`
+	Margin               float32              `json:"Margin"`
+	ResetMonths          int                  `json:"ResetMonths"`
+	NextRateReset        int                  `json:"NextRateReset"`
+	RateResets           []RateReset          `json:"RateResets"`
+	Principal            lib.Money            `json:"Principal"`
+	Interest             float32              `json:"Interest"`
+	TermMonths           int                  `json:"TermMonths"`
+	MonthlyPayment       lib.Money            `json:"MonthlyPayment"`
+	OutstandingPrincipal lib.Money            `json:"OutstandingPrincipal"`
+	InstallmentsPaid     int                  `json:"InstallmentsPaid"`
+	LateFees             lib.Money            `json:"LateFees"`
+	LateFeesCharged      int                  `json:"LateFeesCharged"`
+	PrepaymentPenalty    float32              `json:"PrepaymentPenalty"`
+	Status               MortgageStatus       `json:"Status"`
+	StartDate            time.Time            `json:"StartDate"`
+	Payments             []InstallmentPayment `json:"Payments"`
+	RefinanceRequested   bool                 `json:"RefinanceRequested"`
+	RefinanceOffers      []BankOffer          `json:"RefinanceOffers"`
+	Refinancings         []Refinancing        `json:"Refinancings"`
+	LienID               string               `json:"LienID"`
+	TitleVersion         int                  `json:"TitleVersion"`
+	PolicyHash           string               `json:"PolicyHash"`
+	PolicyStatus         lib.PolicyStatus     `json:"PolicyStatus"`
+}
+
+//Installment - one row of the amortization schedule
+type Installment struct {
+	Number    int       `json:"Number"`
+	DueDate   time.Time `json:"DueDate"`
+	Payment   lib.Money `json:"Payment"`
+	Principal lib.Money `json:"Principal"`
+	Interest  lib.Money `json:"Interest"`
+	Balance   lib.Money `json:"Balance"`
+}
+
+//InstallmentPayment - a paid installment, split into principal and interest
+type InstallmentPayment struct {
+	Number               int       `json:"Number"`
+	TxID                 string    `json:"TxID"`
+	Amount               lib.Money `json:"Amount"`
+	Principal            lib.Money `json:"Principal"`
+	Interest             lib.Money `json:"Interest"`
+	LateFees             lib.Money `json:"LateFees"`
+	PrepaymentPenalty    lib.Money `json:"PrepaymentPenalty"`
+	OutstandingPrincipal lib.Money `json:"OutstandingPrincipal"`
+	DueDate              time.Time `json:"DueDate"`
+	Timestamp            time.Time `json:"Timestamp"`
+}
+
+//MortgageInfo - the mortgage with what the buyer owes next
+type MortgageInfo struct {
+	Mortgage        *Mortgage    `json:"Mortgage"`
+	NextInstallment *Installment `json:"NextInstallment"`
+}
+
+//installmentAt - the installment number due on the balance, the last one clears the balance.
+//The interest is rounded half up to a cent, the principal is what the payment leaves of it.
+//A monthly payment in another currency than the balance is an error.
+func (m *Mortgage) installmentAt(number int, balance lib.Money) (Installment, error) {
+	interest := balance.MulRat(lib.MonthlyRate(m.Interest))
+	if !m.MonthlyPayment.SameCurrency(balance) {
+		return Installment{}, fmt.Errorf("monthly payment %s on a balance in %s", m.MonthlyPayment, balance.Currency)
+	}
+	payment := m.MonthlyPayment
+	// the currencies match, the amounts below can not fail
+	principal, _ := payment.Sub(interest)
+	if cmp, _ := principal.Cmp(balance); cmp >= 0 || number >= m.TermMonths {
+		payment, _ = balance.Add(interest)
+		principal = balance
+	}
+
+	rest, _ := balance.Sub(principal)
+	return Installment{Number: number, DueDate: m.StartDate.AddDate(0, number, 0), Payment: payment, Principal: principal, Interest: interest, Balance: rest}, nil
+}
+
+//nextDueDate - the due date of the oldest unpaid installment
+func (m *Mortgage) nextDueDate() time.Time {
+	return m.StartDate.AddDate(0, m.InstallmentsPaid+1, 0)
+}
+
+//nextInstallment - nil once the mortgage is paid off
+func (m *Mortgage) nextInstallment() (*Installment, error) {
+	if !m.OutstandingPrincipal.IsPositive() {
+		return nil, nil
+	}
+	installment, err := m.installmentAt(m.InstallmentsPaid+1, m.OutstandingPrincipal)
+	if err != nil {
+		return nil, err
+	}
+	return &installment, nil
+}
+
+//amortizationSchedule - the paid installments of the current lender followed by the remaining ones,
+//projected on the current rate when each is paid as scheduled
+func (m *Mortgage) amortizationSchedule() ([]Installment, error) {
+	schedule := make([]Installment, 0, m.TermMonths)
+	if m.InstallmentsPaid <= len(m.Payments) {
+		for _, payment := range m.Payments[len(m.Payments)-m.InstallmentsPaid:] {
+			amount, err := payment.Amount.Sub(payment.LateFees)
+			if err != nil {
+				return nil, err
+			}
+			schedule = append(schedule, Installment{
+				Number:    payment.Number,
+				DueDate:   payment.DueDate,
+				Payment:   amount,
+				Principal: payment.Principal,
+				Interest:  payment.Interest,
+				Balance:   payment.OutstandingPrincipal,
+			})
+		}
+	}
+
+	balance := m.OutstandingPrincipal
+	for number := m.InstallmentsPaid + 1; balance.IsPositive() && number <= m.TermMonths; number++ {
+		installment, err := m.installmentAt(number, balance)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, installment)
+		balance = installment.Balance
+	}
+	return schedule, nil
+}
+
+//newMortgage - the mortgage of the request on the selected BankOffer, starting at the closing time
+func (t *HomelendChaincode) newMortgage(request *Request, startDate time.Time) (*Mortgage, error) {
+	offer := selectedBankOffer(request)
+	if offer == nil {
+		return nil, errors.New("the selected bank offer was not found")
+	}
+
+	months := offer.Duration
+	if months == 0 {
+		months = request.Duration
+	}
+
+	mortgage := &Mortgage{
+		RequestHash:          request.Hash,
+		BuyerHash:            request.BuyerHash,
+		PropertyHash:         request.PropertyHash,
+		Principal:            request.LoanAmount,
+		TermMonths:           months,
+		OutstandingPrincipal: request.LoanAmount,
+		Status:               MortgageActive,
+		StartDate:            startDate,
+	}
+	mortgage.applyOfferTerms(offer)
+
+	var err error
+	mortgage.MonthlyPayment, err = t.mortgagePayment(mortgage.Interest, months, request.LoanAmount)
+	if err != nil {
+		return nil, err
+	}
+	return mortgage, nil
+}
+
+//mortgagePayment - the monthly payment, without interest the principal is split in equal payments rounded up to a cent
+func (t *HomelendChaincode) mortgagePayment(interest float32, months int, principal lib.Money) (lib.Money, error) {
+	if interest == 0 {
+		if months < 1 {
+			return lib.Money{}, errors.New("invalid: duration")
+		}
+		perMonth := (principal.Amount + int64(months) - 1) / int64(months)
+		return lib.NewMoney(perMonth, principal.Currency), nil
+	}
+
+	return t.calcPmt(interest, months, principal)
+}
+
+func (t *HomelendChaincode) getMortgage(stub shim.ChaincodeStubInterface, buyerHash string, requestHash string) (*Mortgage, error) {
+	key, err := stub.CreateCompositeKey(mortgageIndex, []string{buyerHash, requestHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+	if len(dataAsBytes) == 0 {
+		str := fmt.Sprintf("Mortgage does not exist %s %s", buyerHash, requestHash)
+		fmt.Println(str)
+		return nil, errors.New(str)
+	}
+
+	mortgage := &Mortgage{}
+	err = json.Unmarshal(dataAsBytes, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal mortgage: %s", err)
+		return nil, errors.New(str)
+	}
+	return mortgage, nil
+}
+
+func (t *HomelendChaincode) putMortgage(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	key, err := stub.CreateCompositeKey(mortgageIndex, []string{mortgage.BuyerHash, mortgage.RequestHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return errors.New(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(mortgage)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		return errors.New(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		return errors.New(str)
+	}
+	return nil
+}
+
+//getReadableMortgage - the mortgage of args [buyerHash, requestHash] if the caller is its buyer, its bank, Homelend
+//or a bank that may offer to refinance it
+func (t *HomelendChaincode) getReadableMortgage(stub shim.ChaincodeStubInterface, args []string) (*Mortgage, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("Incorrect number of arguments %d.", len(args))
+	}
+
+	identity, err := t.getIdentity(stub, "")
+	if err != nil {
+		return nil, err
+	}
+
+	mortgage, err := t.getMortgage(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if identity != mortgage.BuyerHash && identity != mortgage.BankHash {
+		_, adminErr := t.getIdentity(stub, RoleAdmin)
+		if adminErr != nil && t.refinanceCandidate(stub, mortgage, identity) != nil {
+			return nil, fmt.Errorf("%s can not read the mortgage of request %s", identity, mortgage.RequestHash)
+		}
+	}
+
+	// reads show the late fees and status as of the query, bankAssessDelinquency records them
+	err = t.assessMortgage(stub, mortgage)
+	if err != nil {
+		return nil, err
+	}
+	return mortgage, nil
+}
+
+//buyerPayInstallment - pays the next installment of the mortgage to the bank, args: requestHash
+func (t *HomelendChaincode) buyerPayInstallment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("buyerPayInstallment executed with args: %+v", args))
+
+	var err error
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	identity, err := t.getIdentity(stub, RoleBuyer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request, err := t.getRequest(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage, err := t.getMortgage(stub, identity, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	if mortgage.Status == MortgagePaidOff || !mortgage.OutstandingPrincipal.IsPositive() {
+		str := fmt.Sprintf("the mortgage of request %s is paid off", mortgage.RequestHash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	if mortgage.Status == MortgageForeclosed {
+		str := fmt.Sprintf("the mortgage of request %s was foreclosed", mortgage.RequestHash)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	timestamp, err := helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	// late fees charged up to now are paid with the installment, a rate reset applies to it
+	err = t.assessMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("assessMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	installment, err := mortgage.nextInstallment()
+	if err != nil {
+		str := fmt.Sprintf("nextInstallment error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	lateFees := mortgage.LateFees
+	amount, err := installment.Payment.Add(lateFees)
+	if err != nil {
+		str := fmt.Sprintf("Could not add the late fees %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	description := fmt.Sprintf("installment %d principal %s interest %s late fees %s", installment.Number, installment.Principal, installment.Interest, lateFees)
+	err = t.postJournalEntry(stub, description, request.Hash, transferLines(identity, bankAccount(mortgage.BankHash), amount))
+	if err != nil {
+		str := fmt.Sprintf("postJournalEntry error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	mortgage.OutstandingPrincipal = installment.Balance
+	mortgage.InstallmentsPaid = installment.Number
+	mortgage.LateFees = lib.NewMoney(0, mortgage.Principal.Currency)
+	mortgage.Payments = append(mortgage.Payments, InstallmentPayment{
+		Number:               installment.Number,
+		TxID:                 stub.GetTxID(),
+		Amount:               amount,
+		Principal:            installment.Principal,
+		Interest:             installment.Interest,
+		LateFees:             lateFees,
+		OutstandingPrincipal: installment.Balance,
+		DueDate:              installment.DueDate,
+		Timestamp:            timestamp,
+	})
+
+	// the buyer may still be behind on the following installments,
+	// their rate resets when they are paid
+	err = t.updateDelinquency(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("updateDelinquency error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	request.LoanAmountLeftToRefund = mortgage.OutstandingPrincipal
+	if mortgage.OutstandingPrincipal.IsZero() {
+		mortgage.Status = MortgagePaidOff
+		err = t.transitionRequest(stub, request, StatusMortgagePaidOff)
+		if err != nil {
+			str := fmt.Sprintf("transitionRequest error %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+
+		err = t.releaseMortgageLien(stub, mortgage)
+		if err != nil {
+			str := fmt.Sprintf("Could not release the lien %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+
+		err = t.cancelMortgagePolicy(stub, mortgage)
+		if err != nil {
+			str := fmt.Sprintf("Could not cancel the insurance policy %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+
+		err = t.closeRefinanceRequest(stub, mortgage)
+		if err != nil {
+			str := fmt.Sprintf("closeRefinanceRequest error %+v", err)
+			fmt.Println(str)
+			return shim.Error(str)
+		}
+	}
+
+	err = t.putMortgage(stub, mortgage)
+	if err != nil {
+		str := fmt.Sprintf("putMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.addOrUpdateRequest(stub, request)
+	if err != nil {
+		str := fmt.Sprintf("addOrUpdateRequest error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	fmt.Println("buyerPayInstallment Sucessfully executed")
+	return shim.Success(nil)
+}
+
+//getMortgageInfo - the mortgage, its outstanding balance and the next installment with its due date, args: buyerHash, requestHash
+func (t *HomelendChaincode) getMortgageInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	mortgage, err := t.getReadableMortgage(stub, args)
+	if err != nil {
+		str := fmt.Sprintf("getReadableMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	installment, err := mortgage.nextInstallment()
+	if err != nil {
+		str := fmt.Sprintf("nextInstallment error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(&MortgageInfo{Mortgage: mortgage, NextInstallment: installment})
+}
+
+//getAmortizationSchedule - args: buyerHash, requestHash
+func (t *HomelendChaincode) getAmortizationSchedule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	mortgage, err := t.getReadableMortgage(stub, args)
+	if err != nil {
+		str := fmt.Sprintf("getReadableMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	schedule, err := mortgage.amortizationSchedule()
+	if err != nil {
+		str := fmt.Sprintf("amortizationSchedule error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(schedule)
+}
+
+//getPaymentHistory - the paid installments, args: buyerHash, requestHash
+func (t *HomelendChaincode) getPaymentHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	mortgage, err := t.getReadableMortgage(stub, args)
+	if err != nil {
+		str := fmt.Sprintf("getReadableMortgage error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	payments := mortgage.Payments
+	if payments == nil {
+		payments = make([]InstallmentPayment, 0)
+	}
+	return t.marshalResponse(payments)
+}
+
+func (t *HomelendChaincode) marshalResponse(data interface{}) pb.Response {
+	dataJSONasBytes, err := json.Marshal(data)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(dataJSONasBytes)
+}
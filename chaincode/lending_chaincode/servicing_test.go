@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestMortgageServicing(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusApprovedByBank)
+	n.as(buyerMSP, buyerID).mustFail("buyerPayInstallment", requestHash)
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	info := &MortgageInfo{}
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getMortgageInfo", buyerID, requestHash), info)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	mortgage := info.Mortgage
+	if mortgage.Principal != usd(loanAmount) || mortgage.OutstandingPrincipal != usd(loanAmount) || mortgage.TermMonths != 240 || mortgage.BankHash != bankID {
+		t.Fatalf("unexpected mortgage %+v", mortgage)
+	}
+	if info.NextInstallment == nil || info.NextInstallment.Number != 1 || !info.NextInstallment.DueDate.Equal(mortgage.StartDate.AddDate(0, 1, 0)) {
+		t.Fatalf("unexpected next installment %+v", info.NextInstallment)
+	}
+
+	var schedule []Installment
+	err = json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("getAmortizationSchedule", buyerID, requestHash), &schedule)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if len(schedule) != 240 || !schedule[239].Balance.IsZero() {
+		t.Fatalf("unexpected schedule length %d", len(schedule))
+	}
+	principal := usd(0)
+	for _, installment := range schedule {
+		if sumOf(t, installment.Principal, installment.Interest) != installment.Payment {
+			t.Fatalf("installment %d does not add up %+v", installment.Number, installment)
+		}
+		principal = sumOf(t, principal, installment.Principal)
+	}
+	if principal != usd(loanAmount) {
+		t.Fatalf("the schedule repays %s expected %d", principal, loanAmount)
+	}
+
+	// only the buyer, its bank and Homelend read the mortgage
+	n.as(sellerMSP, sellerID).mustFail("getMortgageInfo", buyerID, requestHash)
+	n.asRole(bankMSP, "bank-2", RoleLoanOfficer).mustFail("getPaymentHistory", buyerID, requestHash)
+	n.as(homelendMSP, homelendID).mustInvoke("getPaymentHistory", buyerID, requestHash)
+
+	// the buyer has no money left after the down payment
+	n.as(buyerMSP, buyerID).mustFail("buyerPayInstallment", requestHash)
+
+	total := usd(0)
+	for _, installment := range schedule {
+		total = sumOf(t, total, installment.Payment)
+	}
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", total.Decimal())
+	for i := 0; i < len(schedule); i++ {
+		n.as(buyerMSP, buyerID).mustInvoke("buyerPayInstallment", requestHash)
+		if i == 0 && n.request().LoanAmountLeftToRefund != schedule[0].Balance {
+			t.Fatalf("expected %s left to refund got %s", schedule[0].Balance, n.request().LoanAmountLeftToRefund)
+		}
+		if i == 0 {
+			n.as(buyerMSP, buyerID).mustInvoke("buyerRequestRefinance", requestHash)
+		}
+	}
+	n.as(buyerMSP, buyerID).mustFail("buyerPayInstallment", requestHash)
+
+	if status := n.request().Status; status != StatusMortgagePaidOff {
+		t.Fatalf("expected status %s got %s", StatusMortgagePaidOff, status)
+	}
+	// the last installment closes the refinance request the buyer opened on the way
+	if hasLink(n.requestLinks(open4refinance), requestHash) || n.mortgageInfo().Mortgage.RefinanceRequested {
+		t.Errorf("the paid off mortgage is still open to refinance offers")
+	}
+
+	var payments []InstallmentPayment
+	err = json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getPaymentHistory", buyerID, requestHash), &payments)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if len(payments) != len(schedule) || payments[0].Interest != schedule[0].Interest || !payments[len(payments)-1].OutstandingPrincipal.IsZero() {
+		t.Fatalf("unexpected payment history %+v", payments[0])
+	}
+
+	cc := &HomelendChaincode{}
+	if balance, _ := cc.getBalance(n.stub, bankAccount(bankID), lib.DefaultCurrency); balance != sumOf(t, usd(bankDepositAmount-loanAmount), total) {
+		t.Errorf("expected the bank to hold %s got %s", sumOf(t, usd(bankDepositAmount-loanAmount), total), balance)
+	}
+	if balance, _ := cc.getBalance(n.stub, buyerID, lib.DefaultCurrency); !balance.IsZero() {
+		t.Errorf("expected the buyer to have paid everything, %s left", balance)
+	}
+}
+
+//updateMortgage - changes the stored mortgage outside of the chaincode functions
+func (n *testNetwork) updateMortgage(update func(mortgage *Mortgage)) {
+	n.t.Helper()
+	cc := &HomelendChaincode{}
+	n.stub.MockTransactionStart("update")
+	defer n.stub.MockTransactionEnd("update")
+
+	mortgage, err := cc.getMortgage(n.stub, buyerID, requestHash)
+	if err != nil {
+		n.t.Fatalf("getMortgage failed: %s", err)
+	}
+	update(mortgage)
+	err = cc.putMortgage(n.stub, mortgage)
+	if err != nil {
+		n.t.Fatalf("putMortgage failed: %s", err)
+	}
+}
+
+//backdateMortgage - moves the start of the mortgage the given number of days into the past
+func (n *testNetwork) backdateMortgage(days int) {
+	n.updateMortgage(func(mortgage *Mortgage) {
+		mortgage.StartDate = mortgage.StartDate.AddDate(0, 0, -days)
+	})
+}
+
+func (n *testNetwork) mortgageInfo() *MortgageInfo {
+	n.t.Helper()
+	info := &MortgageInfo{}
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getMortgageInfo", buyerID, requestHash), info)
+	if err != nil {
+		n.t.Fatalf("unmarshal failed: %v", err)
+	}
+	return info
+}
+
+func TestServicingTerms(t *testing.T) {
+	n := newTestNetwork(t)
+
+	terms := &ServicingTerms{}
+	err := json.Unmarshal(n.as(bankMSP, bankID).mustInvoke("getServicingTerms"), terms)
+	if err != nil || *terms != defaultServicingTerms {
+		t.Fatalf("expected the default servicing terms got %+v %v", terms, err)
+	}
+
+	n.as(bankMSP, bankID).mustFail("setServicingTerms", `{"LateAfterDays":10,"DelinquentAfterDays":20,"DefaultAfterDays":60,"LateFee":50}`)
+	n.as(homelendMSP, homelendID).mustFail("setServicingTerms", `{"LateAfterDays":30,"DelinquentAfterDays":20,"DefaultAfterDays":60,"LateFee":50}`)
+	n.as(homelendMSP, homelendID).mustInvoke("setServicingTerms", `{"LateAfterDays":10,"DelinquentAfterDays":20,"DefaultAfterDays":60,"LateFee":50}`)
+
+	err = json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getServicingTerms"), terms)
+	if err != nil || terms.LateAfterDays != 10 || terms.DelinquentAfterDays != 20 || terms.DefaultAfterDays != 60 || terms.LateFee != usd(50) {
+		t.Errorf("unexpected servicing terms %+v %v", terms, err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//peerChaincode - runs the chaincode on a peerStub instead of the bare MockStub
+type peerChaincode struct {
+	cc shim.Chaincode
+}
+
+func (p *peerChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return p.cc.Init(newPeerStub(stub))
+}
+
+func (p *peerChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return p.cc.Invoke(newPeerStub(stub))
+}
+
+//peerStub - adds to the MockStub what a peer does and the MockStub does not. A transaction reads the state
+//committed before it and not its own writes, paged range queries return one page.
+type peerStub struct {
+	shim.ChaincodeStubInterface
+	// the committed value of every key the transaction wrote, nil when the key did not exist
+	committed map[string][]byte
+}
+
+func newPeerStub(stub shim.ChaincodeStubInterface) *peerStub {
+	return &peerStub{ChaincodeStubInterface: stub, committed: make(map[string][]byte)}
+}
+
+func (s *peerStub) GetState(key string) ([]byte, error) {
+	if value, ok := s.committed[key]; ok {
+		return value, nil
+	}
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *peerStub) PutState(key string, value []byte) error {
+	err := s.keepCommitted(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (s *peerStub) DelState(key string) error {
+	err := s.keepCommitted(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.DelState(key)
+}
+
+//keepCommitted - remembers the committed value of key before the transaction first writes it
+func (s *peerStub) keepCommitted(key string) error {
+	if _, ok := s.committed[key]; ok {
+		return nil
+	}
+	value, err := s.ChaincodeStubInterface.GetState(key)
+	if err != nil {
+		return err
+	}
+	s.committed[key] = value
+	return nil
+}
+
+func (s *peerStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	resultsIterator, err := s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.committedRange(resultsIterator, startKey, endKey)
+}
+
+func (s *peerStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return s.committedRange(resultsIterator, startKey, startKey+string(utf8.MaxRune))
+}
+
+//committedRange - the results of a range query of the MockStub as committed before the transaction,
+//an empty endKey is the end of the state
+func (s *peerStub) committedRange(resultsIterator shim.StateQueryIteratorInterface, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	defer resultsIterator.Close()
+
+	values := make(map[string][]byte)
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		values[kv.Key] = kv.Value
+	}
+	for key, value := range s.committed {
+		if key < startKey || (endKey != "" && key >= endKey) {
+			continue
+		}
+		if value == nil {
+			delete(values, key)
+		} else {
+			values[key] = value
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := &readAheadIterator{}
+	for _, key := range keys {
+		results.results = append(results.results, &queryresult.KV{Key: key, Value: values[key]})
+	}
+	return results, nil
+}
+
+//GetStateByPartialCompositeKeyWithPagination - pages like the LevelDB of a peer, the bookmark is the first key of the next page
+func (s *peerStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	all, err := s.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer all.Close()
+
+	page := &readAheadIterator{}
+	metadata := &pb.QueryResponseMetadata{}
+	for all.HasNext() {
+		kv, err := all.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if len(page.results) == int(pageSize) {
+			metadata.Bookmark = kv.Key
+			break
+		}
+		page.results = append(page.results, kv)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.results))
+	return page, metadata, nil
+}
+
+//readAheadIterator - iterates over results read ahead
+type readAheadIterator struct {
+	results []*queryresult.KV
+}
+
+func (r *readAheadIterator) HasNext() bool {
+	return len(r.results) > 0
+}
+
+func (r *readAheadIterator) Next() (*queryresult.KV, error) {
+	kv := r.results[0]
+	r.results = r.results[1:]
+	return kv, nil
+}
+
+func (r *readAheadIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//mortgageLien - the lien the bank registers on the property for the principal it finances, the first one has the ID
+//of the request and every refinancing adds its number to it
+func mortgageLien(mortgage *Mortgage) *lib.Lien {
+	id := mortgage.RequestHash
+	if len(mortgage.Refinancings) > 0 {
+		id = fmt.Sprintf("%s-%d", mortgage.RequestHash, len(mortgage.Refinancings))
+	}
+	return &lib.Lien{ID: id, HolderHash: mortgage.BankHash, Amount: mortgage.Principal}
+}
+
+//settleTitle - records change of the mortgaged property with settle of the linked government chaincode, the land registry
+//stays the source of truth for the title. The mortgage keeps its active lien and the version of the title record.
+func (t *HomelendChaincode) settleTitle(stub shim.ChaincodeStubInterface, mortgage *Mortgage, change lib.TitleChange) error {
+	links, err := t.getChaincodeLinks(stub)
+	if err != nil {
+		return err
+	}
+
+	change.RequestHash = mortgage.RequestHash
+	change.PropertyHash = mortgage.PropertyHash
+	record := &lib.TitleRecord{}
+	err = t.invokeLinkedChaincode(stub, links.Government, "settle", &change, record)
+	if err != nil {
+		return err
+	}
+
+	if change.ReleaseLienID != "" {
+		mortgage.LienID = ""
+	}
+	if change.Lien != nil {
+		mortgage.LienID = change.Lien.ID
+	}
+	mortgage.TitleVersion = record.Version
+	return nil
+}
+
+//releaseMortgageLien - releases the lien of the bank once the mortgage is paid off. Mortgages closed before
+//closings were registered have no lien and no title change to record.
+func (t *HomelendChaincode) releaseMortgageLien(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	if mortgage.LienID == "" {
+		return nil
+	}
+	return t.settleTitle(stub, mortgage, lib.TitleChange{ReleaseLienID: mortgage.LienID})
+}
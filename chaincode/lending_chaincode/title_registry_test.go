@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+)
+
+func TestClosingRegistersTitle(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusCompletedActiveMortgage)
+
+	title := n.registry.titles[propertyHash]
+	lien := lib.Lien{ID: requestHash, HolderHash: bankID, Amount: usd(loanAmount)}
+	if title.OwnerHash != buyerID || title.Version != 2 || len(title.Liens) != 1 || title.Liens[0] != lien {
+		t.Fatalf("unexpected title record after closing %+v", title)
+	}
+	mortgage := n.mortgageInfo().Mortgage
+	if mortgage.LienID != requestHash || mortgage.TitleVersion != 2 {
+		t.Errorf("unexpected lien of the mortgage %s version %d", mortgage.LienID, mortgage.TitleVersion)
+	}
+
+	// mortgages closed before closings were registered have no lien to release
+	n.updateMortgage(func(mortgage *Mortgage) {
+		mortgage.LienID = ""
+	})
+	n.as(buyerMSP, buyerID).deposit("buyerDeposit", fmt.Sprint(loanAmount+loanAmount/10))
+	n.as(buyerMSP, buyerID).mustInvoke("buyerPayOff", requestHash)
+	if len(n.registry.titles[propertyHash].ActiveLiens()) != 1 {
+		t.Errorf("a lien the mortgage does not know was released")
+	}
+}
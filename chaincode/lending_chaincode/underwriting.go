@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//every bank keeps its underwriting rules under its own key
+const underwritingIndex = "underwriting~bank"
+
+//UnderwritingRules - the policy a bank approves loans with, written by its loan officers.
+//MaxLTV is the loan in percent of the lower of the price and the appraisal, MaxDTI the monthly payment of the
+//selected offer in percent of the monthly Salary and MinInsuranceCoverage the coverage of the selected
+//insurance offer in percent of the loan. Credit grades are letters, A is the best; zero values disable a rule.
+type UnderwritingRules struct {
+	BankHash             string    `json:"BankHash"`
+	MaxLTV               float32   `json:"MaxLTV"`
+	MinCreditGrade       string    `json:"MinCreditGrade"`
+	MaxDTI               float32   `json:"MaxDTI"`
+	MinInsuranceCoverage float32   `json:"MinInsuranceCoverage"`
+	Timestamp            time.Time `json:"Timestamp"`
+}
+
+//defaultUnderwritingRules - used for banks that did not set rules, the appraisal had to cover the loan plus 10% before rule sets existed
+var defaultUnderwritingRules = UnderwritingRules{MaxLTV: 90}
+
+func (rules *UnderwritingRules) validate() error {
+	if rules.MaxLTV < 0 || rules.MaxLTV > 100 || rules.MaxDTI < 0 || rules.MaxDTI > 100 {
+		return errors.New("MaxLTV and MaxDTI are percents between 0 and 100")
+	}
+	if rules.MinInsuranceCoverage < 0 {
+		return errors.New("MinInsuranceCoverage can not be negative")
+	}
+	if rules.MinCreditGrade != "" && (len(rules.MinCreditGrade) != 1 || rules.MinCreditGrade[0] < 'A' || rules.MinCreditGrade[0] > 'Z') {
+		return fmt.Errorf("invalid credit grade %q", rules.MinCreditGrade)
+	}
+	return nil
+}
+
+//percentOf - part in percent of whole, both in the same currency
+func percentOf(part lib.Money, whole lib.Money) *big.Rat {
+	if !whole.IsPositive() {
+		return nil
+	}
+	return big.NewRat(part.Amount*100, whole.Amount)
+}
+
+func formatPercent(percent *big.Rat) string {
+	if percent == nil {
+		return "unbounded"
+	}
+	return percent.FloatString(2) + "%"
+}
+
+func (t *HomelendChaincode) getUnderwritingRules(stub shim.ChaincodeStubInterface, bankHash string) (*UnderwritingRules, error) {
+	key, err := stub.CreateCompositeKey(underwritingIndex, []string{bankHash})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	dataAsBytes, err := stub.GetState(key)
+	if err != nil {
+		str := fmt.Sprintf("Failed to get state %+v", err.Error())
+		return nil, errors.New(str)
+	}
+
+	rules := defaultUnderwritingRules
+	rules.BankHash = bankHash
+	if len(dataAsBytes) == 0 {
+		return &rules, nil
+	}
+
+	err = json.Unmarshal(dataAsBytes, &rules)
+	if err != nil {
+		str := fmt.Sprintf("Failed to unmarshal underwriting rules: %s", err)
+		return nil, errors.New(str)
+	}
+	return &rules, nil
+}
+
+//evaluateUnderwritingRules - every rule of the bank the request fails, amounts are compared in the currency of the loan
+func (t *HomelendChaincode) evaluateUnderwritingRules(stub shim.ChaincodeStubInterface, rules *UnderwritingRules, request *Request) ([]string, error) {
+	var failures []string
+	loan := request.LoanAmount
+
+	if rules.MaxLTV > 0 {
+		appraised, err := t.convert(stub, request.AppraiserAmount, loan.Currency)
+		if err != nil {
+			return nil, err
+		}
+		price, err := t.convert(stub, request.SellingPrice, loan.Currency)
+		if err != nil {
+			return nil, err
+		}
+		value := appraised
+		cmp, err := price.Cmp(value)
+		if err != nil {
+			return nil, err
+		}
+		if price.IsPositive() && cmp < 0 {
+			value = price
+		}
+		cmp, err = loan.Cmp(value.Percent(rules.MaxLTV))
+		if err != nil {
+			return nil, err
+		}
+		if !value.IsPositive() || cmp > 0 {
+			failures = append(failures, fmt.Sprintf("loan to value %s is above the maximum of %.2f%%", formatPercent(percentOf(loan, value)), rules.MaxLTV))
+		}
+	}
+
+	if rules.MinCreditGrade != "" && (request.CreditScore == "" || request.CreditScore > rules.MinCreditGrade) {
+		failures = append(failures, fmt.Sprintf("credit grade %q is below the minimum of %s", request.CreditScore, rules.MinCreditGrade))
+	}
+
+	if rules.MaxDTI > 0 {
+		offer := selectedBankOffer(request)
+		if offer == nil {
+			failures = append(failures, "No bank offer was selected to compute the debt to income")
+		} else {
+			salary, err := t.convert(stub, request.Salary, loan.Currency)
+			if err != nil {
+				return nil, err
+			}
+			cmp, err := offer.MonthlyPayment.Cmp(salary.Percent(rules.MaxDTI))
+			if err != nil {
+				return nil, err
+			}
+			if !salary.IsPositive() || cmp > 0 {
+				failures = append(failures, fmt.Sprintf("debt to income %s is above the maximum of %.2f%%", formatPercent(percentOf(offer.MonthlyPayment, salary)), rules.MaxDTI))
+			}
+		}
+	}
+
+	if rules.MinInsuranceCoverage > 0 {
+		coverage := lib.NewMoney(0, loan.Currency)
+		for _, offer := range request.InsuranceOffers {
+			if offer.Hash == request.SelectedInsuranceOfferHash {
+				converted, err := t.convert(stub, offer.Coverage, loan.Currency)
+				if err != nil {
+					return nil, err
+				}
+				coverage = converted
+			}
+		}
+		cmp, err := coverage.Cmp(loan.Percent(rules.MinInsuranceCoverage))
+		if err != nil {
+			return nil, err
+		}
+		if cmp < 0 {
+			failures = append(failures, fmt.Sprintf("insurance coverage %s is below the required %.2f%%", formatPercent(percentOf(coverage, loan)), rules.MinInsuranceCoverage))
+		}
+	}
+
+	return failures, nil
+}
+
+//setUnderwritingRules - a loan officer sets the rules of its bank, args: UnderwritingRules JSON
+func (t *HomelendChaincode) setUnderwritingRules(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println(fmt.Sprintf("setUnderwritingRules executed with args: %+v", args))
+
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	bankIdentity, err := t.getIdentity(stub, RoleLoanOfficer)
+	if err != nil {
+		str := fmt.Sprintf("getIdentity error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = t.requireActiveParticipant(stub, ParticipantBank, bankIdentity)
+	if err != nil {
+		str := fmt.Sprintf("requireActiveParticipant error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	rules := &UnderwritingRules{}
+	err = json.Unmarshal([]byte(args[0]), rules)
+	if err != nil {
+		str := fmt.Sprintf("Failed to parse JSON: %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = rules.validate()
+	if err != nil {
+		str := fmt.Sprintf("invalid underwriting rules %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	helpers := lib.Helpers{}
+	rules.Timestamp, err = helpers.GetTxTime(stub)
+	if err != nil {
+		str := fmt.Sprintf("GetTxTime error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+	rules.BankHash = bankIdentity
+
+	key, err := stub.CreateCompositeKey(underwritingIndex, []string{bankIdentity})
+	if err != nil {
+		str := fmt.Sprintf("Could not create key %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	dataJSONasBytes, err := json.Marshal(rules)
+	if err != nil {
+		str := fmt.Sprintf("Could not marshal %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	err = stub.PutState(key, dataJSONasBytes)
+	if err != nil {
+		str := fmt.Sprintf("Could not put state %+v", err.Error())
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return shim.Success(nil)
+}
+
+//getUnderwritingRulesInfo - args: bankHash. Returns the rules the bank approves loans with
+func (t *HomelendChaincode) getUnderwritingRulesInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		str := fmt.Sprintf("Incorrect number of arguments %d.", len(args))
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	rules, err := t.getUnderwritingRules(stub, args[0])
+	if err != nil {
+		str := fmt.Sprintf("getUnderwritingRules error %+v", err)
+		fmt.Println(str)
+		return shim.Error(str)
+	}
+
+	return t.marshalResponse(rules)
+}
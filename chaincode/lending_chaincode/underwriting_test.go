@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnderwritingRules(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(bankMSP, bankID).mustFail("setUnderwritingRules", `{"MaxLTV":150}`)
+	n.as(bankMSP, bankID).mustFail("setUnderwritingRules", `{"MinCreditGrade":"AA"}`)
+	n.as(buyerMSP, buyerID).mustFail("setUnderwritingRules", `{"MaxLTV":60}`)
+	n.as(bankMSP, bankID).mustInvoke("setUnderwritingRules", `{"BankHash":"bank-2","MaxLTV":60,"MinCreditGrade":"A","MaxDTI":5,"MinInsuranceCoverage":100}`)
+
+	rules := &UnderwritingRules{}
+	err := json.Unmarshal(n.as(buyerMSP, buyerID).mustInvoke("getUnderwritingRules", bankID), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.BankHash != bankID || rules.MaxLTV != 60 || rules.MinCreditGrade != "A" {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+
+	n.advanceTo(StatusInsuranceOfferSelected)
+	n.as(governmentMSP, governmentID).mustInvoke("governmentPutData", buyerID, requestHash, "true", "false", "true")
+	n.as(bankMSP, bankID).mustInvoke("bankApprove", requestLinkJSON())
+
+	// every failed rule is reported, not only the first one
+	request := n.request()
+	expected := []string{
+		"CheckLien is false",
+		"loan to value 66.67% is above the maximum of 60.00%",
+		`credit grade "B" is below the minimum of A`,
+		"debt to income 7.73% is above the maximum of 5.00%",
+		"insurance coverage 0.00% is below the required 100.00%",
+	}
+	if request.Status != StatusDeclinedByBank || request.DeclineInfo != strings.Join(expected, "; ") {
+		t.Errorf("unexpected decline %s: %s", request.Status, request.DeclineInfo)
+	}
+}
+
+func TestUnderwritingRulesPass(t *testing.T) {
+	n := newTestNetwork(t)
+	n.as(bankMSP, bankID).mustInvoke("setUnderwritingRules", `{"MaxLTV":70,"MinCreditGrade":"B","MaxDTI":10,"MinInsuranceCoverage":100}`)
+
+	n.advanceTo(StatusAppraiserProvidedAmount)
+	n.as(insuranceMSP, insuranceID).mustInvoke("insurancePutOffer", buyerID, requestHash, "1200", insuranceOfferHash, "", fmt.Sprint(loanAmount))
+	n.steps++
+	n.advanceTo(StatusApprovedByBank)
+
+	if info := n.request().DeclineInfo; info != "" {
+		t.Errorf("unexpected DeclineInfo %s", info)
+	}
+}
+
+func TestBankApproveOnlySelectedBank(t *testing.T) {
+	n := newTestNetwork(t)
+	n.advanceTo(StatusGovernmentProvided)
+
+	n.as(bankMSP, "bank-2").mustFail("bankApprove", requestLinkJSON())
+}
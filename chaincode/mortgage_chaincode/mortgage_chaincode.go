@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
 
+	lib "github.com/homelend-blockchain/chaincode/homelendlib"
 	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -13,10 +13,10 @@ import (
 
 //Bank is...
 type Bank struct {
-	Hash        string `json:"Hash"`
-	Name        string `json:"Name"`
-	TotalSupply int    `json:"TotalSupply"`
-	Timestamp   int    `json:"Timestamp"`
+	Hash        string    `json:"Hash"`
+	Name        string    `json:"Name"`
+	TotalSupply lib.Money `json:"TotalSupply"`
+	Timestamp   int       `json:"Timestamp"`
 }
 
 //Seller is...
@@ -40,23 +40,23 @@ type Buyer struct {
 
 //Property is...
 type Property struct {
-	Hash         string `json:"Hash"`
-	Address      string `json:"Address"`
-	SellingPrice int    `json:"SellingPrice"`
-	Timestamp    int    `json:"Timestamp"`
+	Hash         string    `json:"Hash"`
+	Address      string    `json:"Address"`
+	SellingPrice lib.Money `json:"SellingPrice"`
+	Timestamp    int       `json:"Timestamp"`
 }
 
 //Request is...
 type Request struct {
-	Hash         string `json:"Hash"`
-	PropertyHash string `json:"Name"`
-	BuyerHash    string `json:"BuyerHash"`
-	SellerHash   string `json:"SellerHash"`
-	CreditScore  string `json:"CreditScore"`
-	Salary       int    `json:"TotalSupply"`
-	LoanAmount   int    `json:"LoanAmount"`
-	Status       string `json:"Status,omitempty"`
-	Timestamp    int    `json:"Timestamp"`
+	Hash         string    `json:"Hash"`
+	PropertyHash string    `json:"Name"`
+	BuyerHash    string    `json:"BuyerHash"`
+	SellerHash   string    `json:"SellerHash"`
+	CreditScore  string    `json:"CreditScore"`
+	Salary       lib.Money `json:"TotalSupply"`
+	LoanAmount   lib.Money `json:"LoanAmount"`
+	Status       string    `json:"Status,omitempty"`
+	Timestamp    int       `json:"Timestamp"`
 }
 
 //HomelendChaincode is...
@@ -225,55 +225,73 @@ func (t *HomelendChaincode) findHouse(stub shim.ChaincodeStubInterface, sellerID
 	return nil, 0, nil, errors.New("Could not found property")
 }
 
-func (t *HomelendChaincode) getMoney(stub shim.ChaincodeStubInterface, userID string) int {
+//getMoney - the balance of the user, balances written as whole numbers before lib.Money are read as lib.DefaultCurrency
+func (t *HomelendChaincode) getMoney(stub shim.ChaincodeStubInterface, userID string) (lib.Money, error) {
 
 	dataAsBytes, err := stub.GetState(userID)
 	if err != nil {
-		str := fmt.Sprintf("Could not getMoney userID=" + userID)
+		str := fmt.Sprintf("Could not getMoney userID=%s", userID)
 		fmt.Println(str)
-		return -1
+		return lib.Money{}, errors.New(str)
+	}
+
+	if len(dataAsBytes) == 0 {
+		return lib.Money{}, nil
 	}
 
-	escrowMoney, err := strconv.Atoi(string(dataAsBytes))
+	escrowMoney := lib.Money{}
+	err = json.Unmarshal(dataAsBytes, &escrowMoney)
 	if err != nil {
-		str := fmt.Sprintf("Could not getMoney - Atoi userID=" + userID)
+		str := fmt.Sprintf("Could not getMoney - Unmarshal userID=%s", userID)
 		fmt.Println(str)
-		return -1
+		return lib.Money{}, errors.New(str)
 	}
 
-	return escrowMoney
+	return escrowMoney, nil
 }
 
-func (t *HomelendChaincode) moveMoney(stub shim.ChaincodeStubInterface, srcUserID string, destUserID string, sum int) error {
+func (t *HomelendChaincode) moveMoney(stub shim.ChaincodeStubInterface, srcUserID string, destUserID string, sum lib.Money) error {
 
-	srcMoney := t.getMoney(stub, srcUserID)
-	if srcMoney < 0 {
-		str := fmt.Sprintf("Could not get money from srcUserID" + srcUserID)
-		fmt.Println(str)
-		return errors.New(str)
+	srcMoney, err := t.getMoney(stub, srcUserID)
+	if err != nil {
+		return err
 	}
 
-	destMoney := t.getMoney(stub, destUserID)
-	if srcMoney < 0 {
-		str := fmt.Sprintf("Could not get money from srcUserID" + destUserID)
-		fmt.Println(str)
-		return errors.New(str)
+	destMoney, err := t.getMoney(stub, destUserID)
+	if err != nil {
+		return err
+	}
+
+	newSrcMoney, err := srcMoney.Sub(sum)
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	newDestMoney, err := destMoney.Add(sum)
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
 	}
 
-	if srcMoney < sum {
-		str := fmt.Sprintf("not enough money in srcMoney %d and price is %d", srcMoney, sum)
+	if newSrcMoney.IsNegative() {
+		str := fmt.Sprintf("not enough money in srcMoney %s and price is %s", srcMoney, sum)
 		fmt.Println(str)
 		return errors.New(str)
 	}
+	srcMoney = newSrcMoney
+	destMoney = newDestMoney
 
-	srcMoney -= sum
-	destMoney += sum
-
-	srcMoneyStr := strconv.Itoa(srcMoney)
-	destMoneyStr := strconv.Itoa(destMoney)
+	srcMoneyBytes, err := json.Marshal(srcMoney)
+	if err != nil {
+		return err
+	}
+	destMoneyBytes, err := json.Marshal(destMoney)
+	if err != nil {
+		return err
+	}
 
-	stub.PutState(srcUserID, []byte(srcMoneyStr))
-	stub.PutState(destUserID, []byte(destMoneyStr))
+	stub.PutState(srcUserID, srcMoneyBytes)
+	stub.PutState(destUserID, destMoneyBytes)
 
 	moneyTransferBytes, err := stub.GetState(MoneyTransferKey)
 
@@ -286,7 +304,7 @@ func (t *HomelendChaincode) moveMoney(stub shim.ChaincodeStubInterface, srcUserI
 		return err
 	}
 
-	tx2Append := srcUserID + "_" + destUserID + "_" + strconv.Itoa(sum)
+	tx2Append := srcUserID + "_" + destUserID + "_" + sum.String()
 	moneyTransferArr = append(moneyTransferArr, tx2Append)
 
 	moneyTransferBytes, err = json.Marshal(moneyTransferArr)
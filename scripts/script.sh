@@ -6,7 +6,7 @@ CHANNEL_NAME="$1"
 COUNTER=1
 MAX_RETRY=5
 
-declare -a CHAINCODES=("creditscore_chaincode" "government_chaincode" "lending_chaincode")
+declare -a CHAINCODES=("creditscore_chaincode" "government_chaincode" "insurance_chaincode" "lending_chaincode")
 
 echo "Channel name : "${CHANNEL_NAME}
 